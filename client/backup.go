@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/zond/god/common"
+	"io"
+	"net/rpc"
+	"time"
+)
+
+const (
+	backupPageSize = 1024
+)
+
+// Backup will write a backup of all data in the cluster, including configurations, timestamps and expiry times, to w.
+//
+// The data is fetched from the owner of each part of the cluster, one page at a time. Writes made during the backup may or may not be included,
+// and if the cluster changes composition during the backup an error is returned, since parts of the data may have been missed.
+func (self *Conn) Backup(w io.Writer) (records int, err error) {
+	enc := gob.NewEncoder(w)
+	if err = self.Reconnect(); err != nil {
+		return
+	}
+	ringHash := self.ring.Hash()
+	nodes := self.ring.Hosts()
+	if len(nodes) == 0 {
+		err = fmt.Errorf("No known nodes")
+		return
+	}
+	var now time.Time
+	if err = nodes[0].Call("Timenet.ActualTime", 0, &now); err != nil {
+		return
+	}
+	if err = enc.Encode(common.BackupHeader{Version: common.BackupVersion, Time: now.UnixNano()}); err != nil {
+		return
+	}
+	if err = enc.Encode(common.BackupRecord{Type: common.BackupConf, Conf: self.Configuration()}); err != nil {
+		return
+	}
+	records++
+	for _, node := range nodes {
+		r := common.BackupRange{Len: backupPageSize}
+		for {
+			var page []common.BackupRecord
+			if err = node.Call("DHash.Export", r, &page); err != nil {
+				err = fmt.Errorf("While backing up %v: %v", node, err)
+				return
+			}
+			values := 0
+			for _, record := range page {
+				if err = enc.Encode(record); err != nil {
+					return
+				}
+				records++
+				if record.Type == common.BackupValue || record.Type == common.BackupSubValue {
+					values++
+					r.Key, r.SubKey, r.Sub, r.Started = record.Key, record.SubKey, record.Type == common.BackupSubValue, true
+				}
+			}
+			if values < r.Len {
+				break
+			}
+		}
+	}
+	var otherRingHash []byte
+	if err = nodes[0].Call("DHash.RingHash", 0, &otherRingHash); err != nil {
+		return
+	}
+	if bytes.Compare(otherRingHash, ringHash) != 0 {
+		err = fmt.Errorf("The cluster changed during the backup")
+		return
+	}
+	err = enc.Encode(common.BackupRecord{Type: common.BackupEnd})
+	return
+}
+
+// Restore will write all data in a backup made by Backup, read from r, to the cluster.
+//
+// Values keep the timestamps they had when backed up, and will not overwrite newer data (or newer deletes) under the same keys.
+// The cluster configuration is restored using AddConfiguration.
+func (self *Conn) Restore(r io.Reader) (records int, err error) {
+	dec := gob.NewDecoder(r)
+	var header common.BackupHeader
+	if err = dec.Decode(&header); err != nil {
+		return
+	}
+	if header.Version != common.BackupVersion {
+		err = fmt.Errorf("Unknown backup version %v", header.Version)
+		return
+	}
+	var page []common.BackupRecord
+	for {
+		var record common.BackupRecord
+		if err = dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("Backup ended without end marker after %v records", records)
+			}
+			break
+		}
+		if record.Type == common.BackupEnd {
+			break
+		}
+		records++
+		if record.Type == common.BackupConf {
+			for key, value := range record.Conf {
+				self.AddConfiguration(key, value)
+			}
+		} else {
+			if page = append(page, record); len(page) == backupPageSize {
+				if err = self.restore(page); err != nil {
+					return
+				}
+				page = nil
+			}
+		}
+	}
+	if restoreErr := self.restore(page); err == nil {
+		err = restoreErr
+	}
+	return
+}
+func (self *Conn) restore(records []common.BackupRecord) (err error) {
+	pages := make(map[string][]common.BackupRecord)
+	nodes := make(map[string]common.Remote)
+	for _, record := range records {
+		_, _, successor := self.ring.Remotes(record.Key)
+		pages[successor.Addr] = append(pages[successor.Addr], record)
+		nodes[successor.Addr] = *successor
+	}
+	futures := make(map[string]*rpc.Call)
+	for addr, page := range pages {
+		var x int
+		node := nodes[addr]
+		futures[addr] = node.Go("DHash.Import", page, &x)
+	}
+	var retries []common.BackupRecord
+	for addr, future := range futures {
+		<-future.Done
+		if future.Error != nil {
+			if !self.removeNode(nodes[addr], future.Error) {
+				err = future.Error
+			}
+			retries = append(retries, pages[addr]...)
+		}
+	}
+	if err == nil && len(retries) > 0 {
+		err = self.restore(retries)
+	}
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/zond/god/common"
 	"github.com/zond/setop"
 	"net/rpc"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,6 +18,10 @@ const (
 	stopped
 )
 
+const (
+	redundancyConf = "redundancy"
+)
+
 func findKeys(op *setop.SetOp) (result map[string]bool) {
 	result = make(map[string]bool)
 	for _, source := range op.Sources {
@@ -42,10 +47,19 @@ func findKeys(op *setop.SetOp) (result map[string]bool) {
 // Sub trees can be 'mirrored', which means that they contain a tree mirroring its values as keys and its keys as values.
 // To mirror a sub tree, call SubAddConfiguration for the sub tree and set 'mirrored' to 'yes'.
 //
+// Sub trees can also be materialized views, which means that they contain the results of a set expression over other sub trees.
+// To make a sub tree a view, call SubAddConfiguration for the sub tree and set 'view' to the expression, like '(I:IntegerSum a b)'.
+// The node owning the view will keep it up to date, about once a second after any of the sub trees in the expression has changed.
+//
+// Each sub tree can also be kept in a different number of copies than the rest of the database.
+// To change that, call SubAddConfiguration for the sub tree and set 'redundancy' to the number of copies, like '1' for a throwaway cache.
+//
 // Naming conventions:
 //
 // If there are two methods with similar names except that one has a capital S prefixed, that means that the method with the capital S will not return until all nodes responsible for the written data has received the data, while the one without the capital S will return as soon as the owner of the data has received it.
 //
+// How many nodes are responsible for the data depends on the 'redundancy' configuration of its sub tree, or of the cluster, see SubAddConfiguration and AddConfiguration.
+//
 // Methods prefixed Sub will work on sub trees.
 //
 // Methods prefixed Reverse will work in reverse order. Return slices in reverse order and indices from the end instead of the start etc.
@@ -57,19 +71,26 @@ func findKeys(op *setop.SetOp) (result map[string]bool) {
 // To install: go get github.com/zond/god/client
 //
 // Usage: https://github.com/zond/god/blob/master/client/client_test.go
+//
+// Errors:
+//
+// Methods that can not return errors give up, and return zero values, when access to the data is denied or no live nodes are known.
+// Err returns the error that made them give up.
 type Conn struct {
-	ring  *common.Ring
-	state int32
+	ring    *common.Ring
+	state   int32
+	errLock *sync.RWMutex
+	err     error
 }
 
 // NewConnRing creates a new Conn from a given set of known nodes. For internal usage.
 func NewConnRing(ring *common.Ring) *Conn {
-	return &Conn{ring: ring}
+	return &Conn{ring: ring, errLock: new(sync.RWMutex)}
 }
 
 // NewConn creates a new Conn to a cluster defined by the address of one of its members.
 func NewConn(addr string) (result *Conn, err error) {
-	result = &Conn{ring: common.NewRing()}
+	result = &Conn{ring: common.NewRing(), errLock: new(sync.RWMutex)}
 	var newNodes common.Remotes
 	err = common.Switch.Call(addr, "Discord.Nodes", 0, &newNodes)
 	result.ring.SetNodes(newNodes)
@@ -90,9 +111,28 @@ func (self *Conn) hasState(s int32) bool {
 func (self *Conn) changeState(old, neu int32) bool {
 	return atomic.CompareAndSwapInt32(&self.state, old, neu)
 }
-func (self *Conn) removeNode(node common.Remote) {
+
+// Err returns the last error that made this Conn give up a call, like access being denied or no live nodes being known, or nil if there is none.
+func (self *Conn) Err() error {
+	self.errLock.RLock()
+	defer self.errLock.RUnlock()
+	return self.err
+}
+func (self *Conn) setErr(err error) {
+	self.errLock.Lock()
+	defer self.errLock.Unlock()
+	self.err = err
+}
+
+// removeNode will forget node after err happened when calling it, and return whether the call is worth retrying.
+// If err was returned by node itself, like when access was denied, retrying would not help, so node is kept and err is stored to be returned by Err instead.
+func (self *Conn) removeNode(node common.Remote, err error) bool {
+	if _, ok := err.(rpc.ServerError); ok || common.IsAccessDenied(err) {
+		self.setErr(err)
+		return false
+	}
 	self.ring.Remove(node)
-	self.Reconnect()
+	return self.Reconnect() == nil
 }
 
 // Nodes returns the set of known nodes for this Conn.
@@ -104,13 +144,13 @@ func (self *Conn) update() {
 	var otherRingHash []byte
 	node := self.ring.Random()
 	if err := node.Call("DHash.RingHash", 0, &otherRingHash); err != nil {
-		self.removeNode(node)
+		self.removeNode(node, err)
 		return
 	}
 	if bytes.Compare(myRingHash, otherRingHash) != 0 {
 		var newNodes common.Remotes
 		if err := node.Call("Discord.Nodes", 0, &newNodes); err != nil {
-			self.removeNode(node)
+			self.removeNode(node, err)
 			return
 		}
 		self.ring.SetNodes(newNodes)
@@ -131,21 +171,24 @@ func (self *Conn) Start() {
 }
 
 // Reconnect will try to refetch the set of known nodes from a randomly chosen currently known node.
-func (self *Conn) Reconnect() {
-	node := self.ring.Random()
-	var err error
-	for {
+// If access is denied, or none of the known nodes answers, the error is returned and stored to be returned by Err.
+func (self *Conn) Reconnect() (err error) {
+	for self.ring.Size() > 0 {
+		node := self.ring.Random()
 		var newNodes common.Remotes
 		if err = node.Call("Discord.Nodes", 0, &newNodes); err == nil {
 			self.ring.SetNodes(newNodes)
 			return
 		}
-		self.ring.Remove(node)
-		if self.ring.Size() == 0 {
-			panic(fmt.Errorf("%v doesn't know of any live nodes!", self))
+		if common.IsAccessDenied(err) {
+			self.setErr(err)
+			return
 		}
-		node = self.ring.Random()
+		self.ring.Remove(node)
 	}
+	err = fmt.Errorf("%v doesn't know of any live nodes!", self)
+	self.setErr(err)
+	return
 }
 
 func (self *Conn) subClear(key []byte, sync bool) {
@@ -156,8 +199,9 @@ func (self *Conn) subClear(key []byte, sync bool) {
 	_, _, successor := self.ring.Remotes(key)
 	var x int
 	if err := successor.Call("DHash.SubClear", data, &x); err != nil {
-		self.removeNode(*successor)
-		self.subClear(key, sync)
+		if self.removeNode(*successor, err) {
+			self.subClear(key, sync)
+		}
 	}
 }
 func (self *Conn) subDel(key, subKey []byte, sync bool) {
@@ -169,8 +213,9 @@ func (self *Conn) subDel(key, subKey []byte, sync bool) {
 	_, _, successor := self.ring.Remotes(key)
 	var x int
 	if err := successor.Call("DHash.SubDel", data, &x); err != nil {
-		self.removeNode(*successor)
-		self.subDel(key, subKey, sync)
+		if self.removeNode(*successor, err) {
+			self.subDel(key, subKey, sync)
+		}
 	}
 }
 func (self *Conn) subPutVia(succ *common.Remote, key, subKey, value []byte, sync bool) {
@@ -182,7 +227,9 @@ func (self *Conn) subPutVia(succ *common.Remote, key, subKey, value []byte, sync
 	}
 	var x int
 	if err := succ.Call("DHash.SubPut", data, &x); err != nil {
-		self.removeNode(*succ)
+		if !self.removeNode(*succ, err) {
+			return
+		}
 		_, _, newSuccessor := self.ring.Remotes(key)
 		*succ = *newSuccessor
 		self.subPutVia(succ, key, subKey, value, sync)
@@ -192,6 +239,22 @@ func (self *Conn) subPut(key, subKey, value []byte, sync bool) {
 	_, _, successor := self.ring.Remotes(key)
 	self.subPutVia(successor, key, subKey, value, sync)
 }
+func (self *Conn) subPutTTL(key, subKey, value []byte, ttl time.Duration, sync bool) {
+	data := common.Item{
+		Key:      key,
+		SubKey:   subKey,
+		Value:    value,
+		Lifetime: ttl,
+		Sync:     sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err := successor.Call("DHash.SubPutTTL", data, &x); err != nil {
+		if self.removeNode(*successor, err) {
+			self.subPutTTL(key, subKey, value, ttl, sync)
+		}
+	}
+}
 func (self *Conn) del(key []byte, sync bool) {
 	data := common.Item{
 		Key:  key,
@@ -200,8 +263,9 @@ func (self *Conn) del(key []byte, sync bool) {
 	_, _, successor := self.ring.Remotes(key)
 	var x int
 	if err := successor.Call("DHash.Del", data, &x); err != nil {
-		self.removeNode(*successor)
-		self.del(key, sync)
+		if self.removeNode(*successor, err) {
+			self.del(key, sync)
+		}
 	}
 }
 func (self *Conn) putVia(succ *common.Remote, key, value []byte, sync bool) {
@@ -212,7 +276,9 @@ func (self *Conn) putVia(succ *common.Remote, key, value []byte, sync bool) {
 	}
 	var x int
 	if err := succ.Call("DHash.Put", data, &x); err != nil {
-		self.removeNode(*succ)
+		if !self.removeNode(*succ, err) {
+			return
+		}
 		_, _, newSuccessor := self.ring.Remotes(key)
 		*succ = *newSuccessor
 		self.putVia(succ, key, value, sync)
@@ -222,51 +288,181 @@ func (self *Conn) put(key, value []byte, sync bool) {
 	_, _, successor := self.ring.Remotes(key)
 	self.putVia(successor, key, value, sync)
 }
+func (self *Conn) putTTL(key, value []byte, ttl time.Duration, sync bool) {
+	data := common.Item{
+		Key:      key,
+		Value:    value,
+		Lifetime: ttl,
+		Sync:     sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err := successor.Call("DHash.PutTTL", data, &x); err != nil {
+		if self.removeNode(*successor, err) {
+			self.putTTL(key, value, ttl, sync)
+		}
+	}
+}
+func (self *Conn) compareAndSwap(operation string, data common.CAS) (result common.CASResult) {
+	_, _, successor := self.ring.Remotes(data.Key)
+	if err := successor.Call(operation, data, &result); err != nil {
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.compareAndSwap(operation, data)
+	}
+	return
+}
+func (self *Conn) incr(operation string, data common.Incr) (result common.IncrResult, err error) {
+	_, _, successor := self.ring.Remotes(data.Key)
+	if err = successor.Call(operation, data, &result); err != nil {
+		if common.IsAccessDenied(err) {
+			return
+		}
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.incr(operation, data)
+	}
+	if result.Error != "" {
+		err = fmt.Errorf("%v", result.Error)
+	}
+	return
+}
+func (self *Conn) incrInt(operation string, data common.Incr) (result int64, err error) {
+	incrResult, err := self.incr(operation, data)
+	if err != nil {
+		return
+	}
+	return common.DecodeInt64(incrResult.Value)
+}
+func (self *Conn) incrFloat(operation string, data common.Incr) (result float64, err error) {
+	data.Float = true
+	incrResult, err := self.incr(operation, data)
+	if err != nil {
+		return
+	}
+	return common.DecodeFloat64(incrResult.Value)
+}
+func (self *Conn) batch(ops []common.BatchOp, sync bool) (results []common.BatchResult) {
+	results = make([]common.BatchResult, len(ops))
+	batches := make(map[string]*common.Batch)
+	indices := make(map[string][]int)
+	nodes := make(map[string]common.Remote)
+	for index, op := range ops {
+		_, _, successor := self.ring.Remotes(op.Key)
+		if _, ok := batches[successor.Addr]; !ok {
+			batches[successor.Addr] = &common.Batch{Sync: sync}
+			nodes[successor.Addr] = *successor
+		}
+		batches[successor.Addr].Ops = append(batches[successor.Addr].Ops, op)
+		indices[successor.Addr] = append(indices[successor.Addr], index)
+	}
+	futures := make(map[string]*rpc.Call)
+	nodeResults := make(map[string]*[]common.BatchResult)
+	for addr, batch := range batches {
+		var thisResult []common.BatchResult
+		nodeResults[addr] = &thisResult
+		node := nodes[addr]
+		futures[addr] = node.Go("DHash.Batch", *batch, &thisResult)
+	}
+	var retries []common.BatchOp
+	var retryIndices []int
+	for addr, future := range futures {
+		<-future.Done
+		if future.Error != nil {
+			if self.removeNode(nodes[addr], future.Error) {
+				retries = append(retries, batches[addr].Ops...)
+				retryIndices = append(retryIndices, indices[addr]...)
+			}
+		} else {
+			for index, result := range *nodeResults[addr] {
+				results[indices[addr][index]] = result
+			}
+		}
+	}
+	if len(retries) > 0 {
+		for index, result := range self.batch(retries, sync) {
+			// The failed node may already have performed the ops, so whether they existed before is unknown.
+			result.Existed = false
+			results[retryIndices[index]] = result
+		}
+	}
+	return
+}
+
+// parseRedundancy returns the number of copies value, a 'redundancy' configuration, asks for, or 0 if value is not a positive number.
+func parseRedundancy(value string) (result int) {
+	if result, _ = strconv.Atoi(value); result < 1 {
+		return 0
+	}
+	return
+}
+
+// redundancy returns how many copies the cluster keeps of byte values, and of sub trees without a 'redundancy' configuration of their own.
+func (self *Conn) redundancy() int {
+	if result := parseRedundancy(self.Configuration()[redundancyConf]); result > 0 {
+		return result
+	}
+	return self.ring.Redundancy()
+}
+
+// subRedundancy returns how many copies the cluster keeps of the sub tree defined by key.
+func (self *Conn) subRedundancy(key []byte) int {
+	if result := parseRedundancy(self.SubConfiguration(key)[redundancyConf]); result > 0 {
+		return result
+	}
+	return self.redundancy()
+}
+
+// replicas returns the redundancy nodes holding a copy of key, skipping any virtual positions of nodes already included.
+func (self *Conn) replicas(key []byte, redundancy int) common.Remotes {
+	_, _, successor := self.ring.Remotes(key)
+	return self.ring.Successors(*successor, redundancy)
+}
 func (self *Conn) mergeRecent(operation string, r common.Range, up bool) (result []common.Item) {
-	currentRedundancy := self.ring.Redundancy()
-	futures := make([]*rpc.Call, currentRedundancy)
-	results := make([]*[]common.Item, currentRedundancy)
-	nodes := make(common.Remotes, currentRedundancy)
-	nextKey := r.Key
-	var nextSuccessor *common.Remote
-	for i := 0; i < currentRedundancy; i++ {
-		_, _, nextSuccessor = self.ring.Remotes(nextKey)
+	return self.mergeRecentWith(operation, r, self.subRedundancy(r.Key), up, common.MergeItems)
+}
+func (self *Conn) mergeMirrorRecent(operation string, r common.Range, up bool) (result []common.Item) {
+	return self.mergeRecentWith(operation, r, self.subRedundancy(r.Key), up, common.MergeMirrorItems)
+}
+func (self *Conn) mergeRecentWith(operation string, r common.Range, redundancy int, up bool, merge func(arys []*[]common.Item, up bool) []common.Item) (result []common.Item) {
+	nodes := self.replicas(r.Key, redundancy)
+	futures := make([]*rpc.Call, len(nodes))
+	results := make([]*[]common.Item, len(nodes))
+	for i, node := range nodes {
 		var thisResult []common.Item
-		nodes[i] = *nextSuccessor
 		results[i] = &thisResult
-		futures[i] = nextSuccessor.Go(operation, r, &thisResult)
-		nextKey = nextSuccessor.Pos
+		futures[i] = node.Go(operation, r, &thisResult)
 	}
 	for index, future := range futures {
 		<-future.Done
 		if future.Error != nil {
-			self.removeNode(nodes[index])
-			return self.mergeRecent(operation, r, up)
+			if !self.removeNode(nodes[index], future.Error) {
+				return
+			}
+			return self.mergeRecentWith(operation, r, redundancy, up, merge)
 		}
 	}
-	result = common.MergeItems(results, up)
+	result = merge(results, up)
 	return
 }
-func (self *Conn) findRecent(operation string, data common.Item) (result *common.Item) {
-	currentRedundancy := self.ring.Redundancy()
-	futures := make([]*rpc.Call, currentRedundancy)
-	results := make([]*common.Item, currentRedundancy)
-	nodes := make(common.Remotes, currentRedundancy)
-	nextKey := data.Key
-	var nextSuccessor *common.Remote
-	for i := 0; i < currentRedundancy; i++ {
-		_, _, nextSuccessor = self.ring.Remotes(nextKey)
+func (self *Conn) findRecent(operation string, data common.Item, redundancy int) (result *common.Item) {
+	nodes := self.replicas(data.Key, redundancy)
+	futures := make([]*rpc.Call, len(nodes))
+	results := make([]*common.Item, len(nodes))
+	for i, node := range nodes {
 		thisResult := &common.Item{}
-		nodes[i] = *nextSuccessor
 		results[i] = thisResult
-		futures[i] = nextSuccessor.Go(operation, data, thisResult)
-		nextKey = nextSuccessor.Pos
+		futures[i] = node.Go(operation, data, thisResult)
 	}
 	for index, future := range futures {
 		<-future.Done
 		if future.Error != nil {
-			self.removeNode(nodes[index])
-			return self.findRecent(operation, data)
+			if !self.removeNode(nodes[index], future.Error) {
+				return
+			}
+			return self.findRecent(operation, data, redundancy)
 		}
 		if result == nil || result.Timestamp < results[index].Timestamp {
 			result = results[index]
@@ -274,6 +470,49 @@ func (self *Conn) findRecent(operation string, data common.Item) (result *common
 	}
 	return
 }
+func (self *Conn) findRecents(operation string, data []common.Item, redundancy int) (result []common.Item) {
+	result = make([]common.Item, len(data))
+	copy(result, data)
+	groups := make(map[string][]common.Item)
+	indices := make(map[string][]int)
+	replicas := make(map[string]common.Remotes)
+	for index, item := range data {
+		// Keys owned by different positions of the same node can still have different replicas.
+		nodes := self.replicas(item.Key, redundancy)
+		group := nodes.Describe()
+		groups[group] = append(groups[group], item)
+		indices[group] = append(indices[group], index)
+		replicas[group] = nodes
+	}
+	var futures []*rpc.Call
+	var results []*[]common.Item
+	var owners []string
+	var nodes common.Remotes
+	for group, items := range groups {
+		for _, node := range replicas[group] {
+			var thisResult []common.Item
+			nodes = append(nodes, node)
+			results = append(results, &thisResult)
+			owners = append(owners, group)
+			futures = append(futures, node.Go(operation, items, &thisResult))
+		}
+	}
+	for index, future := range futures {
+		<-future.Done
+		if future.Error != nil {
+			if !self.removeNode(nodes[index], future.Error) {
+				return
+			}
+			return self.findRecents(operation, data, redundancy)
+		}
+		for position, item := range *results[index] {
+			if found := &result[indices[owners[index]][position]]; found.Timestamp < item.Timestamp {
+				*found = item
+			}
+		}
+	}
+	return
+}
 func (self *Conn) consume(c chan [2][]byte, wait *sync.WaitGroup, successor *common.Remote) {
 	for pair := range c {
 		self.putVia(successor, pair[0], pair[1], false)
@@ -311,9 +550,9 @@ func (self *Conn) subDump(key []byte, c chan [2][]byte, wait *sync.WaitGroup) {
 // Clear will remove all data from all currently known database nodes.
 func (self *Conn) Clear() {
 	var x int
-	for _, node := range self.ring.Nodes() {
+	for _, node := range self.ring.Hosts() {
 		if err := node.Call("DHash.Clear", 0, &x); err != nil {
-			self.removeNode(node)
+			self.removeNode(node, err)
 		}
 	}
 }
@@ -338,6 +577,63 @@ func (self *Conn) Put(key, value []byte) {
 	self.put(key, value, false)
 }
 
+// SSubPutTTL will put value under subKey in the sub tree defined by key, and make it expire after ttl.
+func (self *Conn) SSubPutTTL(key, subKey, value []byte, ttl time.Duration) {
+	self.subPutTTL(key, subKey, value, ttl, true)
+}
+
+// SubPutTTL will put value under subKey in the sub tree defined by key, and make it expire after ttl.
+func (self *Conn) SubPutTTL(key, subKey, value []byte, ttl time.Duration) {
+	self.subPutTTL(key, subKey, value, ttl, false)
+}
+
+// SPutTTL will put value under key, and make it expire after ttl.
+func (self *Conn) SPutTTL(key, value []byte, ttl time.Duration) {
+	self.putTTL(key, value, ttl, true)
+}
+
+// PutTTL will put value under key, and make it expire after ttl.
+// The expiry is measured by the synchronized clock of the database, and the key will disappear from all replicas at the same time.
+// Get stops returning the value as soon as it expires, while sizes, slices and iterations can include it until its owner removes it, which happens about once a second.
+func (self *Conn) PutTTL(key, value []byte, ttl time.Duration) {
+	self.putTTL(key, value, ttl, false)
+}
+
+// SBatch will perform ops like Batch, but will not return until all replicas have received them.
+func (self *Conn) SBatch(ops []common.BatchOp) (results []common.BatchResult) {
+	return self.batch(ops, true)
+}
+
+// Batch will group ops by the node owning their keys, and send each group to its owner in a single call.
+// The operations are performed in the order given for each owner, and the results are returned in the same order as ops.
+// Groups whose owner fails are resent to the new owner, and the results of resent ops never report Existed.
+func (self *Conn) Batch(ops []common.BatchOp) (results []common.BatchResult) {
+	return self.batch(ops, false)
+}
+
+// Transact will perform ops so that either all or none of them are applied, even if their keys are owned by different nodes.
+// The owner of the first key coordinates the transaction, see dhash.Node#Transact. If nothing was applied, an error telling why is returned.
+func (self *Conn) Transact(ops []common.BatchOp) (err error) {
+	if len(ops) == 0 {
+		return
+	}
+	_, _, successor := self.ring.Remotes(ops[0].Key)
+	var result common.TransactionResult
+	if err = successor.Call("DHash.Transact", common.Transaction{Ops: ops}, &result); err != nil {
+		if common.IsAccessDenied(err) {
+			return
+		}
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.Transact(ops)
+	}
+	if !result.Committed {
+		err = fmt.Errorf("%v", result.Error)
+	}
+	return
+}
+
 // Dump will return a channel to send multiple key/value pairs through. When finished, close the channel and #Wait for the *sync.WaitGroup.
 func (self *Conn) Dump() (c chan [2][]byte, wait *sync.WaitGroup) {
 	wait = new(sync.WaitGroup)
@@ -395,7 +691,9 @@ func (self *Conn) MirrorReverseIndexOf(key, subKey []byte) (index int, existed b
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
 	if err := successor.Call("DHash.MirrorReverseIndexOf", data, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.MirrorReverseIndexOf(key, subKey)
 	}
 	index, existed = result.N, result.Existed
@@ -411,13 +709,36 @@ func (self *Conn) MirrorIndexOf(key, subKey []byte) (index int, existed bool) {
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
 	if err := successor.Call("DHash.MirrorIndexOf", data, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.MirrorIndexOf(key, subKey)
 	}
 	index, existed = result.N, result.Existed
 	return
 }
 
+// IndexIndexOf will return the index of the first entry with indexKey in the secondary index named name of the sub tree defined by key.
+//
+// Secondary indexes are defined by using SubAddConfiguration to set 'index.NAME' to 'bytes', 'bytes:FROM:TO', 'int64' or 'float64', see radix.Tree for details.
+func (self *Conn) IndexIndexOf(key []byte, name string, indexKey []byte) (index int, existed bool) {
+	r := common.Range{
+		Key:  key,
+		Name: name,
+		Min:  indexKey,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Index
+	if err := successor.Call("DHash.IndexIndexOf", r, &result); err != nil {
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.IndexIndexOf(key, name, indexKey)
+	}
+	index, existed = result.N, result.Existed
+	return
+}
+
 // ReverseIndexOf will return the the distance from the end for subKey, looking at the sub tree defined by key.
 func (self *Conn) ReverseIndexOf(key, subKey []byte) (index int, existed bool) {
 	data := common.Item{
@@ -427,7 +748,9 @@ func (self *Conn) ReverseIndexOf(key, subKey []byte) (index int, existed bool) {
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
 	if err := successor.Call("DHash.ReverseIndexOf", data, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.ReverseIndexOf(key, subKey)
 	}
 	index, existed = result.N, result.Existed
@@ -443,7 +766,9 @@ func (self *Conn) IndexOf(key, subKey []byte) (index int, existed bool) {
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
 	if err := successor.Call("DHash.IndexOf", data, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.IndexOf(key, subKey)
 	}
 	index, existed = result.N, result.Existed
@@ -457,17 +782,19 @@ func (self *Conn) Next(key []byte) (nextKey, nextValue []byte, existed bool) {
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
-	firstAddr := successor.Addr
+	first := *successor
 	for {
 		if err := successor.Call("DHash.Next", data, result); err != nil {
-			self.removeNode(*successor)
+			if !self.removeNode(*successor, err) {
+				return
+			}
 			return self.Next(key)
 		}
 		if result.Exists {
 			break
 		}
 		_, _, successor = self.ring.Remotes(successor.Pos)
-		if successor.Addr == firstAddr {
+		if successor.Equal(first) {
 			break
 		}
 	}
@@ -482,17 +809,19 @@ func (self *Conn) Prev(key []byte) (prevKey, prevValue []byte, existed bool) {
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
-	firstAddr := successor.Addr
+	first := *successor
 	for {
 		if err := successor.Call("DHash.Prev", data, result); err != nil {
-			self.removeNode(*successor)
+			if !self.removeNode(*successor, err) {
+				return
+			}
 			return self.Prev(key)
 		}
 		if result.Exists {
 			break
 		}
 		successor, _, _ = self.ring.Remotes(successor.Pos)
-		if successor.Addr == firstAddr {
+		if successor.Equal(first) {
 			break
 		}
 	}
@@ -511,7 +840,9 @@ func (self *Conn) MirrorCount(key, min, max []byte, mininc, maxinc bool) (result
 	}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.MirrorCount", r, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.MirrorCount(key, min, max, mininc, maxinc)
 	}
 	return
@@ -528,12 +859,370 @@ func (self *Conn) Count(key, min, max []byte, mininc, maxinc bool) (result int)
 	}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.Count", r, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.Count(key, min, max, mininc, maxinc)
 	}
 	return
 }
 
+func (self *Conn) aggregate(a common.Aggregate) (value []byte, count int, err error) {
+	_, _, successor := self.ring.Remotes(a.Key)
+	var result common.AggregateResult
+	if err = successor.Call("DHash.Aggregate", a, &result); err != nil {
+		if common.IsAccessDenied(err) {
+			return
+		}
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.aggregate(a)
+	}
+	if result.Error != "" {
+		err = fmt.Errorf("%v", result.Error)
+	}
+	value, count = result.Value, result.Count
+	return
+}
+func indexAggregate(key []byte, min, max *int, typ int, merge setop.SetOpMerge) (result common.Aggregate) {
+	result = common.Aggregate{
+		Key:     key,
+		Type:    typ,
+		Merge:   merge,
+		ByIndex: true,
+		ToEnd:   max == nil,
+	}
+	if min != nil {
+		result.MinIndex = *min
+	}
+	if max != nil {
+		result.MaxIndex = *max
+	}
+	return
+}
+
+// Aggregate will compute an aggregate of type typ (common.AggregateSum, common.AggregateMin, common.AggregateMax, common.AggregateAvg or common.AggregateMerge)
+// over the values between min and max in the sub tree defined by key, decoding them as defined by merge. See common.Aggregate for details.
+// It returns the encoded result and the number of values aggregated.
+// A min of nil will aggregate from the start. A max of nil will aggregate to the end.
+func (self *Conn) Aggregate(key, min, max []byte, mininc, maxinc bool, typ int, merge setop.SetOpMerge) (value []byte, count int, err error) {
+	a := common.Aggregate{
+		Key:    key,
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+		Type:   typ,
+		Merge:  merge,
+	}
+	return self.aggregate(a)
+}
+
+// IndexAggregate will compute an aggregate of type typ over the values between the min'th and the max'th entry of the sub tree defined by key, decoding them as defined by merge.
+// A min of nil will aggregate from the start. A max of nil will aggregate to the end.
+func (self *Conn) IndexAggregate(key []byte, min, max *int, typ int, merge setop.SetOpMerge) (value []byte, count int, err error) {
+	return self.aggregate(indexAggregate(key, min, max, typ, merge))
+}
+
+// MirrorAggregate will compute an aggregate of type typ over the keys between min and max in the mirror tree of the sub tree defined by key,
+// in other words over the values of the sub tree between min and max, decoding them as defined by merge.
+// A min of nil will aggregate from the start. A max of nil will aggregate to the end.
+func (self *Conn) MirrorAggregate(key, min, max []byte, mininc, maxinc bool, typ int, merge setop.SetOpMerge) (value []byte, count int, err error) {
+	a := common.Aggregate{
+		Key:    key,
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+		Type:   typ,
+		Merge:  merge,
+		Mirror: true,
+	}
+	return self.aggregate(a)
+}
+
+// MirrorIndexAggregate will compute an aggregate of type typ over the keys between the min'th and the max'th entry of the mirror tree of the sub tree defined by key,
+// decoding them as defined by merge.
+// A min of nil will aggregate from the start. A max of nil will aggregate to the end.
+func (self *Conn) MirrorIndexAggregate(key []byte, min, max *int, typ int, merge setop.SetOpMerge) (value []byte, count int, err error) {
+	a := indexAggregate(key, min, max, typ, merge)
+	a.Mirror = true
+	return self.aggregate(a)
+}
+
+// ownerRanges returns the nodes responsible for the top level keys in r, in key order, along with the part of r each of them is responsible for.
+// The node responsible for the keys before the first position is also responsible for the keys after the last position, and will appear twice if needed.
+func (self *Conn) ownerRanges(r common.Range) (nodes common.Remotes, ranges []common.Range) {
+	ring := self.ring.Nodes()
+	if len(ring) == 0 {
+		return
+	}
+	var from []byte
+	for _, node := range ring {
+		if owned, ok := r.Within(from, node.Pos); ok {
+			nodes, ranges = append(nodes, node), append(ranges, owned)
+		}
+		from = node.Pos
+	}
+	if owned, ok := r.Within(from, nil); ok {
+		nodes, ranges = append(nodes, ring[0]), append(ranges, owned)
+	}
+	return
+}
+
+// topSlice will call operation on the owner of each part of r, in key order if up and in reverse key order otherwise, until it has r.Len items if r.Len is set.
+func (self *Conn) topSlice(operation string, r common.Range, up bool) (result []common.Item) {
+	nodes, ranges := self.ownerRanges(r)
+	var results []*[]common.Item
+	found := 0
+	for i := 0; i < len(nodes) && (r.Len == 0 || found < r.Len); i++ {
+		index := i
+		if !up {
+			index = len(nodes) - 1 - i
+		}
+		var items []common.Item
+		if err := nodes[index].Call(operation, ranges[index], &items); err != nil {
+			if !self.removeNode(nodes[index], err) {
+				return
+			}
+			return self.topSlice(operation, r, up)
+		}
+		results = append(results, &items)
+		found += len(items)
+	}
+	if len(results) > 0 {
+		result = common.MergeItems(results, up)
+	}
+	if r.Len > 0 && len(result) > r.Len {
+		result = result[:r.Len]
+	}
+	return
+}
+
+// TopSlice will return the byte values between min and max among the top level keys, asking each node responsible for a part of them.
+// A min of nil will return from the start. A max of nil will return to the end.
+func (self *Conn) TopSlice(min, max []byte, mininc, maxinc bool) (result []common.Item) {
+	r := common.Range{
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+	}
+	return self.topSlice("DHash.TopSlice", r, true)
+}
+
+// TopReverseSlice will return the byte values between min and max among the top level keys, in reverse order.
+// A min of nil will return to the start. A max of nil will return from the end.
+func (self *Conn) TopReverseSlice(min, max []byte, mininc, maxinc bool) (result []common.Item) {
+	r := common.Range{
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+	}
+	return self.topSlice("DHash.TopReverseSlice", r, false)
+}
+
+// TopSliceLen will return at most maxRes byte values after min among the top level keys, asking only as many nodes as needed.
+// A min of nil will return from the start.
+func (self *Conn) TopSliceLen(min []byte, mininc bool, maxRes int) (result []common.Item) {
+	if maxRes < 1 {
+		return
+	}
+	r := common.Range{
+		Min:    min,
+		MinInc: mininc,
+		Len:    maxRes,
+	}
+	return self.topSlice("DHash.TopSliceLen", r, true)
+}
+
+// TopReverseSliceLen will return at most maxRes byte values before max among the top level keys, in reverse order, asking only as many nodes as needed.
+// A max of nil will return from the end.
+func (self *Conn) TopReverseSliceLen(max []byte, maxinc bool, maxRes int) (result []common.Item) {
+	if maxRes < 1 {
+		return
+	}
+	r := common.Range{
+		Max:    max,
+		MaxInc: maxinc,
+		Len:    maxRes,
+	}
+	return self.topSlice("DHash.TopReverseSliceLen", r, false)
+}
+
+// TopCount will return the number of byte values between min and max among the top level keys.
+// A min of nil will count from the start. A max of nil will count to the end.
+func (self *Conn) TopCount(min, max []byte, mininc, maxinc bool) (result int) {
+	r := common.Range{
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+	}
+	nodes, ranges := self.ownerRanges(r)
+	var count int
+	for index, node := range nodes {
+		count = 0
+		if err := node.Call("DHash.TopCount", ranges[index], &count); err != nil {
+			if !self.removeNode(node, err) {
+				return
+			}
+			return self.TopCount(min, max, mininc, maxinc)
+		}
+		result += count
+	}
+	return
+}
+
+// prefixNodes returns the nodes responsible for the keys starting with prefix, in ring order starting with the successor of prefix.
+// Nodes with several positions responsible for parts of the keys are only included once.
+func (self *Conn) prefixNodes(prefix []byte) (result common.Remotes) {
+	end := common.PrefixEnd(prefix)
+	seen := make(map[string]bool)
+	_, _, node := self.ring.Remotes(prefix)
+	for steps := self.ring.Size(); node != nil && steps > 0; steps-- {
+		if !seen[node.Addr] {
+			seen[node.Addr] = true
+			result = append(result, *node)
+		}
+		if (end != nil && bytes.Compare(node.Pos, end) > -1) || bytes.Compare(node.Pos, prefix) < 0 {
+			break
+		}
+		next := self.ring.Successor(*node)
+		node = &next
+	}
+	return
+}
+func (self *Conn) prefixDelete(prefix []byte, sync bool) (result int) {
+	data := common.Item{
+		Key:  prefix,
+		Sync: sync,
+	}
+	var deleted int
+	for _, node := range self.prefixNodes(prefix) {
+		deleted = 0
+		if err := node.Call("DHash.PrefixDelete", data, &deleted); err != nil {
+			if !self.removeNode(node, err) {
+				return
+			}
+			return result + self.prefixDelete(prefix, sync)
+		}
+		result += deleted
+	}
+	return
+}
+func (self *Conn) subPrefixDelete(key, prefix []byte, sync bool) (result int) {
+	data := common.Item{
+		Key:    key,
+		SubKey: prefix,
+		Sync:   sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.SubPrefixDelete", data, &result); err != nil {
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.subPrefixDelete(key, prefix, sync)
+	}
+	return
+}
+
+// PrefixSlice will return all byte values with keys starting with prefix, asking each node responsible for a part of them.
+func (self *Conn) PrefixSlice(prefix []byte) (result []common.Item) {
+	data := common.Item{
+		Key: prefix,
+	}
+	nodes := self.prefixNodes(prefix)
+	results := make([]*[]common.Item, len(nodes))
+	for index, node := range nodes {
+		var items []common.Item
+		if err := node.Call("DHash.PrefixSlice", data, &items); err != nil {
+			if !self.removeNode(node, err) {
+				return
+			}
+			return self.PrefixSlice(prefix)
+		}
+		results[index] = &items
+	}
+	if len(results) > 0 {
+		result = common.MergeItems(results, true)
+	}
+	return
+}
+
+// PrefixCount will return the number of byte values with keys starting with prefix, asking each node responsible for a part of them.
+func (self *Conn) PrefixCount(prefix []byte) (result int) {
+	data := common.Item{
+		Key: prefix,
+	}
+	var count int
+	for _, node := range self.prefixNodes(prefix) {
+		count = 0
+		if err := node.Call("DHash.PrefixCount", data, &count); err != nil {
+			if !self.removeNode(node, err) {
+				return
+			}
+			return self.PrefixCount(prefix)
+		}
+		result += count
+	}
+	return
+}
+
+// PrefixDelete will remove all byte values with keys starting with prefix, and return how many there were.
+func (self *Conn) PrefixDelete(prefix []byte) (deleted int) {
+	return self.prefixDelete(prefix, false)
+}
+
+// SPrefixDelete will remove all byte values with keys starting with prefix, and return how many there were.
+func (self *Conn) SPrefixDelete(prefix []byte) (deleted int) {
+	return self.prefixDelete(prefix, true)
+}
+
+// SubPrefixSlice will return all values with sub keys starting with prefix in the sub tree defined by key.
+func (self *Conn) SubPrefixSlice(key, prefix []byte) (result []common.Item) {
+	data := common.Item{
+		Key:    key,
+		SubKey: prefix,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.SubPrefixSlice", data, &result); err != nil {
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.SubPrefixSlice(key, prefix)
+	}
+	return
+}
+
+// SubPrefixCount will return the number of values with sub keys starting with prefix in the sub tree defined by key.
+func (self *Conn) SubPrefixCount(key, prefix []byte) (result int) {
+	data := common.Item{
+		Key:    key,
+		SubKey: prefix,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.SubPrefixCount", data, &result); err != nil {
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.SubPrefixCount(key, prefix)
+	}
+	return
+}
+
+// SubPrefixDelete will remove all values with sub keys starting with prefix from the sub tree defined by key, and return how many there were.
+func (self *Conn) SubPrefixDelete(key, prefix []byte) (deleted int) {
+	return self.subPrefixDelete(key, prefix, false)
+}
+
+// SSubPrefixDelete will remove all values with sub keys starting with prefix from the sub tree defined by key, and return how many there were.
+func (self *Conn) SSubPrefixDelete(key, prefix []byte) (deleted int) {
+	return self.subPrefixDelete(key, prefix, true)
+}
+
 // MirrorNextIndex will return the key, value and index of the first key after index in the mirror tree of the sub tree defined by key.
 func (self *Conn) MirrorNextIndex(key []byte, index int) (foundKey, foundValue []byte, foundIndex int, existed bool) {
 	data := common.Item{
@@ -543,7 +1232,9 @@ func (self *Conn) MirrorNextIndex(key []byte, index int) (foundKey, foundValue [
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.MirrorNextIndex", data, result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.MirrorNextIndex(key, index)
 	}
 	foundKey, foundValue, foundIndex, existed = result.Key, result.Value, result.Index, result.Exists
@@ -559,7 +1250,9 @@ func (self *Conn) MirrorPrevIndex(key []byte, index int) (foundKey, foundValue [
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.MirrorPrevIndex", data, result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.MirrorNextIndex(key, index)
 	}
 	foundKey, foundValue, foundIndex, existed = result.Key, result.Value, result.Index, result.Exists
@@ -575,7 +1268,9 @@ func (self *Conn) NextIndex(key []byte, index int) (foundKey, foundValue []byte,
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.NextIndex", data, result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.NextIndex(key, index)
 	}
 	foundKey, foundValue, foundIndex, existed = result.Key, result.Value, result.Index, result.Exists
@@ -591,7 +1286,9 @@ func (self *Conn) PrevIndex(key []byte, index int) (foundKey, foundValue []byte,
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.PrevIndex", data, result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.NextIndex(key, index)
 	}
 	foundKey, foundValue, foundIndex, existed = result.Key, result.Value, result.Index, result.Exists
@@ -616,7 +1313,7 @@ func (self *Conn) MirrorReverseSliceIndex(key []byte, min, max *int) (result []c
 		MinInc:   min != nil,
 		MaxInc:   max != nil,
 	}
-	result = self.mergeRecent("DHash.MirrorReverseSliceIndex", r, false)
+	result = self.mergeMirrorRecent("DHash.MirrorReverseSliceIndex", r, false)
 	return
 }
 
@@ -638,7 +1335,7 @@ func (self *Conn) MirrorSliceIndex(key []byte, min, max *int) (result []common.I
 		MinInc:   min != nil,
 		MaxInc:   max != nil,
 	}
-	result = self.mergeRecent("DHash.MirrorSliceIndex", r, true)
+	result = self.mergeMirrorRecent("DHash.MirrorSliceIndex", r, true)
 	return
 }
 
@@ -652,7 +1349,7 @@ func (self *Conn) MirrorReverseSlice(key, min, max []byte, mininc, maxinc bool)
 		MinInc: mininc,
 		MaxInc: maxinc,
 	}
-	result = self.mergeRecent("DHash.MirrorReverseSlice", r, false)
+	result = self.mergeMirrorRecent("DHash.MirrorReverseSlice", r, false)
 	return
 }
 
@@ -666,7 +1363,30 @@ func (self *Conn) MirrorSlice(key, min, max []byte, mininc, maxinc bool) (result
 		MinInc: mininc,
 		MaxInc: maxinc,
 	}
-	result = self.mergeRecent("DHash.MirrorSlice", r, true)
+	result = self.mergeMirrorRecent("DHash.MirrorSlice", r, true)
+	return
+}
+
+// IndexSlice will return the entries between min and max in the secondary index named name of the sub tree defined by key.
+// The keys of the returned items are index keys, and the values are the sub keys they were derived from.
+//
+// Since many sub keys can share the same index key, the entries are fetched from the owner of key alone instead of being merged from all replicas.
+func (self *Conn) IndexSlice(key []byte, name string, min, max []byte, mininc, maxinc bool) (result []common.Item) {
+	r := common.Range{
+		Key:    key,
+		Name:   name,
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.IndexSlice", r, &result); err != nil {
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.IndexSlice(key, name, min, max, mininc, maxinc)
+	}
 	return
 }
 
@@ -679,7 +1399,7 @@ func (self *Conn) MirrorSliceLen(key, min []byte, mininc bool, maxRes int) (resu
 		MinInc: mininc,
 		Len:    maxRes,
 	}
-	result = self.mergeRecent("DHash.MirrorSliceLen", r, true)
+	result = self.mergeMirrorRecent("DHash.MirrorSliceLen", r, true)
 	return
 }
 
@@ -692,7 +1412,7 @@ func (self *Conn) MirrorReverseSliceLen(key, max []byte, maxinc bool, maxRes int
 		MaxInc: maxinc,
 		Len:    maxRes,
 	}
-	result = self.mergeRecent("DHash.MirrorReverseSliceLen", r, false)
+	result = self.mergeMirrorRecent("DHash.MirrorReverseSliceLen", r, false)
 	return
 }
 
@@ -800,7 +1520,7 @@ func (self *Conn) SubMirrorPrev(key, subKey []byte) (prevKey, prevValue []byte,
 		Key:    key,
 		SubKey: subKey,
 	}
-	result := self.findRecent("DHash.SubMirrorPrev", data)
+	result := self.findRecent("DHash.SubMirrorPrev", data, self.subRedundancy(key))
 	prevKey, prevValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -811,7 +1531,7 @@ func (self *Conn) SubMirrorNext(key, subKey []byte) (nextKey, nextValue []byte,
 		Key:    key,
 		SubKey: subKey,
 	}
-	result := self.findRecent("DHash.SubMirrorNext", data)
+	result := self.findRecent("DHash.SubMirrorNext", data, self.subRedundancy(key))
 	nextKey, nextValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -822,7 +1542,7 @@ func (self *Conn) SubPrev(key, subKey []byte) (prevKey, prevValue []byte, existe
 		Key:    key,
 		SubKey: subKey,
 	}
-	result := self.findRecent("DHash.SubPrev", data)
+	result := self.findRecent("DHash.SubPrev", data, self.subRedundancy(key))
 	prevKey, prevValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -833,7 +1553,7 @@ func (self *Conn) SubNext(key, subKey []byte) (nextKey, nextValue []byte, existe
 		Key:    key,
 		SubKey: subKey,
 	}
-	result := self.findRecent("DHash.SubNext", data)
+	result := self.findRecent("DHash.SubNext", data, self.subRedundancy(key))
 	nextKey, nextValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -843,7 +1563,7 @@ func (self *Conn) MirrorLast(key []byte) (lastKey, lastValue []byte, existed boo
 	data := common.Item{
 		Key: key,
 	}
-	result := self.findRecent("DHash.MirrorLast", data)
+	result := self.findRecent("DHash.MirrorLast", data, self.subRedundancy(key))
 	lastKey, lastValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -853,7 +1573,7 @@ func (self *Conn) MirrorFirst(key []byte) (firstKey, firstValue []byte, existed
 	data := common.Item{
 		Key: key,
 	}
-	result := self.findRecent("DHash.MirrorFirst", data)
+	result := self.findRecent("DHash.MirrorFirst", data, self.subRedundancy(key))
 	firstKey, firstValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -863,7 +1583,7 @@ func (self *Conn) Last(key []byte) (lastKey, lastValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
 	}
-	result := self.findRecent("DHash.Last", data)
+	result := self.findRecent("DHash.Last", data, self.subRedundancy(key))
 	lastKey, lastValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -873,7 +1593,7 @@ func (self *Conn) First(key []byte) (firstKey, firstValue []byte, existed bool)
 	data := common.Item{
 		Key: key,
 	}
-	result := self.findRecent("DHash.First", data)
+	result := self.findRecent("DHash.First", data, self.subRedundancy(key))
 	firstKey, firstValue, existed = result.Key, result.Value, result.Exists
 	return
 }
@@ -884,7 +1604,7 @@ func (self *Conn) SubGet(key, subKey []byte) (value []byte, existed bool) {
 		Key:    key,
 		SubKey: subKey,
 	}
-	result := self.findRecent("DHash.SubGet", data)
+	result := self.findRecent("DHash.SubGet", data, self.subRedundancy(key))
 	if result.Value != nil {
 		value, existed = result.Value, result.Exists
 	} else {
@@ -898,7 +1618,7 @@ func (self *Conn) Get(key []byte) (value []byte, existed bool) {
 	data := common.Item{
 		Key: key,
 	}
-	result := self.findRecent("DHash.Get", data)
+	result := self.findRecent("DHash.Get", data, self.redundancy())
 	if result.Value != nil {
 		value, existed = result.Value, result.Exists
 	} else {
@@ -907,6 +1627,158 @@ func (self *Conn) Get(key []byte) (value []byte, existed bool) {
 	return
 }
 
+// SubMGet will return the values under subKeys in the sub tree defined by key, in the same order as subKeys.
+// Sub keys without values will have Exists set to false in the result.
+func (self *Conn) SubMGet(key []byte, subKeys [][]byte) (result []common.Item) {
+	data := make([]common.Item, len(subKeys))
+	for index, subKey := range subKeys {
+		data[index] = common.Item{
+			Key:    key,
+			SubKey: subKey,
+		}
+	}
+	return self.findRecents("DHash.SubMGet", data, self.subRedundancy(key))
+}
+
+// MGet will return the values under keys, in the same order as keys.
+// The keys are fetched from all their owners in parallel, and keys without values will have Exists set to false in the result.
+func (self *Conn) MGet(keys [][]byte) (result []common.Item) {
+	data := make([]common.Item, len(keys))
+	for index, key := range keys {
+		data[index] = common.Item{
+			Key: key,
+		}
+	}
+	return self.findRecents("DHash.MGet", data, self.redundancy())
+}
+
+// SubGetTimestamp will return the value and timestamp under subKey in the sub tree defined by key.
+// The timestamp can be used with SubCompareAndSwapTimestamp.
+func (self *Conn) SubGetTimestamp(key, subKey []byte) (value []byte, timestamp int64, existed bool) {
+	data := common.Item{
+		Key:    key,
+		SubKey: subKey,
+	}
+	result := self.findRecent("DHash.SubGet", data, self.subRedundancy(key))
+	value, timestamp, existed = result.Value, result.Timestamp, result.Exists
+	return
+}
+
+// GetTimestamp will return the value and timestamp under key.
+// The timestamp can be used with CompareAndSwapTimestamp.
+func (self *Conn) GetTimestamp(key []byte) (value []byte, timestamp int64, existed bool) {
+	data := common.Item{
+		Key: key,
+	}
+	result := self.findRecent("DHash.Get", data, self.redundancy())
+	value, timestamp, existed = result.Value, result.Timestamp, result.Exists
+	return
+}
+
+// SubCompareAndSwap will put value under subKey in the sub tree defined by key if the current value is expected, or if expected is nil and there is no current value.
+// The comparison is made by the owner of key, and the new value is replicated before SubCompareAndSwap returns.
+// If the swap fails, the current value is returned.
+func (self *Conn) SubCompareAndSwap(key, subKey, expected, value []byte) (current []byte, swapped bool) {
+	result := self.compareAndSwap("DHash.SubCompareAndSwap", common.CAS{
+		Key:            key,
+		SubKey:         subKey,
+		Value:          value,
+		Expected:       expected,
+		ExpectedExists: expected != nil,
+	})
+	current, swapped = result.Value, result.Swapped
+	return
+}
+
+// CompareAndSwap will put value under key if the current value is expected, or if expected is nil and there is no current value.
+// The comparison is made by the owner of key, and the new value is replicated before CompareAndSwap returns.
+// If the swap fails, the current value is returned.
+func (self *Conn) CompareAndSwap(key, expected, value []byte) (current []byte, swapped bool) {
+	result := self.compareAndSwap("DHash.CompareAndSwap", common.CAS{
+		Key:            key,
+		Value:          value,
+		Expected:       expected,
+		ExpectedExists: expected != nil,
+	})
+	current, swapped = result.Value, result.Swapped
+	return
+}
+
+// SubCompareAndSwapTimestamp will put value under subKey in the sub tree defined by key if the current timestamp is expectedTimestamp.
+// If the swap fails, the current value and timestamp are returned, otherwise the new ones.
+func (self *Conn) SubCompareAndSwapTimestamp(key, subKey []byte, expectedTimestamp int64, value []byte) (current []byte, timestamp int64, swapped bool) {
+	result := self.compareAndSwap("DHash.SubCompareAndSwap", common.CAS{
+		Key:               key,
+		SubKey:            subKey,
+		Value:             value,
+		ExpectedTimestamp: expectedTimestamp,
+	})
+	current, timestamp, swapped = result.Value, result.Timestamp, result.Swapped
+	return
+}
+
+// CompareAndSwapTimestamp will put value under key if the current timestamp is expectedTimestamp, as returned by GetTimestamp.
+// If the swap fails, the current value and timestamp are returned, otherwise the new ones.
+func (self *Conn) CompareAndSwapTimestamp(key []byte, expectedTimestamp int64, value []byte) (current []byte, timestamp int64, swapped bool) {
+	result := self.compareAndSwap("DHash.CompareAndSwap", common.CAS{
+		Key:               key,
+		Value:             value,
+		ExpectedTimestamp: expectedTimestamp,
+	})
+	current, timestamp, swapped = result.Value, result.Timestamp, result.Swapped
+	return
+}
+
+// SSubIncr will atomically add delta to the common.EncodeInt64 encoded number under subKey in the sub tree defined by key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) SSubIncr(key, subKey []byte, delta int64) (result int64, err error) {
+	return self.incrInt("DHash.SubIncr", common.Incr{Key: key, SubKey: subKey, Delta: delta, Sync: true})
+}
+
+// SubIncr will atomically add delta to the common.EncodeInt64 encoded number under subKey in the sub tree defined by key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) SubIncr(key, subKey []byte, delta int64) (result int64, err error) {
+	return self.incrInt("DHash.SubIncr", common.Incr{Key: key, SubKey: subKey, Delta: delta})
+}
+
+// SIncr will atomically add delta to the common.EncodeInt64 encoded number under key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) SIncr(key []byte, delta int64) (result int64, err error) {
+	return self.incrInt("DHash.Incr", common.Incr{Key: key, Delta: delta, Sync: true})
+}
+
+// Incr will atomically add delta to the common.EncodeInt64 encoded number under key, and return the result.
+// Missing numbers are considered to be 0.
+//
+// The addition is made by the owner of key, and an error is returned if the current value is not a number.
+func (self *Conn) Incr(key []byte, delta int64) (result int64, err error) {
+	return self.incrInt("DHash.Incr", common.Incr{Key: key, Delta: delta})
+}
+
+// SSubIncrFloat will atomically add delta to the common.EncodeFloat64 encoded number under subKey in the sub tree defined by key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) SSubIncrFloat(key, subKey []byte, delta float64) (result float64, err error) {
+	return self.incrFloat("DHash.SubIncr", common.Incr{Key: key, SubKey: subKey, FloatDelta: delta, Sync: true})
+}
+
+// SubIncrFloat will atomically add delta to the common.EncodeFloat64 encoded number under subKey in the sub tree defined by key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) SubIncrFloat(key, subKey []byte, delta float64) (result float64, err error) {
+	return self.incrFloat("DHash.SubIncr", common.Incr{Key: key, SubKey: subKey, FloatDelta: delta})
+}
+
+// SIncrFloat will atomically add delta to the common.EncodeFloat64 encoded number under key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) SIncrFloat(key []byte, delta float64) (result float64, err error) {
+	return self.incrFloat("DHash.Incr", common.Incr{Key: key, FloatDelta: delta, Sync: true})
+}
+
+// IncrFloat will atomically add delta to the common.EncodeFloat64 encoded number under key, and return the result.
+// Missing numbers are considered to be 0.
+func (self *Conn) IncrFloat(key []byte, delta float64) (result float64, err error) {
+	return self.incrFloat("DHash.Incr", common.Incr{Key: key, FloatDelta: delta})
+}
+
 // DescribeTree will return a string representation of the complete tree in the node at pos.
 // Used for debug purposes, don't do it on big databases!
 func (self *Conn) DescribeTree(pos []byte) (result string, err error) {
@@ -923,7 +1795,7 @@ func (self *Conn) DescribeTree(pos []byte) (result string, err error) {
 // Used for debug purposes, don't do it on big databases!
 func (self *Conn) DescribeAllTrees() string {
 	buf := new(bytes.Buffer)
-	for _, rem := range self.ring.Nodes() {
+	for _, rem := range self.ring.Hosts() {
 		if res, err := self.DescribeTree(rem.Pos); err == nil {
 			fmt.Fprintln(buf, res)
 		}
@@ -933,7 +1805,7 @@ func (self *Conn) DescribeAllTrees() string {
 
 // DescribeAllNodes will return the description structures of all known nodes.
 func (self *Conn) DescribeAllNodes() (result []common.DHashDescription) {
-	for _, rem := range self.ring.Nodes() {
+	for _, rem := range self.ring.Hosts() {
 		if res, err := self.DescribeNode(rem.Pos); err == nil {
 			result = append(result, res)
 		}
@@ -956,7 +1828,9 @@ func (self *Conn) DescribeNode(pos []byte) (result common.DHashDescription, err
 func (self *Conn) SubSize(key []byte) (result int) {
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.SubSize", key, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.SubSize(key)
 	}
 	return
@@ -965,9 +1839,11 @@ func (self *Conn) SubSize(key []byte) (result int) {
 // Size will return the total size of all known nodes.
 func (self *Conn) Size() (result int) {
 	var tmp int
-	for _, node := range self.ring.Nodes() {
+	for _, node := range self.ring.Hosts() {
 		if err := node.Call("DHash.Size", 0, &tmp); err != nil {
-			self.removeNode(node)
+			if !self.removeNode(node, err) {
+				return
+			}
 			return self.Size()
 		}
 		result += tmp
@@ -993,29 +1869,60 @@ func (self *Conn) SetExpression(expr setop.SetExpression) (result []setop.SetOpR
 	if expr.Op == nil {
 		expr.Op = setop.MustParse(expr.Code)
 	}
-	var biggestKey []byte
+	biggestKey := self.biggestKey(expr.Op)
+	_, _, successor := self.ring.Remotes(biggestKey)
+	var results []setop.SetOpResult
+	err := successor.Call("DHash.SetExpression", expr, &results)
+	for err != nil {
+		if !self.removeNode(*successor, err) {
+			return nil
+		}
+		_, _, successor = self.ring.Remotes(biggestKey)
+		err = successor.Call("DHash.SetExpression", expr, &results)
+	}
+	return results
+}
+
+// biggestKey returns the key of the biggest sub tree used by op.
+func (self *Conn) biggestKey(op *setop.SetOp) (result []byte) {
 	biggestSize := 0
 	var thisSize int
 
-	for key, _ := range findKeys(expr.Op) {
+	for key, _ := range findKeys(op) {
 		thisSize = self.SubSize([]byte(key))
-		if biggestKey == nil {
-			biggestKey = []byte(key)
+		if result == nil {
+			result = []byte(key)
 			biggestSize = thisSize
 		} else if thisSize > biggestSize {
-			biggestKey = []byte(key)
+			result = []byte(key)
 			biggestSize = thisSize
 		}
 	}
+	return
+}
+
+// DistributedSetExpression will execute the given expr like SetExpression, but let the nodes owning the sub trees in it do as much of the work as possible.
+//
+// Sub expressions whose sub trees are all owned by one node are executed by that node, and all sub trees are read in growing batches that stop at expr.Max.
+// See dhash.Node.DistributedSetExpression for details.
+//
+// result.Results will contain the results unless expr.Dest is set, and result.Sources how many calls were made to each source.
+func (self *Conn) DistributedSetExpression(expr setop.SetExpression) (result common.SetExpressionResult) {
+	if expr.Op == nil {
+		expr.Op = setop.MustParse(expr.Code)
+	}
+	biggestKey := self.biggestKey(expr.Op)
 	_, _, successor := self.ring.Remotes(biggestKey)
-	var results []setop.SetOpResult
-	err := successor.Call("DHash.SetExpression", expr, &results)
+	err := successor.Call("DHash.DistributedSetExpression", expr, &result)
 	for err != nil {
-		self.removeNode(*successor)
+		result = common.SetExpressionResult{}
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		_, _, successor = self.ring.Remotes(biggestKey)
-		err = successor.Call("DHash.SetExpression", expr, &results)
+		err = successor.Call("DHash.DistributedSetExpression", expr, &result)
 	}
-	return results
+	return
 }
 
 // Configuration will return the configuration for the entire cluster.
@@ -1024,7 +1931,9 @@ func (self *Conn) Configuration() (conf map[string]string) {
 	var result common.Conf
 	_, _, successor := self.ring.Remotes(nil)
 	if err := successor.Call("DHash.Configuration", 0, &result); err != nil {
-		self.removeNode(*successor)
+		if !self.removeNode(*successor, err) {
+			return
+		}
 		return self.Configuration()
 	}
 	return result.Data
@@ -1033,18 +1942,24 @@ func (self *Conn) Configuration() (conf map[string]string) {
 // SubConfiguratino will return the configuration for the sub tree defined by key.
 //
 // mirrored=yes means that the sub tree is currently mirrored.
+//
+// view=EXPRESSION means that the sub tree is a materialized view of the set expression EXPRESSION.
 func (self *Conn) SubConfiguration(key []byte) (conf map[string]string) {
 	var result common.Conf
-	_, _, successor := self.ring.Remotes(nil)
+	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.SubConfiguration", key, &result); err != nil {
-		self.removeNode(*successor)
-		return self.Configuration()
+		if !self.removeNode(*successor, err) {
+			return
+		}
+		return self.SubConfiguration(key)
 	}
 	return result.Data
 }
 
 // AddConfiguration will set a key and value to the cluster configuration.
-// Not internally used for anything right now.
+//
+// To change how many copies are kept of byte values, and of sub trees without a redundancy of their own, set redundancy=NUMBER.
+// To go back to the redundancy of the ring, set redundancy to the empty string.
 func (self *Conn) AddConfiguration(key, value string) {
 	conf := common.ConfItem{
 		Key:   key,
@@ -1053,24 +1968,35 @@ func (self *Conn) AddConfiguration(key, value string) {
 	_, _, successor := self.ring.Remotes(nil)
 	var x int
 	if err := successor.Call("DHash.AddConfiguration", conf, &x); err != nil {
-		self.removeNode(*successor)
-		self.AddConfiguration(key, value)
+		if self.removeNode(*successor, err) {
+			self.AddConfiguration(key, value)
+		}
 	}
 }
 
 // SubAddConfiguration will set a key and value to the configuration of the sub tree defined by key.
 //
 // To mirror a sub tree, set mirrored=yes. To turn off mirroring of a sub tree, set mirrored!=yes.
-func (self *Conn) SubAddConfiguration(treeKey []byte, key, value string) {
+//
+// To make a sub tree a materialized view of a set expression, set view=EXPRESSION. The Append merge function can not be used in views.
+// To stop maintaining the view, set view to the empty string. The sub tree will keep its current contents.
+//
+// To change how many copies are kept of a sub tree, set redundancy=NUMBER. This is useful both for caches that can do with one copy and for critical data that needs more copies than the rest.
+// To make the sub tree follow the cluster configuration again, set redundancy to the empty string.
+//
+// An error is returned if the configuration is denied or invalid, for example a view expression that can not be parsed.
+func (self *Conn) SubAddConfiguration(treeKey []byte, key, value string) (err error) {
 	conf := common.ConfItem{
 		TreeKey: treeKey,
 		Key:     key,
 		Value:   value,
 	}
-	_, _, successor := self.ring.Remotes(nil)
+	_, _, successor := self.ring.Remotes(treeKey)
 	var x int
-	if err := successor.Call("DHash.SubAddConfiguration", conf, &x); err != nil {
-		self.removeNode(*successor)
-		self.AddConfiguration(key, value)
+	if err = successor.Call("DHash.SubAddConfiguration", conf, &x); err != nil {
+		if self.removeNode(*successor, err) {
+			return self.SubAddConfiguration(treeKey, key, value)
+		}
 	}
+	return
 }
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"github.com/zond/god/common"
+)
+
+const (
+	// DefaultPageSize is the number of entries a Cursor fetches at a time, unless told otherwise using Cursor#PageSize.
+	DefaultPageSize = 1000
+)
+
+// Cursor iterates over a range of a sub tree, or its mirror tree, fetching it a page at a time using SliceLen and friends
+// so that the range never has to fit in memory at once.
+//
+// Each page starts where the last one ended, so a Cursor survives node failures the same way the slice methods do, and will see the state
+// of the range as it is when each page is fetched.
+//
+// Since mirror trees can contain the same key many times, mirror Cursors remember the key and value pairs they have returned for the current key,
+// and will fetch larger pages when needed to get past them.
+type Cursor struct {
+	conn     *Conn
+	key      []byte
+	min      []byte
+	max      []byte
+	mininc   bool
+	maxinc   bool
+	reverse  bool
+	mirror   bool
+	pageSize int
+	page     []common.Item
+	pos      int
+	started  bool
+	done     bool
+	last     []byte
+	seen     map[string]map[string]bool
+	current  common.Item
+}
+
+func (self *Conn) newCursor(key, min, max []byte, mininc, maxinc, reverse, mirror bool) *Cursor {
+	return &Cursor{
+		conn:     self,
+		key:      key,
+		min:      min,
+		max:      max,
+		mininc:   mininc,
+		maxinc:   maxinc,
+		reverse:  reverse,
+		mirror:   mirror,
+		pageSize: DefaultPageSize,
+	}
+}
+
+// Cursor will return a Cursor over the range between min and max in the sub tree defined by key.
+// A min of nil will start at the start. A max of nil will continue to the end.
+func (self *Conn) Cursor(key, min, max []byte, mininc, maxinc bool) *Cursor {
+	return self.newCursor(key, min, max, mininc, maxinc, false, false)
+}
+
+// ReverseCursor will return a Cursor over the range between min and max in the sub tree defined by key, in reverse order.
+// A max of nil will start at the end. A min of nil will continue to the start.
+func (self *Conn) ReverseCursor(key, min, max []byte, mininc, maxinc bool) *Cursor {
+	return self.newCursor(key, min, max, mininc, maxinc, true, false)
+}
+
+// MirrorCursor will return a Cursor over the range between min and max in the mirror tree of the sub tree defined by key.
+// A min of nil will start at the start. A max of nil will continue to the end.
+func (self *Conn) MirrorCursor(key, min, max []byte, mininc, maxinc bool) *Cursor {
+	return self.newCursor(key, min, max, mininc, maxinc, false, true)
+}
+
+// MirrorReverseCursor will return a Cursor over the range between min and max in the mirror tree of the sub tree defined by key, in reverse order.
+// A max of nil will start at the end. A min of nil will continue to the start.
+func (self *Conn) MirrorReverseCursor(key, min, max []byte, mininc, maxinc bool) *Cursor {
+	return self.newCursor(key, min, max, mininc, maxinc, true, true)
+}
+
+// PageSize will make the Cursor fetch n entries at a time.
+func (self *Cursor) PageSize(n int) *Cursor {
+	if n > 0 {
+		self.pageSize = n
+	}
+	return self
+}
+
+// Next will move the Cursor to the next entry, and return whether there was one.
+func (self *Cursor) Next() bool {
+	for self.pos >= len(self.page) {
+		if self.done {
+			return false
+		}
+		self.fetch()
+	}
+	self.current = self.page[self.pos]
+	self.pos++
+	if self.mirror {
+		if self.seen == nil {
+			self.seen = make(map[string]map[string]bool)
+		}
+		values, found := self.seen[string(self.current.Key)]
+		if !found {
+			values = make(map[string]bool)
+			self.seen[string(self.current.Key)] = values
+		}
+		values[string(self.current.Value)] = true
+	}
+	self.started, self.last = true, self.current.Key
+	return true
+}
+
+// Item will return the entry the Cursor is at. For mirror Cursors the Key is the value in the sub tree, and the Value is the key.
+func (self *Cursor) Item() common.Item {
+	return self.current
+}
+
+// Close will stop the Cursor and forget the rest of the current page. Next will return false after Close.
+func (self *Cursor) Close() {
+	self.page, self.pos, self.done, self.seen = nil, 0, true, nil
+}
+
+// Each will call f with each remaining entry of the Cursor, until f returns false or there are no more entries.
+func (self *Cursor) Each(f func(item common.Item) bool) {
+	defer self.Close()
+	for self.Next() {
+		if !f(self.current) {
+			return
+		}
+	}
+}
+
+// fetch will fetch the next page, dropping the entries already returned and the entries outside the range.
+func (self *Cursor) fetch() {
+	from, inc := self.min, self.mininc
+	if self.reverse {
+		from, inc = self.max, self.maxinc
+	}
+	var seen map[string]bool
+	if self.started {
+		from, inc = self.last, self.mirror
+		if self.mirror {
+			// The next page starts at the last key, so only the pairs returned for it can show up again.
+			seen = self.seen[string(self.last)]
+			self.seen = map[string]map[string]bool{string(self.last): seen}
+		}
+	}
+	n := self.pageSize + len(seen)
+	var items []common.Item
+	switch {
+	case self.mirror && self.reverse:
+		items = self.conn.MirrorReverseSliceLen(self.key, from, inc, n)
+	case self.mirror:
+		items = self.conn.MirrorSliceLen(self.key, from, inc, n)
+	case self.reverse:
+		items = self.conn.ReverseSliceLen(self.key, from, inc, n)
+	default:
+		items = self.conn.SliceLen(self.key, from, inc, n)
+	}
+	self.done = len(items) < n
+	self.page, self.pos = make([]common.Item, 0, len(items)), 0
+	for _, item := range items {
+		if seen[string(item.Value)] && bytes.Compare(item.Key, self.last) == 0 {
+			continue
+		}
+		if !self.inRange(item.Key) {
+			self.done = true
+			break
+		}
+		self.page = append(self.page, item)
+	}
+}
+
+// inRange returns whether key is before the end of the range.
+func (self *Cursor) inRange(key []byte) bool {
+	if self.reverse {
+		if self.min == nil {
+			return true
+		}
+		cmp := bytes.Compare(key, self.min)
+		return cmp > 0 || (cmp == 0 && self.mininc)
+	}
+	if self.max == nil {
+		return true
+	}
+	cmp := bytes.Compare(key, self.max)
+	return cmp < 0 || (cmp == 0 && self.maxinc)
+}
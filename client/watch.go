@@ -0,0 +1,121 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"sync"
+	"sync/atomic"
+)
+
+// Watcher delivers the events of a subscription to changes in the database.
+//
+// Events from each node arrive in the order they happened on that node, but events from different nodes are not ordered relative to each other.
+//
+// If an Events has Lost set, events were dropped (because the Watcher was too slow to read them, or because a node failed) and
+// anything watched may have changed without notice.
+type Watcher struct {
+	Events chan common.Events
+	conn   *Conn
+	watch  common.Watch
+	state  int32
+	lock   *sync.Mutex
+	nodes  map[string]bool
+	wait   *sync.WaitGroup
+}
+
+func (self *Watcher) hasState(s int32) bool {
+	return atomic.LoadInt32(&self.state) == s
+}
+func (self *Watcher) follow(nodes common.Remotes) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.hasState(started) {
+		return
+	}
+	for _, node := range nodes {
+		if !self.nodes[node.Addr] {
+			self.nodes[node.Addr] = true
+			self.wait.Add(1)
+			go self.poll(node)
+		}
+	}
+}
+func (self *Watcher) forget(node common.Remote) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.nodes, node.Addr)
+}
+func (self *Watcher) deliver(events common.Events) {
+	if self.hasState(started) && (events.Lost || len(events.Events) > 0) {
+		self.Events <- events
+	}
+}
+func (self *Watcher) poll(node common.Remote) {
+	defer self.wait.Done()
+	var id int64
+	var x int
+	var err error
+	for self.hasState(started) {
+		if id == 0 {
+			if err = node.Call("DHash.Subscribe", self.watch, &id); err != nil {
+				break
+			}
+		}
+		var events common.Events
+		if err = node.Call("DHash.Poll", id, &events); err != nil {
+			break
+		}
+		if events.Unknown {
+			id = 0
+			events.Lost = true
+		}
+		self.deliver(events)
+	}
+	if self.hasState(started) {
+		self.forget(node)
+		self.deliver(common.Events{Lost: true})
+		self.conn.removeNode(node, err)
+	} else if id != 0 {
+		node.Call("DHash.Unsubscribe", id, &x)
+	}
+}
+
+// Close will stop this Watcher and remove its subscriptions. Events will be closed when all subscriptions are removed.
+func (self *Watcher) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if atomic.CompareAndSwapInt32(&self.state, started, stopped) {
+		go func() {
+			self.wait.Wait()
+			close(self.Events)
+		}()
+		go func() {
+			for _ = range self.Events {
+			}
+		}()
+	}
+}
+
+// Watch will subscribe to the changes defined by w on all nodes in the cluster, including nodes that join later.
+// The returned Watcher must be closed when no longer used.
+//
+// Only changes made through the API are delivered, not values disappearing due to expiry.
+func (self *Conn) Watch(w common.Watch) (result *Watcher) {
+	result = &Watcher{
+		Events: make(chan common.Events),
+		conn:   self,
+		watch:  w,
+		state:  started,
+		lock:   new(sync.Mutex),
+		nodes:  make(map[string]bool),
+		wait:   new(sync.WaitGroup),
+	}
+	self.ring.AddChangeListener(func(ring *common.Ring) bool {
+		if !result.hasState(started) {
+			return false
+		}
+		result.follow(ring.Nodes())
+		return true
+	})
+	result.follow(self.ring.Nodes())
+	return
+}
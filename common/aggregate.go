@@ -0,0 +1,279 @@
+package common
+
+import (
+	"fmt"
+	"github.com/zond/setop"
+	"math/big"
+)
+
+const (
+	AggregateSum = iota
+	AggregateMin
+	AggregateMax
+	AggregateAvg
+	AggregateMerge
+)
+
+const (
+	bytesValues = iota
+	integerValues
+	floatValues
+	bigIntValues
+)
+
+// Aggregate is a computation over the values in a range of the sub tree under Key, or over the keys in a range of its mirror tree if Mirror.
+//
+// The range is between Min and Max, including them if MinInc and MaxInc, with nil meaning the start or the end.
+// If ByIndex the range is instead between the MinIndex'th and the MaxIndex'th entry, or between the MinIndex'th entry and the end if ToEnd.
+//
+// Merge is one of the merge functions of setop, and defines how the values are decoded: the Integer merges decode them using DecodeInt64,
+// the Float merges using DecodeFloat64 and the BigInt merges using DecodeBigInt. ConCat, First and Last use them as they are.
+//
+// Type AggregateMerge combines the values using Merge, the same way a set expression combines the values of a key present in several sets.
+// AggregateSum, AggregateMin, AggregateMax and AggregateAvg need numbers, and only use Merge to decode them.
+// The result of AggregateAvg is always encoded using EncodeFloat64, the others are encoded the same way as the values.
+type Aggregate struct {
+	Key      []byte
+	Min      []byte
+	Max      []byte
+	MinInc   bool
+	MaxInc   bool
+	ByIndex  bool
+	MinIndex int
+	MaxIndex int
+	ToEnd    bool
+	Type     int
+	Merge    setop.SetOpMerge
+	Mirror   bool
+}
+
+// AggregateResult is the outcome of an Aggregate. Count is the number of values aggregated, and Value is nil if there were none.
+// If a value could not be decoded or combined, or the Aggregate was invalid, Error will describe why.
+type AggregateResult struct {
+	Value []byte
+	Count int
+	Error string
+}
+
+// Aggregator computes an Aggregate one value at a time.
+type Aggregator struct {
+	aggregate Aggregate
+	values    int
+	count     int
+	integer   int64
+	float     float64
+	bigInt    *big.Int
+	bytes     []byte
+	err       error
+}
+
+// Aggregator returns a new Aggregator for this Aggregate.
+func (self Aggregate) Aggregator() (result *Aggregator) {
+	result = &Aggregator{aggregate: self}
+	switch self.Merge {
+	case setop.IntegerSum, setop.IntegerDiv, setop.IntegerMul:
+		result.values = integerValues
+	case setop.FloatSum, setop.FloatDiv, setop.FloatMul:
+		result.values = floatValues
+	case setop.BigIntAnd, setop.BigIntAdd, setop.BigIntAndNot, setop.BigIntDiv, setop.BigIntMod, setop.BigIntMul, setop.BigIntOr, setop.BigIntRem, setop.BigIntXor:
+		result.values = bigIntValues
+	case setop.ConCat, setop.First, setop.Last:
+		result.values = bytesValues
+	default:
+		result.err = fmt.Errorf("Merge function %v can not be used to aggregate", self.Merge)
+	}
+	switch self.Type {
+	case AggregateMerge:
+	case AggregateSum, AggregateMin, AggregateMax, AggregateAvg:
+		if result.values == bytesValues && result.err == nil {
+			result.err = fmt.Errorf("Aggregate type %v needs numbers, but merge function %v does not decode them", self.Type, self.Merge)
+		}
+	default:
+		result.err = fmt.Errorf("Unknown aggregate type: %v", self.Type)
+	}
+	return
+}
+
+// Add will add value to the aggregate, and return whether it could be decoded and combined with the values before it.
+// After Add has returned false it will ignore all values, and Result will describe the problem.
+func (self *Aggregator) Add(value []byte) bool {
+	if self.err != nil {
+		return false
+	}
+	switch self.values {
+	case integerValues:
+		i, err := DecodeInt64(value)
+		if err != nil {
+			self.err = fmt.Errorf("Unable to decode %v as an int64: %v", value, err)
+			return false
+		}
+		self.addInteger(i)
+	case floatValues:
+		f, err := DecodeFloat64(value)
+		if err != nil {
+			self.err = fmt.Errorf("Unable to decode %v as a float64: %v", value, err)
+			return false
+		}
+		self.addFloat(f)
+	case bigIntValues:
+		self.addBigInt(DecodeBigInt(value))
+	default:
+		self.addBytes(value)
+	}
+	if self.err != nil {
+		return false
+	}
+	self.count++
+	return true
+}
+func (self *Aggregator) addInteger(i int64) {
+	if self.count == 0 {
+		self.integer = i
+		return
+	}
+	switch self.aggregate.Type {
+	case AggregateSum, AggregateAvg:
+		self.integer += i
+	case AggregateMin:
+		self.integer = Min64(self.integer, i)
+	case AggregateMax:
+		self.integer = Max64(self.integer, i)
+	default:
+		switch self.aggregate.Merge {
+		case setop.IntegerSum:
+			self.integer += i
+		case setop.IntegerMul:
+			self.integer *= i
+		case setop.IntegerDiv:
+			if i == 0 {
+				self.err = fmt.Errorf("Division by zero")
+				return
+			}
+			self.integer /= i
+		}
+	}
+}
+func (self *Aggregator) addFloat(f float64) {
+	if self.count == 0 {
+		self.float = f
+		return
+	}
+	switch self.aggregate.Type {
+	case AggregateSum, AggregateAvg:
+		self.float += f
+	case AggregateMin:
+		if f < self.float {
+			self.float = f
+		}
+	case AggregateMax:
+		if f > self.float {
+			self.float = f
+		}
+	default:
+		switch self.aggregate.Merge {
+		case setop.FloatSum:
+			self.float += f
+		case setop.FloatMul:
+			self.float *= f
+		case setop.FloatDiv:
+			if f == 0 {
+				self.err = fmt.Errorf("Division by zero")
+				return
+			}
+			self.float /= f
+		}
+	}
+}
+func (self *Aggregator) addBigInt(b *big.Int) {
+	if self.count == 0 {
+		self.bigInt = b
+		return
+	}
+	switch self.aggregate.Type {
+	case AggregateSum, AggregateAvg:
+		self.bigInt.Add(self.bigInt, b)
+	case AggregateMin:
+		if b.Cmp(self.bigInt) < 0 {
+			self.bigInt = b
+		}
+	case AggregateMax:
+		if b.Cmp(self.bigInt) > 0 {
+			self.bigInt = b
+		}
+	default:
+		switch self.aggregate.Merge {
+		case setop.BigIntDiv, setop.BigIntMod, setop.BigIntRem:
+			if b.Sign() == 0 {
+				self.err = fmt.Errorf("Division by zero")
+				return
+			}
+		}
+		switch self.aggregate.Merge {
+		case setop.BigIntAnd:
+			self.bigInt.And(self.bigInt, b)
+		case setop.BigIntAdd:
+			self.bigInt.Add(self.bigInt, b)
+		case setop.BigIntAndNot:
+			self.bigInt.AndNot(self.bigInt, b)
+		case setop.BigIntDiv:
+			self.bigInt.Div(self.bigInt, b)
+		case setop.BigIntMod:
+			self.bigInt.Mod(self.bigInt, b)
+		case setop.BigIntMul:
+			self.bigInt.Mul(self.bigInt, b)
+		case setop.BigIntOr:
+			self.bigInt.Or(self.bigInt, b)
+		case setop.BigIntRem:
+			self.bigInt.Rem(self.bigInt, b)
+		case setop.BigIntXor:
+			self.bigInt.Xor(self.bigInt, b)
+		}
+	}
+}
+func (self *Aggregator) addBytes(b []byte) {
+	if self.count == 0 {
+		self.bytes = append([]byte{}, b...)
+		return
+	}
+	switch self.aggregate.Merge {
+	case setop.ConCat:
+		self.bytes = append(self.bytes, b...)
+	case setop.Last:
+		self.bytes = append([]byte{}, b...)
+	}
+}
+
+// Result returns the aggregate of the values added so far.
+func (self *Aggregator) Result() (result AggregateResult) {
+	result.Count = self.count
+	if self.err != nil {
+		result.Error = self.err.Error()
+		return
+	}
+	if self.count == 0 {
+		return
+	}
+	if self.aggregate.Type == AggregateAvg {
+		switch self.values {
+		case integerValues:
+			result.Value = EncodeFloat64(float64(self.integer) / float64(self.count))
+		case floatValues:
+			result.Value = EncodeFloat64(self.float / float64(self.count))
+		default:
+			avg, _ := new(big.Rat).SetFrac(self.bigInt, big.NewInt(int64(self.count))).Float64()
+			result.Value = EncodeFloat64(avg)
+		}
+		return
+	}
+	switch self.values {
+	case integerValues:
+		result.Value = EncodeInt64(self.integer)
+	case floatValues:
+		result.Value = EncodeFloat64(self.float)
+	case bigIntValues:
+		result.Value = EncodeBigInt(self.bigInt)
+	default:
+		result.Value = self.bytes
+	}
+	return
+}
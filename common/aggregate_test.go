@@ -0,0 +1,65 @@
+package common
+
+import (
+	"bytes"
+	"github.com/zond/setop"
+	"math/big"
+	"testing"
+)
+
+func aggregateAll(a Aggregate, values ...[]byte) AggregateResult {
+	aggregator := a.Aggregator()
+	for _, value := range values {
+		aggregator.Add(value)
+	}
+	return aggregator.Result()
+}
+
+func TestAggregate(t *testing.T) {
+	ints := [][]byte{EncodeInt64(4), EncodeInt64(-2), EncodeInt64(10)}
+	floats := [][]byte{EncodeFloat64(1.5), EncodeFloat64(-0.5), EncodeFloat64(2)}
+	bigs := [][]byte{EncodeBigInt(big.NewInt(12)), EncodeBigInt(big.NewInt(10)), EncodeBigInt(big.NewInt(7))}
+	for _, c := range []struct {
+		aggregate Aggregate
+		values    [][]byte
+		expected  []byte
+	}{
+		{Aggregate{Type: AggregateSum, Merge: setop.IntegerSum}, ints, EncodeInt64(12)},
+		{Aggregate{Type: AggregateMin, Merge: setop.IntegerMul}, ints, EncodeInt64(-2)},
+		{Aggregate{Type: AggregateMax, Merge: setop.IntegerSum}, ints, EncodeInt64(10)},
+		{Aggregate{Type: AggregateAvg, Merge: setop.IntegerSum}, ints, EncodeFloat64(4)},
+		{Aggregate{Type: AggregateMerge, Merge: setop.IntegerMul}, ints, EncodeInt64(-80)},
+		{Aggregate{Type: AggregateMerge, Merge: setop.IntegerDiv}, [][]byte{EncodeInt64(100), EncodeInt64(5), EncodeInt64(2)}, EncodeInt64(10)},
+		{Aggregate{Type: AggregateSum, Merge: setop.FloatSum}, floats, EncodeFloat64(3)},
+		{Aggregate{Type: AggregateMin, Merge: setop.FloatSum}, floats, EncodeFloat64(-0.5)},
+		{Aggregate{Type: AggregateAvg, Merge: setop.FloatSum}, floats, EncodeFloat64(1)},
+		{Aggregate{Type: AggregateMerge, Merge: setop.FloatMul}, floats, EncodeFloat64(-1.5)},
+		{Aggregate{Type: AggregateSum, Merge: setop.BigIntAnd}, bigs, EncodeBigInt(big.NewInt(29))},
+		{Aggregate{Type: AggregateMax, Merge: setop.BigIntAnd}, bigs, EncodeBigInt(big.NewInt(12))},
+		{Aggregate{Type: AggregateMerge, Merge: setop.BigIntAnd}, bigs, EncodeBigInt(big.NewInt(0))},
+		{Aggregate{Type: AggregateMerge, Merge: setop.BigIntOr}, bigs, EncodeBigInt(big.NewInt(15))},
+		{Aggregate{Type: AggregateMerge, Merge: setop.ConCat}, [][]byte{[]byte("a"), []byte("b")}, []byte("ab")},
+		{Aggregate{Type: AggregateMerge, Merge: setop.Last}, [][]byte{[]byte("a"), []byte("b")}, []byte("b")},
+		{Aggregate{Type: AggregateSum, Merge: setop.IntegerSum}, nil, nil},
+	} {
+		result := aggregateAll(c.aggregate, c.values...)
+		if result.Error != "" || result.Count != len(c.values) || bytes.Compare(result.Value, c.expected) != 0 {
+			t.Errorf("%+v of %v should be %v but was %+v", c.aggregate, c.values, c.expected, result)
+		}
+	}
+	for _, c := range []struct {
+		aggregate Aggregate
+		values    [][]byte
+	}{
+		{Aggregate{Type: AggregateSum, Merge: setop.IntegerSum}, [][]byte{EncodeInt64(1), []byte("x")}},
+		{Aggregate{Type: AggregateMerge, Merge: setop.IntegerDiv}, [][]byte{EncodeInt64(1), EncodeInt64(0)}},
+		{Aggregate{Type: AggregateMerge, Merge: setop.FloatDiv}, [][]byte{EncodeFloat64(1), EncodeFloat64(0)}},
+		{Aggregate{Type: AggregateSum, Merge: setop.ConCat}, [][]byte{[]byte("a")}},
+		{Aggregate{Type: AggregateMerge, Merge: setop.Append}, [][]byte{[]byte("a")}},
+		{Aggregate{Type: 17, Merge: setop.IntegerSum}, [][]byte{EncodeInt64(1)}},
+	} {
+		if result := aggregateAll(c.aggregate, c.values...); result.Error == "" {
+			t.Errorf("%+v of %v should fail but gave %+v", c.aggregate, c.values, result)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	accessDenied     = "Access denied"
+	handshakeTimeout = time.Second * 10
+	maxTokenLength   = 1 << 12
+	tokenAccepted    = 1
+	tokenRejected    = 0
+	// tokenMagic starts every token handshake. A net/rpc connection never starts with a zero byte, since gob never sends empty messages,
+	// so connections without a token can skip the handshake.
+	tokenMagic = 0
+)
+
+// AccessDenied returns an error telling that access was denied because of the formatted message.
+func AccessDenied(format string, args ...interface{}) error {
+	return fmt.Errorf("%v: %v", accessDenied, fmt.Sprintf(format, args...))
+}
+
+// IsAccessDenied returns whether err was created by AccessDenied, even if it was transported by net/rpc.
+func IsAccessDenied(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), accessDenied)
+}
+
+// TokenHash returns the hex encoded SHA-256 of token, which is what the configuration of a cluster contains instead of the tokens themselves.
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return HexEncode(sum[:])
+}
+
+// bufferedConn is a net.Conn reading through a bufio.Reader, so that nothing peeked at is lost.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (self bufferedConn) Read(b []byte) (int, error) {
+	return self.reader.Read(b)
+}
+
+// SendToken will present token to the node at the other end of conn, and return an error unless it is accepted.
+// Connections without a token do not need to call it, since nodes treat them as presenting the empty token.
+func SendToken(conn net.Conn, token string) (err error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	buf := make([]byte, 1+binary.MaxVarintLen64+len(token))
+	buf[0] = tokenMagic
+	n := 1 + binary.PutUvarint(buf[1:], uint64(len(token)))
+	n += copy(buf[n:], token)
+	if _, err = conn.Write(buf[:n]); err != nil {
+		return
+	}
+	reply := make([]byte, 1)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return
+	}
+	if reply[0] != tokenAccepted {
+		err = AccessDenied("%v rejected the token", conn.RemoteAddr())
+	}
+	return
+}
+
+// ReceiveToken will read the token presented by the other end of conn, and return it along with a function to reply whether it was accepted.
+// If the other end did not start with a handshake, token is empty and reply is nil. result is the connection to keep using instead of conn.
+func ReceiveToken(conn net.Conn) (result net.Conn, token string, reply func(accepted bool) error, err error) {
+	reader := bufio.NewReader(conn)
+	result = bufferedConn{
+		Conn:   conn,
+		reader: reader,
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	var first []byte
+	if first, err = reader.Peek(1); err != nil {
+		return
+	}
+	if first[0] != tokenMagic {
+		conn.SetDeadline(time.Time{})
+		return
+	}
+	reader.ReadByte()
+	var l uint64
+	if l, err = binary.ReadUvarint(reader); err != nil {
+		return
+	}
+	if l > maxTokenLength {
+		err = fmt.Errorf("Token length %v is too big", l)
+		return
+	}
+	b := make([]byte, l)
+	if _, err = io.ReadFull(reader, b); err != nil {
+		return
+	}
+	token = string(b)
+	reply = func(accepted bool) (err error) {
+		defer conn.SetDeadline(time.Time{})
+		response := []byte{tokenRejected}
+		if accepted {
+			response[0] = tokenAccepted
+		}
+		_, err = conn.Write(response)
+		return
+	}
+	return
+}
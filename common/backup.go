@@ -0,0 +1,51 @@
+package common
+
+const (
+	BackupConf = iota
+	BackupSubConf
+	BackupValue
+	BackupSubValue
+	BackupEnd
+)
+
+// BackupVersion is the version of the backup format written by this version of god.
+const BackupVersion = 1
+
+// BackupHeader starts every backup, and contains the version of the format and the cluster time when the backup started.
+type BackupHeader struct {
+	Version int
+	Time    int64
+}
+
+// BackupRecord is one piece of data in a backup. Type is one of:
+//
+// BackupConf: Conf is the configuration of the whole cluster.
+//
+// BackupSubConf: Conf is the configuration of the sub tree under Key.
+//
+// BackupValue: Value is the byte value under Key.
+//
+// BackupSubValue: Value is the byte value under SubKey in the sub tree under Key.
+//
+// BackupEnd: the backup is complete.
+//
+// Timestamp and Expiry are absolute times of the cluster clock, like the Expiry of an Item.
+type BackupRecord struct {
+	Type      int
+	Key       []byte
+	SubKey    []byte
+	Value     []byte
+	Timestamp int64
+	Expiry    int64
+	Conf      map[string]string
+}
+
+// BackupRange defines a page of the data owned by a node. If Started, the page starts right after the last value record of the previous page,
+// defined by Key (and SubKey if Sub), otherwise it starts at the beginning of the owned data. Len is the max number of value records in the page.
+type BackupRange struct {
+	Key     []byte
+	SubKey  []byte
+	Sub     bool
+	Started bool
+	Len     int
+}
@@ -0,0 +1,34 @@
+package common
+
+const (
+	BatchPut = iota
+	BatchDel
+	BatchSubPut
+	BatchSubDel
+)
+
+// BatchOp is one write operation in a Batch. Type is one of BatchPut, BatchDel, BatchSubPut or BatchSubDel.
+// Expiry is only used when restoring backups, and is ignored in batches sent by clients.
+// TTL is the number of nodes, including the receiving one, the op has left to be replicated to, since different trees can be kept in different numbers of copies.
+type BatchOp struct {
+	Type      int
+	Key       []byte
+	SubKey    []byte
+	Value     []byte
+	Timestamp int64
+	Expiry    int64
+	TTL       int
+}
+
+// Batch is a number of write operations sent to one node in a single call.
+type Batch struct {
+	Ops  []BatchOp
+	TTL  int
+	Sync bool
+}
+
+// BatchResult is the outcome of one BatchOp. Existed tells whether there was a value under the key before the operation.
+type BatchResult struct {
+	Existed bool
+	Error   string
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"bytes"
+)
+
+// CAS is a compare-and-swap operation, where Value will only be put under Key (or SubKey in the sub tree under Key) if the current data matches the expectation.
+//
+// If ExpectedTimestamp is not 0, the current timestamp must be equal to it. Otherwise the current value must exist and be equal to Expected if ExpectedExists,
+// or not exist if !ExpectedExists.
+type CAS struct {
+	Key               []byte
+	SubKey            []byte
+	Value             []byte
+	Expected          []byte
+	ExpectedExists    bool
+	ExpectedTimestamp int64
+}
+
+// Matches returns whether the given current data matches the expectation of this CAS.
+func (self CAS) Matches(value []byte, timestamp int64, exists bool) bool {
+	if self.ExpectedTimestamp != 0 {
+		return timestamp == self.ExpectedTimestamp
+	}
+	if exists != self.ExpectedExists {
+		return false
+	}
+	return !exists || bytes.Compare(value, self.Expected) == 0
+}
+
+// CASResult contains the outcome of a CAS. If Swapped, Value and Timestamp are the newly put data, otherwise they are the data that did not match.
+type CASResult struct {
+	Swapped   bool
+	Value     []byte
+	Timestamp int64
+	Exists    bool
+}
@@ -187,15 +187,45 @@ func BetweenIE(needle, fromInc, toExc []byte) (result bool) {
 	return
 }
 
+// PrefixEnd returns the first key after all keys starting with prefix, or nil if there is no such key (when prefix is empty or only 0xff bytes).
+func PrefixEnd(prefix []byte) (result []byte) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			result = make([]byte, i+1)
+			copy(result, prefix)
+			result[i]++
+			return
+		}
+	}
+	return
+}
+
 // MergeItems will merge the given slices of Items into a slice with the newest version of each Item, with regard to their keys.
 func MergeItems(arys []*[]Item, up bool) (result []Item) {
+	return mergeItems(arys, up, func(a, b Item) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+}
+
+// MergeMirrorItems will merge the given slices of Items from mirror trees into a slice with the newest version of each Item, with regard to their keys and values.
+// Since mirror trees can contain the same key many times, only Items with both the same key and the same value are considered versions of each other.
+func MergeMirrorItems(arys []*[]Item, up bool) (result []Item) {
+	return mergeItems(arys, up, func(a, b Item) int {
+		if cmp := bytes.Compare(a.Key, b.Key); cmp != 0 {
+			return cmp
+		}
+		return bytes.Compare(a.Value, b.Value)
+	})
+}
+
+func mergeItems(arys []*[]Item, up bool, compare func(a, b Item) int) (result []Item) {
 	result = *arys[0]
 	var items []Item
 	for j := 1; j < len(arys); j++ {
 		items = *arys[j]
 		for _, item := range items {
 			i := sort.Search(len(result), func(i int) bool {
-				cmp := bytes.Compare(item.Key, result[i].Key)
+				cmp := compare(item, result[i])
 				if up {
 					return cmp < 1
 				}
@@ -204,7 +234,7 @@ func MergeItems(arys []*[]Item, up bool) (result []Item) {
 			if i == len(result) {
 				result = append(result, item)
 			} else {
-				if bytes.Compare(result[i].Key, item.Key) == 0 {
+				if compare(result[i], item) == 0 {
 					if result[i].Timestamp < item.Timestamp {
 						result[i] = item
 					}
@@ -221,6 +251,7 @@ func MergeItems(arys []*[]Item, up bool) (result []Item) {
 type DHashDescription struct {
 	Addr         string
 	Pos          []byte
+	Zone         string
 	LastReroute  time.Time
 	LastSync     time.Time
 	LastMigrate  time.Time
@@ -236,6 +267,7 @@ func (self DHashDescription) Describe() string {
 	return fmt.Sprintf("%+v", struct {
 		Addr         string
 		Pos          string
+		Zone         string
 		LastReroute  time.Time
 		LastSync     time.Time
 		LastMigrate  time.Time
@@ -247,6 +279,7 @@ func (self DHashDescription) Describe() string {
 	}{
 		Addr:         self.Addr,
 		Pos:          HexEncode(self.Pos),
+		Zone:         self.Zone,
 		LastReroute:  self.LastReroute,
 		LastSync:     self.LastSync,
 		LastMigrate:  self.LastMigrate,
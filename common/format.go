@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// The formats the command line tools use to convert between their string arguments and the byte slices of the database.
+const (
+	StringFormat = "string"
+	FloatFormat  = "float"
+	IntFormat    = "int"
+	BigFormat    = "big"
+	HexFormat    = "hex"
+)
+
+var Formats = []string{StringFormat, FloatFormat, IntFormat, BigFormat, HexFormat}
+
+// EncodeFormat will encode s, written in format, to the byte slice it represents.
+func EncodeFormat(format, s string) (result []byte, err error) {
+	switch format {
+	case StringFormat:
+		result = []byte(s)
+	case FloatFormat:
+		var f float64
+		if f, err = strconv.ParseFloat(s, 64); err == nil {
+			result = EncodeFloat64(f)
+		}
+	case IntFormat:
+		var i int64
+		if i, err = strconv.ParseInt(s, 10, 64); err == nil {
+			result = EncodeInt64(i)
+		}
+	case BigFormat:
+		b, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			err = fmt.Errorf("Bad BigInt format: %v", s)
+			return
+		}
+		result = EncodeBigInt(b)
+	case HexFormat:
+		result, err = hex.DecodeString(s)
+	default:
+		err = fmt.Errorf("Unknown encoding: %v", format)
+	}
+	return
+}
+
+// DecodeFormat will write b in format. If b can not be decoded as format, it will be written as a plain byte slice instead.
+func DecodeFormat(format string, b []byte) (result string, err error) {
+	switch format {
+	case StringFormat:
+		result = string(b)
+	case FloatFormat:
+		if f, e := DecodeFloat64(b); e == nil {
+			result = fmt.Sprint(f)
+		} else {
+			result = fmt.Sprint(b)
+		}
+	case IntFormat:
+		if i, e := DecodeInt64(b); e == nil {
+			result = fmt.Sprint(i)
+		} else {
+			result = fmt.Sprint(b)
+		}
+	case BigFormat:
+		result = fmt.Sprint(DecodeBigInt(b))
+	case HexFormat:
+		result = HexEncode(b)
+	default:
+		err = fmt.Errorf("Unknown encoding: %v", format)
+	}
+	return
+}
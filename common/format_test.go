@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, c := range []struct {
+		format string
+		s      string
+	}{
+		{StringFormat, "hello"},
+		{FloatFormat, "1.5"},
+		{IntFormat, "-12"},
+		{BigFormat, "123456789012345678901234567890"},
+		{HexFormat, "00ff10"},
+	} {
+		b, err := EncodeFormat(c.format, c.s)
+		if err != nil {
+			t.Errorf("%v should encode as %v, but got %v", c.s, c.format, err)
+		}
+		if s, err := DecodeFormat(c.format, b); err != nil || s != c.s {
+			t.Errorf("%v should decode as %v to %v, but got %v, %v", b, c.format, c.s, s, err)
+		}
+	}
+	if _, err := EncodeFormat(IntFormat, "x"); err == nil {
+		t.Errorf("x should not encode as %v", IntFormat)
+	}
+	if _, err := EncodeFormat("rot13", "x"); err == nil {
+		t.Errorf("rot13 should not be a known format")
+	}
+	if _, err := DecodeFormat("rot13", []byte("x")); err == nil {
+		t.Errorf("rot13 should not be a known format")
+	}
+}
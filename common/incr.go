@@ -0,0 +1,45 @@
+package common
+
+// Incr is an atomic addition to the number stored under Key (or SubKey in the sub tree under Key).
+//
+// If Float, the number is assumed to be encoded using EncodeFloat64 and FloatDelta is added to it,
+// otherwise it is assumed to be encoded using EncodeInt64 and Delta is added to it.
+//
+// Missing numbers are considered to be 0.
+type Incr struct {
+	Key        []byte
+	SubKey     []byte
+	Delta      int64
+	FloatDelta float64
+	Float      bool
+	Sync       bool
+}
+
+// IncrResult is the outcome of an Incr. If the current value could not be decoded, Error will describe why and nothing will have been changed.
+type IncrResult struct {
+	Value     []byte
+	Timestamp int64
+	Error     string
+}
+
+// Apply returns the result of adding the delta of this Incr to value, or an error if value could not be decoded.
+func (self Incr) Apply(value []byte, existed bool) (result []byte, err error) {
+	if self.Float {
+		var f float64
+		if existed {
+			if f, err = DecodeFloat64(value); err != nil {
+				return
+			}
+		}
+		result = EncodeFloat64(f + self.FloatDelta)
+	} else {
+		var i int64
+		if existed {
+			if i, err = DecodeInt64(value); err != nil {
+				return
+			}
+		}
+		result = EncodeInt64(i + self.Delta)
+	}
+	return
+}
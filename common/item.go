@@ -1,11 +1,21 @@
 package common
 
+import (
+	"time"
+)
+
+// Item is a value, or a request concerning a value, in the database.
+//
+// Expiry is always an absolute time of the cluster clock, in nanoseconds, after which the value is gone, or 0 if it never expires.
+// Lifetime is only used when asking to put a value, and is how long from now it should live. Nodes convert it to an Expiry before storing or replicating the value.
 type Item struct {
 	Key       []byte
 	SubKey    []byte
 	Value     []byte
 	Exists    bool
 	Timestamp int64
+	Expiry    int64
+	Lifetime  time.Duration
 	TTL       int
 	Index     int
 	Sync      bool
@@ -30,3 +30,14 @@ func TestMergeItems(t *testing.T) {
 		t.Errorf("%v should be %v", found, expected)
 	}
 }
+
+func TestMergeMirrorItems(t *testing.T) {
+	i1 := []Item{Item{Key: []byte{1}, Value: []byte{1}, Timestamp: 44}, Item{Key: []byte{1}, Value: []byte{3}, Timestamp: 44}}
+	i2 := []Item{Item{Key: []byte{1}, Value: []byte{2}, Timestamp: 44}, Item{Key: []byte{1}, Value: []byte{3}, Timestamp: 45}}
+	ary := []*[]Item{&i1, &i2}
+	expected := []Item{Item{Key: []byte{1}, Value: []byte{1}, Timestamp: 44}, Item{Key: []byte{1}, Value: []byte{2}, Timestamp: 44}, Item{Key: []byte{1}, Value: []byte{3}, Timestamp: 45}}
+	found := MergeMirrorItems(ary, true)
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("%v should be %v", found, expected)
+	}
+}
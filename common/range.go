@@ -1,5 +1,9 @@
 package common
 
+import (
+	"bytes"
+)
+
 type Range struct {
 	Key      []byte
 	Min      []byte
@@ -9,4 +13,23 @@ type Range struct {
 	MinIndex int
 	MaxIndex int
 	Len      int
+	Name     string
+}
+
+// Within returns the part of this Range that is between fromInc, inclusive, and toExc, exclusive, and whether that part is non empty.
+// A nil Min or fromInc means the start of the key space, and a nil Max or toExc means the end.
+func (self Range) Within(fromInc, toExc []byte) (result Range, ok bool) {
+	result = self
+	if fromInc != nil && (result.Min == nil || bytes.Compare(result.Min, fromInc) < 0) {
+		result.Min, result.MinInc = fromInc, true
+	}
+	if toExc != nil && (result.Max == nil || bytes.Compare(result.Max, toExc) > -1) {
+		result.Max, result.MaxInc = toExc, false
+	}
+	ok = true
+	if result.Min != nil && result.Max != nil {
+		cmp := bytes.Compare(result.Min, result.Max)
+		ok = cmp < 0 || (cmp == 0 && result.MinInc && result.MaxInc)
+	}
+	return
 }
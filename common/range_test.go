@@ -0,0 +1,45 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string][]byte{
+		"":          nil,
+		"a":         []byte("b"),
+		"ab":        []byte("ac"),
+		"a\xff":     []byte("b"),
+		"a\xff\xff": []byte("b"),
+		"\xff\xff":  nil,
+	} {
+		if end := PrefixEnd([]byte(prefix)); bytes.Compare(end, expected) != 0 || (end == nil) != (expected == nil) {
+			t.Errorf("PrefixEnd(%v) should be %v but was %v", []byte(prefix), expected, end)
+		}
+	}
+}
+
+func TestRangeWithin(t *testing.T) {
+	r := Range{Min: []byte{2}, Max: []byte{6}, MinInc: true, MaxInc: true}
+	for _, c := range []struct {
+		from, to []byte
+		expected Range
+		ok       bool
+	}{
+		{nil, nil, r, true},
+		{[]byte{1}, []byte{7}, r, true},
+		{[]byte{3}, nil, Range{Min: []byte{3}, Max: []byte{6}, MinInc: true, MaxInc: true}, true},
+		{nil, []byte{6}, Range{Min: []byte{2}, Max: []byte{6}, MinInc: true, MaxInc: false}, true},
+		{[]byte{6}, nil, Range{Min: []byte{6}, Max: []byte{6}, MinInc: true, MaxInc: true}, true},
+		{nil, []byte{2}, Range{Min: []byte{2}, Max: []byte{2}, MinInc: true, MaxInc: false}, false},
+		{[]byte{7}, nil, Range{Min: []byte{7}, Max: []byte{6}, MinInc: true, MaxInc: true}, false},
+	} {
+		if found, ok := r.Within(c.from, c.to); ok != c.ok || bytes.Compare(found.Min, c.expected.Min) != 0 || bytes.Compare(found.Max, c.expected.Max) != 0 || found.MinInc != c.expected.MinInc || found.MaxInc != c.expected.MaxInc {
+			t.Errorf("%+v.Within(%v, %v) should be %+v, %v but was %+v, %v", r, c.from, c.to, c.expected, c.ok, found, ok)
+		}
+	}
+	if found, ok := (Range{}).Within([]byte{3}, []byte{5}); !ok || bytes.Compare(found.Min, []byte{3}) != 0 || !found.MinInc || bytes.Compare(found.Max, []byte{5}) != 0 || found.MaxInc {
+		t.Errorf("an unbounded Range within [3, 5) should be [3, 5), but was %+v, %v", found, ok)
+	}
+}
@@ -34,15 +34,22 @@ func (self Remotes) Clone() (result Remotes) {
 	return
 }
 
+// Remote is a route to one position of a node at Addr. Nodes with virtual nodes have several positions in the ring, and Index tells which one this is,
+// where 0 is the primary position that every node has.
+// Zone is the rack, data center or other failure domain of the node, and replicas are placed in different zones when possible.
 type Remote struct {
-	Pos  []byte
-	Addr string
+	Pos   []byte
+	Addr  string
+	Index int
+	Zone  string
 }
 
 func (self Remote) Clone() (result Remote) {
 	result.Pos = make([]byte, len(self.Pos))
 	copy(result.Pos, self.Pos)
 	result.Addr = self.Addr
+	result.Index = self.Index
+	result.Zone = self.Zone
 	return
 }
 func (self Remote) Equal(other Remote) bool {
@@ -56,6 +63,9 @@ func (self Remote) Less(other Remote) bool {
 	return val < 0
 }
 func (self Remote) String() string {
+	if self.Zone != "" {
+		return fmt.Sprintf("[%v@%v in %v]", HexEncode(self.Pos), self.Addr, self.Zone)
+	}
 	return fmt.Sprintf("[%v@%v]", HexEncode(self.Pos), self.Addr)
 }
 func (self Remote) Call(service string, args, reply interface{}) error {
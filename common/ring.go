@@ -16,6 +16,8 @@ type RingChangeListener func(ring *Ring) (keep bool)
 // Ring contains an ordered set of routes to discord.Nodes. 
 // It can fetch predecessor, match and successor for any key or remote (remotes are ordeded first on position, then on address, so that we have
 // a defined orded even between nodes with the same position).
+//
+// A node with virtual nodes has one route per position, all with the same address but different Remote.Index.
 type Ring struct {
 	nodes           Remotes
 	lock            *sync.RWMutex
@@ -51,6 +53,7 @@ func (self *Ring) hash() []byte {
 	for _, node := range self.nodes {
 		hasher.MustWrite(node.Pos)
 		hasher.MustWrite([]byte(node.Addr))
+		hasher.MustWrite([]byte(node.Zone))
 	}
 	return hasher.Get()
 }
@@ -68,14 +71,15 @@ func (self *Ring) Validate() {
 	seen := make(map[string]bool)
 	var last *Remote
 	for _, node := range clone.nodes {
-		if _, ok := seen[node.Addr]; ok {
+		id := fmt.Sprintf("%v/%v", node.Addr, node.Index)
+		if _, ok := seen[id]; ok {
 			panic(fmt.Errorf("Duplicate node in Ring! %v", clone.Describe()))
 		}
 		if last != nil && node.Less(*last) {
 			panic(fmt.Errorf("Badly ordered Ring! %v", clone.Describe()))
 		}
 		last = &node
-		seen[node.Addr] = true
+		seen[id] = true
 	}
 }
 
@@ -116,6 +120,20 @@ func (self *Ring) Nodes() Remotes {
 	return self.nodes.Clone()
 }
 
+// Hosts returns the first Remote of each address in this Ring, so that nodes with several positions are only included once.
+func (self *Ring) Hosts() (result Remotes) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	seen := make(map[string]bool)
+	for _, node := range self.nodes {
+		if !seen[node.Addr] {
+			seen[node.Addr] = true
+			result = append(result, node.Clone())
+		}
+	}
+	return
+}
+
 // Clone returns a copy of this Ring and its contents.
 func (self *Ring) Clone() *Ring {
 	return NewRingNodes(self.Nodes())
@@ -157,15 +175,15 @@ func (self *Ring) Successor(r Remote) Remote {
 	return self.nodes[self.successorIndex(r)].Clone()
 }
 
-// Add adds r to this Ring. If a Node with the same address is already present, it will be updated if needed.
+// Add adds r to this Ring. If a Node with the same address and index is already present, it will be updated if needed.
 func (self *Ring) Add(r Remote) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	oldHash := self.hash()
 	remote := r.Clone()
 	for index, current := range self.nodes {
-		if current.Addr == remote.Addr {
-			if bytes.Compare(current.Pos, remote.Pos) == 0 {
+		if current.Addr == remote.Addr && current.Index == remote.Index {
+			if bytes.Compare(current.Pos, remote.Pos) == 0 && current.Zone == remote.Zone {
 				return
 			}
 			self.nodes = append(self.nodes[:index], self.nodes[index+1:]...)
@@ -182,16 +200,47 @@ func (self *Ring) Add(r Remote) {
 	self.sendChanges(oldHash)
 }
 
-// Redundancy returns the minimum of the number of nodes present and the Redundancy const.
+// Redundancy returns the minimum of the number of addresses present and the Redundancy const.
 func (self *Ring) Redundancy() int {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
-	if len(self.nodes) < Redundancy {
-		return len(self.nodes)
+	addrs := make(map[string]bool)
+	for _, node := range self.nodes {
+		addrs[node.Addr] = true
+	}
+	if len(addrs) < Redundancy {
+		return len(addrs)
 	}
 	return Redundancy
 }
 
+// Successors returns r followed by the first successors of r with addresses not already in the result, until there are n of them or the Ring is exhausted.
+// Since all positions of a node share its address, the result will never contain the same node twice.
+//
+// Successors in zones not already in the result are preferred, and successors in zones already used are only included if there are not enough zones.
+func (self *Ring) Successors(r Remote, n int) (result Remotes) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	result = Remotes{r.Clone()}
+	if len(self.nodes) == 0 {
+		return
+	}
+	seen := map[string]bool{r.Addr: true}
+	zones := map[string]bool{r.Zone: true}
+	next := self.successorIndex(r)
+	for _, anyZone := range []bool{false, true} {
+		for i := 0; i < len(self.nodes) && len(result) < n; i++ {
+			node := self.nodes[(next+i)%len(self.nodes)]
+			if !seen[node.Addr] && (anyZone || !zones[node.Zone]) {
+				seen[node.Addr] = true
+				zones[node.Zone] = true
+				result = append(result, node.Clone())
+			}
+		}
+	}
+	return
+}
+
 // Remotes returns the predecessor of pos, any Remote at pos and the successor of pos.
 func (self *Ring) Remotes(pos []byte) (before, at, after *Remote) {
 	self.lock.RLock()
@@ -337,19 +386,21 @@ func (self *Ring) GetSlot() []byte {
 	return new(big.Int).Add(new(big.Int).SetBytes(self.nodes[biggestSpaceIndex].Pos), new(big.Int).Div(biggestSpace, big.NewInt(2))).Bytes()
 }
 
-// Remove deletes any Nodes in this Ring with the same address as remote.
+// Remove deletes any Nodes in this Ring with the same address as remote, including all their virtual positions.
 func (self *Ring) Remove(remote Remote) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	oldHash := self.hash()
-	for index, current := range self.nodes {
-		if current.Addr == remote.Addr {
-			if len(self.nodes) == 1 {
-				panic("Why would you want to remove the last Node in the Ring? Inconceivable!")
-			}
-			self.nodes = append(self.nodes[:index], self.nodes[index+1:]...)
+	kept := make(Remotes, 0, len(self.nodes))
+	for _, current := range self.nodes {
+		if current.Addr != remote.Addr {
+			kept = append(kept, current)
 		}
 	}
+	if len(kept) == 0 && len(self.nodes) > 0 {
+		panic("Why would you want to remove the last Node in the Ring? Inconceivable!")
+	}
+	self.nodes = kept
 	self.sendChanges(oldHash)
 }
 
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 )
@@ -33,59 +34,59 @@ func TestRingIndices(t *testing.T) {
 func buildRing() (*Ring, Remotes) {
 	r := NewRing()
 	var cmp Remotes
-	r.Add(Remote{[]byte{0}, "a"})
-	cmp = append(cmp, Remote{[]byte{0}, "a"})
-	r.Add(Remote{[]byte{1}, "b"})
-	cmp = append(cmp, Remote{[]byte{1}, "b"})
-	r.Add(Remote{[]byte{2}, "c"})
-	cmp = append(cmp, Remote{[]byte{2}, "c"})
-	r.Add(Remote{[]byte{3}, "d"})
-	cmp = append(cmp, Remote{[]byte{3}, "d"})
-	r.Add(Remote{[]byte{4}, "e"})
-	cmp = append(cmp, Remote{[]byte{4}, "e"})
-	r.Add(Remote{[]byte{6}, "f"})
-	cmp = append(cmp, Remote{[]byte{6}, "f"})
-	r.Add(Remote{[]byte{7}, "g"})
-	cmp = append(cmp, Remote{[]byte{7}, "g"})
+	r.Add(Remote{Pos: []byte{0}, Addr: "a"})
+	cmp = append(cmp, Remote{Pos: []byte{0}, Addr: "a"})
+	r.Add(Remote{Pos: []byte{1}, Addr: "b"})
+	cmp = append(cmp, Remote{Pos: []byte{1}, Addr: "b"})
+	r.Add(Remote{Pos: []byte{2}, Addr: "c"})
+	cmp = append(cmp, Remote{Pos: []byte{2}, Addr: "c"})
+	r.Add(Remote{Pos: []byte{3}, Addr: "d"})
+	cmp = append(cmp, Remote{Pos: []byte{3}, Addr: "d"})
+	r.Add(Remote{Pos: []byte{4}, Addr: "e"})
+	cmp = append(cmp, Remote{Pos: []byte{4}, Addr: "e"})
+	r.Add(Remote{Pos: []byte{6}, Addr: "f"})
+	cmp = append(cmp, Remote{Pos: []byte{6}, Addr: "f"})
+	r.Add(Remote{Pos: []byte{7}, Addr: "g"})
+	cmp = append(cmp, Remote{Pos: []byte{7}, Addr: "g"})
 	return r, cmp
 }
 
 func TestRingClean(t *testing.T) {
 	r, cmp := buildRing()
-	r.Clean(Remote{[]byte{0}, "a"}, Remote{[]byte{2}, "c"})
+	r.Clean(Remote{Pos: []byte{0}, Addr: "a"}, Remote{Pos: []byte{2}, Addr: "c"})
 	cmp = append(cmp[:1], cmp[2:]...)
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
 	}
 	r, cmp = buildRing()
-	r.Clean(Remote{[]byte{0}, "a"}, Remote{[]byte{1}, "b"})
+	r.Clean(Remote{Pos: []byte{0}, Addr: "a"}, Remote{Pos: []byte{1}, Addr: "b"})
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
 	}
 	r, cmp = buildRing()
-	r.Clean(Remote{[]byte{4}, "e"}, Remote{[]byte{6}, "f"})
+	r.Clean(Remote{Pos: []byte{4}, Addr: "e"}, Remote{Pos: []byte{6}, Addr: "f"})
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
 	}
 	r, cmp = buildRing()
-	r.Clean(Remote{[]byte{7}, "g"}, Remote{[]byte{0}, "a"})
+	r.Clean(Remote{Pos: []byte{7}, Addr: "g"}, Remote{Pos: []byte{0}, Addr: "a"})
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
 	}
 	r, cmp = buildRing()
-	r.Clean(Remote{[]byte{7}, "g"}, Remote{[]byte{1}, "b"})
+	r.Clean(Remote{Pos: []byte{7}, Addr: "g"}, Remote{Pos: []byte{1}, Addr: "b"})
 	cmp = cmp[1:]
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
 	}
 	r, cmp = buildRing()
-	r.Clean(Remote{[]byte{6}, "f"}, Remote{[]byte{0}, "a"})
+	r.Clean(Remote{Pos: []byte{6}, Addr: "f"}, Remote{Pos: []byte{0}, Addr: "a"})
 	cmp = cmp[:6]
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
 	}
 	r, cmp = buildRing()
-	r.Clean(Remote{[]byte{3}, "d"}, Remote{[]byte{3}, "d"})
+	r.Clean(Remote{Pos: []byte{3}, Addr: "d"}, Remote{Pos: []byte{3}, Addr: "d"})
 	cmp = cmp[3:4]
 	if !reflect.DeepEqual(r.nodes, cmp) {
 		t.Error(r.nodes, "should ==", cmp)
@@ -94,20 +95,20 @@ func TestRingClean(t *testing.T) {
 
 func TestRingEqualPositions(t *testing.T) {
 	r := NewRing()
-	ra := Remote{[]byte{0}, "a"}
+	ra := Remote{Pos: []byte{0}, Addr: "a"}
 	r.Add(ra)
-	rb := Remote{[]byte{2}, "b"}
+	rb := Remote{Pos: []byte{2}, Addr: "b"}
 	r.Add(rb)
-	rc := Remote{[]byte{2}, "c"}
+	rc := Remote{Pos: []byte{2}, Addr: "c"}
 	r.Add(rc)
-	rd := Remote{[]byte{4}, "d"}
+	rd := Remote{Pos: []byte{4}, Addr: "d"}
 	r.Add(rd)
-	re := Remote{[]byte{5}, "e"}
+	re := Remote{Pos: []byte{5}, Addr: "e"}
 	r.Add(re)
 	if s := r.Predecessor(ra); !s.Equal(re) {
 		t.Errorf("wrong predecessor, wanted %v but got %v", re, s)
 	}
-	if s := r.Predecessor(Remote{[]byte{1}, "aa"}); !s.Equal(ra) {
+	if s := r.Predecessor(Remote{Pos: []byte{1}, Addr: "aa"}); !s.Equal(ra) {
 		t.Errorf("wrong predecessor, wanted %v but got %v", ra, s)
 	}
 	if s := r.Predecessor(rb); !s.Equal(ra) {
@@ -116,7 +117,7 @@ func TestRingEqualPositions(t *testing.T) {
 	if s := r.Predecessor(rc); !s.Equal(rb) {
 		t.Errorf("wrong predecessor, wanted %v but got %v", rb, s)
 	}
-	if s := r.Predecessor(Remote{[]byte{3}, "ca"}); !s.Equal(rc) {
+	if s := r.Predecessor(Remote{Pos: []byte{3}, Addr: "ca"}); !s.Equal(rc) {
 		t.Errorf("wrong predecessor, wanted %v but got %v", rc, s)
 	}
 	if s := r.Predecessor(rd); !s.Equal(rc) {
@@ -128,7 +129,7 @@ func TestRingEqualPositions(t *testing.T) {
 	if s := r.Successor(ra); !s.Equal(rb) {
 		t.Errorf("wrong successor, wanted %v but got %v", rb, s)
 	}
-	if s := r.Successor(Remote{[]byte{1}, "aa"}); !s.Equal(rb) {
+	if s := r.Successor(Remote{Pos: []byte{1}, Addr: "aa"}); !s.Equal(rb) {
 		t.Errorf("wrong successor, wanted %v but got %v", rb, s)
 	}
 	if s := r.Successor(rb); !s.Equal(rc) {
@@ -137,7 +138,7 @@ func TestRingEqualPositions(t *testing.T) {
 	if s := r.Successor(rc); !s.Equal(rd) {
 		t.Errorf("wrong successor, wanted %v but got %v", rd, s)
 	}
-	if s := r.Successor(Remote{[]byte{3}, "ca"}); !s.Equal(rd) {
+	if s := r.Successor(Remote{Pos: []byte{3}, Addr: "ca"}); !s.Equal(rd) {
 		t.Errorf("wrong successor, wanted %v but got %v", rd, s)
 	}
 	if s := r.Successor(rd); !s.Equal(re) {
@@ -159,3 +160,71 @@ func TestRingEqualPositions(t *testing.T) {
 		t.Errorf("wrong byteIndices")
 	}
 }
+
+func TestRingVirtual(t *testing.T) {
+	r := NewRing()
+	a0 := Remote{Pos: []byte{0}, Addr: "a"}
+	b := Remote{Pos: []byte{1}, Addr: "b"}
+	a1 := Remote{Pos: []byte{2}, Addr: "a", Index: 1}
+	c := Remote{Pos: []byte{3}, Addr: "c"}
+	a2 := Remote{Pos: []byte{4}, Addr: "a", Index: 2}
+	for _, remote := range []Remote{a0, b, a1, c, a2} {
+		r.Add(remote)
+	}
+	r.Validate()
+	if r.Size() != 5 {
+		t.Errorf("%v should contain all virtual positions", r.Describe())
+	}
+	if red := r.Redundancy(); red != 3 {
+		t.Errorf("wanted redundancy 3 but got %v", red)
+	}
+	if h := r.Hosts(); !h.Equal(Remotes{a0, b, c}) {
+		t.Errorf("wrong hosts in %v: %v", r.Describe(), h)
+	}
+	if s := r.Successors(a1, 3); !s.Equal(Remotes{a1, c, b}) {
+		t.Errorf("wrong successors of %v: %v", a1, s)
+	}
+	if s := r.Successors(b, 2); !s.Equal(Remotes{b, a1}) {
+		t.Errorf("wrong successors of %v: %v", b, s)
+	}
+	if s := r.Successors(a0, 5); !s.Equal(Remotes{a0, b, c}) {
+		t.Errorf("wrong successors of %v: %v", a0, s)
+	}
+	moved := Remote{Pos: []byte{5}, Addr: "a", Index: 1}
+	r.Add(moved)
+	if !r.Nodes().Equal(Remotes{a0, b, c, a2, moved}) {
+		t.Errorf("%v should have moved %v to %v", r.Describe(), a1, moved)
+	}
+	r.Remove(a0)
+	if !r.Nodes().Equal(Remotes{b, c}) {
+		t.Errorf("%v should not contain any positions of %v", r.Describe(), a0.Addr)
+	}
+}
+
+func TestRingZones(t *testing.T) {
+	r := NewRing()
+	a := Remote{Pos: []byte{0}, Addr: "a", Zone: "x"}
+	b := Remote{Pos: []byte{1}, Addr: "b", Zone: "x"}
+	c := Remote{Pos: []byte{2}, Addr: "c", Zone: "y"}
+	d := Remote{Pos: []byte{3}, Addr: "d", Zone: "y"}
+	e := Remote{Pos: []byte{4}, Addr: "e", Zone: "z"}
+	for _, remote := range []Remote{a, b, c, d, e} {
+		r.Add(remote)
+	}
+	if s := r.Successors(a, 3); !s.Equal(Remotes{a, c, e}) {
+		t.Errorf("wrong successors of %v: %v", a, s)
+	}
+	if s := r.Successors(c, 4); !s.Equal(Remotes{c, e, a, d}) {
+		t.Errorf("wrong successors of %v: %v", c, s)
+	}
+	hash := r.Hash()
+	moved := b
+	moved.Zone = "z"
+	r.Add(moved)
+	if bytes.Compare(hash, r.Hash()) == 0 {
+		t.Errorf("%v should have changed hash when %v changed zone", r.Describe(), b)
+	}
+	if s := r.Successors(a, 3); !s.Equal(Remotes{a, b, c}) {
+		t.Errorf("wrong successors of %v: %v", a, s)
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"github.com/zond/setop"
+)
+
+// SetOpSourceStats describes how the data of one source of a distributed set expression was fetched.
+//
+// Keys are the sub trees the source read, which is more than one if a sub expression was executed by the node owning all its sub trees.
+// Addr is the node that provided the data, Calls the number of RPCs made to it and Results the number of results received.
+// Sources owned by the node executing the expression are read without any calls.
+type SetOpSourceStats struct {
+	Keys    [][]byte
+	Addr    string
+	Calls   int
+	Results int
+}
+
+// SetExpressionResult is the outcome of a distributed set expression. Results is empty if the results were stored in a destination sub tree.
+type SetExpressionResult struct {
+	Results []setop.SetOpResult
+	Sources []SetOpSourceStats
+}
+
+// Calls returns the total number of RPCs made to fetch the data of all sources.
+func (self SetExpressionResult) Calls() (result int) {
+	for _, source := range self.Sources {
+		result += source.Calls
+	}
+	return
+}
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/tls"
+	"io"
+	"net"
 	"net/rpc"
 	"sync"
 )
@@ -9,20 +12,69 @@ import (
 var Switch = newSwitchboard()
 
 // Switchboard is a simple map of net/rpc.Clients, to avoid having to set up new connections for each remote call.
+//
+// Each new connection presents the token of the Switchboard, if it has one, to the node it connects to, see SetToken, and uses TLS if configured to, see SetTLS.
 type Switchboard struct {
-	lock    *sync.RWMutex
-	clients map[string]*rpc.Client
+	lock      *sync.RWMutex
+	clients   map[string]*rpc.Client
+	token     string
+	tlsConfig *tls.Config
 }
 
 func newSwitchboard() *Switchboard {
-	return &Switchboard{new(sync.RWMutex), make(map[string]*rpc.Client)}
+	return &Switchboard{lock: new(sync.RWMutex), clients: make(map[string]*rpc.Client)}
+}
+
+// SetToken will make this Switchboard present token to the nodes it connects to, and close all connections made using the previous token.
+func (self *Switchboard) SetToken(token string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.token = token
+	self.closeAll()
+}
+
+// SetTLS will make this Switchboard connect to nodes using TLS with config, or plain TCP if config is nil, and close all connections made using
+// the previous setting.
+func (self *Switchboard) SetTLS(config *tls.Config) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.tlsConfig = config
+	self.closeAll()
+}
+func (self *Switchboard) closeAll() {
+	for addr, client := range self.clients {
+		client.Close()
+		delete(self.clients, addr)
+	}
+}
+func (self *Switchboard) dial(addr string) (client *rpc.Client, err error) {
+	self.lock.RLock()
+	token, tlsConfig := self.token, self.tlsConfig
+	self.lock.RUnlock()
+	var conn net.Conn
+	if tlsConfig == nil {
+		conn, err = net.Dial("tcp", addr)
+	} else {
+		conn, err = tls.Dial("tcp", addr, tlsConfig)
+	}
+	if err != nil {
+		return
+	}
+	if token != "" {
+		if err = SendToken(conn, token); err != nil {
+			conn.Close()
+			return
+		}
+	}
+	client = rpc.NewClient(conn)
+	return
 }
 func (self *Switchboard) client(addr string) (client *rpc.Client, err error) {
 	self.lock.RLock()
 	client, ok := self.clients[addr]
 	self.lock.RUnlock()
 	if !ok {
-		if client, err = rpc.Dial("tcp", addr); err != nil {
+		if client, err = self.dial(addr); err != nil {
 			return
 		}
 		self.lock.Lock()
@@ -46,18 +98,22 @@ func (self *Switchboard) Go(addr, service string, args, reply interface{}) (call
 	}
 	return
 }
+
+// Call will call service at addr with args and reply. If the cached connection to addr turns out to be broken, it is replaced and the call made once more.
 func (self *Switchboard) Call(addr, service string, args, reply interface{}) (err error) {
-	var client *rpc.Client
-	if client, err = self.client(addr); err != nil {
-		return
-	}
-	if err = client.Call(service, args, reply); err != nil {
-		if err.Error() == "connection is shut down" {
-			self.lock.Lock()
+	for tries := 0; tries < 2; tries++ {
+		var client *rpc.Client
+		if client, err = self.client(addr); err != nil {
+			return
+		}
+		if err = client.Call(service, args, reply); err != rpc.ErrShutdown && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return
+		}
+		self.lock.Lock()
+		if self.clients[addr] == client {
 			delete(self.clients, addr)
-			self.lock.Unlock()
 		}
-		err = self.Call(addr, service, args, reply)
+		self.lock.Unlock()
 	}
 	return
 }
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewTLSConfig will return a tls.Config usable both when listening and when connecting.
+//
+// If certFile and keyFile are not empty strings, the PEM encoded certificate and key in them will be presented to the other end of connections.
+// If caFile is not the empty string, only certificates signed by the PEM encoded certificates in it will be trusted, otherwise the system roots will be used.
+// If mutual, listeners using the config will require connections to present a trusted certificate.
+//
+// Since nodes connect to each other using IP addresses, their certificates must contain those addresses.
+func NewTLSConfig(certFile, keyFile, caFile string, mutual bool) (result *tls.Config, err error) {
+	result = &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(caFile); err != nil {
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("No certificates found in %v", caFile)
+			return
+		}
+		result.RootCAs, result.ClientCAs = pool, pool
+	}
+	if mutual {
+		result.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type echo int
+
+func (self *echo) Echo(s string, result *string) error {
+	*result = s
+	return nil
+}
+
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "god"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return
+}
+
+func TestSwitchboardTLS(t *testing.T) {
+	dir := "test_tls"
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+	serverConfig, err := NewTLSConfig(certFile, keyFile, certFile, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer listener.Close()
+	server := rpc.NewServer()
+	server.RegisterName("Echo", new(echo))
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, _, reply, err := ReceiveToken(conn)
+				if err == nil && (reply == nil || reply(true) == nil) {
+					server.ServeConn(conn)
+				}
+				conn.Close()
+			}()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	mutual := newSwitchboard()
+	mutual.SetTLS(serverConfig)
+	var result string
+	if err = mutual.Call(addr, "Echo.Echo", "hello", &result); err != nil || result != "hello" {
+		t.Errorf("wanted hello, got %#v, %v", result, err)
+	}
+
+	anonymousConfig, err := NewTLSConfig("", "", certFile, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	anonymous := newSwitchboard()
+	anonymous.SetTLS(anonymousConfig)
+	if err = anonymous.Call(addr, "Echo.Echo", "hello", &result); err == nil {
+		t.Errorf("wanted connecting without a certificate to fail")
+	}
+}
@@ -0,0 +1,23 @@
+package common
+
+// The decisions a coordinator can have made about a Transaction.
+const (
+	TransactionPending = iota
+	TransactionCommitted
+	TransactionAborted
+)
+
+// Transaction is a number of write operations, possibly on keys owned by different nodes, that will either all be performed or none of them.
+//
+// Id and Timestamp are set by the node coordinating the transaction, and all operations get the same Timestamp.
+type Transaction struct {
+	Id        string
+	Ops       []BatchOp
+	Timestamp int64
+}
+
+// TransactionResult is the outcome of a Transaction. If Committed is false, none of the operations were performed, and Error tells why.
+type TransactionResult struct {
+	Committed bool
+	Error     string
+}
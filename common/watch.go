@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bytes"
+)
+
+const (
+	WatchKey = iota
+	WatchSubTree
+	WatchPrefix
+)
+
+const (
+	EventPut = iota
+	EventDel
+	EventSubPut
+	EventSubDel
+	EventSubClear
+)
+
+// Watch defines a set of changes to subscribe to. Type is one of WatchKey, WatchSubTree or WatchPrefix.
+//
+// WatchKey will match puts and deletes of the value under Key.
+//
+// WatchSubTree will match puts, deletes and clears in the sub tree under Key.
+//
+// WatchPrefix will match all changes to values and sub trees under keys beginning with Key.
+//
+// Principal is set by the node receiving the subscription to the principal making it, so that only that principal can poll it.
+type Watch struct {
+	Type      int
+	Key       []byte
+	Principal string
+}
+
+// Matches returns whether event is one of the changes this Watch subscribes to.
+func (self Watch) Matches(event Event) bool {
+	switch self.Type {
+	case WatchKey:
+		return (event.Type == EventPut || event.Type == EventDel) && bytes.Compare(event.Key, self.Key) == 0
+	case WatchSubTree:
+		return (event.Type == EventSubPut || event.Type == EventSubDel || event.Type == EventSubClear) && bytes.Compare(event.Key, self.Key) == 0
+	case WatchPrefix:
+		return bytes.HasPrefix(event.Key, self.Key)
+	}
+	return false
+}
+
+// Event is one change to the data in a database. Type is one of EventPut, EventDel, EventSubPut, EventSubDel or EventSubClear.
+type Event struct {
+	Type      int
+	Key       []byte
+	SubKey    []byte
+	Value     []byte
+	Timestamp int64
+}
+
+// Events is the result of polling a subscription.
+//
+// If Lost, events were dropped because the subscriber was too slow to poll them, and the subscriber should assume anything it watches could have changed.
+//
+// If Unknown, the subscription is not known by the polled node (it could have timed out or the node could have restarted) and has to be created again.
+type Events struct {
+	Events  []Event
+	Lost    bool
+	Unknown bool
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestWatchMatches(t *testing.T) {
+	put := Event{Type: EventPut, Key: []byte("apple")}
+	subPut := Event{Type: EventSubPut, Key: []byte("apple"), SubKey: []byte("core")}
+	if !(Watch{Type: WatchKey, Key: []byte("apple")}).Matches(put) {
+		t.Errorf("key watch should match put")
+	}
+	if (Watch{Type: WatchKey, Key: []byte("apple")}).Matches(subPut) {
+		t.Errorf("key watch should not match sub put")
+	}
+	if (Watch{Type: WatchKey, Key: []byte("app")}).Matches(put) {
+		t.Errorf("key watch should not match other key")
+	}
+	if !(Watch{Type: WatchSubTree, Key: []byte("apple")}).Matches(subPut) {
+		t.Errorf("sub tree watch should match sub put")
+	}
+	if (Watch{Type: WatchSubTree, Key: []byte("apple")}).Matches(put) {
+		t.Errorf("sub tree watch should not match put")
+	}
+	if !(Watch{Type: WatchPrefix, Key: []byte("app")}).Matches(put) || !(Watch{Type: WatchPrefix, Key: []byte("app")}).Matches(subPut) {
+		t.Errorf("prefix watch should match put and sub put")
+	}
+	if (Watch{Type: WatchPrefix, Key: []byte("apx")}).Matches(put) {
+		t.Errorf("prefix watch should not match other prefix")
+	}
+}
@@ -0,0 +1,394 @@
+package dhash
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/zond/god/common"
+	"github.com/zond/god/discord"
+	"github.com/zond/setop"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	tokenPrefix = "token."
+	aclPrefix   = "acl."
+	everyone    = "*"
+)
+
+const (
+	// treeAclTimeout is how long the configuration of a sub tree owned by another node is cached when checking access to it.
+	treeAclTimeout = time.Second
+	maxTreeAcls    = 1 << 12
+)
+
+// treeAcl is a cached configuration of a sub tree owned by another node.
+type treeAcl struct {
+	conf    map[string]string
+	expires time.Time
+}
+
+const (
+	openAccess = iota
+	readAccess
+	writeAccess
+	adminAccess
+)
+
+var accessNames = map[string]int{
+	"read":  readAccess,
+	"write": writeAccess,
+	"admin": adminAccess,
+}
+
+// accessLevels is the access needed to call the methods available to clients, both over net/rpc and the JSON API.
+// openAccess methods can be called by anyone with a valid token. Methods not in here can only be called by other nodes.
+// DHash.Poll and DHash.Unsubscribe are further limited to the principal that made the subscription, see authorize.
+var accessLevels = map[string]int{
+	"Discord.Nodes":                  openAccess,
+	"Timenet.ActualTime":             openAccess,
+	"DHash.Nodes":                    openAccess,
+	"DHash.RingHash":                 openAccess,
+	"DHash.Size":                     openAccess,
+	"DHash.Owned":                    openAccess,
+	"DHash.Describe":                 openAccess,
+	"DHash.Poll":                     openAccess,
+	"DHash.Unsubscribe":              openAccess,
+	"DHash.Get":                      readAccess,
+	"DHash.SubGet":                   readAccess,
+	"DHash.MGet":                     readAccess,
+	"DHash.SubMGet":                  readAccess,
+	"DHash.Next":                     readAccess,
+	"DHash.Prev":                     readAccess,
+	"DHash.Count":                    readAccess,
+	"DHash.MirrorCount":              readAccess,
+	"DHash.SubSize":                  readAccess,
+	"DHash.SubNext":                  readAccess,
+	"DHash.SubPrev":                  readAccess,
+	"DHash.SubMirrorNext":            readAccess,
+	"DHash.SubMirrorPrev":            readAccess,
+	"DHash.First":                    readAccess,
+	"DHash.Last":                     readAccess,
+	"DHash.MirrorFirst":              readAccess,
+	"DHash.MirrorLast":               readAccess,
+	"DHash.IndexOf":                  readAccess,
+	"DHash.ReverseIndexOf":           readAccess,
+	"DHash.MirrorIndexOf":            readAccess,
+	"DHash.MirrorReverseIndexOf":     readAccess,
+	"DHash.NextIndex":                readAccess,
+	"DHash.PrevIndex":                readAccess,
+	"DHash.MirrorNextIndex":          readAccess,
+	"DHash.MirrorPrevIndex":          readAccess,
+	"DHash.Slice":                    readAccess,
+	"DHash.ReverseSlice":             readAccess,
+	"DHash.SliceIndex":               readAccess,
+	"DHash.ReverseSliceIndex":        readAccess,
+	"DHash.SliceLen":                 readAccess,
+	"DHash.ReverseSliceLen":          readAccess,
+	"DHash.MirrorSlice":              readAccess,
+	"DHash.MirrorReverseSlice":       readAccess,
+	"DHash.MirrorSliceIndex":         readAccess,
+	"DHash.MirrorReverseSliceIndex":  readAccess,
+	"DHash.MirrorSliceLen":           readAccess,
+	"DHash.MirrorReverseSliceLen":    readAccess,
+	"DHash.Aggregate":                readAccess,
+	"DHash.TopSlice":                 readAccess,
+	"DHash.TopReverseSlice":          readAccess,
+	"DHash.TopSliceLen":              readAccess,
+	"DHash.TopReverseSliceLen":       readAccess,
+	"DHash.TopCount":                 readAccess,
+	"DHash.PrefixSlice":              readAccess,
+	"DHash.PrefixCount":              readAccess,
+	"DHash.SubPrefixSlice":           readAccess,
+	"DHash.SubPrefixCount":           readAccess,
+	"DHash.IndexSlice":               readAccess,
+	"DHash.IndexIndexOf":             readAccess,
+	"DHash.SetExpression":            readAccess,
+	"DHash.DistributedSetExpression": readAccess,
+	"DHash.Subscribe":                readAccess,
+	"DHash.SubConfiguration":         readAccess,
+	"DHash.Put":                      writeAccess,
+	"DHash.PutTTL":                   writeAccess,
+	"DHash.Del":                      writeAccess,
+	"DHash.SubPut":                   writeAccess,
+	"DHash.SubPutTTL":                writeAccess,
+	"DHash.SubDel":                   writeAccess,
+	"DHash.SubClear":                 writeAccess,
+	"DHash.PrefixDelete":             writeAccess,
+	"DHash.SubPrefixDelete":          writeAccess,
+	"DHash.CompareAndSwap":           writeAccess,
+	"DHash.SubCompareAndSwap":        writeAccess,
+	"DHash.Incr":                     writeAccess,
+	"DHash.SubIncr":                  writeAccess,
+	"DHash.Batch":                    writeAccess,
+	"DHash.Transact":                 writeAccess,
+	"DHash.SubAddConfiguration":      adminAccess,
+	"DHash.Clear":                    adminAccess,
+	"DHash.AddConfiguration":         adminAccess,
+	"DHash.Configuration":            adminAccess,
+	"DHash.DescribeTree":             adminAccess,
+	"DHash.Export":                   adminAccess,
+	"DHash.Import":                   adminAccess,
+}
+
+// unkeyedMethods are the methods that can reveal keys other than the ones in their arguments, and therefore need access to all keys.
+var unkeyedMethods = map[string]bool{
+	"DHash.Next":               true,
+	"DHash.Prev":               true,
+	"DHash.TopSlice":           true,
+	"DHash.TopReverseSlice":    true,
+	"DHash.TopSliceLen":        true,
+	"DHash.TopReverseSliceLen": true,
+	"DHash.TopCount":           true,
+}
+
+var bytesType = reflect.TypeOf([]byte{})
+
+// accessRequest is the access needed to a key, where a nil key means all keys.
+type accessRequest struct {
+	key   []byte
+	level int
+}
+
+// accessRequests will return the access needed to call service with args.
+func accessRequests(service string, args interface{}, level int) (result []accessRequest) {
+	if level == openAccess {
+		return
+	}
+	if unkeyedMethods[service] {
+		return []accessRequest{{nil, level}}
+	}
+	value := reflect.ValueOf(args)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Kind() == reflect.Ptr {
+		return []accessRequest{{nil, level}}
+	}
+	switch a := value.Interface().(type) {
+	case setop.SetExpression:
+		op := a.Op
+		if op == nil {
+			var err error
+			if op, err = setop.NewSetOpParser(a.Code).Parse(); err != nil {
+				return []accessRequest{{nil, level}}
+			}
+		}
+		result = setOpRequests(op, level)
+		if a.Dest != nil {
+			result = append(result, accessRequest{a.Dest, writeAccess})
+		}
+	case common.Batch:
+		for _, op := range a.Ops {
+			result = append(result, accessRequest{op.Key, level})
+		}
+	case common.Transaction:
+		for _, op := range a.Ops {
+			result = append(result, accessRequest{op.Key, level})
+		}
+	case []common.Item:
+		for _, item := range a {
+			result = append(result, accessRequest{item.Key, level})
+		}
+	case []byte:
+		result = append(result, accessRequest{a, level})
+	default:
+		if value.Kind() == reflect.Struct {
+			for _, name := range []string{"Key", "TreeKey"} {
+				if field := value.FieldByName(name); field.IsValid() && field.Type() == bytesType {
+					result = append(result, accessRequest{field.Bytes(), level})
+				}
+			}
+			if field := value.FieldByName("Keys"); field.IsValid() && field.Type() == reflect.TypeOf([][]byte{}) {
+				for _, key := range field.Interface().([][]byte) {
+					result = append(result, accessRequest{key, level})
+				}
+			}
+		}
+	}
+	if len(result) == 0 {
+		result = []accessRequest{{nil, level}}
+	}
+	return
+}
+func setOpRequests(op *setop.SetOp, level int) (result []accessRequest) {
+	for _, source := range op.Sources {
+		if source.SetOp != nil {
+			result = append(result, setOpRequests(source.SetOp, level)...)
+		} else {
+			result = append(result, accessRequest{source.Key, level})
+		}
+	}
+	return
+}
+
+// SetSecret will make this Node require that all connections, both over net/rpc and to the JSON API, present a valid token.
+//
+// A connection presenting secret itself has full access, and secret is also what this Node presents when connecting to other nodes, using common.Switch.
+// All nodes in a cluster must therefore share the same secret.
+//
+// Other tokens are configured using AddConfiguration. 'token.NAME' set to common.TokenHash(TOKEN) makes connections presenting TOKEN act as the principal NAME.
+//
+// What principals may do is configured using AddConfiguration and SubAddConfiguration, with the values 'read', 'write' or 'admin', where each level includes the ones before it.
+// 'acl.NAME.PREFIX' gives NAME that access to all keys starting with the hex encoded PREFIX (so 'acl.NAME.' gives access to all keys).
+// 'acl.NAME' in the configuration of a sub tree gives NAME that access to that sub tree only.
+// Using '*' as NAME gives the access to all principals.
+//
+// Reading, writing and clearing sub trees requires 'read' or 'write' access to their keys, while configuring them requires 'admin'.
+// Clearing, backing up or configuring the entire cluster, as well as iterating over the top level keys, requires access to all keys.
+// Prefix scans of the top level keys only require access to the prefix.
+//
+// The empty string (the default) turns authentication off.
+func (self *Node) SetSecret(secret string) {
+	self.lock.Lock()
+	self.secret = secret
+	self.lock.Unlock()
+	common.Switch.SetToken(secret)
+}
+func (self *Node) getSecret() string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.secret
+}
+
+// authenticate will return what principal a connection presenting token acts as, and whether it is allowed to connect at all.
+// The empty principal has full access.
+func (self *Node) authenticate(token string) (principal string, ok bool) {
+	secret := self.getSecret()
+	if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+		return "", true
+	}
+	hash := common.TokenHash(token)
+	conf, _ := self.tree.Configuration()
+	for key, value := range conf {
+		if strings.HasPrefix(key, tokenPrefix) && len(key) > len(tokenPrefix) && subtle.ConstantTimeCompare([]byte(value), []byte(hash)) == 1 {
+			return key[len(tokenPrefix):], true
+		}
+	}
+	return
+}
+
+// authorize will return an error unless principal has the access needed to call the net/rpc method service with args.
+// Subscriptions are bound to the principal making them, and can only be polled or removed by that principal.
+func (self *Node) authorize(principal, service string, args interface{}) error {
+	if w, ok := args.(*common.Watch); ok && service == "DHash.Subscribe" {
+		w.Principal = principal
+	}
+	if principal == "" {
+		return nil
+	}
+	level, found := accessLevels[service]
+	if !found {
+		return common.AccessDenied("%v may not call %v", principal, service)
+	}
+	if id, ok := args.(*int64); ok && (service == "DHash.Poll" || service == "DHash.Unsubscribe") && !self.subscribedBy(*id, principal) {
+		return common.AccessDenied("%v did not make subscription %v", principal, *id)
+	}
+	for _, request := range accessRequests(service, args, level) {
+		if !self.allowed(principal, request.key, request.level) {
+			if request.key == nil {
+				return common.AccessDenied("%v lacks %v access to all keys", principal, accessName(request.level))
+			}
+			return common.AccessDenied("%v lacks %v access to %v", principal, accessName(request.level), common.HexEncode(request.key))
+		}
+	}
+	return nil
+}
+
+// Auth returns the functions this Node uses to authenticate tokens and authorize net/rpc calls, so that servers making calls on behalf of others,
+// like resp.Server, can check access the same way.
+func (self *Node) Auth() (discord.Authenticator, discord.Authorizer) {
+	return self.authenticate, self.authorize
+}
+func accessName(level int) string {
+	for name, l := range accessNames {
+		if l == level {
+			return name
+		}
+	}
+	return "any"
+}
+
+// allowed returns whether principal has at least level access to key, either through the cluster configuration or through the configuration of the sub tree under key.
+func (self *Node) allowed(principal string, key []byte, level int) bool {
+	conf, _ := self.tree.Configuration()
+	if prefixAccess(conf, principal, key) >= level {
+		return true
+	}
+	if key == nil {
+		return false
+	}
+	subConf, ok := self.treeAcl(key)
+	return ok && treeAccess(subConf, principal) >= level
+}
+
+// treeAcl returns the configuration of the sub tree under key. If this node does not own it, the configuration is fetched from the owner and cached
+// for treeAclTimeout, so that checking access does not cost a call to another node every time.
+func (self *Node) treeAcl(key []byte) (result map[string]string, ok bool) {
+	successor := self.node.GetSuccessorFor(key)
+	if successor.Addr == self.node.GetBroadcastAddr() {
+		result, _ = self.tree.SubConfiguration(key)
+		return result, true
+	}
+	now := time.Now()
+	self.lock.RLock()
+	cached, found := self.treeAcls[string(key)]
+	self.lock.RUnlock()
+	if found && cached.expires.After(now) {
+		return cached.conf, true
+	}
+	var subConf common.Conf
+	if err := successor.Call("DHash.SubConfiguration", key, &subConf); err != nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.treeAcls) >= maxTreeAcls {
+		for cachedKey, cached := range self.treeAcls {
+			if !cached.expires.After(now) {
+				delete(self.treeAcls, cachedKey)
+			}
+		}
+		if len(self.treeAcls) >= maxTreeAcls {
+			self.treeAcls = make(map[string]treeAcl)
+		}
+	}
+	self.treeAcls[string(key)] = treeAcl{
+		conf:    subConf.Data,
+		expires: now.Add(treeAclTimeout),
+	}
+	return subConf.Data, true
+}
+
+// prefixAccess returns the highest access given to principal for key by the 'acl.NAME.PREFIX' entries of conf.
+func prefixAccess(conf map[string]string, principal string, key []byte) (result int) {
+	for confKey, value := range conf {
+		if !strings.HasPrefix(confKey, aclPrefix) {
+			continue
+		}
+		parts := strings.SplitN(confKey[len(aclPrefix):], ".", 2)
+		if len(parts) != 2 || (parts[0] != principal && parts[0] != everyone) {
+			continue
+		}
+		prefix, err := hex.DecodeString(parts[1])
+		if err != nil || !bytes.HasPrefix(key, prefix) {
+			continue
+		}
+		if accessNames[value] > result {
+			result = accessNames[value]
+		}
+	}
+	return
+}
+
+// treeAccess returns the highest access given to principal by the 'acl.NAME' entries of the sub tree configuration conf.
+func treeAccess(conf map[string]string, principal string) (result int) {
+	for _, name := range []string{principal, everyone} {
+		if level := accessNames[conf[aclPrefix+name]]; level > result {
+			result = level
+		}
+	}
+	return
+}
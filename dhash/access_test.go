@@ -0,0 +1,165 @@
+package dhash
+
+import (
+	"bytes"
+	"github.com/zond/god/common"
+	"github.com/zond/setop"
+	"net"
+	"net/http"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func dialToken(t *testing.T, addr, token string) (result *rpc.Client, err error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err = common.SendToken(conn, token); err != nil {
+		conn.Close()
+		return
+	}
+	return rpc.NewClient(conn), nil
+}
+
+func postJSON(t *testing.T, url string) (result *http.Response) {
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString("{}"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if result, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("%v", err)
+	}
+	result.Body.Close()
+	return
+}
+
+func TestAccess(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:12191", "127.0.0.1:12191", "").MustStart()
+	defer node.Stop()
+	defer common.Switch.SetToken("")
+	node.SetSecret("secret")
+	node.AddConfiguration(common.ConfItem{Key: "token.alice", Value: common.TokenHash("alicetoken")})
+	node.AddConfiguration(common.ConfItem{Key: "acl.alice." + common.HexEncode([]byte("alice/")), Value: "write"})
+	node.AddConfiguration(common.ConfItem{Key: "acl.*." + common.HexEncode([]byte("public/")), Value: "read"})
+	node.SubAddConfiguration(common.ConfItem{TreeKey: []byte("shared"), Key: "acl.alice", Value: "admin"})
+
+	if principal, ok := node.authenticate("secret"); !ok || principal != "" {
+		t.Errorf("wanted the secret to give full access, got %#v, %v", principal, ok)
+	}
+	if principal, ok := node.authenticate("alicetoken"); !ok || principal != "alice" {
+		t.Errorf("wanted alicetoken to give alice, got %#v, %v", principal, ok)
+	}
+	if _, ok := node.authenticate("badtoken"); ok {
+		t.Errorf("wanted badtoken to be rejected")
+	}
+
+	allowed := []struct {
+		service string
+		args    interface{}
+	}{
+		{"DHash.Put", &common.Item{Key: []byte("alice/x")}},
+		{"DHash.Get", &common.Item{Key: []byte("public/x")}},
+		{"DHash.SubClear", &common.Item{Key: []byte("alice/tree")}},
+		{"DHash.SubAddConfiguration", &common.ConfItem{TreeKey: []byte("shared"), Key: "x", Value: "y"}},
+		{"DHash.SubPut", &common.Item{Key: []byte("shared"), SubKey: []byte("x")}},
+		{"DHash.Batch", &common.Batch{Ops: []common.BatchOp{{Key: []byte("alice/a")}, {Key: []byte("shared")}}}},
+		{"DHash.SetExpression", &setop.SetExpression{Op: &setop.SetOp{Sources: []setop.SetOpSource{{Key: []byte("public/a")}, {Key: []byte("alice/b")}}}, Dest: []byte("alice/c")}},
+		{"DHash.Size", new(int)},
+		{"Discord.Nodes", new(int)},
+	}
+	for _, call := range allowed {
+		if err := node.authorize("alice", call.service, call.args); err != nil {
+			t.Errorf("wanted alice to be allowed to call %v with %+v, got %v", call.service, call.args, err)
+		}
+	}
+	denied := []struct {
+		service string
+		args    interface{}
+	}{
+		{"DHash.Put", &common.Item{Key: []byte("public/x")}},
+		{"DHash.Get", &common.Item{Key: []byte("bob/x")}},
+		{"DHash.SubAddConfiguration", &common.ConfItem{TreeKey: []byte("alice/tree"), Key: "x", Value: "y"}},
+		{"DHash.Clear", new(int)},
+		{"DHash.AddConfiguration", &common.ConfItem{Key: "acl.alice.", Value: "admin"}},
+		{"DHash.Next", &common.Item{Key: []byte("alice/x")}},
+		{"DHash.Batch", &common.Batch{Ops: []common.BatchOp{{Key: []byte("alice/a")}, {Key: []byte("bob/a")}}}},
+		{"DHash.SetExpression", &setop.SetExpression{Op: &setop.SetOp{Sources: []setop.SetOpSource{{Key: []byte("public/a")}}}, Dest: []byte("public/c")}},
+		{"DHash.SlavePut", &common.Item{Key: []byte("alice/x")}},
+	}
+	for _, call := range denied {
+		if err := node.authorize("alice", call.service, call.args); !common.IsAccessDenied(err) {
+			t.Errorf("wanted alice to be denied calling %v with %+v, got %v", call.service, call.args, err)
+		}
+	}
+
+	if _, err := dialToken(t, "127.0.0.1:12191", "badtoken"); !common.IsAccessDenied(err) {
+		t.Errorf("wanted connecting with a bad token to be denied, got %v", err)
+	}
+	anonymous, err := net.Dial("tcp", "127.0.0.1:12191")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var size int
+	if err = rpc.NewClient(anonymous).Call("DHash.Size", 0, &size); !common.IsAccessDenied(err) {
+		t.Errorf("wanted calling without a token to be denied, got %v", err)
+	}
+	anonymous.Close()
+	alice, err := dialToken(t, "127.0.0.1:12191", "alicetoken")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer alice.Close()
+	var x int
+	if err = alice.Call("DHash.Put", common.Item{Key: []byte("alice/x"), Value: []byte("y")}, &x); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err = alice.Call("DHash.Put", common.Item{Key: []byte("bob/x"), Value: []byte("y")}, &x); !common.IsAccessDenied(err) {
+		t.Errorf("wanted putting bob/x as alice to be denied, got %v", err)
+	}
+	var item common.Item
+	if err = alice.Call("DHash.Get", common.Item{Key: []byte("alice/x")}, &item); err != nil || bytes.Compare(item.Value, []byte("y")) != 0 {
+		t.Errorf("wanted alice/x to be y, got %+v, %v", item, err)
+	}
+	if _, _, existed := node.tree.Get([]byte("bob/x")); existed {
+		t.Errorf("wanted bob/x to not exist")
+	}
+	var aliceWatch, otherWatch int64
+	if err = alice.Call("DHash.Subscribe", common.Watch{Type: common.WatchPrefix, Key: []byte("alice/"), Principal: "bob"}, &aliceWatch); err != nil {
+		t.Errorf("%v", err)
+	}
+	node.lock.RLock()
+	w := node.watchers[aliceWatch]
+	node.lock.RUnlock()
+	if w == nil || w.watch.Principal != "alice" {
+		t.Errorf("wanted the subscription to belong to alice, got %+v", w)
+	}
+	node.Subscribe(common.Watch{Type: common.WatchPrefix, Key: []byte("alice/")}, &otherWatch)
+	var events common.Events
+	if err = alice.Call("DHash.Poll", otherWatch, &events); !common.IsAccessDenied(err) {
+		t.Errorf("wanted polling another subscription as alice to be denied, got %v", err)
+	}
+	if err = alice.Call("DHash.Unsubscribe", otherWatch, &x); !common.IsAccessDenied(err) {
+		t.Errorf("wanted removing another subscription as alice to be denied, got %v", err)
+	}
+	if err = alice.Call("DHash.Unsubscribe", aliceWatch, &x); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if resp := postJSON(t, "http://127.0.0.1:12192/rpc/DHash.Clear"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wanted clearing without a token over JSON to be unauthorized, got %v", resp.Status)
+	}
+	if resp := postJSON(t, "http://127.0.0.1:12192/rpc/DHash.Clear?token=alicetoken"); resp.StatusCode == http.StatusOK {
+		t.Errorf("wanted clearing as alice over JSON to fail")
+	}
+	if resp := postJSON(t, "http://127.0.0.1:12192/rpc/DHash.Size?token=alicetoken"); resp.StatusCode != http.StatusOK {
+		t.Errorf("wanted getting the size as alice over JSON to work, got %v", resp.Status)
+	}
+	if _, _, existed := node.tree.Get([]byte("alice/x")); !existed {
+		t.Errorf("wanted alice/x to survive")
+	}
+}
@@ -15,6 +15,7 @@ func (self *Node) Description() common.DHashDescription {
 	return common.DHashDescription{
 		Addr:         self.GetBroadcastAddr(),
 		Pos:          self.node.GetPosition(),
+		Zone:         self.node.GetZone(),
 		LastReroute:  time.Unix(0, atomic.LoadInt64(&self.lastReroute)),
 		LastSync:     time.Unix(0, atomic.LoadInt64(&self.lastSync)),
 		LastMigrate:  time.Unix(0, atomic.LoadInt64(&self.lastMigrate)),
@@ -43,6 +44,15 @@ func (self *Node) Get(data common.Item, result *common.Item) error {
 	result.Value, result.Timestamp, result.Exists = self.tree.Get(data.Key)
 	return nil
 }
+
+// MGet will return the data under each key in data, in the same order as data.
+func (self *Node) MGet(data []common.Item, result *[]common.Item) error {
+	*result = make([]common.Item, len(data))
+	for index, item := range data {
+		self.Get(item, &(*result)[index])
+	}
+	return nil
+}
 func (self *Node) Prev(data common.Item, result *common.Item) error {
 	*result = data
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.Prev(data.Key)
@@ -281,6 +291,26 @@ func (self *Node) MirrorReverseSliceLen(r common.Range, items *[]common.Item) er
 	})
 	return nil
 }
+
+// IndexSlice will return the entries between r.Min and r.Max in the secondary index named r.Name of the sub tree at r.Key.
+// The keys of the returned items are index keys, and the values are the sub keys they were derived from.
+func (self *Node) IndexSlice(r common.Range, items *[]common.Item) error {
+	self.tree.SubIndexEachBetween(r.Key, r.Name, r.Min, r.Max, r.MinInc, r.MaxInc, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return true
+	})
+	return nil
+}
+
+// IndexIndexOf will return the index of the first entry with the index key r.Min in the secondary index named r.Name of the sub tree at r.Key.
+func (self *Node) IndexIndexOf(r common.Range, result *common.Index) error {
+	result.N, result.Existed = self.tree.SubIndexIndexOf(r.Key, r.Name, r.Min)
+	return nil
+}
 func (self *Node) MirrorReverseIndexOf(data common.Item, result *common.Index) error {
 	result.N, result.Existed = self.tree.SubMirrorReverseIndexOf(data.Key, data.SubKey)
 	return nil
@@ -302,29 +332,293 @@ func (self *Node) SubGet(data common.Item, result *common.Item) error {
 	result.Value, result.Timestamp, result.Exists = self.tree.SubGet(data.Key, data.SubKey)
 	return nil
 }
+
+// SubMGet will return the data under each sub key in data, in the same order as data.
+func (self *Node) SubMGet(data []common.Item, result *[]common.Item) error {
+	*result = make([]common.Item, len(data))
+	for index, item := range data {
+		self.SubGet(item, &(*result)[index])
+	}
+	return nil
+}
 func (self *Node) SubClear(data common.Item) error {
-	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.subClear(data)
+	data.TTL, data.Timestamp = self.subRedundancy(data.Key), self.timer.ContinuousTime()
+	if self.subClear(data) {
+		self.notify(common.EventSubClear, data)
+	}
+	return nil
 }
 func (self *Node) SubDel(data common.Item) error {
-	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.subDel(data)
+	data.TTL, data.Timestamp = self.subRedundancy(data.Key), self.timer.ContinuousTime()
+	if self.subDel(data) {
+		self.notify(common.EventSubDel, data)
+	}
+	return nil
 }
 func (self *Node) SubPut(data common.Item) error {
-	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.subPut(data)
+	data.TTL, data.Timestamp, data.Expiry = self.subRedundancy(data.Key), self.timer.ContinuousTime(), 0
+	if self.subPut(data) {
+		self.notify(common.EventSubPut, data)
+	}
+	return nil
+}
+
+// SubPutTTL will put data.Value at data.SubKey in the sub tree at data.Key, and make it expire data.Lifetime from now.
+// The expiry is converted to an absolute time of the cluster clock before being replicated, so that all replicas agree on when it is gone.
+func (self *Node) SubPutTTL(data common.Item) error {
+	data.TTL, data.Timestamp = self.subRedundancy(data.Key), self.timer.ContinuousTime()
+	data.Expiry = data.Timestamp + int64(data.Lifetime)
+	if self.subPut(data) {
+		self.notify(common.EventSubPut, data)
+	}
+	return nil
 }
 func (self *Node) Del(data common.Item) error {
-	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.del(data)
+	data.TTL, data.Timestamp = self.redundancy(), self.timer.ContinuousTime()
+	if self.del(data) {
+		self.notify(common.EventDel, data)
+	}
+	return nil
 }
 func (self *Node) Put(data common.Item) error {
-	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.put(data)
+	data.TTL, data.Timestamp, data.Expiry = self.redundancy(), self.timer.ContinuousTime(), 0
+	if self.put(data) {
+		self.notify(common.EventPut, data)
+	}
+	return nil
+}
+
+// PutTTL will put data.Value at data.Key, and make it expire data.Lifetime from now.
+// The expiry is converted to an absolute time of the cluster clock before being replicated, so that all replicas agree on when it is gone.
+func (self *Node) PutTTL(data common.Item) error {
+	data.TTL, data.Timestamp = self.redundancy(), self.timer.ContinuousTime()
+	data.Expiry = data.Timestamp + int64(data.Lifetime)
+	if self.put(data) {
+		self.notify(common.EventPut, data)
+	}
+	return nil
+}
+
+// CompareAndSwap will put data.Value at data.Key if the current value matches data, and replicate the result synchronously.
+func (self *Node) CompareAndSwap(data common.CAS, result *common.CASResult) error {
+	item := common.Item{
+		Key:       data.Key,
+		Value:     data.Value,
+		TTL:       self.redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+		Sync:      true,
+	}
+	result.Value, result.Timestamp, item.Expiry, result.Exists, result.Swapped = self.tree.UpdateExpiry(item.Key, item.Timestamp, casUpdater(data))
+	if result.Swapped {
+		self.notify(common.EventPut, item)
+		self.replicate(item, "DHash.SlavePut")
+		self.commit(item.Sync)
+	}
+	return nil
+}
+
+// SubCompareAndSwap will put data.Value at data.SubKey in the sub tree at data.Key if the current value matches data, and replicate the result synchronously.
+func (self *Node) SubCompareAndSwap(data common.CAS, result *common.CASResult) error {
+	item := common.Item{
+		Key:       data.Key,
+		SubKey:    data.SubKey,
+		Value:     data.Value,
+		TTL:       self.subRedundancy(data.Key),
+		Timestamp: self.timer.ContinuousTime(),
+		Sync:      true,
+	}
+	result.Value, result.Timestamp, item.Expiry, result.Exists, result.Swapped = self.tree.SubUpdateExpiry(item.Key, item.SubKey, item.Timestamp, casUpdater(data))
+	if result.Swapped {
+		self.notify(common.EventSubPut, item)
+		self.replicate(item, "DHash.SlaveSubPut")
+		self.commit(item.Sync)
+	}
+	return nil
+}
+
+// casUpdater returns a radix.Tree update function that swaps in the value of data if the current value matches it, keeping the current expiry.
+func casUpdater(data common.CAS) func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool) {
+	return func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool) {
+		return data.Value, expiry, data.Matches(value, timestamp, existed)
+	}
+}
+
+// incrUpdater returns a radix.Tree update function that applies data to the current value, keeping the current expiry.
+func incrUpdater(data common.Incr, err *error) func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool) {
+	return func(value []byte, timestamp, expiry int64, existed bool) (result []byte, newExpiry int64, ok bool) {
+		result, *err = data.Apply(value, existed)
+		newExpiry, ok = expiry, *err == nil
+		return
+	}
+}
+
+// Incr will atomically add the delta of data to the number at data.Key, and replicate the result.
+func (self *Node) Incr(data common.Incr, result *common.IncrResult) error {
+	item := common.Item{
+		Key:       data.Key,
+		TTL:       self.redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+		Sync:      data.Sync,
+	}
+	var err error
+	if item.Value, _, item.Expiry, _, _ = self.tree.UpdateExpiry(item.Key, item.Timestamp, incrUpdater(data, &err)); err != nil {
+		result.Error = err.Error()
+		return nil
+	}
+	result.Value, result.Timestamp = item.Value, item.Timestamp
+	self.notify(common.EventPut, item)
+	self.replicate(item, "DHash.SlavePut")
+	self.commit(item.Sync)
+	return nil
+}
+
+// SubIncr will atomically add the delta of data to the number at data.SubKey in the sub tree at data.Key, and replicate the result.
+func (self *Node) SubIncr(data common.Incr, result *common.IncrResult) error {
+	item := common.Item{
+		Key:       data.Key,
+		SubKey:    data.SubKey,
+		TTL:       self.subRedundancy(data.Key),
+		Timestamp: self.timer.ContinuousTime(),
+		Sync:      data.Sync,
+	}
+	var err error
+	if item.Value, _, item.Expiry, _, _ = self.tree.SubUpdateExpiry(item.Key, item.SubKey, item.Timestamp, incrUpdater(data, &err)); err != nil {
+		result.Error = err.Error()
+		return nil
+	}
+	result.Value, result.Timestamp = item.Value, item.Timestamp
+	self.notify(common.EventSubPut, item)
+	self.replicate(item, "DHash.SlaveSubPut")
+	self.commit(item.Sync)
+	return nil
+}
+
+// replicate will forward data to the successor using operation, unless data has reached the end of its TTL.
+func (self *Node) replicate(data common.Item, operation string) {
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, operation)
+		} else {
+			go self.forwardOperation(data, operation)
+		}
+	}
+}
+
+// commit will, if sync, wait until everything written to the tree of this node is logged as durably as the persistence.Logger allows.
+func (self *Node) commit(sync bool) {
+	if sync {
+		self.tree.Sync()
+	}
+}
+
+var batchEvents = map[int]int{
+	common.BatchPut:    common.EventPut,
+	common.BatchDel:    common.EventDel,
+	common.BatchSubPut: common.EventSubPut,
+	common.BatchSubDel: common.EventSubDel,
+}
+
+// Batch will perform all operations in data, in order, and replicate them using one call per replica.
+func (self *Node) Batch(data common.Batch, results *[]common.BatchResult) error {
+	for index := range data.Ops {
+		data.Ops[index].Timestamp, data.Ops[index].Expiry = self.timer.ContinuousTime(), 0
+	}
+	self.batchRedundancy(&data)
+	*results = self.batch(data)
+	self.notifyBatch(data.Ops, *results)
+	return nil
+}
+func (self *Node) notifyBatch(ops []common.BatchOp, results []common.BatchResult) {
+	for index, op := range ops {
+		// Removals of keys that did not exist changed nothing.
+		if results[index].Error == "" && (results[index].Existed || op.Type == common.BatchPut || op.Type == common.BatchSubPut) {
+			self.notify(batchEvents[op.Type], common.Item{
+				Key:       op.Key,
+				SubKey:    op.SubKey,
+				Value:     op.Value,
+				Timestamp: op.Timestamp,
+			})
+		}
+	}
+}
+func (self *Node) batch(data common.Batch) (results []common.BatchResult) {
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardBatch(data)
+		} else {
+			go self.forwardBatch(data)
+		}
+	}
+	results = make([]common.BatchResult, len(data.Ops))
+	for index, op := range data.Ops {
+		switch op.Type {
+		case common.BatchPut:
+			_, results[index].Existed = self.tree.PutExpiry(op.Key, op.Value, op.Timestamp, op.Expiry)
+		case common.BatchDel:
+			_, _, results[index].Existed = self.tree.FakeDel(op.Key, op.Timestamp)
+		case common.BatchSubPut:
+			_, results[index].Existed = self.tree.SubPutExpiry(op.Key, op.SubKey, op.Value, op.Timestamp, op.Expiry)
+		case common.BatchSubDel:
+			_, results[index].Existed = self.tree.SubFakeDel(op.Key, op.SubKey, op.Timestamp)
+		default:
+			results[index].Error = fmt.Sprintf("Unknown batch operation type: %v", op.Type)
+		}
+	}
+	self.commit(data.Sync)
+	return
+}
+
+// forwardBatch will forward the ops in data that have not reached the end of their TTL, grouped by the next replica of their keys.
+func (self *Node) forwardBatch(data common.Batch) {
+	var successors common.Remotes
+	batches := make(map[string]common.Batch)
+	for _, op := range data.Ops {
+		// Nodes without redundancy per tree only set the TTL of the batch.
+		if op.TTL == 0 {
+			op.TTL = data.TTL
+		}
+		if op.TTL < 2 {
+			continue
+		}
+		op.TTL--
+		successor := self.nextReplica(op.Key)
+		batch, found := batches[successor.Addr]
+		if !found {
+			successors = append(successors, successor)
+			batch = common.Batch{
+				Sync: data.Sync,
+			}
+		}
+		if op.TTL > batch.TTL {
+			batch.TTL = op.TTL
+		}
+		batch.Ops = append(batch.Ops, op)
+		batches[successor.Addr] = batch
+	}
+	for _, successor := range successors {
+		self.forwardBatchTo(successor, batches[successor.Addr])
+	}
+}
+func (self *Node) forwardBatchTo(successor common.Remote, data common.Batch) {
+	var x []common.BatchResult
+	operation := "DHash.SlaveBatch"
+	if self.hasCommListeners() {
+		self.triggerCommListeners(Comm{
+			Source:      self.node.Remote(),
+			Destination: successor,
+			Type:        operation,
+		})
+	}
+	err := successor.Call(operation, data, &x)
+	for err != nil {
+		self.node.RemoveNode(successor)
+		successor = self.node.GetSuccessor()
+		err = successor.Call(operation, data, &x)
+	}
 }
 func (self *Node) forwardOperation(data common.Item, operation string) {
 	data.TTL--
-	successor := self.node.GetSuccessor()
+	successor := self.nextReplica(data.Key)
 	var x int
 	if self.hasCommListeners() {
 		self.triggerCommListeners(Comm{
@@ -345,7 +639,9 @@ func (self *Node) forwardOperation(data common.Item, operation string) {
 func (self *Node) Clear() {
 	self.tree.Clear(self.timer.ContinuousTime())
 }
-func (self *Node) subClear(data common.Item) error {
+
+// subClear will clear the sub tree at data.Key, replicate the clear, and return whether anything was removed.
+func (self *Node) subClear(data common.Item) (changed bool) {
 	if data.TTL > 1 {
 		if data.Sync {
 			self.forwardOperation(data, "DHash.SlaveSubClear")
@@ -353,10 +649,13 @@ func (self *Node) subClear(data common.Item) error {
 			go self.forwardOperation(data, "DHash.SlaveSubClear")
 		}
 	}
-	self.tree.SubClear(data.Key, data.Timestamp)
-	return nil
+	changed = self.tree.SubClear(data.Key, data.Timestamp) > 0
+	self.commit(data.Sync)
+	return
 }
-func (self *Node) subDel(data common.Item) error {
+
+// subDel will remove data.SubKey from the sub tree at data.Key, replicate the removal, and return whether it existed.
+func (self *Node) subDel(data common.Item) (changed bool) {
 	if data.TTL > 1 {
 		if data.Sync {
 			self.forwardOperation(data, "DHash.SlaveSubDel")
@@ -364,10 +663,13 @@ func (self *Node) subDel(data common.Item) error {
 			go self.forwardOperation(data, "DHash.SlaveSubDel")
 		}
 	}
-	self.tree.SubFakeDel(data.Key, data.SubKey, data.Timestamp)
-	return nil
+	_, changed = self.tree.SubFakeDel(data.Key, data.SubKey, data.Timestamp)
+	self.commit(data.Sync)
+	return
 }
-func (self *Node) subPut(data common.Item) error {
+
+// subPut will put data.Value at data.SubKey in the sub tree at data.Key, replicate it, and return whether the value is new or different from the old one.
+func (self *Node) subPut(data common.Item) (changed bool) {
 	if data.TTL > 1 {
 		if data.Sync {
 			self.forwardOperation(data, "DHash.SlaveSubPut")
@@ -375,10 +677,14 @@ func (self *Node) subPut(data common.Item) error {
 			go self.forwardOperation(data, "DHash.SlaveSubPut")
 		}
 	}
-	self.tree.SubPut(data.Key, data.SubKey, data.Value, data.Timestamp)
-	return nil
+	oldBytes, existed := self.tree.SubPutExpiry(data.Key, data.SubKey, data.Value, data.Timestamp, data.Expiry)
+	changed = !existed || bytes.Compare(oldBytes, data.Value) != 0
+	self.commit(data.Sync)
+	return
 }
-func (self *Node) del(data common.Item) error {
+
+// del will remove data.Key, replicate the removal, and return whether it existed.
+func (self *Node) del(data common.Item) (changed bool) {
 	if data.TTL > 1 {
 		if data.Sync {
 			self.forwardOperation(data, "DHash.SlaveDel")
@@ -386,10 +692,13 @@ func (self *Node) del(data common.Item) error {
 			go self.forwardOperation(data, "DHash.SlaveDel")
 		}
 	}
-	self.tree.FakeDel(data.Key, data.Timestamp)
-	return nil
+	_, _, changed = self.tree.FakeDel(data.Key, data.Timestamp)
+	self.commit(data.Sync)
+	return
 }
-func (self *Node) put(data common.Item) error {
+
+// put will put data.Value at data.Key, replicate it, and return whether the value is new or different from the old one.
+func (self *Node) put(data common.Item) (changed bool) {
 	if data.TTL > 1 {
 		if data.Sync {
 			self.forwardOperation(data, "DHash.SlavePut")
@@ -397,27 +706,204 @@ func (self *Node) put(data common.Item) error {
 			go self.forwardOperation(data, "DHash.SlavePut")
 		}
 	}
-	self.tree.Put(data.Key, data.Value, data.Timestamp)
-	return nil
+	oldBytes, existed := self.tree.PutExpiry(data.Key, data.Value, data.Timestamp, data.Expiry)
+	changed = !existed || bytes.Compare(oldBytes, data.Value) != 0
+	self.commit(data.Sync)
+	return
 }
-func (self *Node) Size() int {
-	pred := self.node.GetPredecessor()
-	me := self.node.Remote()
-	cmp := bytes.Compare(pred.Pos, me.Pos)
-	if cmp < 0 {
-		return self.tree.SizeBetween(pred.Pos, me.Pos, true, false)
-	} else if cmp > 0 {
-		return self.tree.SizeBetween(pred.Pos, nil, true, false) + self.tree.SizeBetween(nil, me.Pos, true, false)
-	}
-	if pred.Less(me) {
-		return 0
+func (self *Node) Size() (result int) {
+	for _, segment := range self.ownedSegments() {
+		result += self.tree.SizeBetween(segment.min, segment.max, true, false)
 	}
-	return self.tree.Size()
+	return
 }
 func (self *Node) SubSize(key []byte, result *int) error {
 	*result = self.tree.SubSize(key)
 	return nil
 }
+
+// Aggregate will compute a in the sub tree, or mirror tree, at a.Key.
+func (self *Node) Aggregate(a common.Aggregate, result *common.AggregateResult) error {
+	aggregator := a.Aggregator()
+	iterator := func(key []byte, value []byte, version int64) bool {
+		if a.Mirror {
+			return aggregator.Add(key)
+		}
+		return aggregator.Add(value)
+	}
+	indexIterator := func(key []byte, value []byte, version int64, index int) bool {
+		return iterator(key, value, version)
+	}
+	min := &a.MinIndex
+	max := &a.MaxIndex
+	if a.ToEnd {
+		max = nil
+	}
+	switch {
+	case a.Mirror && a.ByIndex:
+		self.tree.SubMirrorEachBetweenIndex(a.Key, min, max, indexIterator)
+	case a.Mirror:
+		self.tree.SubMirrorEachBetween(a.Key, a.Min, a.Max, a.MinInc, a.MaxInc, iterator)
+	case a.ByIndex:
+		self.tree.SubEachBetweenIndex(a.Key, min, max, indexIterator)
+	default:
+		self.tree.SubEachBetween(a.Key, a.Min, a.Max, a.MinInc, a.MaxInc, iterator)
+	}
+	*result = aggregator.Result()
+	return nil
+}
+
+// TopSlice will return the byte values between r.Min and r.Max that this node has responsibility for.
+func (self *Node) TopSlice(r common.Range, items *[]common.Item) error {
+	self.eachOwned(r, true, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return true
+	})
+	return nil
+}
+
+// TopReverseSlice will return the byte values between r.Min and r.Max that this node has responsibility for, in reverse order.
+func (self *Node) TopReverseSlice(r common.Range, items *[]common.Item) error {
+	self.eachOwned(r, false, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return true
+	})
+	return nil
+}
+
+// TopSliceLen will return at most r.Len byte values between r.Min and r.Max that this node has responsibility for.
+// Clients leave r.Max nil, and set it to the end of the part of the range a node owns when asking it.
+func (self *Node) TopSliceLen(r common.Range, items *[]common.Item) error {
+	self.eachOwned(r, true, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return len(*items) < r.Len
+	})
+	return nil
+}
+
+// TopReverseSliceLen will return at most r.Len byte values between r.Min and r.Max that this node has responsibility for, in reverse order.
+// Clients leave r.Min nil, and set it to the start of the part of the range a node owns when asking it.
+func (self *Node) TopReverseSliceLen(r common.Range, items *[]common.Item) error {
+	self.eachOwned(r, false, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return len(*items) < r.Len
+	})
+	return nil
+}
+
+// TopCount will return the number of byte values between r.Min and r.Max that this node has responsibility for.
+func (self *Node) TopCount(r common.Range, result *int) error {
+	self.eachOwned(r, true, func(key []byte, value []byte, version int64) bool {
+		*result++
+		return true
+	})
+	return nil
+}
+
+// PrefixSlice will return the byte values with keys starting with data.Key that this node has responsibility for.
+func (self *Node) PrefixSlice(data common.Item, items *[]common.Item) error {
+	self.eachOwned(prefixRange(data.Key), true, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return true
+	})
+	return nil
+}
+
+// PrefixCount will return the number of byte values with keys starting with data.Key that this node has responsibility for.
+func (self *Node) PrefixCount(data common.Item, result *int) error {
+	self.eachOwned(prefixRange(data.Key), true, func(key []byte, value []byte, version int64) bool {
+		*result++
+		return true
+	})
+	return nil
+}
+
+// PrefixDelete will delete the byte values with keys starting with data.Key that this node has responsibility for, as a Batch, and return how many there were.
+func (self *Node) PrefixDelete(data common.Item, deleted *int) (err error) {
+	var ops []common.BatchOp
+	self.eachOwned(prefixRange(data.Key), true, func(key []byte, value []byte, version int64) bool {
+		ops = append(ops, common.BatchOp{
+			Type: common.BatchDel,
+			Key:  key,
+		})
+		return true
+	})
+	if len(ops) == 0 {
+		return
+	}
+	var results []common.BatchResult
+	if err = self.Batch(common.Batch{Ops: ops, Sync: data.Sync}, &results); err != nil {
+		return
+	}
+	for _, result := range results {
+		if result.Existed {
+			*deleted++
+		}
+	}
+	return
+}
+func (self *Node) SubPrefixSlice(data common.Item, items *[]common.Item) error {
+	self.tree.SubPrefixEach(data.Key, data.SubKey, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return true
+	})
+	return nil
+}
+func (self *Node) SubPrefixCount(data common.Item, result *int) error {
+	*result = self.tree.SubPrefixCount(data.Key, data.SubKey)
+	return nil
+}
+
+// SubPrefixDelete will delete the values with sub keys starting with data.SubKey from the sub tree at data.Key, and return how many there were.
+func (self *Node) SubPrefixDelete(data common.Item, deleted *int) error {
+	data.TTL, data.Timestamp = self.subRedundancy(data.Key), self.timer.ContinuousTime()
+	subKeys := self.subPrefixDelete(data)
+	for _, subKey := range subKeys {
+		self.notify(common.EventSubDel, common.Item{
+			Key:       data.Key,
+			SubKey:    subKey,
+			Timestamp: data.Timestamp,
+		})
+	}
+	*deleted = len(subKeys)
+	return nil
+}
+func (self *Node) subPrefixDelete(data common.Item) (deleted [][]byte) {
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlaveSubPrefixDelete")
+		} else {
+			go self.forwardOperation(data, "DHash.SlaveSubPrefixDelete")
+		}
+	}
+	deleted = self.tree.SubPrefixDelete(data.Key, data.SubKey, data.Timestamp)
+	self.commit(data.Sync)
+	return
+}
 func (self *Node) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) (err error) {
 	if expr.Dest != nil {
 		if expr.Op.Merge == setop.Append {
@@ -448,19 +934,119 @@ func (self *Node) SetExpression(expr setop.SetExpression, items *[]setop.SetOpRe
 		} else {
 			data.SubKey = res.Key
 			data.Value = res.Values[0]
-			data.TTL = self.node.Redundancy()
+			data.TTL = self.subRedundancy(data.Key)
 			data.Timestamp = self.timer.ContinuousTime()
 			self.subPut(data)
 		}
 	})
 	return
 }
+
+// DistributedSetExpression will execute expr like SetExpression, but let the nodes owning the sub trees involved do as much of the work as possible.
+//
+// If all sub trees are owned by one node the entire expression is executed by that node. Otherwise each sub expression whose sub trees are all owned by one other node
+// is executed by that node, and its results are streamed back in batches.
+//
+// All sources are read in batches that start at setOpBufferSize items, or at expr.Len items if that is smaller, and double in size for each call.
+// No source is read beyond expr.Max.
+//
+// result.Sources will describe how many calls were made to, and how many results were received from, each source.
+func (self *Node) DistributedSetExpression(expr setop.SetExpression, result *common.SetExpressionResult) (err error) {
+	if expr.Op == nil {
+		if expr.Op, err = setop.NewSetOpParser(expr.Code).Parse(); err != nil {
+			return
+		}
+	}
+	if expr.Dest != nil {
+		if expr.Op.Merge == setop.Append {
+			err = fmt.Errorf("When storing results of Set expressions the Append merge function is not allowed")
+			return
+		}
+		successor := self.node.GetSuccessorFor(expr.Dest)
+		if successor.Addr != self.node.GetBroadcastAddr() {
+			return successor.Call("DHash.DistributedSetExpression", expr, result)
+		}
+	} else if owner, ok := self.setOpOwner(expr.Op); ok && owner.Addr != self.node.GetBroadcastAddr() {
+		return owner.Call("DHash.DistributedSetExpression", expr, result)
+	}
+	taken := make(map[string]bool)
+	for _, key := range setOpSources(expr.Op) {
+		taken[string(key)] = true
+	}
+	pushed := make(map[string]*treeSkipper)
+	expr.Op = self.planSetOp(expr.Op, taken, pushed)
+	bufferSize := setOpBufferSize
+	if expr.Len > 0 && expr.Len < bufferSize {
+		bufferSize = expr.Len
+	}
+	var skippers []*treeSkipper
+	data := common.Item{
+		Key: expr.Dest,
+	}
+	err = expr.Each(func(b []byte) setop.Skipper {
+		skipper, found := pushed[string(b)]
+		if !found {
+			skipper = &treeSkipper{
+				remote: self.node.GetSuccessorFor(b),
+				key:    b,
+			}
+			if skipper.remote.Addr == self.node.GetBroadcastAddr() {
+				skipper.tree = self.tree
+			}
+		}
+		skipper.distributed = true
+		skipper.max, skipper.maxInc = expr.Max, expr.MaxInc
+		skipper.bufferSize = bufferSize
+		skippers = append(skippers, skipper)
+		return skipper
+	}, func(res *setop.SetOpResult) {
+		if expr.Dest == nil {
+			result.Results = append(result.Results, *res)
+		} else {
+			data.SubKey = res.Key
+			data.Value = res.Values[0]
+			data.TTL = self.subRedundancy(data.Key)
+			data.Timestamp = self.timer.ContinuousTime()
+			self.subPut(data)
+		}
+	})
+	for _, skipper := range skippers {
+		result.Sources = append(result.Sources, skipper.stats())
+	}
+	return
+}
+
+// SetOpSlice will return at most s.Len results between s.Min and s.Max of the sub tree under s.Key, or of the sub expression s.Op if it is set.
+// It is used by other nodes executing distributed set expressions.
+func (self *Node) SetOpSlice(s SetOpSlice, results *[]setop.SetOpResult) error {
+	if s.Op != nil {
+		return self.SetExpression(setop.SetExpression{
+			Op:     s.Op,
+			Min:    s.Min,
+			Max:    s.Max,
+			MinInc: s.MinInc,
+			MaxInc: s.MaxInc,
+			Len:    s.Len,
+		}, results)
+	}
+	self.tree.SubEachBetween(s.Key, s.Min, s.Max, s.MinInc, s.MaxInc, func(key, value []byte, timestamp int64) bool {
+		*results = append(*results, setop.SetOpResult{
+			Key:    key,
+			Values: [][]byte{value},
+		})
+		return len(*results) < s.Len
+	})
+	return nil
+}
 func (self *Node) AddConfiguration(c common.ConfItem) {
-	self.tree.AddConfiguration(self.timer.ContinuousTime(), c.Key, c.Value)
+	if self.tree.AddConfiguration(self.timer.ContinuousTime(), c.Key, c.Value) {
+		conf, _ := self.tree.Configuration()
+		self.registerClusterRedundancy(conf)
+	}
 }
 func (self *Node) forwardConfiguration(c common.ConfItem, operation string) {
 	c.TTL--
-	successor := self.node.GetSuccessor()
+	successor := self.nextReplica(c.TreeKey)
 	var x int
 	err := successor.Call(operation, c, &x)
 	for err != nil {
@@ -471,14 +1057,25 @@ func (self *Node) forwardConfiguration(c common.ConfItem, operation string) {
 }
 func (self *Node) subAddConfiguration(c common.ConfItem) {
 	if self.tree.SubAddConfiguration(c.TreeKey, c.Timestamp, c.Key, c.Value) {
+		conf, _ := self.tree.SubConfiguration(c.TreeKey)
+		self.registerSubConfiguration(c.TreeKey, conf)
 		if c.TTL > 1 {
 			self.forwardConfiguration(c, "DHash.SlaveSubAddConfiguration")
 		}
 	}
 }
-func (self *Node) SubAddConfiguration(c common.ConfItem) {
-	c.TTL, c.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+
+// SubAddConfiguration will set c.Key to c.Value in the configuration of the sub tree under c.TreeKey, and replicate it.
+// It returns an error without changing anything if c sets 'view' to an expression that can not be used in a view.
+func (self *Node) SubAddConfiguration(c common.ConfItem) (err error) {
+	if c.Key == viewConf && c.Value != "" {
+		if _, err = parseView(c.Value); err != nil {
+			return
+		}
+	}
+	c.TTL, c.Timestamp = self.confRedundancy(c), self.timer.ContinuousTime()
 	self.subAddConfiguration(c)
+	return
 }
 func (self *Node) Configuration(x int, result *common.Conf) error {
 	*result = common.Conf{}
@@ -0,0 +1,165 @@
+package dhash
+
+import (
+	"bytes"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+	"sort"
+)
+
+type backupSegment struct {
+	min []byte
+	max []byte
+}
+
+type backupSegments []backupSegment
+
+func (self backupSegments) Len() int {
+	return len(self)
+}
+func (self backupSegments) Less(i, j int) bool {
+	return self[i].min == nil || (self[j].min != nil && bytes.Compare(self[i].min, self[j].min) < 0)
+}
+func (self backupSegments) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+// positionSegments returns the ranges of keys owned by the position me of this node, between its predecessor and itself, in key order.
+func (self *Node) positionSegments(me common.Remote) (result backupSegments) {
+	pred := self.node.GetPredecessorForRemote(me)
+	cmp := bytes.Compare(pred.Pos, me.Pos)
+	if cmp < 0 {
+		result = backupSegments{backupSegment{pred.Pos, me.Pos}}
+	} else if cmp > 0 {
+		result = backupSegments{backupSegment{nil, me.Pos}, backupSegment{pred.Pos, nil}}
+	} else if !pred.Less(me) {
+		result = backupSegments{backupSegment{nil, nil}}
+	}
+	return
+}
+
+// ownedSegments returns the ranges of keys owned by this node, between the predecessors of each of its positions and the positions themselves, in key order.
+func (self *Node) ownedSegments() (result backupSegments) {
+	for _, me := range self.node.Remotes() {
+		segments := self.positionSegments(me)
+		if len(segments) == 1 && segments[0].min == nil && segments[0].max == nil {
+			return segments
+		}
+		result = append(result, segments...)
+	}
+	sort.Sort(result)
+	return
+}
+
+// Export will return a page of the data owned by this node, as defined by r.
+func (self *Node) Export(r common.BackupRange, result *[]common.BackupRecord) error {
+	values := 0
+	exportSub := func(key, min []byte, mininc bool, subTree *radix.Tree) {
+		subTree.ExportBetween(min, nil, mininc, false, func(subKey, byteValue []byte, byteExists bool, timestamp, expiry int64, subSubTree *radix.Tree) bool {
+			if byteExists {
+				*result = append(*result, common.BackupRecord{
+					Type:      common.BackupSubValue,
+					Key:       key,
+					SubKey:    subKey,
+					Value:     byteValue,
+					Timestamp: timestamp,
+					Expiry:    expiry,
+				})
+				values++
+			}
+			return values < r.Len
+		})
+	}
+	export := func(key, byteValue []byte, byteExists bool, timestamp, expiry int64, subTree *radix.Tree) bool {
+		resume := r.Started && bytes.Compare(key, r.Key) == 0
+		if byteExists && !resume {
+			*result = append(*result, common.BackupRecord{
+				Type:      common.BackupValue,
+				Key:       key,
+				Value:     byteValue,
+				Timestamp: timestamp,
+				Expiry:    expiry,
+			})
+			if values++; values >= r.Len {
+				return false
+			}
+		}
+		if subTree != nil {
+			if resume && r.Sub {
+				exportSub(key, r.SubKey, false, subTree)
+			} else {
+				if conf, confTimestamp := subTree.Configuration(); len(conf) > 0 {
+					*result = append(*result, common.BackupRecord{
+						Type:      common.BackupSubConf,
+						Key:       key,
+						Timestamp: confTimestamp,
+						Conf:      conf,
+					})
+				}
+				exportSub(key, nil, true, subTree)
+			}
+		}
+		return values < r.Len
+	}
+	passed := !r.Started
+	for _, segment := range self.ownedSegments() {
+		if passed {
+			self.tree.ExportBetween(segment.min, segment.max, true, false, export)
+		} else if (segment.min == nil || bytes.Compare(r.Key, segment.min) > -1) && (segment.max == nil || bytes.Compare(r.Key, segment.max) < 0) {
+			self.tree.ExportBetween(r.Key, segment.max, true, false, export)
+			passed = true
+		}
+		if values >= r.Len {
+			break
+		}
+	}
+	return nil
+}
+
+// Import will write records with their original timestamps and expiry times, and replicate them synchronously.
+// Values will not be written where this node already has newer data (or newer tombstones).
+func (self *Node) Import(records []common.BackupRecord) {
+	data := common.Batch{
+		Sync: true,
+	}
+	for _, record := range records {
+		switch record.Type {
+		case common.BackupSubConf:
+			for key, value := range record.Conf {
+				c := common.ConfItem{
+					TreeKey:   record.Key,
+					Key:       key,
+					Value:     value,
+					Timestamp: record.Timestamp,
+				}
+				c.TTL = self.confRedundancy(c)
+				self.subAddConfiguration(c)
+			}
+		case common.BackupValue:
+			if _, timestamp, _ := self.tree.Get(record.Key); timestamp >= record.Timestamp {
+				continue
+			}
+			data.Ops = append(data.Ops, common.BatchOp{
+				Type:      common.BatchPut,
+				Key:       record.Key,
+				Value:     record.Value,
+				Timestamp: record.Timestamp,
+				Expiry:    record.Expiry,
+			})
+		case common.BackupSubValue:
+			if _, timestamp, _ := self.tree.SubGet(record.Key, record.SubKey); timestamp >= record.Timestamp {
+				continue
+			}
+			data.Ops = append(data.Ops, common.BatchOp{
+				Type:      common.BatchSubPut,
+				Key:       record.Key,
+				SubKey:    record.SubKey,
+				Value:     record.Value,
+				Timestamp: record.Timestamp,
+				Expiry:    record.Expiry,
+			})
+		}
+	}
+	self.batchRedundancy(&data)
+	self.batch(data)
+}
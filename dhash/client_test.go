@@ -9,6 +9,7 @@ import (
 	"github.com/zond/setop"
 	"math/big"
 	"net"
+	"reflect"
 	"runtime"
 	"testing"
 	"time"
@@ -27,6 +28,8 @@ type testClient interface {
 	Del(key []byte)
 	MirrorReverseIndexOf(key, subKey []byte) (index int, existed bool)
 	MirrorIndexOf(key, subKey []byte) (index int, existed bool)
+	IndexIndexOf(key []byte, name string, indexKey []byte) (index int, existed bool)
+	IndexSlice(key []byte, name string, min, max []byte, mininc, maxinc bool) (result []common.Item)
 	ReverseIndexOf(key, subKey []byte) (index int, existed bool)
 	IndexOf(key, subKey []byte) (index int, existed bool)
 	Next(key []byte) (nextKey, nextValue []byte, existed bool)
@@ -59,10 +62,12 @@ type testClient interface {
 	First(key []byte) (firstKey, firstValue []byte, existed bool)
 	SubGet(key, subKey []byte) (value []byte, existed bool)
 	Get(key []byte) (value []byte, existed bool)
+	SubMGet(key []byte, subKeys [][]byte) (result []common.Item)
+	MGet(keys [][]byte) (result []common.Item)
 	SubSize(key []byte) (result int)
 	Size() (result int)
 	SetExpression(expr setop.SetExpression) (result []setop.SetOpResult)
-	SubAddConfiguration(treeKey []byte, key, value string)
+	SubAddConfiguration(treeKey []byte, key, value string) error
 }
 
 var benchNode *Node
@@ -148,7 +153,20 @@ func testClientInterface(t *testing.T, dhashes []*Node, c testClient) {
 	if rc, ok := c.(*client.Conn); ok {
 		testDump(t, rc)
 		testSubDump(t, rc)
+		testBatch(t, rc)
+		testWatch(t, rc)
+		testBackup(t, rc)
+		testTransact(t, dhashes, rc)
+		testCursor(t, rc)
+		testPrefix(t, dhashes, rc)
+		testTTL(t, dhashes, rc)
+		testTop(t, dhashes, rc)
+		testAggregate(t, rc)
+		testViews(t, rc)
+		testDistributedSetExpression(t, rc)
 	}
+	testMGet(t, c)
+	testSecondaryIndexes(t, c)
 	testNextPrev(t, c)
 	testCounts(t, dhashes, c)
 	testNextPrevIndices(t, dhashes, c)
@@ -323,6 +341,509 @@ func testDump(t *testing.T, c *client.Conn) {
 	}
 }
 
+func testMGet(t *testing.T, c testClient) {
+	var keys [][]byte
+	var subKeys [][]byte
+	for i := 0; i < 20; i++ {
+		key := murmur.HashString(fmt.Sprintf("testMGet%v", i))
+		subKey := []byte(fmt.Sprint(i))
+		keys = append(keys, key)
+		subKeys = append(subKeys, subKey)
+		if i%2 == 0 {
+			c.SPut(key, []byte(fmt.Sprint(i)))
+			c.SSubPut([]byte("testMGet"), subKey, []byte(fmt.Sprint(i)))
+		}
+	}
+	for index, item := range c.MGet(keys) {
+		if bytes.Compare(item.Key, keys[index]) != 0 {
+			t.Errorf("wrong key at %v: %v", index, item)
+		}
+		if index%2 == 0 {
+			if !item.Exists || bytes.Compare(item.Value, []byte(fmt.Sprint(index))) != 0 {
+				t.Errorf("wrong value at %v: %v", index, item)
+			}
+		} else if item.Exists {
+			t.Errorf("%v should not exist", item)
+		}
+	}
+	for index, item := range c.SubMGet([]byte("testMGet"), subKeys) {
+		if bytes.Compare(item.SubKey, subKeys[index]) != 0 {
+			t.Errorf("wrong sub key at %v: %v", index, item)
+		}
+		if index%2 == 0 {
+			if !item.Exists || bytes.Compare(item.Value, []byte(fmt.Sprint(index))) != 0 {
+				t.Errorf("wrong value at %v: %v", index, item)
+			}
+		} else if item.Exists {
+			t.Errorf("%v should not exist", item)
+		}
+	}
+}
+
+func testSecondaryIndexes(t *testing.T, c testClient) {
+	key := []byte("testSecondaryIndexes")
+	c.SubAddConfiguration(key, "index.age", "int64")
+	c.SSubPut(key, []byte("alice"), append(common.EncodeInt64(30), "x"...))
+	c.SSubPut(key, []byte("bob"), append(common.EncodeInt64(20), "y"...))
+	c.SSubPut(key, []byte("carol"), append(common.EncodeInt64(30), "z"...))
+	var names []string
+	for _, item := range c.IndexSlice(key, "age", common.EncodeInt64(25), nil, true, false) {
+		names = append(names, fmt.Sprintf("%v=%s", common.MustDecodeInt64(item.Key), item.Value))
+	}
+	if exp := []string{"30=alice", "30=carol"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("%v should be %v", names, exp)
+	}
+	if index, existed := c.IndexIndexOf(key, "age", common.EncodeInt64(30)); index != 1 || !existed {
+		t.Errorf("30 should be at index 1, not %v, %v", index, existed)
+	}
+}
+
+func testWatch(t *testing.T, c *client.Conn) {
+	watcher := c.Watch(common.Watch{Type: common.WatchPrefix, Key: []byte("testWatch")})
+	defer watcher.Close()
+	time.Sleep(time.Millisecond * 100)
+	c.Del([]byte("testWatchMissing"))
+	c.Put([]byte("testWatch1"), []byte("v1"))
+	c.SubPut([]byte("testWatch2"), []byte("s"), []byte("v2"))
+	c.Del([]byte("testWatch1"))
+	c.Put([]byte("other"), []byte("v3"))
+	var received []common.Event
+	timeout := time.After(time.Second * 5)
+	for len(received) < 3 {
+		select {
+		case events := <-watcher.Events:
+			received = append(received, events.Events...)
+		case <-timeout:
+			t.Fatalf("only got %v", received)
+		}
+	}
+	types := make(map[int]string)
+	for _, event := range received {
+		if string(event.Key) == "testWatchMissing" {
+			t.Errorf("removing a missing key should not be an event: %v", event)
+		}
+		types[event.Type] = string(event.Key)
+	}
+	if types[common.EventPut] != "testWatch1" || types[common.EventSubPut] != "testWatch2" || types[common.EventDel] != "testWatch1" {
+		t.Errorf("wrong events: %v", received)
+	}
+}
+
+func testBackup(t *testing.T, c *client.Conn) {
+	c.SPut([]byte("testBackup"), []byte("v"))
+	c.SSubPut([]byte("testBackup"), []byte("s"), []byte("sv"))
+	size := c.Size()
+	buf := new(bytes.Buffer)
+	written, err := c.Backup(buf)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	c.SDel([]byte("testBackup"))
+	c.SSubDel([]byte("testBackup"), []byte("s"))
+	read, err := c.Restore(buf)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if read != written {
+		t.Errorf("wrote %v records but read %v", written, read)
+	}
+	if val, ex := c.Get([]byte("testBackup")); ex {
+		t.Errorf("restoring an older value should not overwrite a newer delete, but got %v", val)
+	}
+	if s := c.Size(); s != size-2 {
+		t.Errorf("wanted size %v but got %v", size-2, s)
+	}
+}
+
+func testTransact(t *testing.T, dhashes []*Node, c *client.Conn) {
+	from, to := []byte("testTransactFrom"), []byte("testTransactTo")
+	c.SSubPut(from, []byte("item"), []byte("v"))
+	if err := c.Transact([]common.BatchOp{
+		common.BatchOp{Type: common.BatchSubDel, Key: from, SubKey: []byte("item")},
+		common.BatchOp{Type: common.BatchSubPut, Key: to, SubKey: []byte("item"), Value: []byte("v")},
+	}); err != nil {
+		t.Errorf("%v should have been able to move the item: %v", c, err)
+	}
+	if _, ex := c.SubGet(from, []byte("item")); ex {
+		t.Errorf("%v should not have the item in %s", c, from)
+	}
+	if val, ex := c.SubGet(to, []byte("item")); !ex || string(val) != "v" {
+		t.Errorf("%v should have the item in %s, not %v, %v", c, to, val, ex)
+	}
+	locked := common.Transaction{
+		Id:  "testTransact",
+		Ops: []common.BatchOp{common.BatchOp{Type: common.BatchPut, Key: []byte("testTransactLocked")}},
+	}
+	var ok bool
+	for _, d := range dhashes {
+		d.PrepareTransaction(locked, &ok)
+		d.PrepareTransaction(locked, &ok)
+		if lock := d.transactionLocks[transactionLockKey(locked.Ops[0])]; len(lock.part.Ops) != 1 {
+			t.Errorf("%v should have locked %v once, but got %v", d, locked, lock.part)
+		}
+	}
+	if err := c.Transact([]common.BatchOp{
+		common.BatchOp{Type: common.BatchPut, Key: []byte("testTransactFree"), Value: []byte("v")},
+		common.BatchOp{Type: common.BatchPut, Key: []byte("testTransactLocked"), Value: []byte("v")},
+	}); err == nil {
+		t.Errorf("%v should not be able to write a locked key", c)
+	}
+	if _, ex := c.Get([]byte("testTransactFree")); ex {
+		t.Errorf("%v should not have written anything in a failed transaction", c)
+	}
+	var x int
+	for _, d := range dhashes {
+		d.AbortTransaction(locked, &x)
+	}
+	if err := c.Transact([]common.BatchOp{
+		common.BatchOp{Type: common.BatchPut, Key: []byte("testTransactLocked"), Value: []byte("v")},
+	}); err != nil {
+		t.Errorf("%v should be able to write an unlocked key: %v", c, err)
+	}
+	coordinator := dhashes[0]
+	for _, decision := range []string{"Committed", "Aborted"} {
+		key := []byte("testTransact" + decision)
+		timedOut := common.Transaction{
+			Id:        fmt.Sprintf("%v/testTransact%v", coordinator.node.GetBroadcastAddr(), decision),
+			Timestamp: coordinator.timer.ContinuousTime(),
+		}
+		timedOut.Ops = []common.BatchOp{common.BatchOp{Type: common.BatchPut, Key: key, Value: []byte("v"), Timestamp: timedOut.Timestamp}}
+		if decision == "Committed" {
+			coordinator.recordTransaction(decidedPrefix+timedOut.Id, timedOut)
+		}
+		for _, d := range dhashes {
+			if d.node.GetBroadcastAddr() == coordinator.node.GetSuccessorFor(key).Addr {
+				d.PrepareTransaction(timedOut, &ok)
+				d.transactionsLock.Lock()
+				for lockKey, lock := range d.transactionLocks {
+					lock.deadline = time.Now()
+					d.transactionLocks[lockKey] = lock
+				}
+				d.transactionsLock.Unlock()
+				d.resolveTransactions()
+				if len(d.transactionLocks) != 0 {
+					t.Errorf("%v should have resolved %v, but has %v", d, timedOut, d.transactionLocks)
+				}
+			}
+		}
+		coordinator.forgetTransaction(decidedPrefix + timedOut.Id)
+		if _, ex := c.Get(key); ex != (decision == "Committed") {
+			t.Errorf("%v should have found %s %v, but got %v", c, key, decision == "Committed", ex)
+		}
+	}
+}
+
+func collectCursor(cursor *client.Cursor) (result []common.Item) {
+	for cursor.Next() {
+		result = append(result, cursor.Item())
+	}
+	return
+}
+
+func testCursor(t *testing.T, c *client.Conn) {
+	key := []byte("testCursor")
+	for i := byte(0); i < 10; i++ {
+		c.SSubPut(key, []byte{i}, []byte{i / 3})
+	}
+	assertItems(t, collectCursor(c.Cursor(key, nil, nil, true, true).PageSize(3)), []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, []byte{0, 0, 0, 1, 1, 1, 2, 2, 2, 3})
+	assertItems(t, collectCursor(c.Cursor(key, []byte{2}, []byte{5}, false, true).PageSize(2)), []byte{3, 4, 5}, []byte{1, 1, 1})
+	assertItems(t, collectCursor(c.ReverseCursor(key, []byte{2}, []byte{5}, true, false).PageSize(2)), []byte{4, 3, 2}, []byte{1, 1, 0})
+	assertItems(t, collectCursor(c.ReverseCursor(key, nil, nil, true, true).PageSize(4)), []byte{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, []byte{3, 2, 2, 2, 1, 1, 1, 0, 0, 0})
+	c.SubAddConfiguration(key, "mirrored", "yes")
+	assertItems(t, collectCursor(c.MirrorCursor(key, nil, nil, true, true).PageSize(2)), []byte{0, 0, 0, 1, 1, 1, 2, 2, 2, 3}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	assertItems(t, collectCursor(c.MirrorCursor(key, []byte{1}, []byte{2}, true, false).PageSize(1)), []byte{1, 1, 1}, []byte{3, 4, 5})
+	assertItems(t, collectCursor(c.MirrorReverseCursor(key, nil, []byte{2}, true, true).PageSize(2)), []byte{2, 2, 2, 1, 1, 1, 0, 0, 0}, []byte{8, 7, 6, 5, 4, 3, 2, 1, 0})
+	var found []common.Item
+	c.Cursor(key, nil, nil, true, true).PageSize(2).Each(func(item common.Item) bool {
+		found = append(found, item)
+		return len(found) < 3
+	})
+	assertItems(t, found, []byte{0, 1, 2}, []byte{0, 0, 0})
+}
+
+func testTTL(t *testing.T, dhashes []*Node, c *client.Conn) {
+	key := []byte("testTTL")
+	subKey := []byte("sub")
+	c.SPutTTL(key, common.EncodeInt64(1), time.Second)
+	c.SSubPutTTL(key, subKey, common.EncodeInt64(1), time.Second)
+	if n, err := c.SIncr(key, 1); err != nil || n != 2 {
+		t.Errorf("%v should be 2 without error, but got %v", n, err)
+	}
+	if n, err := c.SSubIncr(key, subKey, 1); err != nil || n != 2 {
+		t.Errorf("%v should be 2 without error, but got %v", n, err)
+	}
+	if _, swapped := c.CompareAndSwap(key, common.EncodeInt64(2), common.EncodeInt64(3)); !swapped {
+		t.Errorf("%v should have been swapped", key)
+	}
+	if _, swapped := c.SubCompareAndSwap(key, subKey, common.EncodeInt64(2), common.EncodeInt64(3)); !swapped {
+		t.Errorf("%v/%v should have been swapped", key, subKey)
+	}
+	time.Sleep(time.Second * 2)
+	for _, d := range dhashes {
+		if value, _, existed := d.tree.Get(key); existed {
+			t.Errorf("%v should not contain %v after its TTL, but contains %v", d.GetBroadcastAddr(), key, value)
+		}
+		if value, _, existed := d.tree.SubGet(key, subKey); existed {
+			t.Errorf("%v should not contain %v/%v after its TTL, but contains %v", d.GetBroadcastAddr(), key, subKey, value)
+		}
+	}
+}
+
+func testPrefix(t *testing.T, dhashes []*Node, c *client.Conn) {
+	prefix := dhashes[0].node.GetPosition()[:3]
+	keys := [][]byte{prefix, append(append([]byte{}, prefix...), 0), dhashes[0].node.GetPosition(), append(append([]byte{}, prefix...), 255)}
+	for _, key := range keys {
+		c.SPut(key, key)
+	}
+	items := c.PrefixSlice(prefix)
+	if len(items) != len(keys) {
+		t.Errorf("wanted %v items, got %v", len(keys), items)
+	}
+	for index, item := range items {
+		if bytes.Compare(item.Key, item.Value) != 0 || (index > 0 && bytes.Compare(items[index-1].Key, item.Key) > -1) {
+			t.Errorf("wanted the items with prefix %v in order, got %v", prefix, items)
+		}
+	}
+	if count := c.PrefixCount(prefix); count != len(keys) {
+		t.Errorf("wanted %v keys with prefix %v, got %v", len(keys), prefix, count)
+	}
+	if count := c.PrefixCount(keys[2]); count != 1 {
+		t.Errorf("wanted 1 key with prefix %v, got %v", keys[2], count)
+	}
+	if deleted := c.SPrefixDelete(prefix); deleted != len(keys) {
+		t.Errorf("wanted %v deleted keys, got %v", len(keys), deleted)
+	}
+	if count := c.PrefixCount(prefix); count != 0 {
+		t.Errorf("wanted no keys with prefix %v, got %v", prefix, count)
+	}
+	key := []byte("testPrefix")
+	for _, subKey := range []string{"a", "ab", "abc", "b"} {
+		c.SSubPut(key, []byte(subKey), []byte(subKey))
+	}
+	items = c.SubPrefixSlice(key, []byte("ab"))
+	if len(items) != 2 || string(items[0].Key) != "ab" || string(items[1].Key) != "abc" {
+		t.Errorf("wanted ab and abc, got %v", items)
+	}
+	if count := c.SubPrefixCount(key, []byte("a")); count != 3 {
+		t.Errorf("wanted 3 sub keys with prefix a, got %v", count)
+	}
+	if deleted := c.SSubPrefixDelete(key, []byte("a")); deleted != 3 {
+		t.Errorf("wanted 3 deleted sub keys, got %v", deleted)
+	}
+	if size := c.SubSize(key); size != 1 {
+		t.Errorf("wanted 1 sub key left, got %v", size)
+	}
+}
+
+func assertSameItems(t *testing.T, found, wanted []common.Item) {
+	_, file, line, _ := runtime.Caller(1)
+	if len(found) != len(wanted) {
+		t.Errorf("%v:%v: wanted %v but got %v", file, line, wanted, found)
+		return
+	}
+	for index, item := range found {
+		if bytes.Compare(item.Key, wanted[index].Key) != 0 || bytes.Compare(item.Value, wanted[index].Value) != 0 {
+			t.Errorf("%v:%v: wanted %v but got %v", file, line, wanted, found)
+			return
+		}
+	}
+}
+
+func testTop(t *testing.T, dhashes []*Node, c *client.Conn) {
+	var keys [][]byte
+	for _, dhash := range dhashes {
+		pos := dhash.node.GetPosition()
+		keys = append(keys, append(append([]byte{}, pos[:3]...), 0), pos, append(append([]byte{}, pos[:3]...), 255))
+	}
+	for _, key := range keys {
+		c.SPut(key, key)
+	}
+	defer func() {
+		for _, key := range keys {
+			c.SDel(key)
+		}
+	}()
+	all := c.TopSlice(nil, nil, true, true)
+	if len(all) < len(keys) {
+		t.Fatalf("wanted at least %v items, got %v", len(keys), all)
+	}
+	for index, item := range all {
+		if index > 0 && bytes.Compare(all[index-1].Key, item.Key) > -1 {
+			t.Errorf("wanted the top level keys in order, got %v", all)
+		}
+	}
+	if count := c.TopCount(nil, nil, true, true); count != len(all) {
+		t.Errorf("wanted %v top level keys, got %v", len(all), count)
+	}
+	var reversed []common.Item
+	for index := len(all) - 1; index >= 0; index-- {
+		reversed = append(reversed, all[index])
+	}
+	assertSameItems(t, c.TopReverseSlice(nil, nil, true, true), reversed)
+	assertSameItems(t, c.TopSlice(all[1].Key, all[4].Key, true, false), all[1:4])
+	assertSameItems(t, c.TopReverseSlice(all[1].Key, all[4].Key, false, true), []common.Item{all[4], all[3], all[2]})
+	assertSameItems(t, c.TopSliceLen(nil, true, 3), all[:3])
+	assertSameItems(t, c.TopSliceLen(all[1].Key, false, 2), all[2:4])
+	assertSameItems(t, c.TopReverseSliceLen(nil, true, 2), reversed[:2])
+	assertSameItems(t, c.TopReverseSliceLen(all[3].Key, true, 2), []common.Item{all[3], all[2]})
+	if count := c.TopCount(all[1].Key, all[3].Key, false, true); count != 2 {
+		t.Errorf("wanted 2 top level keys between %v and %v, got %v", all[1].Key, all[3].Key, count)
+	}
+}
+
+func assertAggregate(t *testing.T, value []byte, count int, err error, wantedValue []byte, wantedCount int) {
+	_, file, line, _ := runtime.Caller(1)
+	if err != nil || count != wantedCount || bytes.Compare(value, wantedValue) != 0 {
+		t.Errorf("%v:%v: wanted %v, %v but got %v, %v, %v", file, line, wantedValue, wantedCount, value, count, err)
+	}
+}
+
+func testAggregate(t *testing.T, c *client.Conn) {
+	key := []byte("testAggregate")
+	for i := int64(1); i < 6; i++ {
+		c.SSubPut(key, []byte{byte(i)}, common.EncodeInt64(i*10))
+	}
+	value, count, err := c.Aggregate(key, nil, nil, true, true, common.AggregateSum, setop.IntegerSum)
+	assertAggregate(t, value, count, err, common.EncodeInt64(150), 5)
+	value, count, err = c.Aggregate(key, []byte{2}, []byte{4}, true, false, common.AggregateMax, setop.IntegerSum)
+	assertAggregate(t, value, count, err, common.EncodeInt64(30), 2)
+	min, max := 1, 3
+	value, count, err = c.IndexAggregate(key, &min, &max, common.AggregateAvg, setop.IntegerSum)
+	assertAggregate(t, value, count, err, common.EncodeFloat64(30), 3)
+	value, count, err = c.Aggregate(key, []byte{4}, nil, true, true, common.AggregateMerge, setop.IntegerMul)
+	assertAggregate(t, value, count, err, common.EncodeInt64(2000), 2)
+	c.SubAddConfiguration(key, "mirrored", "yes")
+	value, count, err = c.MirrorAggregate(key, common.EncodeInt64(20), common.EncodeInt64(40), true, true, common.AggregateSum, setop.IntegerSum)
+	assertAggregate(t, value, count, err, common.EncodeInt64(90), 3)
+	value, count, err = c.MirrorIndexAggregate(key, nil, &min, common.AggregateMin, setop.IntegerSum)
+	assertAggregate(t, value, count, err, common.EncodeInt64(10), 2)
+	c.SSubPut(key, []byte{6}, []byte("x"))
+	if _, _, err = c.Aggregate(key, nil, nil, true, true, common.AggregateSum, setop.IntegerSum); err == nil {
+		t.Errorf("wanted an error when aggregating values that are not int64s")
+	}
+}
+
+func assertView(t *testing.T, c *client.Conn, key, keys, values []byte) {
+	common.AssertWithin(t, func() (string, bool) {
+		items := c.Slice(key, nil, nil, true, true)
+		var foundKeys, foundValues []byte
+		for _, item := range items {
+			foundKeys = append(foundKeys, item.Key...)
+			foundValues = append(foundValues, item.Value...)
+		}
+		return fmt.Sprint(items), bytes.Compare(foundKeys, keys) == 0 && bytes.Compare(foundValues, values) == 0
+	}, time.Second*10)
+}
+
+func testViews(t *testing.T, c *client.Conn) {
+	a := []byte("testViewsA")
+	b := []byte("testViewsB")
+	view := []byte("testViews")
+	for i := byte(0); i < 5; i++ {
+		c.SSubPut(a, []byte{i}, []byte{i + 10})
+		c.SSubPut(b, []byte{i + 2}, []byte{i + 20})
+	}
+	if err := c.SubAddConfiguration(view, "view", "(I:First testViewsA"); err == nil {
+		t.Errorf("%v should not accept a view that can not be parsed", c)
+	}
+	if err := c.SubAddConfiguration(view, "view", "(I:First testViewsA testViewsB)"); err != nil {
+		t.Errorf("%v should accept the view: %v", c, err)
+	}
+	assertView(t, c, view, []byte{2, 3, 4}, []byte{12, 13, 14})
+	c.SSubPut(a, []byte{5}, []byte{15})
+	assertView(t, c, view, []byte{2, 3, 4, 5}, []byte{12, 13, 14, 15})
+	c.SSubDel(b, []byte{3})
+	c.SSubPut(a, []byte{4}, []byte{40})
+	assertView(t, c, view, []byte{2, 4, 5}, []byte{12, 40, 15})
+	c.SSubPut(view, []byte{9}, []byte{9})
+	assertView(t, c, view, []byte{2, 4, 5}, []byte{12, 40, 15})
+}
+
+func testDistributedSetExpression(t *testing.T, c *client.Conn) {
+	for i := 0; i < 200; i++ {
+		c.SSubPut([]byte("dsete1"), []byte{byte(i)}, []byte{byte(i)})
+		if i%2 == 0 {
+			c.SSubPut([]byte("dsete2"), []byte{byte(i)}, []byte{2})
+		}
+		if i%3 == 0 {
+			c.SSubPut([]byte("dsete3"), []byte{byte(i)}, []byte{3})
+		}
+	}
+	code := "(I:First dsete1 (U:First dsete2 dsete3))"
+	for _, expr := range []setop.SetExpression{
+		setop.SetExpression{Code: code},
+		setop.SetExpression{Code: code, Len: 5},
+		setop.SetExpression{Code: code, Min: []byte{20}, Max: []byte{40}, MaxInc: true},
+	} {
+		expected := c.SetExpression(expr)
+		result := c.DistributedSetExpression(expr)
+		if fmt.Sprint(result.Results) != fmt.Sprint(expected) {
+			t.Errorf("%+v should give %v but gave %v", expr, expected, result.Results)
+		}
+		if len(result.Sources) == 0 {
+			t.Errorf("%+v should describe its sources", expr)
+		}
+		for _, source := range result.Sources {
+			if expr.Max != nil && source.Results > 41 {
+				t.Errorf("%+v should not read beyond %v, but got %+v", expr, expr.Max, source)
+			}
+		}
+	}
+}
+
+func testBatch(t *testing.T, c *client.Conn) {
+	var ops []common.BatchOp
+	for i := 0; i < 100; i++ {
+		ops = append(ops, common.BatchOp{
+			Type:  common.BatchPut,
+			Key:   murmur.HashString(fmt.Sprint(i)),
+			Value: []byte(fmt.Sprint(i)),
+		}, common.BatchOp{
+			Type:   common.BatchSubPut,
+			Key:    []byte("testBatch"),
+			SubKey: []byte(fmt.Sprint(i)),
+			Value:  []byte(fmt.Sprint(i)),
+		})
+	}
+	for index, result := range c.SBatch(ops) {
+		if result.Existed || result.Error != "" {
+			t.Errorf("%v should not have existed or failed, but got %+v", ops[index], result)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if val, ex := c.Get(murmur.HashString(fmt.Sprint(i))); !ex || bytes.Compare(val, []byte(fmt.Sprint(i))) != 0 {
+			t.Errorf("wrong value for %v: %v, %v", i, val, ex)
+		}
+	}
+	if size := c.SubSize([]byte("testBatch")); size != 100 {
+		t.Errorf("wrong size: %v", size)
+	}
+	ops = []common.BatchOp{
+		common.BatchOp{
+			Type: common.BatchDel,
+			Key:  murmur.HashString(fmt.Sprint(0)),
+		},
+		common.BatchOp{
+			Type:   common.BatchSubDel,
+			Key:    []byte("testBatch"),
+			SubKey: []byte(fmt.Sprint(0)),
+		},
+		common.BatchOp{
+			Type: -1,
+		},
+	}
+	results := c.SBatch(ops)
+	if !results[0].Existed || !results[1].Existed || results[2].Error == "" {
+		t.Errorf("wrong results: %+v", results)
+	}
+	if _, ex := c.Get(murmur.HashString(fmt.Sprint(0))); ex {
+		t.Errorf("should not exist")
+	}
+	if _, ex := c.SubGet([]byte("testBatch"), []byte(fmt.Sprint(0))); ex {
+		t.Errorf("should not exist")
+	}
+}
+
 func testSubGetPutDel(t *testing.T, c testClient) {
 	var key []byte
 	var value []byte
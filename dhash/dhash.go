@@ -2,12 +2,15 @@ package dhash
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/discord"
 	"github.com/zond/god/murmur"
+	"github.com/zond/god/persistence"
 	"github.com/zond/god/radix"
 	"github.com/zond/god/timenet"
+	"net/rpc"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -71,22 +74,56 @@ type Node struct {
 	migrateListeners []MigrateListener
 	commListeners    map[*commListenerContainer]bool
 	nCommListeners   int32
+	watchers         map[int64]*watcher
+	nWatchers        int32
+	nextWatcher      int64
+	transactionsLock *sync.Mutex
+	transactionLocks map[string]transactionLock
+	nextTransaction  int64
+	transacting      map[string]bool
+	views            map[string]view
+	viewSources      map[string]map[string]time.Time
+	nViewSources     int32
+	baseRedundancy   int
+	redundancies     map[string]int
+	treeAcls         map[string]treeAcl
+	secret           string
 	node             *discord.Node
 	timer            *timenet.Timer
 	tree             *radix.Tree
+	transactions     *radix.Tree
 }
 
 func NewNode(listenAddr, broadcastAddr string) *Node {
 	return NewNodeDir(listenAddr, broadcastAddr, broadcastAddr)
 }
 
-// NewNode will return a dhash.Node publishing itself on the given address.
+// NewNodeDir will return a dhash.Node publishing itself on the given address, and logging its data to dir unless it is the empty string.
 func NewNodeDir(listenAddr, broadcastAddr, dir string) (result *Node) {
+	var logger *persistence.Logger
+	if dir != "" {
+		logger = persistence.NewLogger(dir)
+	}
+	return NewNodeLogger(listenAddr, broadcastAddr, logger)
+}
+
+// NewNodeLogger will return a dhash.Node publishing itself on the given address, and logging its data using logger unless it is nil.
+func NewNodeLogger(listenAddr, broadcastAddr string, logger *persistence.Logger) (result *Node) {
 	result = &Node{
-		node:          discord.NewNode(listenAddr, broadcastAddr),
-		lock:          new(sync.RWMutex),
-		commListeners: make(map[*commListenerContainer]bool),
-		state:         created,
+		node:             discord.NewNode(listenAddr, broadcastAddr),
+		lock:             new(sync.RWMutex),
+		commListeners:    make(map[*commListenerContainer]bool),
+		watchers:         make(map[int64]*watcher),
+		nextWatcher:      time.Now().UnixNano(),
+		transactionsLock: new(sync.Mutex),
+		transactionLocks: make(map[string]transactionLock),
+		nextTransaction:  time.Now().UnixNano(),
+		transacting:      make(map[string]bool),
+		views:            make(map[string]view),
+		viewSources:      make(map[string]map[string]time.Time),
+		redundancies:     make(map[string]int),
+		treeAcls:         make(map[string]treeAcl),
+		state:            created,
 	}
 	result.node.AddCommListener(func(source, dest common.Remote, typ string) bool {
 		if result.hasState(started) {
@@ -106,14 +143,47 @@ func NewNodeDir(listenAddr, broadcastAddr, dir string) (result *Node) {
 	})
 	result.timer = timenet.NewTimer((*dhashPeerProducer)(result))
 	result.tree = radix.NewTreeTimer(result.timer)
-	if dir != "" {
-		result.tree.Log(dir).Restore()
+	result.transactions = radix.NewTreeTimer(result.timer)
+	if logger != nil {
+		result.tree.LogTo(logger).Restore()
+		conf, _ := result.tree.Configuration()
+		result.registerClusterRedundancy(conf)
+		result.registerSubConfigurations()
+		result.transactions.LogTo(logger.Child("transactions")).Restore()
+		result.restoreTransactions()
 	}
 	result.node.Export("Timenet", (*timerServer)(result.timer))
 	result.node.Export("DHash", (*dhashServer)(result))
 	result.node.Export("HashTree", (*hashTreeServer)(result))
+	result.node.SetAuthenticator(result.authenticate)
+	result.node.SetAuthorizer(result.authorize)
 	return
 }
+
+// registerSubConfiguration will make this node keep track of the sub tree under key if conf, its entire configuration, requires it.
+func (self *Node) registerSubConfiguration(key []byte, conf map[string]string) {
+	if conf[viewConf] != "" {
+		self.registerView(key)
+	}
+	self.registerRedundancy(key, conf)
+}
+
+// registerSubConfigurations will register all sub trees in the tree of this node according to their configurations. Used when the tree has been restored from disk.
+func (self *Node) registerSubConfigurations() {
+	var keys [][]byte
+	var confs []map[string]string
+	self.tree.ExportBetween(nil, nil, true, true, func(key, byteValue []byte, byteExists bool, timestamp, expiry int64, subTree *radix.Tree) bool {
+		if subTree != nil {
+			conf, _ := subTree.Configuration()
+			keys = append(keys, key)
+			confs = append(confs, conf)
+		}
+		return true
+	})
+	for index, key := range keys {
+		self.registerSubConfiguration(key, confs[index])
+	}
+}
 func (self *Node) AddCommListener(l CommListener) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -189,8 +259,33 @@ func (self *Node) Stop() {
 	}
 }
 
+// SetTLS will make this Node require TLS using config for all connections to both its net/rpc and HTTP ports, and make common.Switch use config
+// when connecting to other nodes. All nodes in a cluster must therefore use TLS, and trust the certificates of each other.
+//
+// If config requires client certificates, see common.NewTLSConfig, browsers using the web UI will have to present one as well.
+// It has to be called before the Node is started.
+func (self *Node) SetTLS(config *tls.Config) {
+	self.node.SetTLS(config)
+	common.Switch.SetTLS(config)
+}
+
+// SetZone will make this Node announce itself as part of zone, for example a rack or data center, so that the replicas of each key are spread over
+// as many zones as possible. The web UI shows the zone of each node.
+// It has to be called before the Node is started.
+func (self *Node) SetZone(zone string) {
+	self.node.SetZone(zone)
+}
+
+// SetVirtualNodes will make this Node occupy n positions in the ring, so that a server joining or leaving the cluster takes over or hands over
+// many small ranges of keys spread over all other servers instead of one big range shared with its neighbours.
+// Each position migrates on its own, handing keys over to the next position of another node.
+// It has to be called before the Node is started.
+func (self *Node) SetVirtualNodes(n int) {
+	self.node.SetVirtualNodes(n)
+}
+
 // Start will spin up this dhash.Node, including its discord.Node and timenet.Timer.
-// It will also start the sync, clean and migrate jobs.
+// It will also start the sync, clean, expire and migrate jobs, the job removing abandoned subscriptions, the job maintaining materialized views and the job resolving timed out transactions.
 func (self *Node) Start() (err error) {
 	if !self.changeState(created, started) {
 		return fmt.Errorf("%v can only be started when in state 'created'", self)
@@ -201,7 +296,11 @@ func (self *Node) Start() (err error) {
 	self.timer.Start()
 	go self.syncPeriodically()
 	go self.cleanPeriodically()
+	go self.expirePeriodically()
 	go self.migratePeriodically()
+	go self.cleanWatchersPeriodically()
+	go self.refreshViewsPeriodically()
+	go self.resolveTransactionsPeriodically()
 	self.startJson()
 	return
 }
@@ -220,25 +319,36 @@ func (self *Node) triggerSyncListeners(source, dest common.Remote, pulled, pushe
 	defer self.lock.Unlock()
 	self.syncListeners = newListeners
 }
+
+// sync will synchronize the keys between the predecessor of each position of this node and the position itself with the other replicas of that position,
+// and then the sub trees owned by this node that have a redundancy of their own.
+// Each replica only gets the byte values and sub trees that should be kept in more copies than its index among the replicas. At least as many replicas as the
+// redundancy of the ring are visited, so that the configuration of the cluster, and sub trees kept in more copies than the byte values, are spread.
 func (self *Node) sync() {
 	var pulled int
 	var pushed int
-	selfRemote := self.node.Remote()
-	nextSuccessor := self.node.GetSuccessor()
-	for i := 0; i < self.node.Redundancy()-1; i++ {
-		myPos := self.node.GetPosition()
-		remoteHash := remoteHashTree{
-			source:      selfRemote,
-			destination: nextSuccessor,
-			node:        self,
-		}
-		pushed = radix.NewSync(self.tree, remoteHash).From(self.node.GetPredecessor().Pos).To(myPos).Run().PutCount()
-		pulled = radix.NewSync(remoteHash, self.tree).From(self.node.GetPredecessor().Pos).To(myPos).Run().PutCount()
-		if pushed != 0 || pulled != 0 {
-			self.triggerSyncListeners(selfRemote, nextSuccessor, pulled, pushed)
+	redundancy := self.redundancy()
+	replicas := self.node.Redundancy()
+	if redundancy > replicas {
+		replicas = redundancy
+	}
+	for _, selfRemote := range self.node.Remotes() {
+		predPos := self.node.GetPredecessorForRemote(selfRemote).Pos
+		for index, replica := range self.node.GetReplicasForRemote(selfRemote, replicas)[1:] {
+			remoteHash := remoteHashTree{
+				source:      selfRemote,
+				destination: replica,
+				node:        self,
+			}
+			filter := self.replicaFilter(index+1, redundancy)
+			pushed = radix.NewSync(self.tree, remoteHash).From(predPos).To(selfRemote.Pos).Filter(filter).Run().PutCount()
+			pulled = radix.NewSync(remoteHash, localHashTree{self.tree, self}).From(predPos).To(selfRemote.Pos).Filter(filter).Run().PutCount()
+			if pushed != 0 || pulled != 0 {
+				self.triggerSyncListeners(selfRemote, replica, pulled, pushed)
+			}
 		}
-		nextSuccessor = self.node.GetSuccessorForRemote(nextSuccessor)
 	}
+	self.syncRedundancies()
 }
 func (self *Node) syncPeriodically() {
 	for self.hasState(started) {
@@ -252,6 +362,12 @@ func (self *Node) cleanPeriodically() {
 		time.Sleep(syncInterval)
 	}
 }
+func (self *Node) expirePeriodically() {
+	for self.hasState(started) {
+		self.tree.Expire()
+		time.Sleep(syncInterval)
+	}
+}
 func (self *Node) triggerMigrateListeners(oldPos, newPos []byte) {
 	self.lock.RLock()
 	newListeners := make([]MigrateListener, 0, len(self.migrateListeners))
@@ -267,15 +383,16 @@ func (self *Node) triggerMigrateListeners(oldPos, newPos []byte) {
 	defer self.lock.Unlock()
 	self.migrateListeners = newListeners
 }
-func (self *Node) changePosition(newPos []byte) {
+
+// changePosition will move the position me of this node to newPos.
+func (self *Node) changePosition(me common.Remote, newPos []byte) {
 	for len(newPos) < murmur.Size {
 		newPos = append(newPos, 0)
 	}
-	oldPos := self.node.GetPosition()
-	if bytes.Compare(newPos, oldPos) != 0 {
-		self.node.SetPosition(newPos)
+	if bytes.Compare(newPos, me.Pos) != 0 {
+		self.node.SetVirtualPosition(me.Index, newPos)
 		atomic.StoreInt64(&self.lastMigrate, time.Now().UnixNano())
-		self.triggerMigrateListeners(oldPos, newPos)
+		self.triggerMigrateListeners(me.Pos, newPos)
 	}
 }
 func (self *Node) isLeader() bool {
@@ -287,42 +404,75 @@ func (self *Node) migratePeriodically() {
 		time.Sleep(syncInterval)
 	}
 }
+
+// migrate will move at most one position of this node, see migratePosition.
 func (self *Node) migrate() {
 	lastAllowedChange := time.Now().Add(-1 * migrateWaitFactor * syncInterval).UnixNano()
 	if lastAllowedChange > common.Max64(atomic.LoadInt64(&self.lastSync), atomic.LoadInt64(&self.lastReroute), atomic.LoadInt64(&self.lastMigrate)) {
-		var succSize int
-		succ := self.node.GetSuccessor()
-		if err := succ.Call("DHash.Owned", 0, &succSize); err != nil {
+		for _, me := range self.node.Remotes() {
+			if self.migratePosition(me) {
+				return
+			}
+		}
+	}
+}
+
+// positionOwned will ask succ how many keys its position owns, falling back to how many keys it owns in total if it does not know about virtual positions.
+func (self *Node) positionOwned(succ common.Remote) (result int, err error) {
+	if err = succ.Call("DHash.PositionOwned", succ, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok && !common.IsAccessDenied(err) {
+			err = succ.Call("DHash.Owned", 0, &result)
+		}
+	}
+	return
+}
+
+// migratePosition will move the position me of this node towards its predecessor, handing keys over to its successor, if it owns a lot more keys
+// than the successor. It returns whether the position was moved.
+func (self *Node) migratePosition(me common.Remote) (moved bool) {
+	succ := self.node.GetSuccessorForRemote(me)
+	var succSize int
+	if succ.Addr == me.Addr {
+		if succ.Index == me.Index {
+			return
+		}
+		// Keys handed over to another position of this node will be handed over further once that position migrates.
+		succSize = self.PositionOwned(succ)
+	} else {
+		var err error
+		if succSize, err = self.positionOwned(succ); err != nil {
 			self.node.RemoveNode(succ)
+			return
+		}
+	}
+	mySize := self.PositionOwned(me)
+	if mySize > 10 && float64(mySize) > float64(succSize)*migrateHysteresis {
+		wantedDelta := (mySize - succSize) / 2
+		var existed bool
+		var wantedPos []byte
+		pred := self.node.GetPredecessorForRemote(me)
+		if bytes.Compare(pred.Pos, me.Pos) < 1 {
+			if wantedPos, existed = self.tree.NextMarkerIndex(self.tree.RealSizeBetween(nil, me.Pos, true, false) - wantedDelta); !existed {
+				return
+			}
 		} else {
-			mySize := self.Owned()
-			if mySize > 10 && float64(mySize) > float64(succSize)*migrateHysteresis {
-				wantedDelta := (mySize - succSize) / 2
-				var existed bool
-				var wantedPos []byte
-				pred := self.node.GetPredecessor()
-				if bytes.Compare(pred.Pos, self.node.GetPosition()) < 1 {
-					if wantedPos, existed = self.tree.NextMarkerIndex(self.tree.RealSizeBetween(nil, self.node.GetPosition(), true, false) - wantedDelta); !existed {
-						return
-					}
-				} else {
-					ownedAfterNil := self.tree.RealSizeBetween(nil, succ.Pos, true, false)
-					if ownedAfterNil > wantedDelta {
-						if wantedPos, existed = self.tree.NextMarkerIndex(ownedAfterNil - wantedDelta); !existed {
-							return
-						}
-					} else {
-						if wantedPos, existed = self.tree.NextMarkerIndex(self.tree.RealSize() + ownedAfterNil - wantedDelta); !existed {
-							return
-						}
-					}
+			ownedAfterNil := self.tree.RealSizeBetween(nil, succ.Pos, true, false)
+			if ownedAfterNil > wantedDelta {
+				if wantedPos, existed = self.tree.NextMarkerIndex(ownedAfterNil - wantedDelta); !existed {
+					return
 				}
-				if common.BetweenIE(wantedPos, self.node.GetPredecessor().Pos, self.node.GetPosition()) {
-					self.changePosition(wantedPos)
+			} else {
+				if wantedPos, existed = self.tree.NextMarkerIndex(self.tree.RealSize() + ownedAfterNil - wantedDelta); !existed {
+					return
 				}
 			}
 		}
+		if common.BetweenIE(wantedPos, pred.Pos, me.Pos) {
+			self.changePosition(me, wantedPos)
+			moved = true
+		}
 	}
+	return
 }
 func (self *Node) circularNext(key []byte) (nextKey []byte, existed bool) {
 	if nextKey, existed = self.tree.NextMarker(key); existed {
@@ -336,18 +486,26 @@ func (self *Node) circularNext(key []byte) (nextKey []byte, existed bool) {
 	return
 }
 func (self *Node) owners(key []byte) (owners common.Remotes, isOwner bool) {
-	owners = append(owners, self.node.GetSuccessorFor(key))
-	if owners[0].Addr == self.node.GetBroadcastAddr() {
-		isOwner = true
-	}
-	for i := 1; i < self.node.Redundancy(); i++ {
-		owners = append(owners, self.node.GetSuccessorForRemote(owners[i-1]))
-		if owners[i].Addr == self.node.GetBroadcastAddr() {
+	owners = self.node.GetReplicasForRemote(self.node.GetSuccessorFor(key), self.redundancy())
+	for _, owner := range owners {
+		if owner.Addr == self.node.GetBroadcastAddr() {
 			isOwner = true
 		}
 	}
 	return
 }
+
+// nextReplica returns the replica of key following this node, according to our ring, or our successor if we are not one of its replicas.
+// All nodes are considered replicas, since it is the TTL of what is replicated that decides how many of them get it.
+func (self *Node) nextReplica(key []byte) common.Remote {
+	replicas := self.node.GetReplicasFor(key, self.node.CountHosts())
+	for index, replica := range replicas[:len(replicas)-1] {
+		if replica.Addr == self.node.GetBroadcastAddr() {
+			return replicas[index+1]
+		}
+	}
+	return self.node.GetSuccessor()
+}
 func (self *Node) triggerCleanListeners(source, dest common.Remote, cleaned, pushed int) {
 	self.lock.RLock()
 	newListeners := make([]CleanListener, 0, len(self.cleanListeners))
@@ -364,10 +522,18 @@ func (self *Node) triggerCleanListeners(source, dest common.Remote, cleaned, pus
 	self.cleanListeners = newListeners
 }
 func (self *Node) clean() {
-	selfRemote := self.node.Remote()
+	for _, selfRemote := range self.node.Remotes() {
+		self.cleanAfter(selfRemote)
+	}
+	self.cleanRedundancies()
+}
+
+// cleanAfter will push the segment after selfRemote to its owners and remove it from this node, if this node is not one of them.
+// Sub trees with a redundancy of their own are left to cleanRedundancies.
+func (self *Node) cleanAfter(selfRemote common.Remote) {
 	var cleaned int
 	var pushed int
-	if nextKey, existed := self.circularNext(self.node.GetPosition()); existed {
+	if nextKey, existed := self.circularNext(selfRemote.Pos); existed {
 		if owners, isOwner := self.owners(nextKey); !isOwner {
 			var sync *radix.Sync
 			for index, owner := range owners {
@@ -375,7 +541,9 @@ func (self *Node) clean() {
 					source:      selfRemote,
 					destination: owner,
 					node:        self,
-				}).From(nextKey).To(owners[0].Pos)
+				}).From(nextKey).To(owners[0].Pos).Filter(func(key []byte, subTree bool) bool {
+					return !subTree || self.ownRedundancy(key) == 0
+				})
 				if index == len(owners)-2 {
 					sync.Destroy()
 				}
@@ -403,18 +571,54 @@ func (self *Node) Time() time.Time {
 	return time.Unix(0, self.timer.ContinuousTime())
 }
 
+// ownedRanges returns the parts of r that this node has responsibility for, in key order.
+func (self *Node) ownedRanges(r common.Range) (result []common.Range) {
+	for _, segment := range self.ownedSegments() {
+		if owned, ok := r.Within(segment.min, segment.max); ok {
+			result = append(result, owned)
+		}
+	}
+	return
+}
+
+// eachOwned will iterate over the byte values in r that this node has responsibility for using f, in key order if up and in reverse key order otherwise.
+func (self *Node) eachOwned(r common.Range, up bool, f radix.TreeIterator) {
+	ranges := self.ownedRanges(r)
+	cont := true
+	iterator := func(key, value []byte, version int64) bool {
+		cont = f(key, value, version)
+		return cont
+	}
+	for index := 0; index < len(ranges) && cont; index++ {
+		if up {
+			owned := ranges[index]
+			self.tree.EachBetween(owned.Min, owned.Max, owned.MinInc, owned.MaxInc, iterator)
+		} else {
+			owned := ranges[len(ranges)-1-index]
+			self.tree.ReverseEachBetween(owned.Min, owned.Max, owned.MinInc, owned.MaxInc, iterator)
+		}
+	}
+}
+func prefixRange(prefix []byte) common.Range {
+	return common.Range{
+		Min:    prefix,
+		Max:    common.PrefixEnd(prefix),
+		MinInc: true,
+	}
+}
+
 // Owned returns the number of items, including tombstones, that this node has responsibility for.
-func (self *Node) Owned() int {
-	pred := self.node.GetPredecessor()
-	me := self.node.Remote()
-	cmp := bytes.Compare(pred.Pos, me.Pos)
-	if cmp < 0 {
-		return self.tree.RealSizeBetween(pred.Pos, me.Pos, true, false)
-	} else if cmp > 0 {
-		return self.tree.RealSizeBetween(pred.Pos, nil, true, false) + self.tree.RealSizeBetween(nil, me.Pos, true, false)
-	}
-	if pred.Less(me) {
-		return 0
-	}
-	return self.tree.RealSize()
+func (self *Node) Owned() (result int) {
+	for _, segment := range self.ownedSegments() {
+		result += self.tree.RealSizeBetween(segment.min, segment.max, true, false)
+	}
+	return
+}
+
+// PositionOwned returns the number of keys, including tombstones, owned by the position me of this node.
+func (self *Node) PositionOwned(me common.Remote) (result int) {
+	for _, segment := range self.positionSegments(me) {
+		result += self.tree.RealSizeBetween(segment.min, segment.max, true, false)
+	}
+	return
 }
@@ -12,19 +12,67 @@ func (self *dhashServer) Clear(x int, y *int) error {
 	return nil
 }
 func (self *dhashServer) SlaveSubPut(data common.Item, x *int) error {
-	return (*Node)(self).subPut(data)
+	(*Node)(self).subPut(data)
+	return nil
 }
 func (self *dhashServer) SlaveSubClear(data common.Item, x *int) error {
-	return (*Node)(self).subClear(data)
+	(*Node)(self).subClear(data)
+	return nil
+}
+func (self *dhashServer) SlaveSubPrefixDelete(data common.Item, x *int) error {
+	(*Node)(self).subPrefixDelete(data)
+	return nil
 }
 func (self *dhashServer) SlaveSubDel(data common.Item, x *int) error {
-	return (*Node)(self).subDel(data)
+	(*Node)(self).subDel(data)
+	return nil
 }
 func (self *dhashServer) SlaveDel(data common.Item, x *int) error {
-	return (*Node)(self).del(data)
+	(*Node)(self).del(data)
+	return nil
 }
 func (self *dhashServer) SlavePut(data common.Item, x *int) error {
-	return (*Node)(self).put(data)
+	(*Node)(self).put(data)
+	return nil
+}
+func (self *dhashServer) SlaveBatch(data common.Batch, results *[]common.BatchResult) error {
+	*results = (*Node)(self).batch(data)
+	return nil
+}
+func (self *dhashServer) Subscribe(w common.Watch, id *int64) error {
+	return (*Node)(self).Subscribe(w, id)
+}
+func (self *dhashServer) Poll(id int64, result *common.Events) error {
+	return (*Node)(self).Poll(id, result)
+}
+func (self *dhashServer) Unsubscribe(id int64, x *int) error {
+	(*Node)(self).Unsubscribe(id)
+	return nil
+}
+func (self *dhashServer) Export(r common.BackupRange, result *[]common.BackupRecord) error {
+	return (*Node)(self).Export(r, result)
+}
+func (self *dhashServer) Import(records []common.BackupRecord, x *int) error {
+	(*Node)(self).Import(records)
+	return nil
+}
+func (self *dhashServer) Batch(data common.Batch, results *[]common.BatchResult) error {
+	return (*Node)(self).Batch(data, results)
+}
+func (self *dhashServer) Transact(data common.Transaction, result *common.TransactionResult) error {
+	return (*Node)(self).Transact(data, result)
+}
+func (self *dhashServer) PrepareTransaction(data common.Transaction, result *bool) error {
+	return (*Node)(self).PrepareTransaction(data, result)
+}
+func (self *dhashServer) CommitTransaction(data common.Transaction, result *bool) error {
+	return (*Node)(self).CommitTransaction(data, result)
+}
+func (self *dhashServer) AbortTransaction(data common.Transaction, x *int) error {
+	return (*Node)(self).AbortTransaction(data, x)
+}
+func (self *dhashServer) TransactionDecision(id string, result *int) error {
+	return (*Node)(self).TransactionDecision(id, result)
 }
 func (self *dhashServer) SubDel(data common.Item, x *int) error {
 	return (*Node)(self).SubDel(data)
@@ -32,15 +80,39 @@ func (self *dhashServer) SubDel(data common.Item, x *int) error {
 func (self *dhashServer) SubClear(data common.Item, x *int) error {
 	return (*Node)(self).SubClear(data)
 }
+func (self *dhashServer) SubPrefixDelete(data common.Item, deleted *int) error {
+	return (*Node)(self).SubPrefixDelete(data, deleted)
+}
+func (self *dhashServer) PrefixDelete(data common.Item, deleted *int) error {
+	return (*Node)(self).PrefixDelete(data, deleted)
+}
 func (self *dhashServer) SubPut(data common.Item, x *int) error {
 	return (*Node)(self).SubPut(data)
 }
+func (self *dhashServer) SubPutTTL(data common.Item, x *int) error {
+	return (*Node)(self).SubPutTTL(data)
+}
+func (self *dhashServer) SubCompareAndSwap(data common.CAS, result *common.CASResult) error {
+	return (*Node)(self).SubCompareAndSwap(data, result)
+}
+func (self *dhashServer) CompareAndSwap(data common.CAS, result *common.CASResult) error {
+	return (*Node)(self).CompareAndSwap(data, result)
+}
+func (self *dhashServer) SubIncr(data common.Incr, result *common.IncrResult) error {
+	return (*Node)(self).SubIncr(data, result)
+}
+func (self *dhashServer) Incr(data common.Incr, result *common.IncrResult) error {
+	return (*Node)(self).Incr(data, result)
+}
 func (self *dhashServer) Del(data common.Item, x *int) error {
 	return (*Node)(self).Del(data)
 }
 func (self *dhashServer) Put(data common.Item, x *int) error {
 	return (*Node)(self).Put(data)
 }
+func (self *dhashServer) PutTTL(data common.Item, x *int) error {
+	return (*Node)(self).PutTTL(data)
+}
 func (self *dhashServer) RingHash(x int, result *[]byte) error {
 	return (*Node)(self).RingHash(x, result)
 }
@@ -59,6 +131,12 @@ func (self *dhashServer) Prev(data common.Item, result *common.Item) error {
 func (self *dhashServer) SubGet(data common.Item, result *common.Item) error {
 	return (*Node)(self).SubGet(data, result)
 }
+func (self *dhashServer) SubMGet(data []common.Item, result *[]common.Item) error {
+	return (*Node)(self).SubMGet(data, result)
+}
+func (self *dhashServer) MGet(data []common.Item, result *[]common.Item) error {
+	return (*Node)(self).MGet(data, result)
+}
 func (self *dhashServer) Get(data common.Item, result *common.Item) error {
 	return (*Node)(self).Get(data, result)
 }
@@ -73,6 +151,10 @@ func (self *dhashServer) Owned(x int, result *int) error {
 	*result = (*Node)(self).Owned()
 	return nil
 }
+func (self *dhashServer) PositionOwned(r common.Remote, result *int) error {
+	*result = (*Node)(self).PositionOwned(r)
+	return nil
+}
 func (self *dhashServer) Describe(x int, result *common.DHashDescription) error {
 	*result = (*Node)(self).Description()
 	return nil
@@ -99,6 +181,12 @@ func (self *dhashServer) MirrorReverseIndexOf(data common.Item, result *common.I
 func (self *dhashServer) MirrorIndexOf(data common.Item, result *common.Index) error {
 	return (*Node)(self).MirrorIndexOf(data, result)
 }
+func (self *dhashServer) IndexSlice(r common.Range, result *[]common.Item) error {
+	return (*Node)(self).IndexSlice(r, result)
+}
+func (self *dhashServer) IndexIndexOf(r common.Range, result *common.Index) error {
+	return (*Node)(self).IndexIndexOf(r, result)
+}
 func (self *dhashServer) ReverseIndexOf(data common.Item, result *common.Index) error {
 	return (*Node)(self).ReverseIndexOf(data, result)
 }
@@ -153,6 +241,36 @@ func (self *dhashServer) ReverseSlice(r common.Range, result *[]common.Item) err
 func (self *dhashServer) Slice(r common.Range, result *[]common.Item) error {
 	return (*Node)(self).Slice(r, result)
 }
+func (self *dhashServer) Aggregate(a common.Aggregate, result *common.AggregateResult) error {
+	return (*Node)(self).Aggregate(a, result)
+}
+func (self *dhashServer) TopSlice(r common.Range, result *[]common.Item) error {
+	return (*Node)(self).TopSlice(r, result)
+}
+func (self *dhashServer) TopReverseSlice(r common.Range, result *[]common.Item) error {
+	return (*Node)(self).TopReverseSlice(r, result)
+}
+func (self *dhashServer) TopSliceLen(r common.Range, result *[]common.Item) error {
+	return (*Node)(self).TopSliceLen(r, result)
+}
+func (self *dhashServer) TopReverseSliceLen(r common.Range, result *[]common.Item) error {
+	return (*Node)(self).TopReverseSliceLen(r, result)
+}
+func (self *dhashServer) TopCount(r common.Range, result *int) error {
+	return (*Node)(self).TopCount(r, result)
+}
+func (self *dhashServer) PrefixSlice(data common.Item, result *[]common.Item) error {
+	return (*Node)(self).PrefixSlice(data, result)
+}
+func (self *dhashServer) PrefixCount(data common.Item, result *int) error {
+	return (*Node)(self).PrefixCount(data, result)
+}
+func (self *dhashServer) SubPrefixSlice(data common.Item, result *[]common.Item) error {
+	return (*Node)(self).SubPrefixSlice(data, result)
+}
+func (self *dhashServer) SubPrefixCount(data common.Item, result *int) error {
+	return (*Node)(self).SubPrefixCount(data, result)
+}
 func (self *dhashServer) SliceIndex(r common.Range, result *[]common.Item) error {
 	return (*Node)(self).SliceIndex(r, result)
 }
@@ -168,6 +286,12 @@ func (self *dhashServer) ReverseSliceLen(r common.Range, result *[]common.Item)
 func (self *dhashServer) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) error {
 	return (*Node)(self).SetExpression(expr, items)
 }
+func (self *dhashServer) DistributedSetExpression(expr setop.SetExpression, result *common.SetExpressionResult) error {
+	return (*Node)(self).DistributedSetExpression(expr, result)
+}
+func (self *dhashServer) SetOpSlice(s SetOpSlice, results *[]setop.SetOpResult) error {
+	return (*Node)(self).SetOpSlice(s, results)
+}
 
 func (self *dhashServer) AddConfiguration(c common.ConfItem, x *int) error {
 	(*Node)(self).AddConfiguration(c)
@@ -178,7 +302,14 @@ func (self *dhashServer) SlaveSubAddConfiguration(c common.ConfItem, x *int) err
 	return nil
 }
 func (self *dhashServer) SubAddConfiguration(c common.ConfItem, x *int) error {
-	(*Node)(self).SubAddConfiguration(c)
+	return (*Node)(self).SubAddConfiguration(c)
+}
+func (self *dhashServer) AddViewSource(data common.Item, added *bool) error {
+	*added = (*Node)(self).AddViewSource(data.Key, data.SubKey)
+	return nil
+}
+func (self *dhashServer) InvalidateView(key []byte, x *int) error {
+	(*Node)(self).InvalidateView(key)
 	return nil
 }
 func (self *dhashServer) Configuration(x int, result *common.Conf) error {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
 	"os"
 	"runtime"
 	"sort"
@@ -32,25 +33,41 @@ func countHaving(t *testing.T, dhashes []*Node, key, value []byte) (result int)
 	return
 }
 
+func countSubHaving(t *testing.T, dhashes []*Node, key, subKey, value []byte) (result int) {
+	for _, d := range dhashes {
+		if foundValue, _, existed := d.tree.SubGet(key, subKey); existed && bytes.Compare(foundValue, value) == 0 {
+			result++
+		}
+	}
+	return
+}
+
 func testStartup(t *testing.T, n, port int) (dhashes []*Node) {
+	return testStartupVirtual(t, n, 1, port)
+}
+
+func testStartupVirtual(t *testing.T, n, virtualNodes, port int) (dhashes []*Node) {
 	for i := 0; i < n; i++ {
 		os.RemoveAll(fmt.Sprintf("127.0.0.1:%v", port+i*2))
 	}
 	dhashes = make([]*Node, n)
 	for i := 0; i < n; i++ {
 		dhashes[i] = NewNode(fmt.Sprintf("127.0.0.1:%v", port+i*2), fmt.Sprintf("127.0.0.1:%v", port+i*2))
+		dhashes[i].SetVirtualNodes(virtualNodes)
 		dhashes[i].MustStart()
 	}
 	for i := 1; i < n; i++ {
 		dhashes[i].MustJoin(fmt.Sprintf("127.0.0.1:%v", port))
 	}
+	// Join only announces the primary position of each node. The other positions spread one ping interval at a time, as successors are notified
+	// and rings are compared, so rings with more positions per node need proportionally longer to converge.
 	common.AssertWithin(t, func() (string, bool) {
 		routes := make(map[string]bool)
 		for _, dhash := range dhashes {
 			routes[dhash.node.GetNodes().Describe()] = true
 		}
-		return fmt.Sprint(routes), len(routes) == 1
-	}, time.Second*10)
+		return fmt.Sprint(routes), len(routes) == 1 && len(dhashes[0].node.GetNodes()) == n*virtualNodes
+	}, time.Second*10*time.Duration(virtualNodes))
 	return
 }
 
@@ -119,6 +136,122 @@ func testMigrate(t *testing.T, dhashes []*Node) {
 	}, time.Second*100)
 }
 
+func testVirtualOwners(t *testing.T, dhashes []*Node) {
+	for _, d := range dhashes {
+		for _, key := range [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")} {
+			owners, _ := d.owners(key)
+			addrs := make(map[string]bool)
+			for _, owner := range owners {
+				addrs[owner.Addr] = true
+			}
+			if len(owners) != common.Redundancy || len(addrs) != common.Redundancy {
+				t.Errorf("%v should have %v owners on different nodes, but got %v", common.HexEncode(key), common.Redundancy, owners)
+			}
+		}
+	}
+}
+
+func testVirtualOwned(t *testing.T, dhashes []*Node) {
+	var item common.Item
+	for i := 0; i < 100; i++ {
+		item.Key = murmur.HashString(fmt.Sprint(i))
+		item.Value = []byte(fmt.Sprint(i))
+		item.Timestamp = 1
+		dhashes[0].Put(item)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		sum := 0
+		status := new(bytes.Buffer)
+		ok := true
+		for _, d := range dhashes {
+			sum += d.Owned()
+			fmt.Fprintf(status, "%v %v %v\n", d.node.GetBroadcastAddr(), d.Owned(), d.tree.RealSize())
+			if d.Owned() == 0 || d.tree.RealSize() >= 100 {
+				ok = false
+			}
+		}
+		for i := 0; i < 100; i++ {
+			if having := countHaving(t, dhashes, murmur.HashString(fmt.Sprint(i)), []byte(fmt.Sprint(i))); having != common.Redundancy {
+				fmt.Fprintf(status, "%v is on %v nodes\n", i, having)
+				ok = false
+			}
+		}
+		return string(status.Bytes()), ok && sum == 100
+	}, time.Second*20)
+}
+
+func testRedundancy(t *testing.T, dhashes []*Node) {
+	redundancies := map[string]int{
+		"cache":    1,
+		"critical": len(dhashes),
+		"plain":    common.Redundancy,
+	}
+	owners := make(map[string]*Node)
+	for key, redundancy := range redundancies {
+		successor := dhashes[0].node.GetSuccessorFor([]byte(key))
+		for _, d := range dhashes {
+			if d.node.GetBroadcastAddr() == successor.Addr {
+				owners[key] = d
+			}
+		}
+		if key != "plain" {
+			owners[key].SubAddConfiguration(common.ConfItem{TreeKey: []byte(key), Key: redundancyConf, Value: fmt.Sprint(redundancy)})
+		}
+		owners[key].SubPut(common.Item{Key: []byte(key), SubKey: []byte("k"), Value: []byte(key), Sync: true})
+		if having := countSubHaving(t, dhashes, []byte(key), []byte("k"), []byte(key)); having != redundancy {
+			t.Errorf("%v should be on %v nodes when written synchronously, but is on %v", key, redundancy, having)
+		}
+	}
+	time.Sleep(syncInterval * 3)
+	for key, redundancy := range redundancies {
+		if having := countSubHaving(t, dhashes, []byte(key), []byte("k"), []byte(key)); having != redundancy {
+			t.Errorf("%v should stay on %v nodes, but is on %v", key, redundancy, having)
+		}
+	}
+	// Batches from nodes without redundancy per tree have no TTL per op.
+	owners["plain"].batch(common.Batch{
+		TTL:  common.Redundancy,
+		Sync: true,
+		Ops: []common.BatchOp{
+			{Type: common.BatchPut, Key: []byte("old"), Value: []byte("old"), Timestamp: owners["plain"].timer.ContinuousTime()},
+		},
+	})
+	if having := countHaving(t, dhashes, []byte("old"), []byte("old")); having != common.Redundancy {
+		t.Errorf("old should be on %v nodes when written by an old node, but is on %v", common.Redundancy, having)
+	}
+	owners["critical"].SubAddConfiguration(common.ConfItem{TreeKey: []byte("critical"), Key: redundancyConf, Value: "2"})
+	common.AssertWithin(t, func() (string, bool) {
+		having := countSubHaving(t, dhashes, []byte("critical"), []byte("k"), []byte("critical"))
+		return fmt.Sprint(having), having == 2
+	}, time.Second*20)
+}
+
+func testVirtualMigrate(t *testing.T, dhashes []*Node) {
+	for _, d := range dhashes {
+		d.Clear()
+	}
+	var item common.Item
+	for i := 0; i < 1000; i++ {
+		item.Key = []byte(fmt.Sprint(i))
+		item.Value = []byte(fmt.Sprint(i))
+		item.Timestamp = 1
+		dhashes[0].Put(item)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		sum := 0
+		status := new(bytes.Buffer)
+		ok := true
+		for _, d := range dhashes {
+			sum += d.Owned()
+			fmt.Fprintf(status, "%v %v\n", d.node.GetBroadcastAddr(), d.Owned())
+			if d.Owned() == 0 {
+				ok = false
+			}
+		}
+		return string(status.Bytes()), ok && sum == 1000
+	}, time.Second*100)
+}
+
 func stopServers(servers []*Node) {
 	for _, d := range servers {
 		d.Stop()
@@ -133,3 +266,19 @@ func TestDHash(t *testing.T) {
 	testPut(t, dhashes)
 	testMigrate(t, dhashes)
 }
+
+func TestVirtualNodes(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	dhashes := testStartupVirtual(t, 4, 4, 15191)
+	defer stopServers(dhashes)
+	testVirtualOwners(t, dhashes)
+	testVirtualOwned(t, dhashes)
+	testVirtualMigrate(t, dhashes)
+}
+
+func TestRedundancy(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	dhashes := testStartup(t, 5, 16191)
+	defer stopServers(dhashes)
+	testRedundancy(t, dhashes)
+}
@@ -12,6 +12,7 @@ type HashTreeItem struct {
 	SubKey    []radix.Nibble
 	Timestamp int64
 	Expected  int64
+	Expiry    int64
 	Value     []byte
 	Exists    bool
 }
@@ -21,17 +22,23 @@ type hashTreeServer Node
 func (self *hashTreeServer) Configure(conf common.Conf, x *int) error {
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	(*Node)(self).tree.Configure(conf.Data, conf.Timestamp)
+	(*Node)(self).registerClusterRedundancy(conf.Data)
 	return nil
 }
 func (self *hashTreeServer) SubConfigure(conf common.Conf, x *int) error {
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	(*Node)(self).tree.SubConfigure(conf.TreeKey, conf.Data, conf.Timestamp)
+	(*Node)(self).registerSubConfiguration(conf.TreeKey, conf.Data)
 	return nil
 }
 func (self *hashTreeServer) Hash(x int, result *[]byte) error {
 	*result = (*Node)(self).tree.Hash()
 	return nil
 }
+func (self *hashTreeServer) SubHash(key []byte, result *[]byte) error {
+	*result = (*Node)(self).tree.SubHash(key)
+	return nil
+}
 func (self *hashTreeServer) Finger(key []radix.Nibble, result *radix.Print) error {
 	*result = *((*Node)(self).tree.Finger(key))
 	return nil
@@ -39,12 +46,12 @@ func (self *hashTreeServer) Finger(key []radix.Nibble, result *radix.Print) erro
 func (self *hashTreeServer) GetTimestamp(key []radix.Nibble, result *HashTreeItem) error {
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*result = HashTreeItem{Key: key}
-	result.Value, result.Timestamp, result.Exists = (*Node)(self).tree.GetTimestamp(key)
+	result.Value, result.Timestamp, result.Expiry, result.Exists = (*Node)(self).tree.GetTimestamp(key)
 	return nil
 }
 func (self *hashTreeServer) PutTimestamp(data HashTreeItem, changed *bool) error {
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
-	*changed = (*Node)(self).tree.PutTimestamp(data.Key, data.Value, data.Exists, data.Expected, data.Timestamp)
+	*changed = (*Node)(self).tree.PutTimestamp(data.Key, data.Value, data.Exists, data.Expected, data.Timestamp, data.Expiry)
 	return nil
 }
 func (self *hashTreeServer) DelTimestamp(data HashTreeItem, changed *bool) error {
@@ -59,12 +66,12 @@ func (self *hashTreeServer) SubFinger(data HashTreeItem, result *radix.Print) er
 func (self *hashTreeServer) SubGetTimestamp(data HashTreeItem, result *HashTreeItem) error {
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*result = data
-	result.Value, result.Timestamp, result.Exists = (*Node)(self).tree.SubGetTimestamp(data.Key, data.SubKey)
+	result.Value, result.Timestamp, result.Expiry, result.Exists = (*Node)(self).tree.SubGetTimestamp(data.Key, data.SubKey)
 	return nil
 }
 func (self *hashTreeServer) SubPutTimestamp(data HashTreeItem, changed *bool) error {
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
-	*changed = (*Node)(self).tree.SubPutTimestamp(data.Key, data.SubKey, data.Value, data.Exists, data.Expected, data.Timestamp)
+	*changed = (*Node)(self).tree.SubPutTimestamp(data.Key, data.SubKey, data.Value, data.Exists, data.Expected, data.Timestamp, data.Expiry)
 	return nil
 }
 func (self *hashTreeServer) SubDelTimestamp(data HashTreeItem, changed *bool) error {
@@ -14,7 +14,8 @@ import (
 // It is NOT meant to be used as a real client, since if you are using Go anyway the client.Conn type is much more efficient.
 type JSONClient string
 
-func (self JSONClient) call(action string, params, result interface{}) {
+// call will call action with params and decode the response into result, or return the error the server responded with.
+func (self JSONClient) call(action string, params, result interface{}) (err error) {
 	client := new(http.Client)
 	buf := new(bytes.Buffer)
 	if params != nil {
@@ -32,11 +33,19 @@ func (self JSONClient) call(action string, params, result interface{}) {
 	if err != nil {
 		panic(err)
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var message string
+		if err = json.NewDecoder(resp.Body).Decode(&message); err != nil {
+			panic(err)
+		}
+		return fmt.Errorf("%v", message)
+	}
 	err = json.NewDecoder(resp.Body).Decode(result)
 	if err != nil {
 		panic(err)
 	}
-	resp.Body.Close()
+	return
 }
 func (self JSONClient) SSubPut(key, subKey, value []byte) {
 	var x Nothing
@@ -260,6 +269,28 @@ func (self JSONClient) MirrorReverseSlice(key, min, max []byte, mininc, maxinc b
 	self.call("MirrorReverseSlice", item, &result)
 	return result
 }
+func (self JSONClient) IndexSlice(key []byte, name string, min, max []byte, mininc, maxinc bool) (result []common.Item) {
+	item := NamedIndexRange{
+		Key:    key,
+		Name:   name,
+		Min:    min,
+		Max:    max,
+		MinInc: mininc,
+		MaxInc: maxinc,
+	}
+	self.call("IndexSlice", item, &result)
+	return result
+}
+func (self JSONClient) IndexIndexOf(key []byte, name string, indexKey []byte) (index int, existed bool) {
+	item := NamedIndexKeyReq{
+		Key:      key,
+		Name:     name,
+		IndexKey: indexKey,
+	}
+	var result common.Index
+	self.call("IndexIndexOf", item, &result)
+	return result.N, result.Existed
+}
 func (self JSONClient) MirrorSlice(key, min, max []byte, mininc, maxinc bool) (result []common.Item) {
 	item := KeyRange{
 		Key:    key,
@@ -436,6 +467,21 @@ func (self JSONClient) Get(key []byte) (value []byte, existed bool) {
 	self.call("Get", item, &result)
 	return result.Value, result.Exists
 }
+func (self JSONClient) SubMGet(key []byte, subKeys [][]byte) (result []common.Item) {
+	item := SubKeysReq{
+		Key:     key,
+		SubKeys: subKeys,
+	}
+	self.call("SubMGet", item, &result)
+	return result
+}
+func (self JSONClient) MGet(keys [][]byte) (result []common.Item) {
+	item := KeysReq{
+		Keys: keys,
+	}
+	self.call("MGet", item, &result)
+	return result
+}
 func (self JSONClient) SubSize(key []byte) (result int) {
 	self.call("SubSize", KeyReq{Key: key}, &result)
 	return result
@@ -456,12 +502,12 @@ func (self JSONClient) AddConfiguration(key, value string) {
 	var x Nothing
 	self.call("AddConfiguration", conf, &x)
 }
-func (self JSONClient) SubAddConfiguration(treeKey []byte, key, value string) {
+func (self JSONClient) SubAddConfiguration(treeKey []byte, key, value string) error {
 	conf := SubConf{
 		TreeKey: treeKey,
 		Key:     key,
 		Value:   value,
 	}
 	var x Nothing
-	self.call("SubAddConfiguration", conf, &x)
+	return self.call("SubAddConfiguration", conf, &x)
 }
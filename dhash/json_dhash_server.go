@@ -55,6 +55,13 @@ type KeyOp struct {
 type KeyReq struct {
 	Key []byte
 }
+type KeysReq struct {
+	Keys [][]byte
+}
+type SubKeysReq struct {
+	Key     []byte
+	SubKeys [][]byte
+}
 type KeyRange struct {
 	Key    []byte
 	Min    []byte
@@ -62,6 +69,19 @@ type KeyRange struct {
 	MinInc bool
 	MaxInc bool
 }
+type NamedIndexRange struct {
+	Key    []byte
+	Name   string
+	Min    []byte
+	Max    []byte
+	MinInc bool
+	MaxInc bool
+}
+type NamedIndexKeyReq struct {
+	Key      []byte
+	Name     string
+	IndexKey []byte
+}
 type IndexRange struct {
 	Key      []byte
 	MinIndex *int
@@ -255,6 +275,27 @@ func (self *JSONApi) Get(k KeyReq, result *ValueRes) (err error) {
 	}
 	return
 }
+func (self *JSONApi) SubMGet(k SubKeysReq, result *[]SubValueRes) (err error) {
+	for _, item := range (*Node)(self).client().SubMGet(k.Key, k.SubKeys) {
+		*result = append(*result, SubValueRes{
+			Key:    item.Key,
+			SubKey: item.SubKey,
+			Value:  item.Value,
+			Exists: item.Exists,
+		})
+	}
+	return nil
+}
+func (self *JSONApi) MGet(k KeysReq, result *[]ValueRes) (err error) {
+	for _, item := range (*Node)(self).client().MGet(k.Keys) {
+		*result = append(*result, ValueRes{
+			Key:    item.Key,
+			Value:  item.Value,
+			Exists: item.Exists,
+		})
+	}
+	return nil
+}
 func (self *JSONApi) Size(x Nothing, result *int) (err error) {
 	*result = (*Node)(self).Size()
 	return nil
@@ -377,6 +418,18 @@ func (self *JSONApi) MirrorIndexOf(i SubKeyReq, result *common.Index) (err error
 	}
 	return
 }
+func (self *JSONApi) IndexIndexOf(i NamedIndexKeyReq, result *common.Index) (err error) {
+	r := common.Range{
+		Key:  i.Key,
+		Name: i.Name,
+		Min:  i.IndexKey,
+	}
+	var f bool
+	if f, err = self.forwardUnlessMe("DHash.IndexIndexOf", r.Key, r, result); !f {
+		err = (*Node)(self).IndexIndexOf(r, result)
+	}
+	return
+}
 func (self *JSONApi) ReverseIndexOf(i SubKeyReq, result *common.Index) (err error) {
 	data := common.Item{
 		Key:    i.Key,
@@ -555,6 +608,23 @@ func (self *JSONApi) MirrorReverseSlice(kr KeyRange, result *[]ValueRes) (err er
 	self.convert(items, result)
 	return
 }
+func (self *JSONApi) IndexSlice(ir NamedIndexRange, result *[]ValueRes) (err error) {
+	r := common.Range{
+		Key:    ir.Key,
+		Name:   ir.Name,
+		Min:    ir.Min,
+		Max:    ir.Max,
+		MinInc: ir.MinInc,
+		MaxInc: ir.MaxInc,
+	}
+	var items []common.Item
+	var f bool
+	if f, err = self.forwardUnlessMe("DHash.IndexSlice", r.Key, r, &items); !f {
+		err = (*Node)(self).IndexSlice(r, &items)
+	}
+	self.convert(items, result)
+	return
+}
 func (self *JSONApi) MirrorSlice(kr KeyRange, result *[]ValueRes) (err error) {
 	r := common.Range{
 		Key:    kr.Key,
@@ -777,14 +847,18 @@ func (self *JSONApi) AddConfiguration(co Conf, x *Nothing) (err error) {
 	(*Node)(self).AddConfiguration(c)
 	return nil
 }
-func (self *JSONApi) SubAddConfiguration(co SubConf, x *Nothing) (err error) {
+func (self *JSONApi) SubAddConfiguration(co SubConf, n *Nothing) (err error) {
 	c := common.ConfItem{
 		TreeKey: co.TreeKey,
 		Key:     co.Key,
 		Value:   co.Value,
 	}
-	(*Node)(self).SubAddConfiguration(c)
-	return nil
+	var x int
+	var f bool
+	if f, err = self.forwardUnlessMe("DHash.SubAddConfiguration", c.TreeKey, c, &x); !f {
+		err = (*Node)(self).SubAddConfiguration(c)
+	}
+	return
 }
 func (self *JSONApi) Configuration(x Nothing, result *common.Conf) (err error) {
 	*result = common.Conf{}
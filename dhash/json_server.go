@@ -2,9 +2,11 @@ package dhash
 
 import (
 	"code.google.com/p/go.net/websocket"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/zond/god/client"
 	"github.com/zond/god/common"
 	"github.com/zond/god/web"
 	"io"
@@ -15,6 +17,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,6 +30,12 @@ type socketMessage struct {
 	Data interface{} `json:"data"`
 }
 
+// watchMessage is sent by websocket clients to start ("Watch") or stop ("Unwatch") receiving the events matching Data.
+type watchMessage struct {
+	Type string       `json:"type"`
+	Data common.Watch `json:"data"`
+}
+
 var prefPattern = regexp.MustCompile("^([^\\s;]+)(;q=([\\d.]+))?$")
 
 func mostAccepted(r *http.Request, def, name string) string {
@@ -56,10 +65,21 @@ func wantsHTML(r *http.Request, m *mux.RouteMatch) bool {
 	return mostAccepted(r, "text/html", "Accept") == "text/html"
 }
 
+// requestToken returns the token presented by r, either as an 'Authorization: Bearer TOKEN' header or as a 'token' query parameter
+// (since browsers can't set headers for websockets).
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return header[len("Bearer "):]
+	}
+	return r.URL.Query().Get("token")
+}
+
 type requestContext struct {
-	method   string
-	request  *http.Request
-	response http.ResponseWriter
+	method    string
+	request   *http.Request
+	response  http.ResponseWriter
+	node      *Node
+	principal string
 }
 
 func (self *requestContext) ReadRequestHeader(r *rpc.Request) error {
@@ -90,6 +110,9 @@ func (self *requestContext) ReadRequestBody(b interface{}) (err error) {
 				err = json.NewDecoder(self.request.Body).Decode(b)
 			}
 		}
+		if err == nil {
+			err = self.node.authorize(self.principal, self.method, b)
+		}
 	}
 	return
 }
@@ -118,29 +141,87 @@ func (self *requestContext) Close() error {
 
 type jsonRpcServer struct {
 	server *rpc.Server
+	node   *Node
 }
 
 func (self jsonRpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := self.node.authenticate(requestToken(r))
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
 	context := &requestContext{
-		method:   mux.Vars(r)["method"],
-		request:  r,
-		response: w,
+		method:    mux.Vars(r)["method"],
+		request:   r,
+		response:  w,
+		node:      self.node,
+		principal: principal,
 	}
 	self.server.ServeRequest(context)
 }
 
-func (self *Node) jsonDescription() string {
-	b, err := json.Marshal(socketMessage{
+// socket serializes the messages sent over one websocket, since watchers and listeners send from their own goroutines.
+type socket struct {
+	ws   *websocket.Conn
+	lock sync.Mutex
+}
+
+// send will marshal message and send it over the websocket.
+func (self *socket) send(message socketMessage) (err error) {
+	b, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return websocket.Message.Send(self.ws, string(b))
+}
+
+func (self *Node) jsonDescription() socketMessage {
+	return socketMessage{
 		Type: "RingChange",
 		Data: map[string]interface{}{
 			"description": self.Description(),
 			"routes":      self.node.Nodes(),
 		},
-	})
-	if err != nil {
-		panic(err)
 	}
-	return string(b)
+}
+
+func (self *Node) handleWatchMessage(sock *socket, principal string, watchers map[string]*client.Watcher, mess watchMessage) {
+	id := fmt.Sprintf("%v:%v", mess.Data.Type, string(mess.Data.Key))
+	switch mess.Type {
+	case "Watch":
+		if err := self.authorize(principal, "DHash.Subscribe", mess.Data); err != nil {
+			sock.send(socketMessage{
+				Type: "Error",
+				Data: err.Error(),
+			})
+			return
+		}
+		if _, ok := watchers[id]; !ok {
+			watcher := self.client().Watch(mess.Data)
+			watchers[id] = watcher
+			go func() {
+				for events := range watcher.Events {
+					if err := sock.send(socketMessage{
+						Type: "Events",
+						Data: map[string]interface{}{
+							"watch":  mess.Data,
+							"events": events.Events,
+							"lost":   events.Lost,
+						},
+					}); err != nil {
+						watcher.Close()
+					}
+				}
+			}()
+		}
+	case "Unwatch":
+		if watcher, ok := watchers[id]; ok {
+			watcher.Close()
+			delete(watchers, id)
+		}
+	}
 }
 
 func (self *Node) startJson() {
@@ -153,21 +234,28 @@ func (self *Node) startJson() {
 	jsonApi := (*JSONApi)(self)
 	web.SetApi(reflect.TypeOf(jsonApi))
 	rpcServer.RegisterName("DHash", jsonApi)
-	jsonServer := jsonRpcServer{server: rpcServer}
+	jsonServer := jsonRpcServer{server: rpcServer, node: self}
 	router := mux.NewRouter()
 	router.Methods("POST").Path("/rpc/{method}").MatcherFunc(wantsJSON).Handler(jsonServer)
+	(*restServer)(self).route(router)
 	web.Route(func(ws *websocket.Conn) {
-		if websocket.Message.Send(ws, self.jsonDescription()) == nil {
+		principal, ok := self.authenticate(requestToken(ws.Request()))
+		if !ok {
+			ws.Close()
+			return
+		}
+		sock := &socket{ws: ws}
+		if sock.send(self.jsonDescription()) == nil {
 			go func() {
 				for {
 					time.Sleep(updateInterval)
-					if websocket.Message.Send(ws, self.jsonDescription()) != nil {
+					if sock.send(self.jsonDescription()) != nil {
 						break
 					}
 				}
 			}()
 			self.AddCommListener(func(comm Comm) bool {
-				b, err := json.Marshal(socketMessage{
+				return sock.send(socketMessage{
 					Type: "Comm",
 					Data: map[string]interface{}{
 						"source":      comm.Source,
@@ -176,27 +264,13 @@ func (self *Node) startJson() {
 						"sub_key":     comm.SubKey,
 						"type":        comm.Type,
 					},
-				})
-				if err != nil {
-					panic(err)
-				}
-				return websocket.Message.Send(ws, string(b)) == nil
+				}) == nil
 			})
 			self.AddChangeListener(func(ring *common.Ring) bool {
-				b, err := json.Marshal(socketMessage{
-					Type: "RingChange",
-					Data: map[string]interface{}{
-						"description": self.Description(),
-						"routes":      self.node.Nodes(),
-					},
-				})
-				if err != nil {
-					panic(err)
-				}
-				return websocket.Message.Send(ws, string(b)) == nil
+				return sock.send(self.jsonDescription()) == nil
 			})
 			self.AddSyncListener(func(source, dest common.Remote, pulled, pushed int) bool {
-				b, err := json.Marshal(socketMessage{
+				return sock.send(socketMessage{
 					Type: "Sync",
 					Data: map[string]interface{}{
 						"source":      source,
@@ -204,14 +278,10 @@ func (self *Node) startJson() {
 						"pulled":      pulled,
 						"pushed":      pushed,
 					},
-				})
-				if err != nil {
-					panic(err)
-				}
-				return websocket.Message.Send(ws, string(b)) == nil
+				}) == nil
 			})
 			self.AddCleanListener(func(source, dest common.Remote, cleaned, pushed int) bool {
-				b, err := json.Marshal(socketMessage{
+				return sock.send(socketMessage{
 					Type: "Clean",
 					Data: map[string]interface{}{
 						"source":      source,
@@ -219,17 +289,21 @@ func (self *Node) startJson() {
 						"cleaned":     cleaned,
 						"pushed":      pushed,
 					},
-				})
-				if err != nil {
-					panic(err)
-				}
-				return websocket.Message.Send(ws, string(b)) == nil
+				}) == nil
 			})
+			watchers := make(map[string]*client.Watcher)
 			var mess string
 			for {
 				if err = websocket.Message.Receive(ws, &mess); err != nil {
 					break
 				}
+				var watchMess watchMessage
+				if json.Unmarshal([]byte(mess), &watchMess) == nil {
+					self.handleWatchMessage(sock, principal, watchers, watchMess)
+				}
+			}
+			for _, watcher := range watchers {
+				watcher.Close()
 			}
 		}
 	}, router)
@@ -239,6 +313,9 @@ func (self *Node) startJson() {
 	if err != nil {
 		panic(err)
 	}
+	if config := self.node.GetTLS(); config != nil {
+		listener = tls.NewListener(listener, config)
+	}
 	go (&http.Server{
 		Handler: mux,
 	}).Serve(listener)
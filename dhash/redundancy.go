@@ -0,0 +1,189 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+	"strconv"
+)
+
+const (
+	redundancyConf = "redundancy"
+)
+
+// parseRedundancy returns the number of copies value asks for, or 0 if value is not a positive number.
+func parseRedundancy(value string) (result int) {
+	if result, _ = strconv.Atoi(value); result < 1 {
+		return 0
+	}
+	return
+}
+
+// limitRedundancy returns redundancy limited to the number of nodes in the ring.
+func (self *Node) limitRedundancy(redundancy int) int {
+	if hosts := self.node.CountHosts(); redundancy > hosts {
+		return hosts
+	}
+	return redundancy
+}
+
+// redundancy returns how many copies to keep of byte values, and of sub trees without a 'redundancy' configuration of their own.
+// That is the 'redundancy' configuration of the cluster if there is one, otherwise the redundancy of the ring.
+func (self *Node) redundancy() int {
+	self.lock.RLock()
+	result := self.baseRedundancy
+	self.lock.RUnlock()
+	if result > 0 {
+		return self.limitRedundancy(result)
+	}
+	return self.node.Redundancy()
+}
+
+// ownRedundancy returns the 'redundancy' configuration of the sub tree under key, or 0 if it has none.
+func (self *Node) ownRedundancy(key []byte) int {
+	self.lock.RLock()
+	result := self.redundancies[string(key)]
+	self.lock.RUnlock()
+	return self.limitRedundancy(result)
+}
+
+// subRedundancy returns how many copies to keep of the sub tree under key.
+func (self *Node) subRedundancy(key []byte) int {
+	if result := self.ownRedundancy(key); result > 0 {
+		return result
+	}
+	return self.redundancy()
+}
+
+// confRedundancy returns how many nodes c must be replicated to. If c lowers the redundancy of a sub tree that contains data, this is the old redundancy,
+// so that the nodes no longer supposed to keep the sub tree learn about it as well.
+func (self *Node) confRedundancy(c common.ConfItem) (result int) {
+	result = self.subRedundancy(c.TreeKey)
+	if c.Key == redundancyConf {
+		old := result
+		if result = self.limitRedundancy(parseRedundancy(c.Value)); result == 0 {
+			result = self.redundancy()
+		}
+		if old > result && self.tree.SubSize(c.TreeKey) > 0 {
+			result = old
+		}
+	}
+	return
+}
+
+// batchRedundancy will set the TTL of each op in data to the redundancy of the tree it writes to, and the TTL of data to the largest of them.
+func (self *Node) batchRedundancy(data *common.Batch) {
+	data.TTL = 0
+	for index, op := range data.Ops {
+		if op.Type == common.BatchSubPut || op.Type == common.BatchSubDel {
+			data.Ops[index].TTL = self.subRedundancy(op.Key)
+		} else {
+			data.Ops[index].TTL = self.redundancy()
+		}
+		if data.Ops[index].TTL > data.TTL {
+			data.TTL = data.Ops[index].TTL
+		}
+	}
+}
+
+// replicaFilter returns a radix.Sync filter letting through the byte values and sub trees that should be kept by the replica at index of their owners,
+// where byte values are kept in redundancy copies.
+func (self *Node) replicaFilter(index, redundancy int) func(key []byte, subTree bool) bool {
+	return func(key []byte, subTree bool) bool {
+		if subTree {
+			return self.subRedundancy(key) > index
+		}
+		return redundancy > index
+	}
+}
+
+// registerClusterRedundancy will remember the 'redundancy' configuration in conf, the configuration of the cluster, so that it is not parsed for every write.
+func (self *Node) registerClusterRedundancy(conf map[string]string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.baseRedundancy = parseRedundancy(conf[redundancyConf])
+}
+
+// registerRedundancy will remember the 'redundancy' configuration in conf, the configuration of the sub tree under key, so that it is not parsed for every write.
+// Sub trees with a 'redundancy' configuration of their own are synchronized and cleaned one by one, since their owners are not the same as the owners of the rest of their segment.
+func (self *Node) registerRedundancy(key []byte, conf map[string]string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if redundancy := parseRedundancy(conf[redundancyConf]); redundancy > 0 {
+		self.redundancies[string(key)] = redundancy
+	} else {
+		delete(self.redundancies, string(key))
+	}
+}
+
+// redundancyOwners returns the keys of all sub trees with a 'redundancy' configuration of their own, along with the nodes that should keep each of them.
+func (self *Node) redundancyOwners() (keys [][]byte, owners []common.Remotes) {
+	self.lock.RLock()
+	redundancies := make([]int, 0, len(self.redundancies))
+	for key, redundancy := range self.redundancies {
+		keys = append(keys, []byte(key))
+		redundancies = append(redundancies, redundancy)
+	}
+	self.lock.RUnlock()
+	for index, key := range keys {
+		owners = append(owners, self.node.GetReplicasForRemote(self.node.GetSuccessorFor(key), self.limitRedundancy(redundancies[index])))
+	}
+	return
+}
+
+// subTreeRange returns a range from key to the key right after it, so that synchronizing the range will only synchronize the sub tree under key.
+func subTreeRange(key []byte) (from, to []byte) {
+	return key, append(append(make([]byte, 0, len(key)+1), key...), 0)
+}
+
+// syncRedundancies will synchronize each registered sub tree with a 'redundancy' configuration of its own, that is owned by this node, with its replicas.
+func (self *Node) syncRedundancies() {
+	keys, owners := self.redundancyOwners()
+	for index, key := range keys {
+		if owners[index][0].Addr == self.node.GetBroadcastAddr() {
+			from, to := subTreeRange(key)
+			for _, replica := range owners[index][1:] {
+				remoteHash := remoteHashTree{
+					source:      owners[index][0],
+					destination: replica,
+					node:        self,
+				}
+				pushed := radix.NewSync(self.tree, remoteHash).From(from).To(to).Run().PutCount()
+				pulled := radix.NewSync(remoteHash, localHashTree{self.tree, self}).From(from).To(to).Run().PutCount()
+				if pushed != 0 || pulled != 0 {
+					self.triggerSyncListeners(owners[index][0], replica, pulled, pushed)
+				}
+			}
+		}
+	}
+}
+
+// cleanRedundancies will push each registered sub tree with a 'redundancy' configuration of its own, that this node is not supposed to keep, to the nodes that are, and then remove it from this node.
+func (self *Node) cleanRedundancies() {
+	keys, owners := self.redundancyOwners()
+	for index, key := range keys {
+		isOwner := false
+		for _, owner := range owners[index] {
+			if owner.Addr == self.node.GetBroadcastAddr() {
+				isOwner = true
+			}
+		}
+		// Empty sub trees are left alone, since they would clear the sub trees of the owners if they were created later.
+		if !isOwner && self.tree.SubSize(key) > 0 {
+			from, to := subTreeRange(key)
+			for ownerIndex, owner := range owners[index] {
+				sync := radix.NewSync(self.tree, remoteHashTree{
+					source:      self.node.Remote(),
+					destination: owner,
+					node:        self,
+				}).From(from).To(to)
+				if ownerIndex == len(owners[index])-1 {
+					sync.Destroy()
+				}
+				sync.Run()
+				if cleaned, pushed := sync.DelCount(), sync.PutCount(); cleaned != 0 || pushed != 0 {
+					self.triggerCleanListeners(self.node.Remote(), owner, cleaned, pushed)
+				}
+			}
+		}
+	}
+}
@@ -53,19 +53,20 @@ func (self remoteHashTree) Finger(key []radix.Nibble) (result *radix.Print) {
 	self.destination.Call("HashTree.Finger", key, result)
 	return
 }
-func (self remoteHashTree) GetTimestamp(key []radix.Nibble) (value []byte, timestamp int64, present bool) {
+func (self remoteHashTree) GetTimestamp(key []radix.Nibble) (value []byte, timestamp, expiry int64, present bool) {
 	result := HashTreeItem{}
 	self.destination.Call("HashTree.GetTimestamp", key, &result)
-	value, timestamp, present = result.Value, result.Timestamp, result.Exists
+	value, timestamp, expiry, present = result.Value, result.Timestamp, result.Expiry, result.Exists
 	return
 }
-func (self remoteHashTree) PutTimestamp(key []radix.Nibble, value []byte, present bool, expected, timestamp int64) (changed bool) {
+func (self remoteHashTree) PutTimestamp(key []radix.Nibble, value []byte, present bool, expected, timestamp, expiry int64) (changed bool) {
 	data := HashTreeItem{
 		Key:       key,
 		Value:     value,
 		Exists:    present,
 		Expected:  expected,
 		Timestamp: timestamp,
+		Expiry:    expiry,
 	}
 	op := "HashTree.PutTimestamp"
 	if self.node.hasCommListeners() {
@@ -105,16 +106,16 @@ func (self remoteHashTree) SubFinger(key, subKey []radix.Nibble) (result *radix.
 	self.destination.Call("HashTree.SubFinger", data, result)
 	return
 }
-func (self remoteHashTree) SubGetTimestamp(key, subKey []radix.Nibble) (value []byte, timestamp int64, present bool) {
+func (self remoteHashTree) SubGetTimestamp(key, subKey []radix.Nibble) (value []byte, timestamp, expiry int64, present bool) {
 	data := HashTreeItem{
 		Key:    key,
 		SubKey: subKey,
 	}
 	self.destination.Call("HashTree.SubGetTimestamp", data, &data)
-	value, timestamp, present = data.Value, data.Timestamp, data.Exists
+	value, timestamp, expiry, present = data.Value, data.Timestamp, data.Expiry, data.Exists
 	return
 }
-func (self remoteHashTree) SubPutTimestamp(key, subKey []radix.Nibble, value []byte, present bool, subExpected, subTimestamp int64) (changed bool) {
+func (self remoteHashTree) SubPutTimestamp(key, subKey []radix.Nibble, value []byte, present bool, subExpected, subTimestamp, subExpiry int64) (changed bool) {
 	data := HashTreeItem{
 		Key:       key,
 		SubKey:    subKey,
@@ -122,6 +123,7 @@ func (self remoteHashTree) SubPutTimestamp(key, subKey []radix.Nibble, value []b
 		Exists:    present,
 		Expected:  subExpected,
 		Timestamp: subTimestamp,
+		Expiry:    subExpiry,
 	}
 	op := "HashTree.SubPutTimestamp"
 	if self.node.hasCommListeners() {
@@ -190,3 +192,18 @@ func (self remoteHashTree) SubKillTimestamp(key []radix.Nibble, expected int64)
 	self.destination.Call(op, data, &deleted)
 	return
 }
+
+// localHashTree is the tree of node as the destination of a radix.Sync, making node keep track of the configurations it receives.
+type localHashTree struct {
+	*radix.Tree
+	node *Node
+}
+
+func (self localHashTree) Configure(conf map[string]string, timestamp int64) {
+	self.Tree.Configure(conf, timestamp)
+	self.node.registerClusterRedundancy(conf)
+}
+func (self localHashTree) SubConfigure(key []byte, conf map[string]string, timestamp int64) {
+	self.Tree.SubConfigure(key, conf, timestamp)
+	self.node.registerSubConfiguration(key, conf)
+}
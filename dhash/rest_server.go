@@ -0,0 +1,355 @@
+package dhash
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/zond/god/common"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	base64Type = "application/base64"
+	jsonType   = "application/json"
+	rawType    = "application/octet-stream"
+)
+
+// restServer serves a RESTful HTTP API, translating requests to the JSONApi methods, and authorizing them the same way as calls to the JSON API.
+//
+// Byte values live at /trees/{key}, sub trees at /trees/{key}/sub and their values at /trees/{key}/sub/{subKey}, where GET reads, PUT writes and DELETE removes.
+//
+// Keys are used as they are in the path, which means that they can not contain '/'. With the query parameter 'encoding=base64' the keys in the path,
+// and the 'min', 'max' and 'from' query parameters, are instead base64url encoded (RFC 4648, padding optional), which allows any key.
+//
+// Request bodies are base64 encoded if their Content-Type is application/base64, otherwise raw. Single values are returned raw, unless
+// application/base64 or application/json (a ValueRes or SubValueRes) is preferred by the Accept header. Ranges are always returned as JSON lists of ValueRes.
+//
+// Sub tree ranges are selected using query parameters:
+// 'min', 'max', 'mininc' and 'maxinc' (defaulting to true) for a key range,
+// 'minIndex' and 'maxIndex' for an index range,
+// 'from', 'frominc' (defaulting to true) and 'len' for a page of results,
+// and 'reverse' and 'mirror' to iterate in reverse or over the mirror tree.
+type restServer Node
+
+type restError struct {
+	status int
+	err    error
+}
+
+func (self restError) Error() string {
+	return self.err.Error()
+}
+
+func (self *restServer) route(router *mux.Router) {
+	router.Methods("GET").Path("/trees/{key}").HandlerFunc(self.handler(self.get))
+	router.Methods("PUT").Path("/trees/{key}").HandlerFunc(self.handler(self.put))
+	router.Methods("DELETE").Path("/trees/{key}").HandlerFunc(self.handler(self.del))
+	router.Methods("GET").Path("/trees/{key}/sub").HandlerFunc(self.handler(self.slice))
+	router.Methods("DELETE").Path("/trees/{key}/sub").HandlerFunc(self.handler(self.subClear))
+	router.Methods("GET").Path("/trees/{key}/sub/{subKey}").HandlerFunc(self.handler(self.subGet))
+	router.Methods("PUT").Path("/trees/{key}/sub/{subKey}").HandlerFunc(self.handler(self.subPut))
+	router.Methods("DELETE").Path("/trees/{key}/sub/{subKey}").HandlerFunc(self.handler(self.subDel))
+}
+
+// handler will return an http.HandlerFunc authenticating the request and running f with the principal of the request, responding with any error f returns.
+func (self *restServer) handler(f func(w http.ResponseWriter, r *http.Request, principal string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := (*Node)(self).authenticate(requestToken(r))
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		if err := f(w, r, principal); err != nil {
+			status := http.StatusInternalServerError
+			if restErr, ok := err.(restError); ok {
+				status = restErr.status
+			} else if common.IsAccessDenied(err) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+		}
+	}
+}
+
+// call will authorize principal to call the JSONApi method with args, and then call f.
+func (self *restServer) call(principal, method string, args interface{}, f func() error) (err error) {
+	if err = (*Node)(self).authorize(principal, "DHash."+method, args); err != nil {
+		return
+	}
+	return f()
+}
+func (self *restServer) api() *JSONApi {
+	return (*JSONApi)(self)
+}
+
+func restBody(r *http.Request) (result []byte, err error) {
+	if result, err = ioutil.ReadAll(r.Body); err != nil {
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == base64Type {
+		if result, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(result))); err != nil {
+			err = restError{http.StatusBadRequest, err}
+		}
+	}
+	return
+}
+func restValue(w http.ResponseWriter, r *http.Request, value []byte, exists bool, res interface{}) error {
+	switch mostAccepted(r, rawType, "Accept") {
+	case jsonType:
+		return restJSON(w, res)
+	case base64Type:
+		if !exists {
+			return restError{http.StatusNotFound, fmt.Errorf("Not found")}
+		}
+		w.Header().Set("Content-Type", base64Type)
+		_, err := w.Write([]byte(base64.StdEncoding.EncodeToString(value)))
+		return err
+	}
+	if !exists {
+		return restError{http.StatusNotFound, fmt.Errorf("Not found")}
+	}
+	w.Header().Set("Content-Type", rawType)
+	_, err := w.Write(value)
+	return err
+}
+func restJSON(w http.ResponseWriter, res interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	return json.NewEncoder(w).Encode(res)
+}
+func restSync(r *http.Request) bool {
+	return r.URL.Query().Get("sync") == "true"
+}
+
+// restParams parses the keys and query parameters of a request, remembering the first error.
+type restParams struct {
+	vars    map[string]string
+	values  map[string][]string
+	encoded bool
+	err     error
+}
+
+func newRestParams(r *http.Request) *restParams {
+	values := r.URL.Query()
+	return &restParams{
+		vars:    mux.Vars(r),
+		values:  values,
+		encoded: values.Get("encoding") == "base64",
+	}
+}
+
+// decode returns the key s, found in name, decoded from base64url if the request has 'encoding=base64'.
+func (self *restParams) decode(name, s string) []byte {
+	if !self.encoded {
+		return []byte(s)
+	}
+	result, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil && self.err == nil {
+		self.err = restError{http.StatusBadRequest, fmt.Errorf("Bad %v: %v", name, err)}
+	}
+	return result
+}
+
+// key returns the key in the path variable name.
+func (self *restParams) key(name string) []byte {
+	return self.decode(name, self.vars[name])
+}
+
+func (self *restParams) has(name string) bool {
+	return len(self.values[name]) > 0
+}
+func (self *restParams) bytes(name string) []byte {
+	if !self.has(name) {
+		return nil
+	}
+	return self.decode(name, self.values[name][0])
+}
+func (self *restParams) bool(name string, def bool) bool {
+	if !self.has(name) {
+		return def
+	}
+	result, err := strconv.ParseBool(self.values[name][0])
+	if err != nil && self.err == nil {
+		self.err = restError{http.StatusBadRequest, fmt.Errorf("Bad %v: %v", name, err)}
+	}
+	return result
+}
+func (self *restParams) int(name string) *int {
+	if !self.has(name) {
+		return nil
+	}
+	result, err := strconv.Atoi(self.values[name][0])
+	if err != nil && self.err == nil {
+		self.err = restError{http.StatusBadRequest, fmt.Errorf("Bad %v: %v", name, err)}
+	}
+	return &result
+}
+
+func (self *restServer) get(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := KeyReq{Key: params.key("key")}
+	if params.err != nil {
+		return params.err
+	}
+	var res ValueRes
+	if err = self.call(principal, "Get", args, func() error { return self.api().Get(args, &res) }); err != nil {
+		return
+	}
+	return restValue(w, r, res.Value, res.Exists, res)
+}
+func (self *restServer) put(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := ValueOp{Key: params.key("key"), Sync: restSync(r)}
+	if params.err != nil {
+		return params.err
+	}
+	if args.Value, err = restBody(r); err != nil {
+		return
+	}
+	if err = self.call(principal, "Put", args, func() error { return self.api().Put(args, &Nothing{}) }); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+func (self *restServer) del(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := KeyOp{Key: params.key("key"), Sync: restSync(r)}
+	if params.err != nil {
+		return params.err
+	}
+	if err = self.call(principal, "Del", args, func() error { return self.api().Del(args, &Nothing{}) }); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+func (self *restServer) subGet(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := SubKeyReq{Key: params.key("key"), SubKey: params.key("subKey")}
+	if params.err != nil {
+		return params.err
+	}
+	var res SubValueRes
+	if err = self.call(principal, "SubGet", args, func() error { return self.api().SubGet(args, &res) }); err != nil {
+		return
+	}
+	return restValue(w, r, res.Value, res.Exists, res)
+}
+func (self *restServer) subPut(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := SubValueOp{Key: params.key("key"), SubKey: params.key("subKey"), Sync: restSync(r)}
+	if params.err != nil {
+		return params.err
+	}
+	if args.Value, err = restBody(r); err != nil {
+		return
+	}
+	if err = self.call(principal, "SubPut", args, func() error { return self.api().SubPut(args, &Nothing{}) }); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+func (self *restServer) subDel(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := SubKeyOp{Key: params.key("key"), SubKey: params.key("subKey"), Sync: restSync(r)}
+	if params.err != nil {
+		return params.err
+	}
+	if err = self.call(principal, "SubDel", args, func() error { return self.api().SubDel(args, &Nothing{}) }); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+func (self *restServer) subClear(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	args := SubKeyOp{Key: params.key("key"), Sync: restSync(r)}
+	if params.err != nil {
+		return params.err
+	}
+	if err = self.call(principal, "SubClear", args, func() error { return self.api().SubClear(args, &Nothing{}) }); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+
+// slice will return the range of the sub tree selected by the query parameters, using the JSONApi method matching them.
+func (self *restServer) slice(w http.ResponseWriter, r *http.Request, principal string) (err error) {
+	params := newRestParams(r)
+	key := params.key("key")
+	prefix := ""
+	if params.bool("mirror", false) {
+		prefix = "Mirror"
+	}
+	if params.bool("reverse", false) {
+		prefix += "Reverse"
+	}
+	var method string
+	var args interface{}
+	var res []ValueRes
+	var f func() error
+	switch {
+	case params.has("minIndex") || params.has("maxIndex"):
+		method = prefix + "SliceIndex"
+		a := IndexRange{Key: key, MinIndex: params.int("minIndex"), MaxIndex: params.int("maxIndex")}
+		args = a
+		switch prefix {
+		case "":
+			f = func() error { return self.api().SliceIndex(a, &res) }
+		case "Reverse":
+			f = func() error { return self.api().ReverseSliceIndex(a, &res) }
+		case "Mirror":
+			f = func() error { return self.api().MirrorSliceIndex(a, &res) }
+		default:
+			f = func() error { return self.api().MirrorReverseSliceIndex(a, &res) }
+		}
+	case params.has("len"):
+		method = prefix + "SliceLen"
+		a := PageRange{Key: key, From: params.bytes("from"), FromInc: params.bool("frominc", true)}
+		if l := params.int("len"); l != nil {
+			a.Len = *l
+		}
+		args = a
+		switch prefix {
+		case "":
+			f = func() error { return self.api().SliceLen(a, &res) }
+		case "Reverse":
+			f = func() error { return self.api().ReverseSliceLen(a, &res) }
+		case "Mirror":
+			f = func() error { return self.api().MirrorSliceLen(a, &res) }
+		default:
+			f = func() error { return self.api().MirrorReverseSliceLen(a, &res) }
+		}
+	default:
+		method = prefix + "Slice"
+		a := KeyRange{Key: key, Min: params.bytes("min"), Max: params.bytes("max"), MinInc: params.bool("mininc", true), MaxInc: params.bool("maxinc", true)}
+		args = a
+		switch prefix {
+		case "":
+			f = func() error { return self.api().Slice(a, &res) }
+		case "Reverse":
+			f = func() error { return self.api().ReverseSlice(a, &res) }
+		case "Mirror":
+			f = func() error { return self.api().MirrorSlice(a, &res) }
+		default:
+			f = func() error { return self.api().MirrorReverseSlice(a, &res) }
+		}
+	}
+	if params.err != nil {
+		return params.err
+	}
+	if err = self.call(principal, method, args, f); err != nil {
+		return
+	}
+	if res == nil {
+		res = []ValueRes{}
+	}
+	return restJSON(w, res)
+}
@@ -0,0 +1,110 @@
+package dhash
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/zond/god/common"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func doREST(t *testing.T, method, url, contentType, accept, body string) (status int, result string) {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func assertREST(t *testing.T, method, url, contentType, accept, body string, wantedStatus int, wantedBody string) {
+	if status, result := doREST(t, method, url, contentType, accept, body); status != wantedStatus || (wantedBody != "" && result != wantedBody) {
+		t.Errorf("%v %v: wanted %v %#v, got %v %#v", method, url, wantedStatus, wantedBody, status, result)
+	}
+}
+
+func assertRESTRange(t *testing.T, url string, wanted ...string) {
+	status, body := doREST(t, "GET", url, "", "", "")
+	var res []ValueRes
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &res) != nil {
+		t.Errorf("GET %v: wanted a JSON list, got %v %#v", url, status, body)
+		return
+	}
+	var found []string
+	for _, item := range res {
+		found = append(found, string(item.Key), string(item.Value))
+	}
+	if !reflect.DeepEqual(found, wanted) {
+		t.Errorf("GET %v: wanted %v, got %v", url, wanted, found)
+	}
+}
+
+func TestREST(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:14191", "127.0.0.1:14191", "").MustStart()
+	defer node.Stop()
+	time.Sleep(time.Millisecond * 100)
+	base := "http://127.0.0.1:14192/trees/"
+
+	assertREST(t, "GET", base+"k", "", "", "", http.StatusNotFound, "")
+	assertREST(t, "PUT", base+"k", "", "", "v", http.StatusNoContent, "")
+	assertREST(t, "GET", base+"k", "", "", "", http.StatusOK, "v")
+	assertREST(t, "GET", base+"k", "", "application/base64", "", http.StatusOK, "dg==")
+	assertREST(t, "PUT", base+"k", "application/base64", "", "djI=", http.StatusNoContent, "")
+	assertREST(t, "GET", base+"k", "", "", "", http.StatusOK, "v2")
+	assertREST(t, "PUT", base+"k", "application/base64", "", "!", http.StatusBadRequest, "")
+	if status, body := doREST(t, "GET", base+"k", "", "application/json", ""); status != http.StatusOK || body != "{\"Key\":\"aw==\",\"Value\":\"djI=\",\"Exists\":true}\n" {
+		t.Errorf("wanted k as JSON, got %v %#v", status, body)
+	}
+	assertREST(t, "DELETE", base+"k", "", "", "", http.StatusNoContent, "")
+	assertREST(t, "GET", base+"k", "", "", "", http.StatusNotFound, "")
+
+	for _, subKey := range []string{"a", "b", "c", "d"} {
+		assertREST(t, "PUT", base+"t/sub/"+subKey, "", "", "v"+subKey, http.StatusNoContent, "")
+	}
+	assertREST(t, "GET", base+"t/sub/b", "", "", "", http.StatusOK, "vb")
+	assertREST(t, "DELETE", base+"t/sub/b", "", "", "", http.StatusNoContent, "")
+	assertREST(t, "GET", base+"t/sub/b", "", "", "", http.StatusNotFound, "")
+	assertRESTRange(t, base+"t/sub", "a", "va", "c", "vc", "d", "vd")
+	assertRESTRange(t, base+"t/sub?min=a&max=d&mininc=false&maxinc=false", "c", "vc")
+	assertRESTRange(t, base+"t/sub?reverse=true&min=a&max=c", "c", "vc", "a", "va")
+	assertRESTRange(t, base+"t/sub?minIndex=1&maxIndex=2", "c", "vc", "d", "vd")
+	assertRESTRange(t, base+"t/sub?from=c&len=5", "c", "vc", "d", "vd")
+	assertREST(t, "GET", base+"t/sub?len=x", "", "", "", http.StatusBadRequest, "")
+	assertREST(t, "DELETE", base+"t/sub", "", "", "", http.StatusNoContent, "")
+	assertRESTRange(t, base+"t/sub")
+
+	assertREST(t, "PUT", base+"YS9i_w?encoding=base64", "", "", "v", http.StatusNoContent, "")
+	if value, _, existed := node.tree.Get([]byte("a/b\xff")); !existed || string(value) != "v" {
+		t.Errorf("wanted a/b\\xff => v, got %v, %v", value, existed)
+	}
+	assertREST(t, "GET", base+"YS9i_w==?encoding=base64", "", "", "", http.StatusOK, "v")
+	assertREST(t, "GET", base+"YS9i_w", "", "", "", http.StatusNotFound, "")
+	assertREST(t, "GET", base+"!?encoding=base64", "", "", "", http.StatusBadRequest, "")
+	assertREST(t, "PUT", base+"dA/sub/eC95?encoding=base64", "", "", "v", http.StatusNoContent, "")
+	assertRESTRange(t, base+"t/sub", "x/y", "v")
+	assertRESTRange(t, base+"dA/sub?encoding=base64&min=YQ&max=eC95", "x/y", "v")
+	assertREST(t, "DELETE", base+"dA/sub/eC95?encoding=base64", "", "", "", http.StatusNoContent, "")
+	assertRESTRange(t, base+"t/sub")
+
+	defer common.Switch.SetToken("")
+	node.SetSecret("secret")
+	assertREST(t, "GET", base+"k", "", "", "", http.StatusUnauthorized, "")
+	assertREST(t, "GET", base+"k?token=secret", "", "", "", http.StatusNotFound, "")
+}
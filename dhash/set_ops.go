@@ -2,21 +2,47 @@ package dhash
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/radix"
 	"github.com/zond/setop"
 )
 
 const (
-	setOpBufferSize = 128
+	setOpBufferSize    = 128
+	setOpMaxBufferSize = 4096
 )
 
+// SetOpSlice is a request for at most Len results between Min and Max of one source of a distributed set expression,
+// either the sub tree under Key or the sub expression Op.
+type SetOpSlice struct {
+	Key    []byte
+	Op     *setop.SetOp
+	Min    []byte
+	Max    []byte
+	MinInc bool
+	MaxInc bool
+	Len    int
+}
+
+// treeSkipper is a setop.Skipper reading the sub tree under key, either from tree or from remote, in buffers of setOpBufferSize items.
+//
+// If distributed it will instead read using SetOpSlice, never beyond max, and double the size of the buffer each time it is refilled
+// up to setOpMaxBufferSize. If op is set it will read the results of op, as executed by remote, instead of a sub tree.
 type treeSkipper struct {
 	key          []byte
+	op           *setop.SetOp
 	tree         *radix.Tree
 	remote       common.Remote
 	buffer       []setop.SetOpResult
 	currentIndex int
+	exhausted    bool
+	distributed  bool
+	max          []byte
+	maxInc       bool
+	bufferSize   int
+	calls        int
+	results      int
 }
 
 func (self *treeSkipper) Skip(min []byte, inc bool) (result *setop.SetOpResult, err error) {
@@ -64,29 +90,49 @@ func (self *treeSkipper) nextFromBuf() (result *setop.SetOpResult) {
 	return
 }
 
+// refill will replace the buffer with the items after min. When it gets fewer items than it asked for the source is exhausted, and later refills will not ask again.
 func (self *treeSkipper) refill(min []byte, inc bool) (err error) {
-	self.buffer = make([]setop.SetOpResult, 0, setOpBufferSize)
+	size := self.size()
+	self.buffer = make([]setop.SetOpResult, 0, size)
 	self.currentIndex = 0
+	if self.exhausted {
+		return
+	}
 	if self.tree == nil {
-		if err = self.remoteRefill(min, inc); err != nil {
+		if self.distributed {
+			err = self.distributedRefill(min, inc, size)
+		} else {
+			err = self.remoteRefill(min, inc, size)
+		}
+		if err != nil {
 			return
 		}
 	} else {
-		if err = self.treeRefill(min, inc); err != nil {
+		if err = self.treeRefill(min, inc, size); err != nil {
 			return
 		}
 	}
+	self.results += len(self.buffer)
+	self.exhausted = len(self.buffer) < size
 	return
 }
 
-func (self *treeSkipper) remoteRefill(min []byte, inc bool) (err error) {
+func (self *treeSkipper) size() int {
+	if self.bufferSize == 0 {
+		return setOpBufferSize
+	}
+	return self.bufferSize
+}
+
+func (self *treeSkipper) remoteRefill(min []byte, inc bool, size int) (err error) {
 	r := common.Range{
 		Key:    self.key,
 		Min:    min,
 		MinInc: inc,
-		Len:    setOpBufferSize,
+		Len:    size,
 	}
 	var items []common.Item
+	self.calls++
 	if err = self.remote.Call("DHash.SliceLen", r, &items); err != nil {
 		return
 	}
@@ -96,11 +142,91 @@ func (self *treeSkipper) remoteRefill(min []byte, inc bool) (err error) {
 	return
 }
 
-func (self *treeSkipper) treeRefill(min []byte, inc bool) error {
+func (self *treeSkipper) distributedRefill(min []byte, inc bool, size int) (err error) {
+	s := SetOpSlice{
+		Key:    self.key,
+		Op:     self.op,
+		Min:    min,
+		Max:    self.max,
+		MinInc: inc,
+		MaxInc: self.maxInc,
+		Len:    size,
+	}
+	self.calls++
+	if err = self.remote.Call("DHash.SetOpSlice", s, &self.buffer); err != nil {
+		return
+	}
+	self.bufferSize = common.Min(size*2, setOpMaxBufferSize)
+	return
+}
+
+func (self *treeSkipper) treeRefill(min []byte, inc bool, size int) error {
 	filler := func(key, value []byte, timestamp int64) bool {
 		self.buffer = append(self.buffer, setop.SetOpResult{key, [][]byte{value}})
-		return len(self.buffer) < setOpBufferSize
+		return len(self.buffer) < size
 	}
-	self.tree.SubEachBetween(self.key, min, nil, inc, false, filler)
+	self.tree.SubEachBetween(self.key, min, self.max, inc, self.maxInc, filler)
 	return nil
 }
+
+// stats returns the common.SetOpSourceStats describing what this treeSkipper has read.
+func (self *treeSkipper) stats() (result common.SetOpSourceStats) {
+	result = common.SetOpSourceStats{
+		Addr:    self.remote.Addr,
+		Calls:   self.calls,
+		Results: self.results,
+	}
+	if self.op == nil {
+		result.Keys = [][]byte{self.key}
+	} else {
+		result.Keys = setOpSources(self.op)
+	}
+	return
+}
+
+// setOpOwner returns the node owning all the sub trees used by op, if there is one.
+func (self *Node) setOpOwner(op *setop.SetOp) (owner common.Remote, ok bool) {
+	for index, key := range setOpSources(op) {
+		successor := self.node.GetSuccessorFor(key)
+		if index == 0 {
+			owner = successor
+		} else if successor.Addr != owner.Addr {
+			return
+		}
+	}
+	ok = owner.Addr != ""
+	return
+}
+
+// planSetOp returns a copy of op where each sub expression whose sub trees are all owned by one other node is replaced by a source key not used in the expression,
+// and a distributed treeSkipper for each such key that will read the results of the sub expression from the node owning it.
+func (self *Node) planSetOp(op *setop.SetOp, taken map[string]bool, pushed map[string]*treeSkipper) (result *setop.SetOp) {
+	result = &setop.SetOp{
+		Type:  op.Type,
+		Merge: op.Merge,
+	}
+	for _, source := range op.Sources {
+		if source.SetOp != nil {
+			if owner, ok := self.setOpOwner(source.SetOp); ok && owner.Addr != self.node.GetBroadcastAddr() {
+				key := []byte(fmt.Sprintf("\x00pushed.%v", len(pushed)))
+				for taken[string(key)] {
+					key = append(key, '.')
+				}
+				taken[string(key)] = true
+				pushed[string(key)] = &treeSkipper{
+					key:    key,
+					op:     source.SetOp,
+					remote: owner,
+				}
+				source = setop.SetOpSource{
+					Key:    key,
+					Weight: source.Weight,
+				}
+			} else {
+				source.SetOp = self.planSetOp(source.SetOp, taken, pushed)
+			}
+		}
+		result.Sources = append(result.Sources, source)
+	}
+	return
+}
@@ -0,0 +1,375 @@
+package dhash
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/zond/god/common"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	transactionTimeout = time.Second * 30
+	preparedPrefix     = "prepared/"
+	decidedPrefix      = "decided/"
+)
+
+// transactionLock is held on a key by a prepared transaction until it is committed or aborted. part contains the operations of the transaction on the key.
+type transactionLock struct {
+	id       string
+	deadline time.Time
+	part     common.Transaction
+}
+
+func transactionLockKey(op common.BatchOp) string {
+	return fmt.Sprintf("%v/%v", common.HexEncode(op.Key), common.HexEncode(op.SubKey))
+}
+
+func encodeTransaction(data common.Transaction) []byte {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(data); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeTransaction(b []byte) (result common.Transaction) {
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&result); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// recordTransaction will store data under key in the local transaction records of this node, which are logged if the node logs its data.
+func (self *Node) recordTransaction(key string, data common.Transaction) {
+	self.transactions.Put([]byte(key), encodeTransaction(data), self.timer.ContinuousTime())
+}
+func (self *Node) forgetTransaction(key string) {
+	self.transactions.Del([]byte(key))
+}
+
+// restoreTransactions will lock the keys of the transactions prepared before this node was restarted again. Since the locks are restored without deadlines,
+// resolveTransactions will ask the coordinators what became of them as soon as the node has started.
+func (self *Node) restoreTransactions() {
+	self.transactions.PrefixEach([]byte(preparedPrefix), func(key, value []byte, timestamp int64) bool {
+		part := decodeTransaction(value)
+		self.transactionLocks[transactionLockKey(part.Ops[0])] = transactionLock{
+			id:   part.Id,
+			part: part,
+		}
+		return true
+	})
+}
+
+func (self *Node) setTransactionPending(id string, pending bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if pending {
+		self.transacting[id] = true
+	} else {
+		delete(self.transacting, id)
+	}
+}
+func (self *Node) transactionPending(id string) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.transacting[id]
+}
+
+// transactionParts will split data into one part for each node owning any of its keys.
+func (self *Node) transactionParts(data common.Transaction) (parts map[string]*common.Transaction, owners map[string]common.Remote) {
+	parts = make(map[string]*common.Transaction)
+	owners = make(map[string]common.Remote)
+	for _, op := range data.Ops {
+		owner := self.node.GetSuccessorFor(op.Key)
+		part, found := parts[owner.Addr]
+		if !found {
+			part = &common.Transaction{
+				Id:        data.Id,
+				Timestamp: data.Timestamp,
+			}
+			parts[owner.Addr] = part
+			owners[owner.Addr] = owner
+		}
+		part.Ops = append(part.Ops, op)
+	}
+	return
+}
+
+// Transact will perform all operations in data, even if their keys are owned by different nodes, so that either all or none of them are applied.
+//
+// This node coordinates the transaction using a two-phase commit where the owners of the keys are participants. All operations get the same timestamp
+// from the timenet.Timer of this node. If another transaction holds any of the keys, or any participant is unreachable, nothing is applied and result.Error
+// tells why.
+//
+// Transactions only exclude other transactions. Regular writes to the same keys are not blocked, and are ordered by their timestamps as usual.
+//
+// Participants record their locks, and this node records the decision to commit, in their logs before going on, so that both survive restarts.
+// If this node fails during the commit phase, participants that did not get the decision will ask this node what it decided once transactionTimeout has passed,
+// and commit or release the keys accordingly. Parts that could not be committed are retried by this node until they are applied.
+func (self *Node) Transact(data common.Transaction, result *common.TransactionResult) error {
+	data.Id = fmt.Sprintf("%v/%v", self.node.GetBroadcastAddr(), atomic.AddInt64(&self.nextTransaction, 1))
+	data.Timestamp = self.timer.ContinuousTime()
+	ops := make([]common.BatchOp, len(data.Ops))
+	for index, op := range data.Ops {
+		if _, ok := batchEvents[op.Type]; !ok {
+			result.Error = fmt.Sprintf("Unknown batch operation type: %v", op.Type)
+			return nil
+		}
+		op.Timestamp, op.Expiry = data.Timestamp, 0
+		ops[index] = op
+	}
+	data.Ops = ops
+	self.setTransactionPending(data.Id, true)
+	defer self.setTransactionPending(data.Id, false)
+	parts, owners := self.transactionParts(data)
+	var prepared []string
+	for addr, part := range parts {
+		var ok bool
+		if err := owners[addr].Call("DHash.PrepareTransaction", *part, &ok); err != nil || !ok {
+			for _, preparedAddr := range prepared {
+				var x int
+				owners[preparedAddr].Call("DHash.AbortTransaction", *parts[preparedAddr], &x)
+			}
+			if err != nil {
+				self.node.RemoveNode(owners[addr])
+				result.Error = fmt.Sprintf("Participant %v failed: %v", addr, err)
+			} else {
+				result.Error = fmt.Sprintf("Participant %v has keys locked by another transaction", addr)
+			}
+			return nil
+		}
+		prepared = append(prepared, addr)
+	}
+	self.recordTransaction(decidedPrefix+data.Id, data)
+	self.transactions.Sync()
+	self.commitDecided(data)
+	result.Committed = true
+	return nil
+}
+
+// commitDecided will make the owners of the keys of data, that this node has decided to commit, apply their parts of it.
+// When all parts are applied the decision is forgotten, otherwise it is kept with the operations left for resolveTransactions to retry.
+func (self *Node) commitDecided(data common.Transaction) {
+	remaining := common.Transaction{
+		Id:        data.Id,
+		Timestamp: data.Timestamp,
+	}
+	parts, owners := self.transactionParts(data)
+	for addr, part := range parts {
+		if !self.commitTransaction(owners[addr], *part) {
+			remaining.Ops = append(remaining.Ops, part.Ops...)
+		}
+	}
+	if len(remaining.Ops) == 0 {
+		self.forgetTransaction(decidedPrefix + data.Id)
+	} else if len(remaining.Ops) < len(data.Ops) {
+		self.recordTransaction(decidedPrefix+data.Id, remaining)
+	}
+}
+
+// commitTransaction will make owner apply part, and return whether part got applied.
+// If owner fails, or has not prepared part, for example since it has taken over the keys of part after the prepare phase, whoever owns the keys of part now
+// will have to both prepare and commit part instead.
+func (self *Node) commitTransaction(owner common.Remote, part common.Transaction) bool {
+	var committed bool
+	err := owner.Call("DHash.CommitTransaction", part, &committed)
+	if err == nil && committed {
+		return true
+	}
+	if err != nil {
+		self.node.RemoveNode(owner)
+	}
+	retries, owners := self.transactionParts(part)
+	for addr, retry := range retries {
+		var prepared bool
+		if err := owners[addr].Call("DHash.PrepareTransaction", *retry, &prepared); err != nil || !prepared {
+			return false
+		}
+		if err := owners[addr].Call("DHash.CommitTransaction", *retry, &committed); err != nil || !committed {
+			return false
+		}
+	}
+	return true
+}
+
+// PrepareTransaction will lock the keys of data for the transaction data.Id, and set result to whether that was possible.
+// The locks are recorded in the log of this node before result is set, and are held until the transaction is committed or aborted, or until
+// resolveTransactions learns from the coordinator what became of it. Preparing the same transaction again replaces its locks on the keys of data.
+func (self *Node) PrepareTransaction(data common.Transaction, result *bool) error {
+	self.transactionsLock.Lock()
+	defer self.transactionsLock.Unlock()
+	for _, op := range data.Ops {
+		if lock, found := self.transactionLocks[transactionLockKey(op)]; found && lock.id != data.Id {
+			*result = false
+			return nil
+		}
+	}
+	deadline := time.Now().Add(transactionTimeout)
+	var keys []string
+	locks := make(map[string]transactionLock)
+	for _, op := range data.Ops {
+		key := transactionLockKey(op)
+		lock, found := locks[key]
+		if !found {
+			keys = append(keys, key)
+			lock = transactionLock{
+				id:       data.Id,
+				deadline: deadline,
+				part: common.Transaction{
+					Id:        data.Id,
+					Timestamp: data.Timestamp,
+				},
+			}
+		}
+		lock.part.Ops = append(lock.part.Ops, op)
+		locks[key] = lock
+	}
+	for _, key := range keys {
+		self.transactionLocks[key] = locks[key]
+		self.recordTransaction(preparedPrefix+data.Id+"/"+key, locks[key].part)
+	}
+	self.transactions.Sync()
+	*result = true
+	return nil
+}
+
+// CommitTransaction will apply and replicate the operations in data, release the keys locked for data.Id, and set result to true.
+// If any of the keys is not locked for data.Id, nothing is applied and result is set to false.
+// The keys stay locked while the operations are replicated, but other transactions can be prepared and committed on other keys meanwhile.
+func (self *Node) CommitTransaction(data common.Transaction, result *bool) error {
+	if !self.holdsTransaction(data) {
+		*result = false
+		return nil
+	}
+	batch := common.Batch{
+		Ops:  data.Ops,
+		Sync: true,
+	}
+	self.batchRedundancy(&batch)
+	self.notifyBatch(data.Ops, self.batch(batch))
+	self.releaseTransaction(data)
+	*result = true
+	return nil
+}
+
+// AbortTransaction will release the keys locked for data.Id without applying anything.
+func (self *Node) AbortTransaction(data common.Transaction, x *int) error {
+	self.releaseTransaction(data)
+	return nil
+}
+
+// holdsTransaction returns whether all keys of data are locked for data.Id.
+func (self *Node) holdsTransaction(data common.Transaction) bool {
+	self.transactionsLock.Lock()
+	defer self.transactionsLock.Unlock()
+	for _, op := range data.Ops {
+		if lock, found := self.transactionLocks[transactionLockKey(op)]; !found || lock.id != data.Id {
+			return false
+		}
+	}
+	return true
+}
+func (self *Node) releaseTransaction(data common.Transaction) {
+	self.transactionsLock.Lock()
+	defer self.transactionsLock.Unlock()
+	for _, op := range data.Ops {
+		key := transactionLockKey(op)
+		if lock, found := self.transactionLocks[key]; found && lock.id == data.Id {
+			delete(self.transactionLocks, key)
+			self.forgetTransaction(preparedPrefix + data.Id + "/" + key)
+		}
+	}
+}
+
+// TransactionDecision will set result to what this node, as the coordinator of the transaction id, has decided about it.
+// Transactions this node is not preparing or committing, and has no record of, were aborted.
+func (self *Node) TransactionDecision(id string, result *int) error {
+	if self.transactionPending(id) {
+		*result = common.TransactionPending
+	} else if _, _, found := self.transactions.Get([]byte(decidedPrefix + id)); found {
+		*result = common.TransactionCommitted
+	} else {
+		*result = common.TransactionAborted
+	}
+	return nil
+}
+
+// transactionDecision returns what the coordinator of the transaction id has decided about it, or common.TransactionPending if the coordinator is unreachable.
+func (self *Node) transactionDecision(id string) (result int) {
+	result = common.TransactionPending
+	if index := strings.LastIndex(id, "/"); index != -1 {
+		coordinator := common.Remote{Addr: id[:index]}
+		if coordinator.Addr == self.node.GetBroadcastAddr() {
+			self.TransactionDecision(id, &result)
+		} else if err := coordinator.Call("DHash.TransactionDecision", id, &result); err != nil {
+			result = common.TransactionPending
+		}
+	}
+	return
+}
+
+// resolveTransactions will ask the coordinators of the transactions whose locks have timed out what they decided, and commit or release them accordingly.
+// Locks of transactions whose coordinators are unreachable, or still preparing them, are kept for another transactionTimeout.
+// It will also retry the parts not yet applied of the transactions this node has decided to commit.
+func (self *Node) resolveTransactions() {
+	now := time.Now()
+	expired := make(map[string]*common.Transaction)
+	self.transactionsLock.Lock()
+	for _, lock := range self.transactionLocks {
+		if lock.deadline.Before(now) {
+			part, found := expired[lock.id]
+			if !found {
+				part = &common.Transaction{
+					Id:        lock.id,
+					Timestamp: lock.part.Timestamp,
+				}
+				expired[lock.id] = part
+			}
+			part.Ops = append(part.Ops, lock.part.Ops...)
+		}
+	}
+	self.transactionsLock.Unlock()
+	for id, part := range expired {
+		switch self.transactionDecision(id) {
+		case common.TransactionCommitted:
+			var committed bool
+			self.CommitTransaction(*part, &committed)
+		case common.TransactionAborted:
+			var x int
+			self.AbortTransaction(*part, &x)
+		default:
+			self.extendTransaction(*part)
+		}
+	}
+	var decided []common.Transaction
+	self.transactions.PrefixEach([]byte(decidedPrefix), func(key, value []byte, timestamp int64) bool {
+		decided = append(decided, decodeTransaction(value))
+		return true
+	})
+	for _, data := range decided {
+		if !self.transactionPending(data.Id) {
+			self.commitDecided(data)
+		}
+	}
+}
+func (self *Node) extendTransaction(data common.Transaction) {
+	self.transactionsLock.Lock()
+	defer self.transactionsLock.Unlock()
+	deadline := time.Now().Add(transactionTimeout)
+	for _, op := range data.Ops {
+		key := transactionLockKey(op)
+		if lock, found := self.transactionLocks[key]; found && lock.id == data.Id {
+			lock.deadline = deadline
+			self.transactionLocks[key] = lock
+		}
+	}
+}
+func (self *Node) resolveTransactionsPeriodically() {
+	for self.hasState(started) {
+		self.resolveTransactions()
+		time.Sleep(syncInterval)
+	}
+}
@@ -0,0 +1,250 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zond/god/common"
+	"github.com/zond/setop"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	viewConf = "view"
+	// viewSourceTimeout is how long the owner of a sub tree remembers that it is used by a view, unless the owner of the view renews it.
+	viewSourceTimeout = time.Second * 30
+	// viewRenewInterval is how often the owner of a view renews it with the owners of the sub trees it uses.
+	viewRenewInterval = time.Second * 10
+)
+
+// view is the state of a materialized view on the node owning it.
+type view struct {
+	dirty   bool
+	renewed time.Time
+}
+
+// setOpSources returns the keys of all sub trees used by op, in the order they appear in the expression.
+func setOpSources(op *setop.SetOp) (result [][]byte) {
+	for _, source := range op.Sources {
+		if source.Key != nil {
+			result = append(result, source.Key)
+		} else {
+			result = append(result, setOpSources(source.SetOp)...)
+		}
+	}
+	return
+}
+
+// parseView returns the set operation of the view expression code, or an error if it can not be used in a view.
+func parseView(code string) (result *setop.SetOp, err error) {
+	if result, err = setop.NewSetOpParser(code).Parse(); err != nil {
+		return
+	}
+	if result.Merge == setop.Append {
+		err = fmt.Errorf("The Append merge function can not be used in views")
+	}
+	return
+}
+
+// registerView will make this node keep track of the sub tree under key, which has a set expression as its 'view' configuration.
+// Only the owner of the sub tree will actually maintain it, but all nodes keep track of the views they know of in case they become owners.
+func (self *Node) registerView(key []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, ok := self.views[string(key)]; !ok {
+		self.views[string(key)] = view{dirty: true}
+	}
+}
+
+func (self *Node) unregisterView(key []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.views, string(key))
+}
+
+// InvalidateView will make this node materialize the view under key again, since one of the sub trees it uses has changed.
+func (self *Node) InvalidateView(key []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if v, ok := self.views[string(key)]; ok {
+		v.dirty = true
+		self.views[string(key)] = v
+	}
+}
+
+// AddViewSource will make this node, the owner of the sub tree under source, invalidate the view under viewKey whenever the sub tree changes,
+// for viewSourceTimeout. It returns whether this node did not already know of the view, in which case it may have missed changes.
+func (self *Node) AddViewSource(source, viewKey []byte) (added bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	views, ok := self.viewSources[string(source)]
+	if !ok {
+		views = make(map[string]time.Time)
+		self.viewSources[string(source)] = views
+		atomic.AddInt32(&self.nViewSources, 1)
+	}
+	if deadline, found := views[string(viewKey)]; !found || deadline.Before(time.Now()) {
+		added = true
+	}
+	views[string(viewKey)] = time.Now().Add(viewSourceTimeout)
+	return
+}
+
+// invalidateViews will invalidate all views using the sub tree under source, that have been renewed within viewSourceTimeout.
+func (self *Node) invalidateViews(source []byte) {
+	if atomic.LoadInt32(&self.nViewSources) == 0 {
+		return
+	}
+	now := time.Now()
+	var viewKeys [][]byte
+	self.lock.RLock()
+	for viewKey, deadline := range self.viewSources[string(source)] {
+		if deadline.After(now) {
+			viewKeys = append(viewKeys, []byte(viewKey))
+		}
+	}
+	self.lock.RUnlock()
+	for _, viewKey := range viewKeys {
+		owner := self.node.GetSuccessorFor(viewKey)
+		if owner.Addr == self.node.GetBroadcastAddr() {
+			self.InvalidateView(viewKey)
+		} else {
+			var x int
+			go owner.Call("DHash.InvalidateView", viewKey, &x)
+		}
+	}
+}
+
+// cleanViewSources will forget the views that have not been renewed within viewSourceTimeout.
+func (self *Node) cleanViewSources() {
+	now := time.Now()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for source, views := range self.viewSources {
+		for viewKey, deadline := range views {
+			if deadline.Before(now) {
+				delete(views, viewKey)
+			}
+		}
+		if len(views) == 0 {
+			delete(self.viewSources, source)
+		}
+	}
+	atomic.StoreInt32(&self.nViewSources, int32(len(self.viewSources)))
+}
+
+// renewView will make the owners of the sub trees used by op, and of the view under key itself, invalidate the view when they change.
+// If any of them did not already know about the view, the view is invalidated, since changes may have been missed.
+func (self *Node) renewView(key []byte, op *setop.SetOp) {
+	missed := false
+	for _, source := range append(setOpSources(op), key) {
+		var added bool
+		owner := self.node.GetSuccessorFor(source)
+		if owner.Addr == self.node.GetBroadcastAddr() {
+			added = self.AddViewSource(source, key)
+		} else if err := owner.Call("DHash.AddViewSource", common.Item{Key: source, SubKey: key}, &added); err != nil {
+			self.node.RemoveNode(owner)
+			return
+		}
+		missed = missed || added
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if v, ok := self.views[string(key)]; ok {
+		v.renewed = time.Now()
+		v.dirty = v.dirty || missed
+		self.views[string(key)] = v
+	}
+}
+
+// materializeView will evaluate op and make the sub tree under key contain exactly its results, by putting the changed results and deleting the keys no longer in them.
+func (self *Node) materializeView(key []byte, op *setop.SetOp) (err error) {
+	var results []setop.SetOpResult
+	if err = self.SetExpression(setop.SetExpression{Op: op}, &results); err != nil {
+		return
+	}
+	stale := make(map[string][]byte)
+	self.tree.SubEachBetween(key, nil, nil, false, false, func(subKey, value []byte, timestamp int64) bool {
+		stale[string(subKey)] = value
+		return true
+	})
+	for _, res := range results {
+		if old, ok := stale[string(res.Key)]; !ok || bytes.Compare(old, res.Values[0]) != 0 {
+			self.SubPut(common.Item{
+				Key:    key,
+				SubKey: res.Key,
+				Value:  res.Values[0],
+			})
+		}
+		delete(stale, string(res.Key))
+	}
+	for subKey, _ := range stale {
+		self.SubDel(common.Item{
+			Key:    key,
+			SubKey: []byte(subKey),
+		})
+	}
+	return
+}
+
+// refreshView will renew the view under key with the owners of its sub trees when needed, and materialize it again if any of them has invalidated it.
+func (self *Node) refreshView(key []byte) {
+	conf, _ := self.tree.SubConfiguration(key)
+	code := conf[viewConf]
+	if code == "" {
+		self.unregisterView(key)
+		return
+	}
+	op, err := parseView(code)
+	if err != nil {
+		return
+	}
+	self.lock.RLock()
+	v := self.views[string(key)]
+	self.lock.RUnlock()
+	if time.Now().Sub(v.renewed) > viewRenewInterval {
+		self.renewView(key, op)
+	}
+	self.lock.Lock()
+	v, ok := self.views[string(key)]
+	if !ok || !v.dirty {
+		self.lock.Unlock()
+		return
+	}
+	// Invalidations arriving while materializing make the view dirty again.
+	v.dirty = false
+	self.views[string(key)] = v
+	self.lock.Unlock()
+	if err = self.materializeView(key, op); err != nil {
+		self.InvalidateView(key)
+	}
+}
+
+// refreshViews will refresh all registered views owned by this node.
+func (self *Node) refreshViews() {
+	self.lock.RLock()
+	keys := make([][]byte, 0, len(self.views))
+	for key, _ := range self.views {
+		keys = append(keys, []byte(key))
+	}
+	self.lock.RUnlock()
+	for _, key := range keys {
+		if self.node.GetSuccessorFor(key).Addr == self.node.GetBroadcastAddr() {
+			self.refreshView(key)
+		} else {
+			// Should this node become the owner again, the view has to be renewed and materialized from scratch.
+			self.lock.Lock()
+			if _, ok := self.views[string(key)]; ok {
+				self.views[string(key)] = view{dirty: true}
+			}
+			self.lock.Unlock()
+		}
+	}
+	self.cleanViewSources()
+}
+func (self *Node) refreshViewsPeriodically() {
+	for self.hasState(started) {
+		self.refreshViews()
+		time.Sleep(syncInterval)
+	}
+}
@@ -0,0 +1,135 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pollTimeout       = time.Second * 10
+	watcherTimeout    = time.Minute
+	watcherBufferSize = 1024
+)
+
+type watcher struct {
+	watch    common.Watch
+	events   chan common.Event
+	lost     int32
+	lastPoll int64
+}
+
+func (self *watcher) send(event common.Event) {
+	select {
+	case self.events <- event:
+	default:
+		atomic.StoreInt32(&self.lost, 1)
+	}
+}
+func (self *watcher) poll(result *common.Events) {
+	atomic.StoreInt64(&self.lastPoll, time.Now().UnixNano())
+	defer atomic.StoreInt64(&self.lastPoll, time.Now().UnixNano())
+	select {
+	case event := <-self.events:
+		result.Events = append(result.Events, event)
+	case <-time.After(pollTimeout):
+		return
+	}
+	for len(result.Events) < watcherBufferSize {
+		select {
+		case event := <-self.events:
+			result.Events = append(result.Events, event)
+		default:
+			result.Lost = atomic.CompareAndSwapInt32(&self.lost, 1, 0)
+			return
+		}
+	}
+	result.Lost = atomic.CompareAndSwapInt32(&self.lost, 1, 0)
+}
+
+// Subscribe will start collecting the events on this node matching w, and return an id to Poll them with.
+// Subscriptions that have not been polled for watcherTimeout will be removed.
+func (self *Node) Subscribe(w common.Watch, id *int64) error {
+	*id = atomic.AddInt64(&self.nextWatcher, 1)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.watchers[*id] = &watcher{
+		watch:    w,
+		events:   make(chan common.Event, watcherBufferSize),
+		lastPoll: time.Now().UnixNano(),
+	}
+	atomic.StoreInt32(&self.nWatchers, int32(len(self.watchers)))
+	return nil
+}
+
+// subscribedBy returns whether the subscription with the given id, if there is one, was made by principal.
+func (self *Node) subscribedBy(id int64, principal string) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	w, ok := self.watchers[id]
+	return !ok || w.watch.Principal == principal
+}
+
+// Poll will wait up to pollTimeout for events to the subscription with the given id, and return all events collected.
+func (self *Node) Poll(id int64, result *common.Events) error {
+	self.lock.RLock()
+	w, ok := self.watchers[id]
+	self.lock.RUnlock()
+	if !ok {
+		result.Unknown = true
+		return nil
+	}
+	w.poll(result)
+	return nil
+}
+
+// Unsubscribe will remove the subscription with the given id.
+func (self *Node) Unsubscribe(id int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.watchers, id)
+	atomic.StoreInt32(&self.nWatchers, int32(len(self.watchers)))
+}
+func (self *Node) hasWatchers() bool {
+	return atomic.LoadInt32(&self.nWatchers) > 0
+}
+func (self *Node) triggerWatchers(event common.Event) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for _, w := range self.watchers {
+		if w.watch.Matches(event) {
+			w.send(event)
+		}
+	}
+}
+
+// notify will send an event of type typ describing data to all matching subscriptions, and invalidate the views using the sub tree under data.Key.
+func (self *Node) notify(typ int, data common.Item) {
+	self.invalidateViews(data.Key)
+	if self.hasWatchers() {
+		self.triggerWatchers(common.Event{
+			Type:      typ,
+			Key:       data.Key,
+			SubKey:    data.SubKey,
+			Value:     data.Value,
+			Timestamp: data.Timestamp,
+		})
+	}
+}
+func (self *Node) cleanWatchers() {
+	limit := time.Now().Add(-watcherTimeout).UnixNano()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for id, w := range self.watchers {
+		if atomic.LoadInt64(&w.lastPoll) < limit {
+			delete(self.watchers, id)
+		}
+	}
+	atomic.StoreInt32(&self.nWatchers, int32(len(self.watchers)))
+}
+func (self *Node) cleanWatchersPeriodically() {
+	for self.hasState(started) {
+		self.cleanWatchers()
+		time.Sleep(watcherTimeout)
+	}
+}
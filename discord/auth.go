@@ -0,0 +1,156 @@
+package discord
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/gob"
+	"github.com/zond/god/common"
+	"io"
+	"net"
+	"net/rpc"
+)
+
+// Authenticator is a function deciding whether a connection presenting token is allowed, and what principal it will act as.
+type Authenticator func(token string) (principal string, ok bool)
+
+// Authorizer is a function deciding whether principal is allowed to call service with args. A non nil error will be returned to the caller instead of
+// calling service.
+type Authorizer func(principal, service string, args interface{}) error
+
+// authCodec is a gob rpc.ServerCodec, like the one net/rpc uses, that asks an Authorizer about every request before letting it through.
+type authCodec struct {
+	rwc        io.ReadWriteCloser
+	dec        *gob.Decoder
+	enc        *gob.Encoder
+	encBuf     *bufio.Writer
+	principal  string
+	authorizer Authorizer
+	service    string
+}
+
+func newAuthCodec(conn io.ReadWriteCloser, principal string, authorizer Authorizer) *authCodec {
+	buf := bufio.NewWriter(conn)
+	return &authCodec{
+		rwc:        conn,
+		dec:        gob.NewDecoder(conn),
+		enc:        gob.NewEncoder(buf),
+		encBuf:     buf,
+		principal:  principal,
+		authorizer: authorizer,
+	}
+}
+func (self *authCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	if err = self.dec.Decode(r); err == nil {
+		self.service = r.ServiceMethod
+	}
+	return
+}
+func (self *authCodec) ReadRequestBody(body interface{}) (err error) {
+	if err = self.dec.Decode(body); err != nil {
+		return
+	}
+	if body != nil && self.authorizer != nil {
+		err = self.authorizer(self.principal, self.service, body)
+	}
+	return
+}
+func (self *authCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = self.enc.Encode(r); err != nil {
+		if self.encBuf.Flush() == nil {
+			self.Close()
+		}
+		return
+	}
+	if err = self.enc.Encode(body); err != nil {
+		if self.encBuf.Flush() == nil {
+			self.Close()
+		}
+		return
+	}
+	return self.encBuf.Flush()
+}
+func (self *authCodec) Close() error {
+	return self.rwc.Close()
+}
+
+// SetAuthenticator will make this Node ask f about the token presented by each new connection. Without an Authenticator all connections are allowed.
+func (self *Node) SetAuthenticator(f Authenticator) {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.authenticator = f
+}
+
+// SetAuthorizer will make this Node ask f about each call made to it. Without an Authorizer all calls are allowed.
+func (self *Node) SetAuthorizer(f Authorizer) {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.authorizer = f
+}
+func (self *Node) authenticate(token string) (principal string, ok bool) {
+	self.metaLock.RLock()
+	f := self.authenticator
+	self.metaLock.RUnlock()
+	if f == nil {
+		return "", true
+	}
+	return f(token)
+}
+func (self *Node) authorize(principal, service string, args interface{}) error {
+	self.metaLock.RLock()
+	f := self.authorizer
+	self.metaLock.RUnlock()
+	if f == nil {
+		return nil
+	}
+	return f(principal, service, args)
+}
+
+// SetTLS will make this Node require TLS using config for all connections to it, or plain TCP if config is nil.
+// It has to be called before the Node is started. To make the Node use TLS when connecting to other nodes, see common.Switchboard#SetTLS.
+func (self *Node) SetTLS(config *tls.Config) {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.tlsConfig = config
+}
+func (self *Node) GetTLS() *tls.Config {
+	self.metaLock.RLock()
+	defer self.metaLock.RUnlock()
+	return self.tlsConfig
+}
+
+// accept will serve server on all connections to listener that present an accepted token.
+func (self *Node) accept(server *rpc.Server, listener net.Listener) {
+	if config := self.GetTLS(); config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go self.serve(server, conn)
+	}
+}
+func (self *Node) serve(server *rpc.Server, conn net.Conn) {
+	conn, token, reply, err := common.ReceiveToken(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	principal, ok := self.authenticate(token)
+	if reply == nil {
+		if !ok {
+			// Connections without a token can not be told that during a handshake, so all their calls are denied instead.
+			server.ServeCodec(newAuthCodec(conn, principal, denyAll))
+			return
+		}
+	} else if err = reply(ok); err != nil || !ok {
+		conn.Close()
+		return
+	}
+	server.ServeCodec(newAuthCodec(conn, principal, self.authorize))
+}
+
+func denyAll(principal, service string, args interface{}) error {
+	return common.AccessDenied("%v requires a token", service)
+}
@@ -28,3 +28,17 @@ func TestStartup(t *testing.T) {
 		return fmt.Sprint(routes), len(routes) == 1 && nodes[0].ring.Size() > 0
 	}, time.Second*30)
 }
+
+func TestVirtualPositions(t *testing.T) {
+	node1 := NewNode("127.0.0.1:9291", "127.0.0.1:9291").SetVirtualNodes(4)
+	node1.placeVirtualNodes()
+	node2 := NewNode("127.0.0.1:9291", "127.0.0.1:9291").SetVirtualNodes(4)
+	node2.placeVirtualNodes()
+	if remotes1, remotes2 := node1.Remotes(), node2.Remotes(); len(remotes1) != 4 || remotes1.Describe() != remotes2.Describe() {
+		t.Errorf("%v and %v should be the same 4 positions", remotes1, remotes2)
+	}
+	node2.SetVirtualPosition(2, []byte{1})
+	if remote := node2.Remotes()[2]; remote.Index != 2 || string(remote.Pos) != string([]byte{1}) {
+		t.Errorf("%v should have moved to position 01", remote)
+	}
+}
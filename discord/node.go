@@ -2,6 +2,7 @@ package discord
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/murmur"
@@ -32,22 +33,29 @@ const (
 // Like chord networks, it is a ring of nodes ordered by a position metric. Unlike chord, every node has every other node in its routing table.
 // This allows stable networks to route with a constant time complexity.
 type Node struct {
-	ring          *common.Ring
-	position      []byte
-	listenAddr    string
-	broadcastAddr string
-	listener      *net.TCPListener
-	metaLock      *sync.RWMutex
-	routeLock     *sync.Mutex
-	state         int32
-	exports       map[string]interface{}
-	commListeners []CommListener
+	ring             *common.Ring
+	position         []byte
+	virtualPositions [][]byte
+	virtualNodes     int
+	zone             string
+	listenAddr       string
+	broadcastAddr    string
+	listener         *net.TCPListener
+	metaLock         *sync.RWMutex
+	routeLock        *sync.Mutex
+	state            int32
+	exports          map[string]interface{}
+	commListeners    []CommListener
+	authenticator    Authenticator
+	authorizer       Authorizer
+	tlsConfig        *tls.Config
 }
 
 func NewNode(listenAddr, broadcastAddr string) (result *Node) {
 	return &Node{
 		ring:          common.NewRing(),
 		position:      make([]byte, murmur.Size),
+		virtualNodes:  1,
 		listenAddr:    listenAddr,
 		broadcastAddr: broadcastAddr,
 		exports:       make(map[string]interface{}),
@@ -101,6 +109,68 @@ func (self *Node) SetPosition(position []byte) *Node {
 	return self
 }
 
+// SetVirtualNodes will make this Node occupy n positions in the ring instead of one, so that it owns many small ranges of keys instead of one big.
+// The positions after the first are derived from the broadcast address of this Node and their index, so that they are spread evenly over the ring
+// and the same every time the Node starts.
+// It has to be called before the Node is started.
+func (self *Node) SetVirtualNodes(n int) *Node {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	self.virtualNodes = n
+	return self
+}
+
+// VirtualNodes returns the number of positions this Node occupies in the ring.
+func (self *Node) VirtualNodes() int {
+	self.metaLock.RLock()
+	defer self.metaLock.RUnlock()
+	return self.virtualNodes
+}
+
+// placeVirtualNodes will place the virtual positions of this Node at the hashes of its broadcast address and their index.
+func (self *Node) placeVirtualNodes() {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.virtualPositions = nil
+	for index := 1; index < self.virtualNodes; index++ {
+		self.virtualPositions = append(self.virtualPositions, murmur.HashString(fmt.Sprintf("%v/%v", self.broadcastAddr, index)))
+	}
+}
+
+// SetVirtualPosition will move the index'th position of this Node, where 0 is the primary position, to position.
+func (self *Node) SetVirtualPosition(index int, position []byte) *Node {
+	if index == 0 {
+		return self.SetPosition(position)
+	}
+	self.metaLock.Lock()
+	self.virtualPositions[index-1] = make([]byte, len(position))
+	copy(self.virtualPositions[index-1], position)
+	self.metaLock.Unlock()
+	self.routeLock.Lock()
+	defer self.routeLock.Unlock()
+	self.ring.Add(self.Remotes()[index])
+	return self
+}
+
+// SetZone will make this Node announce itself as part of zone, so that other Nodes avoid placing replicas of the same keys in it.
+// It has to be called before the Node is started.
+func (self *Node) SetZone(zone string) *Node {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.zone = zone
+	return self
+}
+
+// GetZone returns the zone of this Node.
+func (self *Node) GetZone() string {
+	self.metaLock.RLock()
+	defer self.metaLock.RUnlock()
+	return self.zone
+}
+
 // GetNodes will return remotes to all Nodes in the ring.
 func (self *Node) GetNodes() (result common.Remotes) {
 	return self.ring.Nodes()
@@ -115,6 +185,11 @@ func (self *Node) Redundancy() int {
 func (self *Node) CountNodes() int {
 	return self.ring.Size()
 }
+
+// CountHosts returns the number of distinct Nodes in the ring, counting the virtual positions of each Node once.
+func (self *Node) CountHosts() int {
+	return len(self.ring.Hosts())
+}
 func (self *Node) GetPosition() (result []byte) {
 	self.metaLock.RLock()
 	defer self.metaLock.RUnlock()
@@ -162,9 +237,34 @@ func (self *Node) setListener(l *net.TCPListener) {
 	self.listener = l
 }
 
-// Remote returns a remote to this Node.
+// Remote returns a remote to the primary position of this Node.
 func (self *Node) Remote() common.Remote {
-	return common.Remote{self.GetPosition(), self.GetBroadcastAddr()}
+	return common.Remote{
+		Pos:  self.GetPosition(),
+		Addr: self.GetBroadcastAddr(),
+		Zone: self.GetZone(),
+	}
+}
+
+// Remotes returns remotes to all positions of this Node, the primary position first.
+func (self *Node) Remotes() (result common.Remotes) {
+	result = common.Remotes{self.Remote()}
+	self.metaLock.RLock()
+	defer self.metaLock.RUnlock()
+	for index, position := range self.virtualPositions {
+		result = append(result, common.Remote{
+			Pos:   position,
+			Addr:  self.broadcastAddr,
+			Index: index + 1,
+			Zone:  self.zone,
+		})
+	}
+	return
+}
+func (self *Node) addSelf() {
+	for _, remote := range self.Remotes() {
+		self.ring.Add(remote)
+	}
 }
 
 // Stop will shut down this Node permanently.
@@ -205,8 +305,9 @@ func (self *Node) Start() (err error) {
 			return
 		}
 	}
-	self.ring.Add(self.Remote())
-	go server.Accept(self.getListener())
+	self.placeVirtualNodes()
+	self.addSelf()
+	go self.accept(server, self.getListener())
 	go self.notifyPeriodically()
 	go self.pingPeriodically()
 	return
@@ -230,6 +331,7 @@ func (self *Node) RingHash() []byte {
 }
 
 // Ping will compare the hash of this Node with the one in the received PingPack, and request the entire routing ring from the sender if they are not equal.
+// The predecessors of all positions of this Node are kept, since they are known better by this Node than by the sender.
 func (self *Node) Ping(ping PingPack) (me common.Remote) {
 	me = self.Remote()
 	if bytes.Compare(ping.RingHash, self.ring.Hash()) != 0 {
@@ -239,30 +341,45 @@ func (self *Node) Ping(ping PingPack) (me common.Remote) {
 		} else {
 			self.routeLock.Lock()
 			defer self.routeLock.Unlock()
-			pred := self.ring.Predecessor(me)
+			remotes := self.Remotes()
+			preds := make(common.Remotes, len(remotes))
+			for index, remote := range remotes {
+				preds[index] = self.ring.Predecessor(remote)
+			}
 			self.ring.SetNodes(newNodes)
-			self.ring.Add(me)
-			self.ring.Add(pred)
-			self.ring.Clean(pred, me)
+			self.addSelf()
+			for index, remote := range remotes {
+				self.ring.Add(preds[index])
+				self.ring.Clean(preds[index], remote)
+			}
 		}
 	}
 	return
 }
+
+// pingPredecessor will ping the predecessors of all positions of this Node, except the ones that are positions of this Node as well.
 func (self *Node) pingPredecessor() {
-	pred := self.GetPredecessor()
-	ping := PingPack{
-		RingHash: self.ring.Hash(),
-		Caller:   self.Remote(),
-	}
-	var newPred common.Remote
-	op := "Discord.Ping"
-	self.triggerCommListeners(self.Remote(), pred, op)
-	if err := pred.Call(op, ping, &newPred); err != nil {
-		self.RemoveNode(pred)
-	} else {
-		self.routeLock.Lock()
-		defer self.routeLock.Unlock()
-		self.ring.Add(newPred)
+	pinged := map[string]bool{self.GetBroadcastAddr(): true}
+	for _, remote := range self.Remotes() {
+		pred := self.GetPredecessorForRemote(remote)
+		if pinged[pred.Addr] {
+			continue
+		}
+		pinged[pred.Addr] = true
+		ping := PingPack{
+			RingHash: self.ring.Hash(),
+			Caller:   remote,
+		}
+		var newPred common.Remote
+		op := "Discord.Ping"
+		self.triggerCommListeners(remote, pred, op)
+		if err := pred.Call(op, ping, &newPred); err != nil {
+			self.RemoveNode(pred)
+		} else {
+			self.routeLock.Lock()
+			self.ring.Add(newPred)
+			self.routeLock.Unlock()
+		}
 	}
 }
 
@@ -271,26 +388,32 @@ func (self *Node) Nodes() common.Remotes {
 	return self.ring.Nodes()
 }
 
-// Notify will add the caller to the ring of this Node.
+// Notify will add the caller to the ring of this Node, and return the predecessor of the position of this Node succeeding the caller.
 func (self *Node) Notify(caller common.Remote) common.Remote {
 	self.routeLock.Lock()
 	defer self.routeLock.Unlock()
 	self.ring.Add(caller)
-	return self.GetPredecessor()
+	return self.GetPredecessorForRemote(self.GetSuccessorForRemote(caller))
 }
+
+// notifySuccessor will notify the successors of all positions of this Node, except the ones that are positions of this Node as well.
 func (self *Node) notifySuccessor() {
-	succ := self.GetSuccessor()
-	var otherPred common.Remote
-	op := "Discord.Notify"
-	selfRemote := self.Remote()
-	self.triggerCommListeners(selfRemote, succ, op)
-	if err := succ.Call(op, selfRemote, &otherPred); err != nil {
-		self.RemoveNode(succ)
-	} else {
-		if otherPred.Addr != self.GetBroadcastAddr() {
-			self.routeLock.Lock()
-			defer self.routeLock.Unlock()
-			self.ring.Add(otherPred)
+	for _, selfRemote := range self.Remotes() {
+		succ := self.GetSuccessorForRemote(selfRemote)
+		if succ.Addr == self.GetBroadcastAddr() {
+			continue
+		}
+		var otherPred common.Remote
+		op := "Discord.Notify"
+		self.triggerCommListeners(selfRemote, succ, op)
+		if err := succ.Call(op, selfRemote, &otherPred); err != nil {
+			self.RemoveNode(succ)
+		} else {
+			if otherPred.Addr != self.GetBroadcastAddr() {
+				self.routeLock.Lock()
+				self.ring.Add(otherPred)
+				self.routeLock.Unlock()
+			}
 		}
 	}
 }
@@ -311,6 +434,7 @@ func (self *Node) Join(addr string) (err error) {
 	}
 	self.routeLock.Lock()
 	self.ring.SetNodes(newNodes)
+	self.addSelf()
 	self.routeLock.Unlock()
 	var x common.Remote
 	if err = common.Switch.Call(addr, "Discord.Notify", self.Remote(), &x); err != nil {
@@ -353,9 +477,29 @@ func (self *Node) HasNode(pos []byte) bool {
 	return false
 }
 
-// GetSuccessor will return our successor on the ring.
+// GetSuccessor will return our successor on the ring, skipping any virtual positions of our own. If there is no other Node it will return us.
 func (self *Node) GetSuccessor() common.Remote {
-	return self.GetSuccessorForRemote(self.Remote())
+	me := self.Remote()
+	successor := self.GetSuccessorForRemote(me)
+	for steps := self.ring.Size(); steps > 0 && successor.Addr == me.Addr; steps-- {
+		successor = self.GetSuccessorForRemote(successor)
+	}
+	if successor.Addr == me.Addr {
+		return me
+	}
+	return successor
+}
+
+// GetReplicasForRemote will return r followed by the first successors of r that are other Nodes, preferably in other zones, up to n Nodes in total.
+// These are the Nodes responsible for the keys r is responsible for, when n copies of them are kept.
+func (self *Node) GetReplicasForRemote(r common.Remote, n int) common.Remotes {
+	return self.ring.Successors(r, n)
+}
+
+// GetReplicasFor will return the n Nodes responsible for key according to our ring, without asking any other Node.
+func (self *Node) GetReplicasFor(key []byte, n int) common.Remotes {
+	_, _, successor := self.ring.Remotes(key)
+	return self.GetReplicasForRemote(*successor, n)
 }
 
 // GetSuccessorFor will return the successor for the provided remote.
@@ -374,7 +518,7 @@ func (self *Node) GetSuccessorFor(key []byte) common.Remote {
 	// If we consider ourselves successors, just return us
 	if successor.Addr != self.GetBroadcastAddr() {
 		// Double check by asking the successor we found what predecessor it has
-		if err := successor.Call("Discord.GetPredecessor", 0, predecessor); err != nil {
+		if err := self.getPredecessorOf(*successor, predecessor); err != nil {
 			self.RemoveNode(*successor)
 			return self.GetSuccessorFor(key)
 		}
@@ -389,3 +533,14 @@ func (self *Node) GetSuccessorFor(key []byte) common.Remote {
 	}
 	return *successor
 }
+
+// getPredecessorOf will ask remote about the predecessor of its position, falling back to asking about its only position if remote
+// does not know about virtual positions.
+func (self *Node) getPredecessorOf(remote common.Remote, predecessor *common.Remote) (err error) {
+	if err = remote.Call("Discord.GetPredecessorForRemote", remote, predecessor); err != nil {
+		if _, ok := err.(rpc.ServerError); ok && !common.IsAccessDenied(err) {
+			err = remote.Call("Discord.GetPredecessor", 0, predecessor)
+		}
+	}
+	return
+}
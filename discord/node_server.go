@@ -22,6 +22,10 @@ func (self *nodeServer) GetPredecessor(x int, predecessor *common.Remote) error
 	*predecessor = (*Node)(self).GetPredecessor()
 	return nil
 }
+func (self *nodeServer) GetPredecessorForRemote(r common.Remote, predecessor *common.Remote) error {
+	*predecessor = (*Node)(self).GetPredecessorForRemote(r)
+	return nil
+}
 func (self *nodeServer) GetSuccessorFor(key []byte, successor *common.Remote) error {
 	*successor = (*Node)(self).GetSuccessorFor(key)
 	return nil
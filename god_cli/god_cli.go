@@ -9,7 +9,6 @@ import (
 	"github.com/zond/god/common"
 	"github.com/zond/setop"
 	"io"
-	"math/big"
 	"os"
 	"regexp"
 	"strconv"
@@ -17,66 +16,31 @@ import (
 	"sync"
 )
 
-const (
-	stringFormat = "string"
-	floatFormat  = "float"
-	intFormat    = "int"
-	bigFormat    = "big"
-)
-
-var formats = []string{stringFormat, floatFormat, intFormat, bigFormat}
-
 type action func(conn *client.Conn, args []string)
 
 var ip = flag.String("ip", "127.0.0.1", "IP address to connect to")
 var port = flag.Int("port", 9191, "Port to connect to")
-var enc = flag.String("enc", stringFormat, fmt.Sprintf("What format to assume when encoding and decoding byte slices: %v", formats))
+var enc = flag.String("enc", common.StringFormat, fmt.Sprintf("What format to assume when encoding and decoding byte slices: %v", common.Formats))
+var pageSize = flag.Int("pageSize", client.DefaultPageSize, "How many entries the iterate commands fetch at a time.")
+var token = flag.String("token", "", "Token to present to the cluster, if it requires authentication.")
+var useTLS = flag.Bool("tls", false, "Whether to connect using TLS. Implied by -tlsCA and -tlsCert.")
+var tlsCA = flag.String("tlsCA", "", "PEM file with the certificates to trust when verifying the cluster. Defaults to the system roots.")
+var tlsCert = flag.String("tlsCert", "", "PEM file with the certificate to present to the cluster, if it requires one.")
+var tlsKey = flag.String("tlsKey", "", "PEM file with the private key of -tlsCert.")
 
 func encode(s string) []byte {
-	switch *enc {
-	case stringFormat:
-		return []byte(s)
-	case floatFormat:
-		result, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			panic(err)
-		}
-		return common.EncodeFloat64(result)
-	case intFormat:
-		result, err := strconv.ParseInt(s, 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		return common.EncodeInt64(result)
-	case bigFormat:
-		result, ok := new(big.Int).SetString(s, 10)
-		if !ok {
-			panic(fmt.Errorf("Bad BigInt format: %v", s))
-		}
-		return common.EncodeBigInt(result)
+	result, err := common.EncodeFormat(*enc, s)
+	if err != nil {
+		panic(err)
 	}
-	panic(fmt.Errorf("Unknown encoding: %v", *enc))
+	return result
 }
 func decode(b []byte) string {
-	switch *enc {
-	case stringFormat:
-		return string(b)
-	case floatFormat:
-		res, err := common.DecodeFloat64(b)
-		if err != nil {
-			return fmt.Sprint(b)
-		}
-		return fmt.Sprint(res)
-	case intFormat:
-		res, err := common.DecodeInt64(b)
-		if err != nil {
-			return fmt.Sprint(b)
-		}
-		return fmt.Sprint(res)
-	case bigFormat:
-		return fmt.Sprint(common.DecodeBigInt(b))
+	result, err := common.DecodeFormat(*enc, b)
+	if err != nil {
+		panic(err)
 	}
-	panic(fmt.Errorf("Unknown encoding: %v", *enc))
+	return result
 }
 
 type actionSpec struct {
@@ -101,26 +65,50 @@ var actions = map[*actionSpec]action{
 	newActionSpec("mirrorSlice \\S+ \\S+ \\S+"):             mirrorSlice,
 	newActionSpec("mirrorSliceLen \\S+ \\S+ \\d+"):          mirrorSliceLen,
 	newActionSpec("mirrorReverseSliceLen \\S+ \\S+ \\d+"):   mirrorReverseSliceLen,
+	newActionSpec("indexSlice \\S+ \\S+ \\S+ \\S+"):         indexSlice,
+	newActionSpec("indexIndexOf \\S+ \\S+ \\S+"):            indexIndexOf,
 	newActionSpec("reverseSliceIndex \\S+ \\d+ \\d+"):       reverseSliceIndex,
 	newActionSpec("sliceIndex \\S+ \\d+ \\d+"):              sliceIndex,
 	newActionSpec("reverseSlice \\S+ \\S+ \\S+"):            reverseSlice,
 	newActionSpec("slice \\S+ \\S+ \\S+"):                   slice,
 	newActionSpec("sliceLen \\S+ \\S+ \\d+"):                sliceLen,
 	newActionSpec("reverseSliceLen \\S+ \\S+ \\d+"):         reverseSliceLen,
+	newActionSpec("iterate \\S+"):                           iterate,
+	newActionSpec("reverseIterate \\S+"):                    reverseIterate,
+	newActionSpec("mirrorIterate \\S+"):                     mirrorIterate,
+	newActionSpec("mirrorReverseIterate \\S+"):              mirrorReverseIterate,
+	newActionSpec("topSlice \\S+ \\S+"):                     topSlice,
+	newActionSpec("topReverseSlice \\S+ \\S+"):              topReverseSlice,
+	newActionSpec("topSliceLen \\S+ \\d+"):                  topSliceLen,
+	newActionSpec("topReverseSliceLen \\S+ \\d+"):           topReverseSliceLen,
+	newActionSpec("topCount \\S+ \\S+"):                     topCount,
+	newActionSpec("prefixSlice \\S+"):                       prefixSlice,
+	newActionSpec("prefixCount \\S+"):                       prefixCount,
+	newActionSpec("prefixDelete \\S+"):                      prefixDelete,
+	newActionSpec("subPrefixSlice \\S+ \\S+"):               subPrefixSlice,
+	newActionSpec("subPrefixCount \\S+ \\S+"):               subPrefixCount,
+	newActionSpec("subPrefixDelete \\S+ \\S+"):              subPrefixDelete,
 	newActionSpec("setOp .+"):                               setOp,
 	newActionSpec("dumpSetOp \\S+ .+"):                      dumpSetOp,
+	newActionSpec("distributedSetOp .+"):                    distributedSetOp,
 	newActionSpec("put \\S+ \\S+"):                          put,
 	newActionSpec("clear"):                                  clear,
 	newActionSpec("dump"):                                   dump,
 	newActionSpec("subDump \\S+"):                           subDump,
+	newActionSpec("backup \\S+"):                            backup,
+	newActionSpec("restore \\S+"):                           restore,
 	newActionSpec("subSize \\S+"):                           subSize,
 	newActionSpec("size"):                                   size,
 	newActionSpec("count \\S+ \\S+ \\S+"):                   count,
 	newActionSpec("mirrorCount \\S+ \\S+ \\S+"):             mirrorCount,
 	newActionSpec("get \\S+"):                               get,
+	newActionSpec("mGet \\S+"):                              mGet,
 	newActionSpec("del \\S+"):                               del,
 	newActionSpec("subPut \\S+ \\S+ \\S+"):                  subPut,
+	newActionSpec("incr \\S+ \\S+"):                         incr,
+	newActionSpec("subIncr \\S+ \\S+ \\S+"):                 subIncr,
 	newActionSpec("subGet \\S+ \\S+"):                       subGet,
+	newActionSpec("subMGet \\S+ \\S+"):                      subMGet,
 	newActionSpec("subDel \\S+ \\S+"):                       subDel,
 	newActionSpec("subClear \\S+"):                          subClear,
 	newActionSpec("describeAll"):                            describeAll,
@@ -149,6 +137,8 @@ var actions = map[*actionSpec]action{
 	newActionSpec("subConfiguration \\S+"):                  subConfiguration,
 	newActionSpec("configure \\S+ \\S+"):                    configure,
 	newActionSpec("subConfigure \\S+ \\S+ \\S+"):            subConfigure,
+	newActionSpec("addToken \\S+ \\S+"):                     addToken,
+	newActionSpec("grant \\S+ \\S+ \\S+"):                   grant,
 }
 
 func mustAtoi(s string) *int {
@@ -159,6 +149,14 @@ func mustAtoi(s string) *int {
 	return &i
 }
 
+func mustParseInt64(s string) int64 {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
 func configuration(conn *client.Conn, args []string) {
 	fmt.Println(conn.Configuration())
 }
@@ -175,6 +173,14 @@ func subConfigure(conn *client.Conn, args []string) {
 	conn.SubAddConfiguration([]byte(args[1]), args[2], args[3])
 }
 
+func addToken(conn *client.Conn, args []string) {
+	conn.AddConfiguration(fmt.Sprintf("token.%v", args[1]), common.TokenHash(args[2]))
+}
+
+func grant(conn *client.Conn, args []string) {
+	conn.AddConfiguration(fmt.Sprintf("acl.%v.%v", args[1], common.HexEncode(encode(args[3]))), args[2])
+}
+
 func subSize(conn *client.Conn, args []string) {
 	fmt.Println(conn.SubSize([]byte(args[1])))
 }
@@ -207,6 +213,18 @@ func mirrorSlice(conn *client.Conn, args []string) {
 	}
 }
 
+func indexSlice(conn *client.Conn, args []string) {
+	for i, item := range conn.IndexSlice([]byte(args[1]), args[2], encode(args[3]), encode(args[4]), true, false) {
+		fmt.Printf("%v: %v => %v\n", i, decode(item.Key), string(item.Value))
+	}
+}
+
+func indexIndexOf(conn *client.Conn, args []string) {
+	if index, existed := conn.IndexIndexOf([]byte(args[1]), args[2], encode(args[3])); existed {
+		fmt.Println(index)
+	}
+}
+
 func mirrorSliceLen(conn *client.Conn, args []string) {
 	for _, item := range conn.MirrorSliceLen([]byte(args[1]), []byte(args[2]), true, *(mustAtoi(args[3]))) {
 		fmt.Printf("%v => %v\n", decode(item.Key), string(item.Value))
@@ -255,6 +273,90 @@ func reverseSliceLen(conn *client.Conn, args []string) {
 	}
 }
 
+func topSlice(conn *client.Conn, args []string) {
+	for i, item := range conn.TopSlice([]byte(args[1]), []byte(args[2]), true, false) {
+		fmt.Printf("%v: %v => %v\n", i, string(item.Key), decode(item.Value))
+	}
+}
+
+func topReverseSlice(conn *client.Conn, args []string) {
+	for i, item := range conn.TopReverseSlice([]byte(args[1]), []byte(args[2]), true, false) {
+		fmt.Printf("%v: %v => %v\n", i, string(item.Key), decode(item.Value))
+	}
+}
+
+func topSliceLen(conn *client.Conn, args []string) {
+	for _, item := range conn.TopSliceLen([]byte(args[1]), true, *(mustAtoi(args[2]))) {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+	}
+}
+
+func topReverseSliceLen(conn *client.Conn, args []string) {
+	for _, item := range conn.TopReverseSliceLen([]byte(args[1]), true, *(mustAtoi(args[2]))) {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+	}
+}
+
+func topCount(conn *client.Conn, args []string) {
+	fmt.Println(conn.TopCount([]byte(args[1]), []byte(args[2]), true, false))
+}
+
+func prefixSlice(conn *client.Conn, args []string) {
+	for _, item := range conn.PrefixSlice([]byte(args[1])) {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+	}
+}
+
+func prefixCount(conn *client.Conn, args []string) {
+	fmt.Println(conn.PrefixCount([]byte(args[1])))
+}
+
+func prefixDelete(conn *client.Conn, args []string) {
+	fmt.Println(conn.PrefixDelete([]byte(args[1])))
+}
+
+func subPrefixSlice(conn *client.Conn, args []string) {
+	for _, item := range conn.SubPrefixSlice([]byte(args[1]), []byte(args[2])) {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+	}
+}
+
+func subPrefixCount(conn *client.Conn, args []string) {
+	fmt.Println(conn.SubPrefixCount([]byte(args[1]), []byte(args[2])))
+}
+
+func subPrefixDelete(conn *client.Conn, args []string) {
+	fmt.Println(conn.SubPrefixDelete([]byte(args[1]), []byte(args[2])))
+}
+
+func iterate(conn *client.Conn, args []string) {
+	conn.Cursor([]byte(args[1]), nil, nil, true, true).PageSize(*pageSize).Each(func(item common.Item) bool {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+		return true
+	})
+}
+
+func reverseIterate(conn *client.Conn, args []string) {
+	conn.ReverseCursor([]byte(args[1]), nil, nil, true, true).PageSize(*pageSize).Each(func(item common.Item) bool {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+		return true
+	})
+}
+
+func mirrorIterate(conn *client.Conn, args []string) {
+	conn.MirrorCursor([]byte(args[1]), nil, nil, true, true).PageSize(*pageSize).Each(func(item common.Item) bool {
+		fmt.Printf("%v => %v\n", decode(item.Key), string(item.Value))
+		return true
+	})
+}
+
+func mirrorReverseIterate(conn *client.Conn, args []string) {
+	conn.MirrorReverseCursor([]byte(args[1]), nil, nil, true, true).PageSize(*pageSize).Each(func(item common.Item) bool {
+		fmt.Printf("%v => %v\n", decode(item.Key), string(item.Value))
+		return true
+	})
+}
+
 func printSetOpRes(res setop.SetOpResult) {
 	var vals []string
 	for _, val := range res.Values {
@@ -285,6 +387,25 @@ func dumpSetOp(conn *client.Conn, args []string) {
 	}
 }
 
+func distributedSetOp(conn *client.Conn, args []string) {
+	op, err := setop.NewSetOpParser(args[1]).Parse()
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		result := conn.DistributedSetExpression(setop.SetExpression{Op: op})
+		for _, res := range result.Results {
+			printSetOpRes(res)
+		}
+		for _, source := range result.Sources {
+			var keys []string
+			for _, key := range source.Keys {
+				keys = append(keys, string(key))
+			}
+			fmt.Printf("%v from %v: %v calls, %v results\n", keys, source.Addr, source.Calls, source.Results)
+		}
+	}
+}
+
 func mirrorReverseIndexOf(conn *client.Conn, args []string) {
 	if index, existed := conn.MirrorReverseIndexOf([]byte(args[1]), []byte(args[2])); existed {
 		fmt.Println(index)
@@ -309,6 +430,36 @@ func indexOf(conn *client.Conn, args []string) {
 	}
 }
 
+func incr(conn *client.Conn, args []string) {
+	var result interface{}
+	var err error
+	if *enc == common.FloatFormat {
+		result, err = conn.IncrFloat([]byte(args[1]), common.MustParseFloat64(args[2]))
+	} else {
+		result, err = conn.Incr([]byte(args[1]), mustParseInt64(args[2]))
+	}
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(result)
+	}
+}
+
+func subIncr(conn *client.Conn, args []string) {
+	var result interface{}
+	var err error
+	if *enc == common.FloatFormat {
+		result, err = conn.SubIncrFloat([]byte(args[1]), []byte(args[2]), common.MustParseFloat64(args[3]))
+	} else {
+		result, err = conn.SubIncr([]byte(args[1]), []byte(args[2]), mustParseInt64(args[3]))
+	}
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(result)
+	}
+}
+
 func show(conn *client.Conn) {
 	fmt.Println(conn.Describe())
 }
@@ -451,6 +602,26 @@ func subGet(conn *client.Conn, args []string) {
 	}
 }
 
+func mGet(conn *client.Conn, args []string) {
+	keys := make([][]byte, len(args)-1)
+	for index, arg := range args[1:] {
+		keys[index] = []byte(arg)
+	}
+	for _, item := range conn.MGet(keys) {
+		fmt.Printf("%v => %v, exists: %v\n", string(item.Key), decode(item.Value), item.Exists)
+	}
+}
+
+func subMGet(conn *client.Conn, args []string) {
+	subKeys := make([][]byte, len(args)-2)
+	for index, arg := range args[2:] {
+		subKeys[index] = []byte(arg)
+	}
+	for _, item := range conn.SubMGet([]byte(args[1]), subKeys) {
+		fmt.Printf("%v => %v, exists: %v\n", string(item.SubKey), decode(item.Value), item.Exists)
+	}
+}
+
 func clear(conn *client.Conn, args []string) {
 	conn.Clear()
 }
@@ -465,6 +636,34 @@ func subDump(conn *client.Conn, args []string) {
 	linedump(dump, wait)
 }
 
+func backup(conn *client.Conn, args []string) {
+	file, err := os.Create(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer file.Close()
+	records, err := conn.Backup(file)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("Wrote %v records to %v\n", records, args[1])
+}
+
+func restore(conn *client.Conn, args []string) {
+	file, err := os.Open(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer file.Close()
+	records, err := conn.Restore(file)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("Restored %v records from %v\n", records, args[1])
+}
+
 func linedump(dump chan [2][]byte, wait *sync.WaitGroup) {
 	defer func() {
 		close(dump)
@@ -509,6 +708,14 @@ func del(conn *client.Conn, args []string) {
 
 func main() {
 	flag.Parse()
+	common.Switch.SetToken(*token)
+	if *useTLS || *tlsCA != "" || *tlsCert != "" {
+		config, err := common.NewTLSConfig(*tlsCert, *tlsKey, *tlsCA, false)
+		if err != nil {
+			panic(err)
+		}
+		common.Switch.SetTLS(config)
+	}
 	conn := client.MustConn(fmt.Sprintf("%v:%v", *ip, *port))
 	if len(flag.Args()) == 0 {
 		show(conn)
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/zond/god/common"
+	"github.com/zond/god/persistence"
+	"os"
+	"time"
+)
+
+const (
+	confOp     = "conf"
+	subConfOp  = "subConf"
+	putOp      = "put"
+	subPutOp   = "subPut"
+	delOp      = "del"
+	subDelOp   = "subDel"
+	clearOp    = "clear"
+	subClearOp = "subClear"
+)
+
+var opTypes = []string{confOp, subConfOp, putOp, subPutOp, delOp, subDelOp, clearOp, subClearOp}
+
+type command func(logger *persistence.Logger, f filter) int
+
+var dir = flag.String("dir", "", "The data directory of the node to inspect.")
+var enc = flag.String("enc", common.StringFormat, fmt.Sprintf("What format to assume when encoding and decoding byte slices: %v", common.Formats))
+var key = flag.String("key", "", "Only consider ops on this key.")
+var subKey = flag.String("subKey", "", "Only consider ops on this sub key.")
+var after = flag.String("after", "", "Only consider ops with timestamps at or after this RFC3339 time.")
+var before = flag.String("before", "", "Only consider ops with timestamps before this RFC3339 time.")
+var compress = flag.Bool("compress", false, "Whether to compress the snapshot written by compact.")
+var force = flag.Bool("force", false, "Whether to compact even if the logfiles are corrupt, dropping everything after the first corrupt block of each file.")
+
+var commands = map[string]command{
+	"list":    list,
+	"stats":   stats,
+	"verify":  verify,
+	"compact": compact,
+}
+
+func encode(s string) []byte {
+	result, err := common.EncodeFormat(*enc, s)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+func decode(b []byte) string {
+	if b == nil {
+		return "-"
+	}
+	result, err := common.DecodeFormat(*enc, b)
+	if err != nil {
+		panic(err)
+	}
+	if *enc == common.StringFormat {
+		return fmt.Sprintf("%#v", result)
+	}
+	return result
+}
+func parseTime(s string) int64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t.UnixNano()
+}
+func formatTime(nanos int64) string {
+	if nanos == 0 {
+		return "-"
+	}
+	return time.Unix(0, nanos).Format(time.RFC3339Nano)
+}
+
+// opType will return what kind of operation op is, one of opTypes.
+func opType(op persistence.Op) string {
+	if op.Configuration != nil {
+		if op.Key == nil {
+			return confOp
+		}
+		return subConfOp
+	}
+	if op.Put {
+		if op.SubKey == nil {
+			return putOp
+		}
+		return subPutOp
+	}
+	if op.Clear {
+		if op.Key == nil {
+			return clearOp
+		}
+		return subClearOp
+	}
+	if op.SubKey == nil {
+		return delOp
+	}
+	return subDelOp
+}
+
+// filter decides which ops to consider, based on the command line flags.
+type filter struct {
+	key    []byte
+	subKey []byte
+	after  int64
+	before int64
+}
+
+func newFilter() (result filter) {
+	if *key != "" {
+		result.key = encode(*key)
+	}
+	if *subKey != "" {
+		result.subKey = encode(*subKey)
+	}
+	if *after != "" {
+		result.after = parseTime(*after)
+	}
+	if *before != "" {
+		result.before = parseTime(*before)
+	}
+	return
+}
+func (self filter) matches(op persistence.Op) bool {
+	if self.key != nil && bytes.Compare(op.Key, self.key) != 0 {
+		return false
+	}
+	if self.subKey != nil && bytes.Compare(op.SubKey, self.subKey) != 0 {
+		return false
+	}
+	if self.after != 0 && op.Timestamp < self.after {
+		return false
+	}
+	if self.before != 0 && op.Timestamp >= self.before {
+		return false
+	}
+	return true
+}
+func printLosses(losses []persistence.Loss) {
+	for _, loss := range losses {
+		fmt.Println(loss)
+	}
+}
+
+func list(logger *persistence.Logger, f filter) int {
+	losses := logger.Verify(func(op persistence.Op) {
+		if f.matches(op) {
+			switch typ := opType(op); typ {
+			case confOp, subConfOp:
+				fmt.Printf("%v %v %v %v\n", formatTime(op.Timestamp), typ, decode(op.Key), op.Configuration)
+			default:
+				fmt.Printf("%v %v %v %v %v %v\n", formatTime(op.Timestamp), typ, decode(op.Key), decode(op.SubKey), decode(op.Value), formatTime(op.Expiry))
+			}
+		}
+	})
+	printLosses(losses)
+	if len(losses) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func stats(logger *persistence.Logger, f filter) int {
+	snapshot, logs := logger.Logfiles()
+	if snapshot != "" {
+		logs = append([]string{snapshot}, logs...)
+	}
+	var size int64
+	for _, file := range logs {
+		fi, err := os.Stat(file)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%v\t%v bytes\n", file, fi.Size())
+		size += fi.Size()
+	}
+	counts := make(map[string]int)
+	keys := make(map[string]bool)
+	subTrees := make(map[string]bool)
+	var ops int
+	var first, last int64
+	losses := logger.Verify(func(op persistence.Op) {
+		if f.matches(op) {
+			ops++
+			counts[opType(op)]++
+			if op.Key != nil {
+				if op.SubKey == nil && op.Configuration == nil && !op.Clear {
+					keys[string(op.Key)] = true
+				} else {
+					subTrees[string(op.Key)] = true
+				}
+			}
+			if op.Timestamp != 0 {
+				if first == 0 || op.Timestamp < first {
+					first = op.Timestamp
+				}
+				if op.Timestamp > last {
+					last = op.Timestamp
+				}
+			}
+		}
+	})
+	fmt.Printf("files\t%v\n", len(logs))
+	fmt.Printf("bytes\t%v\n", size)
+	fmt.Printf("ops\t%v\n", ops)
+	for _, typ := range opTypes {
+		fmt.Printf("%v\t%v\n", typ, counts[typ])
+	}
+	fmt.Printf("keys\t%v\n", len(keys))
+	fmt.Printf("subTrees\t%v\n", len(subTrees))
+	if ops > 0 {
+		fmt.Printf("first\t%v\n", formatTime(first))
+		fmt.Printf("last\t%v\n", formatTime(last))
+	}
+	fmt.Printf("losses\t%v\n", len(losses))
+	printLosses(losses)
+	return 0
+}
+
+func verify(logger *persistence.Logger, f filter) int {
+	var ops int
+	losses := logger.Verify(func(op persistence.Op) {
+		ops++
+	})
+	printLosses(losses)
+	if len(losses) > 0 {
+		fmt.Printf("%v ops readable, %v files corrupt\n", ops, len(losses))
+		return 1
+	}
+	fmt.Printf("%v ops ok\n", ops)
+	return 0
+}
+
+func compact(logger *persistence.Logger, f filter) int {
+	if losses := logger.Verify(func(op persistence.Op) {}); len(losses) > 0 {
+		printLosses(losses)
+		if !*force {
+			fmt.Println("Refusing to compact corrupt logfiles without -force")
+			return 1
+		}
+	}
+	logger.Compact()
+	snapshot, _ := logger.Logfiles()
+	fmt.Printf("Compacted into %v\n", snapshot)
+	return 0
+}
+
+func main() {
+	flag.Parse()
+	if *dir == "" || len(flag.Args()) != 1 || commands[flag.Arg(0)] == nil {
+		fmt.Fprintf(os.Stderr, "Usage: %v -dir DIR [flags] list|stats|verify|compact\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(commands[flag.Arg(0)](persistence.NewLogger(*dir).Compress(*compress), newFilter()))
+}
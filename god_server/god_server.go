@@ -3,21 +3,42 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/zond/god/client"
 	"github.com/zond/god/common"
 	"github.com/zond/god/dhash"
+	"github.com/zond/god/persistence"
+	"github.com/zond/god/resp"
 	"runtime"
+	"time"
 )
 
 const (
 	address = "address"
 )
 
+var fsyncModes = map[string]int{
+	"never":    persistence.FsyncNever,
+	"interval": persistence.FsyncInterval,
+	"always":   persistence.FsyncAlways,
+}
+
 var listenIp = flag.String("listenIp", "127.0.0.1", "IP address to listen at.")
 var broadcastIp = flag.String("broadcastIp", "127.0.0.1", "IP address to broadcast to the cluster.")
 var port = flag.Int("port", 9191, "Port to listen to for net/rpc connections. The next port will be used for the HTTP service.")
 var joinIp = flag.String("joinIp", "", "IP address to join.")
 var joinPort = flag.Int("joinPort", 9191, "Port to join.")
 var verbose = flag.Bool("verbose", false, "Whether the server should be log verbosely to the console.")
+var compress = flag.Bool("compress", false, "Whether to compress logfiles and snapshots.")
+var fsync = flag.String("fsync", "never", "When to fsync logfiles: 'always' after every write, every 'interval', or 'never'. Synchronous writes always wait for their log to be written, and unless 'never', fsynced.")
+var fsyncInterval = flag.Int("fsyncInterval", 1000, "Milliseconds between each fsync when -fsync=interval.")
+var secret = flag.String("secret", "", "Secret shared by all nodes in the cluster. If set, all connections must present either the secret or a token configured using 'token.NAME', and are limited by the 'acl.' configuration, see dhash.Node#SetSecret.")
+var tlsCert = flag.String("tlsCert", "", "PEM file with the certificate of this node. Together with -tlsKey it makes all connections to and from this node, including the HTTP service, use TLS.")
+var tlsKey = flag.String("tlsKey", "", "PEM file with the private key of -tlsCert.")
+var tlsCA = flag.String("tlsCA", "", "PEM file with the certificates to trust when verifying other nodes, and clients if -tlsMutual. Defaults to the system roots.")
+var tlsMutual = flag.Bool("tlsMutual", false, "Whether to require all connections to this node to present a certificate trusted by -tlsCA.")
+var respPort = flag.Int("respPort", 0, "Port to listen to for Redis protocol (RESP) connections, see resp.Server. 0 turns the RESP listener off.")
+var virtualNodes = flag.Int("virtualNodes", 1, "Number of positions this node occupies in the ring. More positions spread the keys of joining and leaving nodes over the whole cluster, see dhash.Node#SetVirtualNodes.")
+var zone = flag.String("zone", "", "Rack, data center or other failure domain of this node. Replicas of the same keys will be placed in different zones when possible.")
 var dir = flag.String("dir", address, "Where to store logfiles and snapshots. Defaults to a directory named after the listening ip/port. The empty string will turn off persistence.")
 
 func main() {
@@ -26,7 +47,25 @@ func main() {
 	if *dir == address {
 		*dir = fmt.Sprintf("%v_%v", *broadcastIp, *port)
 	}
-	s := dhash.NewNodeDir(fmt.Sprintf("%v:%v", *listenIp, *port), fmt.Sprintf("%v:%v", *broadcastIp, *port), *dir)
+	var logger *persistence.Logger
+	if *dir != "" {
+		mode, ok := fsyncModes[*fsync]
+		if !ok {
+			panic(fmt.Errorf("Unknown fsync mode %#v", *fsync))
+		}
+		logger = persistence.NewLogger(*dir).Compress(*compress).Fsync(mode, time.Duration(*fsyncInterval)*time.Millisecond)
+	}
+	s := dhash.NewNodeLogger(fmt.Sprintf("%v:%v", *listenIp, *port), fmt.Sprintf("%v:%v", *broadcastIp, *port), logger)
+	s.SetSecret(*secret)
+	s.SetVirtualNodes(*virtualNodes)
+	s.SetZone(*zone)
+	if *tlsCert != "" || *tlsKey != "" {
+		config, err := common.NewTLSConfig(*tlsCert, *tlsKey, *tlsCA, *tlsMutual)
+		if err != nil {
+			panic(err)
+		}
+		s.SetTLS(config)
+	}
 	if *verbose {
 		s.AddChangeListener(func(ring *common.Ring) bool {
 			fmt.Println(s.Describe())
@@ -49,6 +88,17 @@ func main() {
 	if *joinIp != "" {
 		s.MustJoin(fmt.Sprintf("%v:%v", *joinIp, *joinPort))
 	}
+	if *respPort != 0 {
+		conn := client.MustConn(s.GetBroadcastAddr())
+		conn.Start()
+		server := resp.NewServer(conn)
+		if *secret != "" {
+			server.SetAuth(s.Auth())
+		}
+		go func() {
+			panic(server.ListenAndServe(fmt.Sprintf("%v:%v", *listenIp, *respPort)))
+		}()
+	}
 
 	select {}
 }
@@ -0,0 +1,302 @@
+package persistence
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// The on disk format of a logfile is a header (logMagic followed by the format version), followed by blocks.
+//
+// Each block is a big endian uint32 payload length, a big endian uint32 CRC-32C of the flags and the payload, a flags byte and the payload.
+// If the flags have blockCompressed set, the payload is compressed using compress/flate.
+//
+// The (uncompressed) payload of a block contains one or more records. Each record is a uvarint length, a big endian uint32 CRC-32C of the
+// encoded Op and the encoded Op.
+const (
+	logMagic      = "GODLOG"
+	logVersion    = 1
+	maxBlockSize  = 1 << 16
+	maxBlockBytes = 1 << 26
+)
+
+const (
+	blockCompressed = 1 << iota
+)
+
+const (
+	opPut = 1 << iota
+	opClear
+	opKey
+	opSubKey
+	opValue
+	opConfiguration
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(b)))])
+	buf.Write(b)
+}
+func writeVarint(buf *bytes.Buffer, i int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], i)])
+}
+func readBytes(r *bytes.Reader) (result []byte, err error) {
+	var l uint64
+	if l, err = binary.ReadUvarint(r); err != nil {
+		return
+	}
+	if l > uint64(r.Len()) {
+		err = fmt.Errorf("Length %v is longer than remaining %v bytes", l, r.Len())
+		return
+	}
+	result = make([]byte, l)
+	_, err = io.ReadFull(r, result)
+	return
+}
+
+// encode will append the binary encoding of this Op to buf.
+func (self Op) encode(buf *bytes.Buffer) {
+	var flags byte
+	if self.Put {
+		flags |= opPut
+	}
+	if self.Clear {
+		flags |= opClear
+	}
+	if self.Key != nil {
+		flags |= opKey
+	}
+	if self.SubKey != nil {
+		flags |= opSubKey
+	}
+	if self.Value != nil {
+		flags |= opValue
+	}
+	if self.Configuration != nil {
+		flags |= opConfiguration
+	}
+	buf.WriteByte(flags)
+	if self.Key != nil {
+		writeBytes(buf, self.Key)
+	}
+	if self.SubKey != nil {
+		writeBytes(buf, self.SubKey)
+	}
+	if self.Value != nil {
+		writeBytes(buf, self.Value)
+	}
+	writeVarint(buf, self.Timestamp)
+	writeVarint(buf, self.Expiry)
+	if self.Configuration != nil {
+		writeVarint(buf, int64(len(self.Configuration)))
+		for key, value := range self.Configuration {
+			writeBytes(buf, []byte(key))
+			writeBytes(buf, []byte(value))
+		}
+	}
+}
+
+// decodeOp will return the Op encoded in b.
+func decodeOp(b []byte) (result Op, err error) {
+	r := bytes.NewReader(b)
+	var flags byte
+	if flags, err = r.ReadByte(); err != nil {
+		return
+	}
+	result.Put, result.Clear = flags&opPut != 0, flags&opClear != 0
+	if flags&opKey != 0 {
+		if result.Key, err = readBytes(r); err != nil {
+			return
+		}
+	}
+	if flags&opSubKey != 0 {
+		if result.SubKey, err = readBytes(r); err != nil {
+			return
+		}
+	}
+	if flags&opValue != 0 {
+		if result.Value, err = readBytes(r); err != nil {
+			return
+		}
+	}
+	if result.Timestamp, err = binary.ReadVarint(r); err != nil {
+		return
+	}
+	if result.Expiry, err = binary.ReadVarint(r); err != nil {
+		return
+	}
+	if flags&opConfiguration != 0 {
+		var n int64
+		if n, err = binary.ReadVarint(r); err != nil {
+			return
+		}
+		result.Configuration = make(map[string]string)
+		var key, value []byte
+		for i := int64(0); i < n; i++ {
+			if key, err = readBytes(r); err != nil {
+				return
+			}
+			if value, err = readBytes(r); err != nil {
+				return
+			}
+			result.Configuration[string(key)] = string(value)
+		}
+	}
+	if r.Len() != 0 {
+		err = fmt.Errorf("%v trailing bytes after op", r.Len())
+	}
+	return
+}
+
+// appendRecord will append op as a record to block.
+func appendRecord(block *bytes.Buffer, op Op) {
+	encoded := new(bytes.Buffer)
+	op.encode(encoded)
+	var tmp [binary.MaxVarintLen64]byte
+	block.Write(tmp[:binary.PutUvarint(tmp[:], uint64(encoded.Len()))])
+	binary.BigEndian.PutUint32(tmp[:4], crc32.Checksum(encoded.Bytes(), crcTable))
+	block.Write(tmp[:4])
+	block.Write(encoded.Bytes())
+}
+
+// decodeRecords will return the Ops in the records of payload, up to the first record that is corrupt, and the number of bytes of payload they occupy.
+// If a corrupt record is found, err tells why.
+func decodeRecords(payload []byte) (result []Op, valid int, err error) {
+	r := bytes.NewReader(payload)
+	var l uint64
+	var sum [4]byte
+	var op Op
+	for r.Len() > 0 {
+		if l, err = binary.ReadUvarint(r); err != nil {
+			err = fmt.Errorf("Torn record length at block offset %v: %v", valid, err)
+			return
+		}
+		if _, err = io.ReadFull(r, sum[:]); err != nil {
+			err = fmt.Errorf("Torn record checksum at block offset %v: %v", valid, err)
+			return
+		}
+		if l > uint64(r.Len()) {
+			err = fmt.Errorf("Record length %v at block offset %v is longer than remaining %v bytes", l, valid, r.Len())
+			return
+		}
+		encoded := make([]byte, l)
+		if _, err = io.ReadFull(r, encoded); err != nil {
+			return
+		}
+		if crc32.Checksum(encoded, crcTable) != binary.BigEndian.Uint32(sum[:]) {
+			err = fmt.Errorf("Record checksum mismatch at block offset %v", valid)
+			return
+		}
+		if op, err = decodeOp(encoded); err != nil {
+			err = fmt.Errorf("Corrupt record at block offset %v: %v", valid, err)
+			return
+		}
+		result = append(result, op)
+		valid = len(payload) - r.Len()
+	}
+	return
+}
+
+// writeHeader will write the header of a logfile to w.
+func writeHeader(w io.Writer) (err error) {
+	_, err = w.Write(append([]byte(logMagic), logVersion))
+	return
+}
+
+// readHeader will return whether r starts with a valid header, and the version of the format.
+func readHeader(r io.Reader) (version int, ok bool) {
+	header := make([]byte, len(logMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	if string(header[:len(logMagic)]) != logMagic {
+		return
+	}
+	return int(header[len(logMagic)]), true
+}
+
+// writeBlock will write the records in block to w, compressing them if compress and if that makes them smaller.
+func writeBlock(w io.Writer, block []byte, compress bool) (written int, err error) {
+	var flags byte
+	payload := block
+	if compress {
+		compressed := new(bytes.Buffer)
+		var compressor *flate.Writer
+		if compressor, err = flate.NewWriter(compressed, flate.DefaultCompression); err != nil {
+			return
+		}
+		if _, err = compressor.Write(block); err != nil {
+			return
+		}
+		if err = compressor.Close(); err != nil {
+			return
+		}
+		if compressed.Len() < len(block) {
+			flags |= blockCompressed
+			payload = compressed.Bytes()
+		}
+	}
+	buf := make([]byte, 9, 9+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	buf[8] = flags
+	buf = append(buf, payload...)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], crcTable))
+	return w.Write(buf)
+}
+
+// readBlock will return the Ops in the next block of r, the number of bytes the block occupied, and whether its payload is compressed.
+// If r is at the end of the file, io.EOF is returned.
+//
+// The records of a block are verified one by one, so a corrupt or torn block still returns the Ops of the records before the first corrupt one,
+// along with an error telling what is wrong. recovered is then the (uncompressed) records of those Ops, so that the block can be rewritten with only them.
+// A block whose own checksum fails even though all its records are intact is accepted.
+func readBlock(r io.Reader) (result []Op, read int, recovered []byte, compressed bool, err error) {
+	header := make([]byte, 9)
+	if read, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("Torn block header")
+		}
+		return
+	}
+	l := binary.BigEndian.Uint32(header[:4])
+	if l > maxBlockBytes {
+		err = fmt.Errorf("Block length %v is too big", l)
+		return
+	}
+	compressed = header[8]&blockCompressed != 0
+	payload := make([]byte, l)
+	var n int
+	var tornErr error
+	n, err = io.ReadFull(r, payload)
+	read += n
+	if err != nil {
+		tornErr = fmt.Errorf("Torn block: %v", err)
+		payload = payload[:n]
+	}
+	checksumOk := tornErr == nil && crc32.Update(crc32.Checksum(header[8:], crcTable), crcTable, payload) == binary.BigEndian.Uint32(header[4:8])
+	if compressed {
+		// A corrupt payload is decompressed as far as possible, and the records tell how much of it is intact.
+		var decompressErr error
+		if payload, decompressErr = ioutil.ReadAll(flate.NewReader(bytes.NewReader(payload))); decompressErr != nil && tornErr == nil {
+			tornErr = fmt.Errorf("Corrupt compressed block: %v", decompressErr)
+		}
+	}
+	var valid int
+	if result, valid, err = decodeRecords(payload); err == nil {
+		err = tornErr
+	} else if !checksumOk {
+		err = fmt.Errorf("Block checksum mismatch: %v", err)
+	}
+	if err != nil {
+		recovered = payload[:valid]
+	}
+	return
+}
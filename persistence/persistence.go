@@ -1,6 +1,7 @@
 package persistence
 
 import (
+	"bytes"
 	"encoding/gob"
 	"fmt"
 	"io"
@@ -23,6 +24,12 @@ const (
 	playing
 )
 
+const (
+	FsyncNever = iota
+	FsyncInterval
+	FsyncAlways
+)
+
 const (
 	snapSuffix       = "snap"
 	logSuffix        = "log"
@@ -35,17 +42,34 @@ type Op struct {
 	SubKey        []byte
 	Value         []byte
 	Timestamp     int64
+	Expiry        int64
 	Put           bool
 	Clear         bool
 	Configuration map[string]string
 }
 
+// Loss describes the end of a logfile that could not be replayed because it was corrupt.
+// Offset is where the valid data ended, and Bytes is how many bytes after it were lost.
+// For logfiles written in the old unversioned format the position of the corruption is unknown, and Offset and Bytes will be -1.
+type Loss struct {
+	Filename string
+	Offset   int64
+	Bytes    int64
+	Err      error
+}
+
+func (self Loss) String() string {
+	return fmt.Sprintf("%v: lost %v bytes after offset %v: %v", self.Filename, self.Bytes, self.Offset, self.Err)
+}
+
 type logfile struct {
 	timestamp time.Time
 	filename  string
 	suffix    string
 	file      *os.File
-	encoder   *gob.Encoder
+	compress  bool
+	dirty     bool
+	block     *bytes.Buffer
 	decoder   *gob.Decoder
 }
 
@@ -74,12 +98,81 @@ func parseLogfile(file string) (rval *logfile, err error) {
 	return
 }
 
-func (self *logfile) play(operate Operate) {
+// play will replay all valid records in this logfile using operate, and return a description of what was lost if the file is corrupt.
+// The loss starts at the first corrupt record, or at the start of its block if the block is compressed.
+// If repair, a corrupt file will be truncated at the start of the corrupt block, and the valid records of that block written back in a new block.
+func (self *logfile) play(operate Operate, repair bool) (loss *Loss) {
 	if self == nil {
 		return
 	}
 	self.read()
 	defer self.close()
+	version, ok := readHeader(self.file)
+	if !ok {
+		if _, err := self.file.Seek(0, 0); err != nil {
+			panic(err)
+		}
+		return self.playLegacy(operate)
+	}
+	offset := int64(len(logMagic) + 1)
+	if version != logVersion {
+		return self.lose(offset, fmt.Errorf("Unknown format version %v", version))
+	}
+	for {
+		ops, read, recovered, compressed, err := readBlock(self.file)
+		if err == io.EOF {
+			return
+		}
+		for _, op := range ops {
+			operate(op)
+		}
+		if err != nil {
+			lost := offset
+			if len(recovered) > 0 && !compressed {
+				lost += int64(9 + len(recovered))
+			}
+			loss = self.lose(lost, err)
+			if repair {
+				if err = self.truncate(offset, recovered, compressed); err != nil {
+					log.Printf("failed truncating %v: %v", self.filename, err)
+				}
+			}
+			return
+		}
+		offset += int64(read)
+	}
+}
+
+// truncate will cut this logfile at offset, and then append a block containing recovered, if it contains any records.
+func (self *logfile) truncate(offset int64, recovered []byte, compress bool) (err error) {
+	if err = os.Truncate(self.filename, offset); err != nil || len(recovered) == 0 {
+		return
+	}
+	var file *os.File
+	if file, err = os.OpenFile(self.filename, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = writeBlock(file, recovered, compress)
+	return
+}
+
+func (self *logfile) lose(offset int64, err error) *Loss {
+	fi, statErr := self.file.Stat()
+	if statErr != nil {
+		panic(statErr)
+	}
+	return &Loss{
+		Filename: self.filename,
+		Offset:   offset,
+		Bytes:    fi.Size() - offset,
+		Err:      err,
+	}
+}
+
+// playLegacy will replay a logfile written in the old unversioned gob format.
+func (self *logfile) playLegacy(operate Operate) (loss *Loss) {
+	self.decoder = gob.NewDecoder(self.file)
 	var err error
 	for {
 		var op Op
@@ -90,8 +183,14 @@ func (self *logfile) play(operate Operate) {
 		operate(op)
 	}
 	if err != io.EOF {
-		panic(err)
+		loss = &Loss{
+			Filename: self.filename,
+			Offset:   -1,
+			Bytes:    -1,
+			Err:      err,
+		}
 	}
+	return
 }
 
 func (self *logfile) read() *logfile {
@@ -100,7 +199,6 @@ func (self *logfile) read() *logfile {
 	if err != nil {
 		panic(err)
 	}
-	self.decoder = gob.NewDecoder(self.file)
 	return self
 }
 
@@ -110,10 +208,37 @@ func (self *logfile) write() *logfile {
 	if err != nil {
 		panic(err)
 	}
-	self.encoder = gob.NewEncoder(self.file)
+	if err = writeHeader(self.file); err != nil {
+		panic(err)
+	}
+	self.block = new(bytes.Buffer)
 	return self
 }
 
+// append will add op to the block waiting to be written to this logfile.
+func (self *logfile) append(op Op) {
+	appendRecord(self.block, op)
+}
+
+// flush will write the waiting block, if any, to this logfile.
+func (self *logfile) flush() (err error) {
+	if self.block.Len() > 0 {
+		_, err = writeBlock(self.file, self.block.Bytes(), self.compress)
+		self.block.Reset()
+		self.dirty = true
+	}
+	return
+}
+
+// sync will commit everything written to this logfile to stable storage, if anything was written since the last sync.
+func (self *logfile) sync() (err error) {
+	if self.dirty {
+		err = self.file.Sync()
+		self.dirty = false
+	}
+	return
+}
+
 func (self *logfile) close() {
 	self.file.Close()
 }
@@ -136,15 +261,20 @@ type Operate func(o Op)
 
 // Logger is something that can log or replay Ops.
 type Logger struct {
-	ops      chan Op
-	stops    chan chan bool
-	dir      string
-	state    int32
-	snapping int32
-	maxSize  int64
-	suffix   string
-	cond     *sync.Cond
-	lock     *sync.Mutex
+	ops           chan Op
+	syncs         chan chan bool
+	stops         chan chan bool
+	dir           string
+	state         int32
+	snapping      int32
+	maxSize       int64
+	compress      bool
+	fsync         int
+	fsyncInterval time.Duration
+	suffix        string
+	cond          *sync.Cond
+	lock          *sync.Mutex
+	stateLock     *sync.RWMutex
 }
 
 // NewLogger will return a Logger that will dump data into dir, or replay data from dir.
@@ -154,12 +284,14 @@ func NewLogger(dir string) *Logger {
 	}
 	lock := new(sync.Mutex)
 	return &Logger{
-		ops:    make(chan Op),
-		stops:  make(chan chan bool),
-		dir:    dir,
-		suffix: logSuffix,
-		lock:   lock,
-		cond:   sync.NewCond(lock),
+		ops:       make(chan Op),
+		syncs:     make(chan chan bool),
+		stops:     make(chan chan bool),
+		dir:       dir,
+		suffix:    logSuffix,
+		lock:      lock,
+		cond:      sync.NewCond(lock),
+		stateLock: new(sync.RWMutex),
 	}
 }
 
@@ -183,6 +315,31 @@ func (self *Logger) Limit(maxSize int64) *Logger {
 	return self
 }
 
+// Compress will make this Logger compress the blocks it writes, when that makes them smaller.
+// Logfiles are readable whether they are compressed or not, so it can be changed between restarts.
+func (self *Logger) Compress(compress bool) *Logger {
+	self.compress = compress
+	return self
+}
+
+// Fsync will decide when this Logger commits what it has written to stable storage.
+//
+// FsyncNever leaves it to the operating system, FsyncInterval will fsync every interval if anything was written,
+// and FsyncAlways will fsync after every block written.
+//
+// Independent of mode, Sync will wait for everything dumped before it to be written, and unless the mode is FsyncNever, to be fsynced.
+func (self *Logger) Fsync(mode int, interval time.Duration) *Logger {
+	self.fsync = mode
+	self.fsyncInterval = interval
+	return self
+}
+
+// Child will return a new Logger with the same settings as this Logger, logging to the subdirectory name of the directory of this Logger.
+// Logfiles are only looked for directly inside the directory of a Logger, so the two will not disturb each other.
+func (self *Logger) Child(name string) *Logger {
+	return NewLogger(filepath.Join(self.dir, name)).Limit(self.maxSize).Compress(self.compress).Fsync(self.fsync, self.fsyncInterval)
+}
+
 func (self *Logger) logfiles() (result logfiles) {
 	dir, err := os.Open(self.dir)
 	if err != nil {
@@ -228,19 +385,66 @@ func (self *Logger) Recording() bool {
 }
 
 // Play will replay the latest snapshot and all logfiles created after it using the provided operate.
-func (self *Logger) Play(operate Operate) {
+//
+// If a snapshot or logfile is corrupt, it will be replayed up to the first corrupt record and then truncated there, and Play will
+// continue with the next logfile. What was lost is logged and returned.
+func (self *Logger) Play(operate Operate) (losses []Loss) {
+	losses = self.play(operate, true)
+	for _, loss := range losses {
+		log.Printf("%v", loss)
+	}
+	return
+}
+
+// Verify will replay the latest snapshot and all logfiles created after it using the provided operate, just like Play, but
+// will not truncate or log corrupt files. What would be lost by Play is returned.
+func (self *Logger) Verify(operate Operate) (losses []Loss) {
+	return self.play(operate, false)
+}
+
+func (self *Logger) play(operate Operate, repair bool) (losses []Loss) {
 	if self.changeState(stopped, playing) {
 		defer self.changeState(playing, stopped)
 		snapshot, logs := self.latest()
-		snapshot.play(operate)
+		if loss := snapshot.play(operate, repair); loss != nil {
+			losses = append(losses, *loss)
+		}
 		for _, logf := range logs {
-			logf.play(operate)
+			if loss := logf.play(operate, repair); loss != nil {
+				losses = append(losses, *loss)
+			}
 		}
 	}
+	return
+}
+
+// Logfiles will return the names of the latest snapshot (or the empty string if there is none) and all logfiles created after it,
+// in the order Play would replay them.
+func (self *Logger) Logfiles() (snapshot string, logs []string) {
+	latestSnapshot, logfiles := self.latest()
+	if latestSnapshot != nil {
+		snapshot = latestSnapshot.filename
+	}
+	for _, logf := range logfiles {
+		logs = append(logs, logf.filename)
+	}
+	return
+}
+
+// Compact will merge the latest snapshot and all logfiles created after it into a new snapshot, and remove the old snapshots and logfiles.
+// Only the latest value of each key survives, and deleted keys disappear. It is meant for offline use, and will panic if this Logger is recording or playing.
+func (self *Logger) Compact() {
+	if !self.changeState(stopped, playing) {
+		panic(fmt.Errorf("%v unable to change state from stopped to playing", self))
+	}
+	defer self.changeState(playing, stopped)
+	self.compact(nil)
 }
 
 // Stop will stop this Logger. It will not return until all running recordings or snaphots are finished.
 func (self *Logger) Stop() *Logger {
+	self.stateLock.Lock()
+	defer self.stateLock.Unlock()
 	if self.hasState(recording) {
 		stop := make(chan bool)
 		self.stops <- stop
@@ -320,9 +524,9 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 			}
 		}
 	}
-	snap.play(operate)
+	snap.play(operate, false)
 	for _, logf := range files {
-		logf.play(operate)
+		logf.play(operate, false)
 	}
 	if latestConf != nil {
 		self.Dump(*latestConf)
@@ -340,13 +544,13 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 	}
 }
 
-func (self *Logger) snapshotAndDelete(oldrec *logfile, p chan *logfile, snapping *int32) {
-	defer atomic.StoreInt32(snapping, 0)
-	defer self.cond.Broadcast()
+func (self *Logger) compact(p chan *logfile) {
 	latestSnapshot, logfiles := self.latest()
-	snapshotter := NewLogger(self.dir).setSuffix(unfinishedSuffix)
+	snapshotter := NewLogger(self.dir).setSuffix(unfinishedSuffix).Compress(self.compress).Fsync(self.fsync, self.fsyncInterval)
 	snapshotfile := <-snapshotter.Record()
-	p <- snapshotfile
+	if p != nil {
+		p <- snapshotfile
+	}
 	snapshotter.snapshot(latestSnapshot, logfiles)
 	snapshotter.Stop()
 	if err := os.Rename(snapshotfile.filename, filepath.Join(self.dir, fmt.Sprintf("%v.%v", snapshotfile.timestamp.UnixNano(), snapSuffix))); err != nil {
@@ -355,18 +559,26 @@ func (self *Logger) snapshotAndDelete(oldrec *logfile, p chan *logfile, snapping
 	self.clearOlderThan(snapshotfile.timestamp)
 }
 
+func (self *Logger) snapshotAndDelete(oldrec *logfile, p chan *logfile, snapping *int32) {
+	defer atomic.StoreInt32(snapping, 0)
+	defer self.cond.Broadcast()
+	self.compact(p)
+}
+
 func (self *Logger) swap(fi *os.FileInfo, err *error, rec *logfile) *logfile {
 	if atomic.LoadInt32(&self.snapping) == 0 {
 		if *fi, *err = os.Stat(rec.filename); *err != nil {
 			panic(*err)
 		}
 		if (*fi).Size() > self.maxSize {
+			self.sync(rec)
 			rec.close()
 			started := make(chan *logfile)
 			atomic.StoreInt32(&self.snapping, 1)
 			go self.snapshotAndDelete(rec, started, &self.snapping)
 			<-started
 			rec = createLogfile(self.dir, self.suffix)
+			rec.compress = self.compress
 			rec.write()
 		}
 	}
@@ -375,6 +587,8 @@ func (self *Logger) swap(fi *os.FileInfo, err *error, rec *logfile) *logfile {
 
 // Record will make this Logger start recording.
 func (self *Logger) Record() (rval chan *logfile) {
+	self.stateLock.Lock()
+	defer self.stateLock.Unlock()
 	if !self.changeState(stopped, recording) {
 		panic(fmt.Errorf("%v unable to change state from stopped to recording", self))
 	}
@@ -383,16 +597,39 @@ func (self *Logger) Record() (rval chan *logfile) {
 	return
 }
 
+func (self *Logger) sync(rec *logfile) {
+	if err := rec.flush(); err != nil {
+		panic(err)
+	}
+	if self.fsync != FsyncNever {
+		if err := rec.sync(); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func (self *Logger) record(p chan *logfile) {
 	var err error
 	var op Op
 	var fi os.FileInfo
 	var stop chan bool
+	var done chan bool
+	var waiting []chan bool
+	var tick <-chan time.Time
 
 	rec := createLogfile(self.dir, self.suffix)
+	rec.compress = self.compress
 	rec.write()
 	p <- rec
-	defer rec.close()
+	defer func() {
+		rec.close()
+	}()
+
+	if self.fsync == FsyncInterval && self.fsyncInterval > 0 {
+		ticker := time.NewTicker(self.fsyncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 
 	for {
 		if self.maxSize != 0 {
@@ -401,10 +638,46 @@ func (self *Logger) record(p chan *logfile) {
 
 		select {
 		case op = <-self.ops:
-			if err = rec.encoder.Encode(op); err != nil {
+			rec.append(op)
+			for more := true; more && rec.block.Len() < maxBlockSize; {
+				select {
+				case op = <-self.ops:
+					rec.append(op)
+				default:
+					more = false
+				}
+			}
+			if err = rec.flush(); err != nil {
+				panic(err)
+			}
+			if self.fsync == FsyncAlways {
+				if err = rec.sync(); err != nil {
+					panic(err)
+				}
+			}
+		case done = <-self.syncs:
+			// Group commit: everyone waiting for a sync right now, and everything they dumped, shares one fsync.
+			waiting = append(waiting[:0], done)
+			for more := true; more && rec.block.Len() < maxBlockSize; {
+				select {
+				case op = <-self.ops:
+					rec.append(op)
+				case done = <-self.syncs:
+					waiting = append(waiting, done)
+				default:
+					more = false
+				}
+			}
+			self.sync(rec)
+			for _, done = range waiting {
+				done <- true
+			}
+		case <-tick:
+			if err = rec.sync(); err != nil {
 				panic(err)
 			}
 		case stop = <-self.stops:
+			self.sync(rec)
 			if !self.changeState(recording, stopped) {
 				panic(fmt.Errorf("%v unable to change state from recording to stopped", self))
 			}
@@ -413,6 +686,7 @@ func (self *Logger) record(p chan *logfile) {
 		}
 		select {
 		case stop = <-self.stops:
+			self.sync(rec)
 			if !self.changeState(recording, stopped) {
 				panic(fmt.Errorf("%v unable to change state from recording to stopped", self))
 			}
@@ -430,3 +704,17 @@ func (self *Logger) Dump(o Op) {
 	}
 	self.ops <- o
 }
+
+// Sync will not return until all Ops dumped before it are written to the logfile, and unless this Logger is in FsyncNever mode,
+// committed to stable storage. Concurrent calls share the same fsync.
+// If this Logger is not recording Sync will do nothing, since Stop has already written everything dumped before it.
+func (self *Logger) Sync() {
+	self.stateLock.RLock()
+	defer self.stateLock.RUnlock()
+	if !self.hasState(recording) {
+		return
+	}
+	done := make(chan bool, 1)
+	self.syncs <- done
+	<-done
+}
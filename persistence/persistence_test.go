@@ -1,11 +1,13 @@
 package persistence
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 type testmap struct {
@@ -83,6 +85,203 @@ func TestRecordPlay(t *testing.T) {
 	}
 }
 
+func TestCompressedRecordPlay(t *testing.T) {
+	os.RemoveAll("test4")
+	p := NewLogger("test4").Compress(true)
+	p.Record()
+	var ops []Op
+	for i := 0; i < 100; i++ {
+		op := Op{
+			Key:           []byte(fmt.Sprint(i)),
+			SubKey:        []byte{},
+			Value:         []byte("some value that will compress quite well since it is repeated over and over"),
+			Timestamp:     int64(i),
+			Expiry:        int64(-i),
+			Put:           true,
+			Configuration: map[string]string{"a": fmt.Sprint(i)},
+		}
+		ops = append(ops, op)
+		p.Dump(op)
+	}
+	p.Stop()
+	var ary []Op
+	if losses := p.Play(operator(&ary)); len(losses) != 0 {
+		t.Errorf("%v should be empty", losses)
+	}
+	if !reflect.DeepEqual(ary, ops) {
+		t.Errorf("%+v should be %+v", ary, ops)
+	}
+}
+
+func TestCorruptPlay(t *testing.T) {
+	os.RemoveAll("test5")
+	p := NewLogger("test5")
+	p.Record()
+	op := Op{
+		Key:       []byte("a"),
+		Value:     []byte("1"),
+		Timestamp: 1,
+		Put:       true,
+	}
+	p.Dump(op)
+	p.Stop()
+	logs := p.logfiles()
+	if len(logs) != 1 {
+		t.Fatalf("%v should contain one logfile", logs)
+	}
+	fi, err := os.Stat(logs[0].filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(logs[0].filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte{0, 0, 0, 5, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	var ary []Op
+	losses := p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, []Op{op}) {
+		t.Errorf("%+v should be %+v", ary, []Op{op})
+	}
+	if len(losses) != 1 || losses[0].Offset != fi.Size() || losses[0].Bytes != 7 {
+		t.Errorf("%v should contain one loss of 7 bytes at %v", losses, fi.Size())
+	}
+	ary = nil
+	if losses = p.Play(operator(&ary)); len(losses) != 0 {
+		t.Errorf("%v should be empty after truncation", losses)
+	}
+	if !reflect.DeepEqual(ary, []Op{op}) {
+		t.Errorf("%+v should be %+v", ary, []Op{op})
+	}
+}
+
+func TestCorruptRecordPlay(t *testing.T) {
+	os.RemoveAll("test8")
+	p := NewLogger("test8")
+	p.Record()
+	var ops []Op
+	for i := 0; i < 3; i++ {
+		op := Op{
+			Key:       []byte(fmt.Sprint(i)),
+			Value:     []byte("v"),
+			Timestamp: int64(i + 1),
+			Put:       true,
+		}
+		ops = append(ops, op)
+		p.Dump(op)
+	}
+	p.Stop()
+	logs := p.logfiles()
+	if len(logs) != 1 {
+		t.Fatalf("%v should contain one logfile", logs)
+	}
+	fi, err := os.Stat(logs[0].filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := new(bytes.Buffer)
+	appendRecord(last, ops[2])
+	f, err := os.OpenFile(logs[0].filename, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte{0xff}, fi.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	var ary []Op
+	losses := p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, ops[:2]) {
+		t.Errorf("%+v should be %+v", ary, ops[:2])
+	}
+	lost := int64(last.Len())
+	if len(losses) != 1 || losses[0].Offset != fi.Size()-lost || losses[0].Bytes != lost {
+		t.Errorf("%v should contain one loss of %v bytes at %v", losses, lost, fi.Size()-lost)
+	}
+	ary = nil
+	if losses = p.Play(operator(&ary)); len(losses) != 0 {
+		t.Errorf("%v should be empty after truncation", losses)
+	}
+	if !reflect.DeepEqual(ary, ops[:2]) {
+		t.Errorf("%+v should be %+v", ary, ops[:2])
+	}
+}
+
+func TestSync(t *testing.T) {
+	for _, mode := range []int{FsyncNever, FsyncInterval, FsyncAlways} {
+		os.RemoveAll("test6")
+		p := NewLogger("test6").Fsync(mode, time.Millisecond)
+		p.Record()
+		wait := new(sync.WaitGroup)
+		for i := 0; i < 10; i++ {
+			wait.Add(1)
+			go func(i int) {
+				defer wait.Done()
+				p.Dump(Op{
+					Key:       []byte(fmt.Sprint(i)),
+					Value:     []byte(fmt.Sprint(i)),
+					Timestamp: int64(i),
+					Put:       true,
+				})
+				p.Sync()
+			}(i)
+		}
+		wait.Wait()
+		var ary []Op
+		NewLogger("test6").Play(operator(&ary))
+		if len(ary) != 10 {
+			t.Errorf("with mode %v, %+v should contain 10 ops before stopping", mode, ary)
+		}
+		p.Stop()
+		p.Sync()
+	}
+}
+
+func TestCompact(t *testing.T) {
+	os.RemoveAll("test7")
+	p := NewLogger("test7")
+	p.Record()
+	kept := Op{
+		Key:       []byte("a"),
+		Value:     []byte("2"),
+		Timestamp: 2,
+		Put:       true,
+	}
+	p.Dump(Op{
+		Key:       []byte("a"),
+		Value:     []byte("1"),
+		Timestamp: 1,
+		Put:       true,
+	})
+	p.Dump(Op{
+		Key:       []byte("b"),
+		Value:     []byte("1"),
+		Timestamp: 1,
+		Put:       true,
+	})
+	p.Dump(kept)
+	p.Dump(Op{
+		Key:       []byte("b"),
+		Timestamp: 3,
+	})
+	p.Stop()
+	p.Compact()
+	snapshot, logs := p.Logfiles()
+	if snapshot == "" || len(logs) != 0 {
+		t.Errorf("%v, %v should be one snapshot and no logfiles", snapshot, logs)
+	}
+	var ary []Op
+	if losses := p.Verify(operator(&ary)); len(losses) != 0 {
+		t.Errorf("%v should be empty", losses)
+	}
+	if !reflect.DeepEqual(ary, []Op{kept}) {
+		t.Errorf("%+v should be %+v", ary, []Op{kept})
+	}
+}
+
 func TestSwap(t *testing.T) {
 	os.RemoveAll("test3")
 	tm := newTestmap()
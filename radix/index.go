@@ -0,0 +1,258 @@
+package radix
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	indexPrefix  = "index."
+	bytesIndex   = "bytes"
+	int64Index   = "int64"
+	float64Index = "float64"
+)
+
+// index is a named secondary index of a Tree, containing a Tree where the keys are derived from the values of the master Tree,
+// and the values are the keys of the master Tree.
+type index struct {
+	definition string
+	kind       string
+	from       int
+	to         int
+	tree       *Tree
+}
+
+func newIndex(definition string, timer Timer) (result *index, ok bool) {
+	parts := strings.Split(definition, ":")
+	result = &index{
+		definition: definition,
+		kind:       parts[0],
+		to:         -1,
+		tree:       NewTreeTimer(timer),
+	}
+	switch result.kind {
+	case bytesIndex:
+		if len(parts) == 1 {
+			return result, true
+		}
+		if len(parts) != 3 {
+			return nil, false
+		}
+		var err error
+		if result.from, err = strconv.Atoi(parts[1]); err != nil || result.from < 0 {
+			return nil, false
+		}
+		if parts[2] != "" {
+			if result.to, err = strconv.Atoi(parts[2]); err != nil || result.to < result.from {
+				return nil, false
+			}
+		}
+		return result, true
+	case int64Index, float64Index:
+		if len(parts) != 1 {
+			return nil, false
+		}
+		result.to = 8
+		return result, true
+	}
+	return nil, false
+}
+
+// sortable will return b, an index key as seen by the user, in a form that sorts in the order of the index.
+func (self *index) sortable(b []byte) []byte {
+	if len(b) != 8 {
+		return b
+	}
+	switch self.kind {
+	case int64Index:
+		result := append([]byte{}, b...)
+		result[0] ^= 0x80
+		return result
+	case float64Index:
+		result := append([]byte{}, b...)
+		if result[0]&0x80 != 0 {
+			for i := range result {
+				result[i] ^= 0xff
+			}
+		} else {
+			result[0] ^= 0x80
+		}
+		return result
+	}
+	return b
+}
+
+// unsortable will return b, an index key in sortable form, as seen by the user.
+func (self *index) unsortable(b []byte) []byte {
+	if len(b) != 8 {
+		return b
+	}
+	switch self.kind {
+	case int64Index:
+		result := append([]byte{}, b...)
+		result[0] ^= 0x80
+		return result
+	case float64Index:
+		result := append([]byte{}, b...)
+		if result[0]&0x80 != 0 {
+			result[0] ^= 0x80
+		} else {
+			for i := range result {
+				result[i] ^= 0xff
+			}
+		}
+		return result
+	}
+	return b
+}
+
+// extract will return the sortable index key of value, and whether value should be indexed at all.
+func (self *index) extract(value []byte) (result []byte, ok bool) {
+	if self.to == -1 {
+		if len(value) < self.from {
+			return
+		}
+		return value[self.from:], true
+	}
+	if len(value) < self.to {
+		return
+	}
+	return self.sortable(value[self.from:self.to]), true
+}
+func (self *index) put(key, value []byte, timestamp int64) {
+	if indexKey, ok := self.extract(value); ok {
+		self.tree.Put(mirrorKey(key, indexKey), key, timestamp)
+	}
+}
+func (self *index) fakeDel(key, value []byte, timestamp int64) {
+	if indexKey, ok := self.extract(value); ok {
+		self.tree.FakeDel(mirrorKey(key, indexKey), timestamp)
+	}
+}
+func (self *index) del(key, value []byte) {
+	if indexKey, ok := self.extract(value); ok {
+		self.tree.Del(mirrorKey(key, indexKey))
+	}
+}
+func (self *index) iterator(min, max []byte, mininc, maxinc bool, f TreeIterator) TreeIterator {
+	return newMirrorIterator(min, max, mininc, maxinc, func(key, value []byte, timestamp int64) bool {
+		return f(self.unsortable(key), value, timestamp)
+	})
+}
+
+// configureIndexes will make the indexes of this Tree match the 'index.NAME' entries of conf, building any new indexes from the current content.
+func (self *Tree) configureIndexes(conf map[string]string) {
+	indexes := make(map[string]*index)
+	for key, definition := range conf {
+		if strings.HasPrefix(key, indexPrefix) {
+			name := key[len(indexPrefix):]
+			if old, found := self.indexes[name]; found && old.definition == definition {
+				indexes[name] = old
+			} else if neu, ok := newIndex(definition, self.timer); ok {
+				self.root.each(nil, byteValue, func(key, byteValue []byte, treeValue *Tree, use int, timestamp int64) bool {
+					neu.put(key, byteValue, timestamp)
+					return true
+				})
+				indexes[name] = neu
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		indexes = nil
+	}
+	self.indexes = indexes
+}
+func (self *Tree) index(name string) *index {
+	if self == nil {
+		return nil
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.indexes[name]
+}
+
+// IndexEachBetween will iterate between min and max in the index named name using f.
+// f will see the index keys as keys, and the keys of this Tree as values.
+func (self *Tree) IndexEachBetween(name string, min, max []byte, mininc, maxinc bool, f TreeIterator) {
+	index := self.index(name)
+	if index == nil {
+		return
+	}
+	min, max = index.sortable(min), index.sortable(max)
+	if maxinc && max != nil {
+		maxinc = false
+		max = incrementBytes(max)
+	}
+	index.tree.EachBetween(min, max, mininc, maxinc, index.iterator(min, max, mininc, maxinc, f))
+}
+
+// IndexReverseEachBetween will iterate between min and max in the index named name, in reverse order, using f.
+// f will see the index keys as keys, and the keys of this Tree as values.
+func (self *Tree) IndexReverseEachBetween(name string, min, max []byte, mininc, maxinc bool, f TreeIterator) {
+	index := self.index(name)
+	if index == nil {
+		return
+	}
+	min, max = index.sortable(min), index.sortable(max)
+	if maxinc && max != nil {
+		maxinc = false
+		max = incrementBytes(max)
+	}
+	index.tree.ReverseEachBetween(min, max, mininc, maxinc, index.iterator(min, max, mininc, maxinc, f))
+}
+
+// IndexIndexOf will return the index of the first entry with key (or the index it would have if it existed) in the index named name.
+func (self *Tree) IndexIndexOf(name string, key []byte) (ind int, existed bool) {
+	index := self.index(name)
+	if index == nil {
+		return
+	}
+	key = index.sortable(key)
+	ind = index.tree.Size()
+	index.tree.EachBetween(key, nil, true, false, func(k, v []byte, ts int64) bool {
+		existed = bytes.Compare(key, k[:len(k)-len(escapeBytes(v))-1]) == 0
+		ind, _ = index.tree.IndexOf(k)
+		return false
+	})
+	return
+}
+
+// IndexSizeBetween returns the number of entries between min and max in the index named name.
+func (self *Tree) IndexSizeBetween(name string, min, max []byte, mininc, maxinc bool) (result int) {
+	self.IndexEachBetween(name, min, max, mininc, maxinc, func(key, value []byte, timestamp int64) bool {
+		result++
+		return true
+	})
+	return
+}
+func (self *Tree) SubIndexEachBetween(key []byte, name string, min, max []byte, mininc, maxinc bool, f TreeIterator) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		subTree.IndexEachBetween(name, min, max, mininc, maxinc, f)
+	}
+}
+func (self *Tree) SubIndexReverseEachBetween(key []byte, name string, min, max []byte, mininc, maxinc bool, f TreeIterator) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		subTree.IndexReverseEachBetween(name, min, max, mininc, maxinc, f)
+	}
+}
+func (self *Tree) SubIndexIndexOf(key []byte, name string, indexKey []byte) (ind int, existed bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		ind, existed = subTree.IndexIndexOf(name, indexKey)
+	}
+	return
+}
+func (self *Tree) SubIndexSizeBetween(key []byte, name string, min, max []byte, mininc, maxinc bool) (result int) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		result = subTree.IndexSizeBetween(name, min, max, mininc, maxinc)
+	}
+	return
+}
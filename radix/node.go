@@ -2,6 +2,7 @@ package radix
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"github.com/zond/god/murmur"
@@ -31,18 +32,20 @@ type nodeIterator func(key, byteValue []byte, treeValue *Tree, use int, timestam
 // node.use != 0 && node.empty => node is invalid?
 // node.empty && node.timestamp == 0 => node is invalid?
 type node struct {
-	segment   []Nibble // the bit of the key for this node that separates it from its parent
-	byteValue []byte
-	byteHash  []byte // cached hash of the byteValue
-	treeValue *Tree
-	timestamp int64  // only used in regard to byteValues. treeValues ignore them (since they have their own timestamps inside them). a timestamp of 0 will be considered REALLY empty
-	hash      []byte // cached hash of the entire node
-	children  []*node
-	empty     bool // this node only serves a structural purpose (ie remove it if it is no longer useful for that)
-	use       int  // the values in this node that are to be considered 'present'. even if this is a zero, do not remove the node if empty is false - it is still a tombstone.
-	treeSize  int  // size of the tree in this node and those of all of its children
-	byteSize  int  // number of byte values in this node and all of its children
-	realSize  int  // number of actual values, including tombstones
+	segment    []Nibble // the bit of the key for this node that separates it from its parent
+	byteValue  []byte
+	byteHash   []byte // cached hash of the byteValue
+	treeValue  *Tree
+	timestamp  int64  // only used in regard to byteValues. treeValues ignore them (since they have their own timestamps inside them). a timestamp of 0 will be considered REALLY empty
+	hash       []byte // cached hash of the entire node
+	children   []*node
+	empty      bool  // this node only serves a structural purpose (ie remove it if it is no longer useful for that)
+	use        int   // the values in this node that are to be considered 'present'. even if this is a zero, do not remove the node if empty is false - it is still a tombstone.
+	treeSize   int   // size of the tree in this node and those of all of its children
+	byteSize   int   // number of byte values in this node and all of its children
+	realSize   int   // number of actual values, including tombstones
+	expiry     int64 // the time, according to the Timer of the Tree, when the byteValue of this node expires. 0 means never
+	nextExpiry int64 // the earliest expiry of this node and all of its children, including those of the treeValue. 0 means never
 }
 
 func newNode(segment []Nibble, byteValue []byte, treeValue *Tree, timestamp int64, empty bool, use int) *node {
@@ -59,6 +62,36 @@ func newNode(segment []Nibble, byteValue []byte, treeValue *Tree, timestamp int6
 	}
 }
 
+// newExpiringNode returns a node containing a byte value that will expire at expiry.
+func newExpiringNode(segment []Nibble, byteValue []byte, timestamp, expiry int64, use int) (result *node) {
+	result = newNode(segment, byteValue, nil, timestamp, false, use)
+	result.expiry = expiry
+	result.byteHash = expiringHash(byteValue, expiry)
+	return
+}
+
+// expiringHash returns the hash of byteValue combined with expiry, to make sure that Sync notices when
+// two otherwise equal values expire at different times.
+func expiringHash(byteValue []byte, expiry int64) []byte {
+	if expiry == 0 {
+		return murmur.HashBytes(byteValue)
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(byteValue)
+	if err := binary.Write(buf, binary.BigEndian, expiry); err != nil {
+		panic(err)
+	}
+	return murmur.HashBytes(buf.Bytes())
+}
+
+// minExpiry returns the earliest of a and b, ignoring zeroes.
+func minExpiry(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
 // setSegment copies the given part to be our segment.
 func (self *node) setSegment(part []Nibble) {
 	new_segment := make([]Nibble, len(part))
@@ -77,7 +110,14 @@ func (self *node) rehash(key []Nibble, now int64) {
 	self.treeSize = 0
 	self.byteSize = 0
 	self.realSize = 0
+	self.nextExpiry = 0
 	self.realSize += self.treeValue.RealSize()
+	if self.use&byteValue != 0 {
+		self.nextExpiry = self.expiry
+	}
+	if self.use&treeValue != 0 {
+		self.nextExpiry = minExpiry(self.nextExpiry, self.treeValue.nextExpiry())
+	}
 	if self.timestamp != 0 {
 		self.realSize++
 	}
@@ -100,6 +140,7 @@ func (self *node) rehash(key []Nibble, now int64) {
 			self.treeSize += child.treeSize
 			self.byteSize += child.byteSize
 			self.realSize += child.realSize
+			self.nextExpiry = minExpiry(self.nextExpiry, child.nextExpiry)
 			h.Write(child.hash)
 		}
 	}
@@ -255,6 +296,14 @@ func (self *node) indexOf(count int, segment []Nibble, use int, up bool) (index
 
 // get will return values for the given key, if it exists
 func (self *node) get(segment []Nibble) (byteValue []byte, treeValue *Tree, timestamp int64, existed int) {
+	if found := self.find(segment); found != nil {
+		byteValue, treeValue, timestamp, existed = found.byteValue, found.treeValue, found.timestamp, found.use
+	}
+	return
+}
+
+// find will return the node for the given key, if it exists
+func (self *node) find(segment []Nibble) (result *node) {
 	if self == nil {
 		return
 	}
@@ -264,12 +313,12 @@ func (self *node) get(segment []Nibble) (byteValue []byte, treeValue *Tree, time
 		beyond_self = i >= len(self.segment)
 		beyond_segment = i >= len(segment)
 		if beyond_self && beyond_segment {
-			byteValue, treeValue, timestamp, existed = self.byteValue, self.treeValue, self.timestamp, self.use
+			result = self
 			return
 		} else if beyond_segment {
 			return
 		} else if beyond_self {
-			byteValue, treeValue, timestamp, existed = self.children[segment[i]].get(segment[i:])
+			result = self.children[segment[i]].find(segment[i:])
 			return
 		} else if segment[i] != self.segment[i] {
 			return
@@ -278,6 +327,26 @@ func (self *node) get(segment []Nibble) (byteValue []byte, treeValue *Tree, time
 	panic("Shouldn't happen")
 }
 
+// expired returns whether the byte value of this node has expired at now.
+func (self *node) expired(now int64) bool {
+	return self.expiry != 0 && self.expiry <= now
+}
+
+// eachExpired will call f with the key and node of each node in this tree that contains a byte value or a tree value
+// with something that has expired at now.
+func (self *node) eachExpired(prefix []Nibble, now int64, f func(key []Nibble, n *node)) {
+	if self == nil || self.nextExpiry == 0 || self.nextExpiry > now {
+		return
+	}
+	prefix = append(prefix, self.segment...)
+	if !self.empty {
+		f(append([]Nibble{}, prefix...), self)
+	}
+	for _, child := range self.children {
+		child.eachExpired(prefix, now, f)
+	}
+}
+
 // del will return this node or a child replacement after removing the value type defined by use (byteValue and/or treeValue).
 func (self *node) del(prefix, segment []Nibble, use int, now int64) (result *node, oldBytes []byte, oldTree *Tree, timestamp int64, existed int) {
 	if self == nil {
@@ -293,7 +362,7 @@ func (self *node) del(prefix, segment []Nibble, use int, now int64) (result *nod
 				if self.use&use&byteValue != 0 {
 					oldBytes = self.byteValue
 					existed |= byteValue
-					self.byteValue, self.byteHash, self.use = nil, murmur.HashBytes(nil), self.use&^byteValue
+					self.byteValue, self.byteHash, self.expiry, self.use = nil, murmur.HashBytes(nil), 0, self.use&^byteValue
 				}
 				if self.use&use&treeValue != 0 {
 					oldTree = self.treeValue
@@ -313,7 +382,7 @@ func (self *node) del(prefix, segment []Nibble, use int, now int64) (result *nod
 				}
 				if n_children > 1 || self.segment == nil {
 					result, oldBytes, oldTree, timestamp, existed = self, self.byteValue, self.treeValue, self.timestamp, self.use
-					self.byteValue, self.byteHash, self.treeValue, self.empty, self.use, self.timestamp = nil, murmur.HashBytes(nil), nil, true, 0, 0
+					self.byteValue, self.byteHash, self.expiry, self.treeValue, self.empty, self.use, self.timestamp = nil, murmur.HashBytes(nil), 0, nil, true, 0, 0
 					self.rehash(append(prefix, segment...), now)
 				} else if n_children == 1 {
 					a_child.setSegment(append(self.segment, a_child.segment...))
@@ -380,7 +449,7 @@ func (self *node) insertHelp(prefix []Nibble, n *node, use int, now int64) (resu
 		if beyond_n && beyond_self {
 			result, oldBytes, oldTree, timestamp, existed = self, self.byteValue, self.treeValue, self.timestamp, self.use
 			if use&byteValue != 0 {
-				self.byteValue, self.byteHash = n.byteValue, n.byteHash
+				self.byteValue, self.byteHash, self.expiry = n.byteValue, n.byteHash, n.expiry
 				if n.use&byteValue == 0 {
 					self.use &^= byteValue
 				} else {
@@ -160,8 +160,10 @@ func (self *node) sizeBetween(prefix, min, max []Nibble, mincmp, maxcmp, use int
 func (self *node) eachBetweenIndex(prefix []Nibble, count int, min, max *int, use int, f nodeIndexIterator) (cont bool) {
 	cont = true
 	prefix = append(prefix, self.segment...)
-	if !self.empty && (use == 0 || self.use&use != 0) && (min == nil || count >= *min) && (max == nil || count <= *max) {
-		cont = f(Stitch(prefix), self.byteValue, self.treeValue, self.use, self.timestamp, count)
+	if !self.empty && (use == 0 || self.use&use != 0) {
+		if (min == nil || count >= *min) && (max == nil || count <= *max) {
+			cont = f(Stitch(prefix), self.byteValue, self.treeValue, self.use, self.timestamp, count)
+		}
 		if use == 0 || self.use&use&byteValue != 0 {
 			count++
 		}
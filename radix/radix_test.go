@@ -25,6 +25,281 @@ func init() {
 	benchmarkTestTree.logger.Clear()
 }
 
+type testTimer int64
+
+func (self *testTimer) ContinuousTime() int64 {
+	return int64(*self)
+}
+
+func TestTreeExpiry(t *testing.T) {
+	timer := testTimer(10)
+	tree := NewTreeTimer(&timer)
+	tree.PutExpiry([]byte("a"), []byte("a"), 1, 20)
+	tree.Put([]byte("b"), []byte("b"), 1)
+	if value, _, existed := tree.Get([]byte("a")); !existed || bytes.Compare(value, []byte("a")) != 0 {
+		t.Errorf("%v should contain a => a", tree.Describe())
+	}
+	if expired := tree.Expire(); expired != 0 {
+		t.Errorf("%v should not have expired anything, but expired %v", tree.Describe(), expired)
+	}
+	timer = 20
+	if _, _, existed := tree.Get([]byte("a")); existed {
+		t.Errorf("%v should not consider a existing after its expiry", tree.Describe())
+	}
+	if expired := tree.Expire(); expired != 1 {
+		t.Errorf("%v should have expired 1 value, but expired %v", tree.Describe(), expired)
+	}
+	if _, timestamp, existed := tree.Get([]byte("a")); existed || timestamp != 2 {
+		t.Errorf("%v should contain a tombstone with timestamp 2 at a", tree.Describe())
+	}
+	if value, _, existed := tree.Get([]byte("b")); !existed || bytes.Compare(value, []byte("b")) != 0 {
+		t.Errorf("%v should contain b => b", tree.Describe())
+	}
+	if tree.Size() != 1 {
+		t.Errorf("%v should have size 1", tree.Describe())
+	}
+	tree.PutExpiry([]byte("a"), []byte("a"), 3, 30)
+	tree.Put([]byte("a"), []byte("a"), 4)
+	timer = 40
+	if expired := tree.Expire(); expired != 0 {
+		t.Errorf("%v should not have expired anything, but expired %v", tree.Describe(), expired)
+	}
+	if _, _, existed := tree.Get([]byte("a")); !existed {
+		t.Errorf("%v should contain a, since it was overwritten without expiry", tree.Describe())
+	}
+}
+
+func TestSubTreeExpiry(t *testing.T) {
+	timer := testTimer(10)
+	tree := NewTreeTimer(&timer)
+	tree.AddConfiguration(1, mirrored, yes)
+	tree.SubAddConfiguration([]byte("a"), 1, mirrored, yes)
+	tree.SubPutExpiry([]byte("a"), []byte("b"), []byte("c"), 1, 20)
+	tree.SubPut([]byte("a"), []byte("d"), []byte("e"), 1)
+	if tree.SubSize([]byte("a")) != 2 {
+		t.Errorf("%v should have a sub tree of size 2", tree.Describe())
+	}
+	timer = 20
+	if _, _, existed := tree.SubGet([]byte("a"), []byte("b")); existed {
+		t.Errorf("%v should not consider a/b existing after its expiry", tree.Describe())
+	}
+	if expired := tree.Expire(); expired != 1 {
+		t.Errorf("%v should have expired 1 value, but expired %v", tree.Describe(), expired)
+	}
+	if tree.SubSize([]byte("a")) != 1 {
+		t.Errorf("%v should have a sub tree of size 1", tree.Describe())
+	}
+	if size := tree.SubMirrorSizeBetween([]byte("a"), nil, nil, true, false); size != 1 {
+		t.Errorf("%v should have a sub tree mirror of size 1, not %v", tree.Describe(), size)
+	}
+}
+
+func TestUpdateExpiry(t *testing.T) {
+	timer := testTimer(10)
+	tree := NewTreeTimer(&timer)
+	tree.PutExpiry([]byte("a"), []byte("a"), 1, 20)
+	tree.SubPutExpiry([]byte("b"), []byte("c"), []byte("d"), 1, 20)
+	if _, _, _, swapped := tree.PutIf([]byte("a"), []byte("b"), 2, func(value []byte, timestamp int64, existed bool) bool { return existed }); !swapped {
+		t.Errorf("%v should have swapped a", tree.Describe())
+	}
+	if _, _, _, updated := tree.SubUpdate([]byte("b"), []byte("c"), 2, func(value []byte, timestamp int64, existed bool) ([]byte, bool) { return []byte("e"), existed }); !updated {
+		t.Errorf("%v should have updated b/c", tree.Describe())
+	}
+	if _, _, expiry, _, _ := tree.UpdateExpiry([]byte("a"), 3, func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool) {
+		return value, expiry, false
+	}); expiry != 20 {
+		t.Errorf("%v should have kept the expiry of a, but it is %v", tree.Describe(), expiry)
+	}
+	timer = 20
+	if _, _, existed := tree.Get([]byte("a")); existed {
+		t.Errorf("%v should not consider a existing after its expiry", tree.Describe())
+	}
+	if _, _, existed := tree.SubGet([]byte("b"), []byte("c")); existed {
+		t.Errorf("%v should not consider b/c existing after its expiry", tree.Describe())
+	}
+	if _, _, expiry, _, _ := tree.UpdateExpiry([]byte("a"), 3, func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool) {
+		return []byte("c"), expiry, true
+	}); expiry != 0 {
+		t.Errorf("%v should not keep the expiry of an expired value, but it is %v", tree.Describe(), expiry)
+	}
+	timer = 30
+	if _, _, existed := tree.Get([]byte("a")); !existed {
+		t.Errorf("%v should contain a, since it was updated after its expiry", tree.Describe())
+	}
+}
+
+func TestSyncExpiry(t *testing.T) {
+	timer := testTimer(10)
+	tree1 := NewTreeTimer(&timer)
+	tree2 := NewTreeTimer(&timer)
+	tree1.PutExpiry([]byte("a"), []byte("a"), 1, 20)
+	tree1.SubPutExpiry([]byte("b"), []byte("c"), []byte("d"), 1, 20)
+	tree2.Put([]byte("a"), []byte("a"), 1)
+	tree2.SubPut([]byte("b"), []byte("c"), []byte("d"), 1)
+	if bytes.Compare(tree1.Hash(), tree2.Hash()) == 0 {
+		t.Errorf("%v and %v should have different hashes since they expire differently", tree1.Describe(), tree2.Describe())
+	}
+	NewSync(tree1, tree2).Run()
+	if bytes.Compare(tree1.Hash(), tree2.Hash()) != 0 {
+		t.Errorf("%v and %v should have equal hashes after sync", tree1.Describe(), tree2.Describe())
+	}
+	timer = 20
+	if expired := tree2.Expire(); expired != 2 {
+		t.Errorf("%v should have expired 2 values, but expired %v", tree2.Describe(), expired)
+	}
+	tree1.Expire()
+	if !tree1.deepEqual(tree2) {
+		t.Errorf("%v and %v should be equal after both expired", tree1.Describe(), tree2.Describe())
+	}
+}
+
+func TestTreePutIf(t *testing.T) {
+	tree := NewTree()
+	cas := common.CAS{
+		Expected:       []byte("a"),
+		ExpectedExists: true,
+	}
+	if _, _, existed, swapped := tree.PutIf([]byte("k"), []byte("b"), 1, cas.Matches); existed || swapped {
+		t.Errorf("%v should not swap a missing value when expecting a", tree.Describe())
+	}
+	if value, timestamp, existed, swapped := tree.PutIf([]byte("k"), []byte("a"), 1, common.CAS{}.Matches); !existed || !swapped || bytes.Compare(value, []byte("a")) != 0 || timestamp != 1 {
+		t.Errorf("%v should swap a missing value when expecting nothing", tree.Describe())
+	}
+	if value, _, existed, swapped := tree.PutIf([]byte("k"), []byte("c"), 2, common.CAS{}.Matches); !existed || swapped || bytes.Compare(value, []byte("a")) != 0 {
+		t.Errorf("%v should not swap an existing value when expecting nothing", tree.Describe())
+	}
+	if _, _, _, swapped := tree.PutIf([]byte("k"), []byte("b"), 2, cas.Matches); !swapped {
+		t.Errorf("%v should swap a when expecting a", tree.Describe())
+	}
+	if value, timestamp, _, swapped := tree.PutIf([]byte("k"), []byte("c"), 3, common.CAS{ExpectedTimestamp: 1}.Matches); swapped || bytes.Compare(value, []byte("b")) != 0 || timestamp != 2 {
+		t.Errorf("%v should not swap when expecting timestamp 1", tree.Describe())
+	}
+	if _, _, _, swapped := tree.PutIf([]byte("k"), []byte("c"), 3, common.CAS{ExpectedTimestamp: 2}.Matches); !swapped {
+		t.Errorf("%v should swap when expecting timestamp 2", tree.Describe())
+	}
+	if value, _, _ := tree.Get([]byte("k")); bytes.Compare(value, []byte("c")) != 0 {
+		t.Errorf("%v should contain k => c", tree.Describe())
+	}
+}
+
+func TestTreeSubPutIf(t *testing.T) {
+	tree := NewTree()
+	cas := common.CAS{
+		Expected:       []byte("a"),
+		ExpectedExists: true,
+	}
+	if _, _, _, swapped := tree.SubPutIf([]byte("k"), []byte("s"), []byte("b"), 1, cas.Matches); swapped {
+		t.Errorf("%v should not swap in a missing sub tree when expecting a", tree.Describe())
+	}
+	if tree.SubSize([]byte("k")) != 0 {
+		t.Errorf("%v should not have created a sub tree", tree.Describe())
+	}
+	if _, _, _, swapped := tree.SubPutIf([]byte("k"), []byte("s"), []byte("a"), 1, common.CAS{}.Matches); !swapped {
+		t.Errorf("%v should swap in a missing sub tree when expecting nothing", tree.Describe())
+	}
+	if _, _, _, swapped := tree.SubPutIf([]byte("k"), []byte("s"), []byte("b"), 2, cas.Matches); !swapped {
+		t.Errorf("%v should swap a when expecting a", tree.Describe())
+	}
+	if value, _, _, swapped := tree.SubPutIf([]byte("k"), []byte("s"), []byte("c"), 3, cas.Matches); swapped || bytes.Compare(value, []byte("b")) != 0 {
+		t.Errorf("%v should not swap b when expecting a", tree.Describe())
+	}
+	if value, _, _ := tree.SubGet([]byte("k"), []byte("s")); bytes.Compare(value, []byte("b")) != 0 {
+		t.Errorf("%v should contain k/s => b", tree.Describe())
+	}
+}
+
+func TestTreeUpdate(t *testing.T) {
+	tree := NewTree()
+	incr := common.Incr{Delta: 2}
+	add := func(value []byte, timestamp int64, existed bool) (result []byte, ok bool) {
+		result, err := incr.Apply(value, existed)
+		return result, err == nil
+	}
+	tree.Update([]byte("a"), 1, add)
+	tree.SubUpdate([]byte("b"), []byte("c"), 1, add)
+	tree.Update([]byte("a"), 2, add)
+	tree.SubUpdate([]byte("b"), []byte("c"), 2, add)
+	if value, _, _ := tree.Get([]byte("a")); common.MustDecodeInt64(value) != 4 {
+		t.Errorf("%v should contain a => 4", tree.Describe())
+	}
+	if value, _, _ := tree.SubGet([]byte("b"), []byte("c")); common.MustDecodeInt64(value) != 4 {
+		t.Errorf("%v should contain b/c => 4", tree.Describe())
+	}
+	tree.Put([]byte("a"), []byte("x"), 3)
+	if _, _, _, updated := tree.Update([]byte("a"), 4, add); updated {
+		t.Errorf("%v should not update a non number", tree.Describe())
+	}
+}
+
+func TestTreeExportBetween(t *testing.T) {
+	tree := NewTree()
+	tree.Put([]byte("a"), []byte("1"), 1)
+	tree.PutExpiry([]byte("b"), []byte("2"), 2, 1<<62)
+	tree.SubPut([]byte("b"), []byte("c"), []byte("3"), 3)
+	tree.SubPut([]byte("d"), []byte("e"), []byte("4"), 4)
+	tree.Put([]byte("f"), []byte("5"), 5)
+	tree.Del([]byte("f"))
+	tree.FakeDel([]byte("a"), 6)
+	var found []string
+	tree.ExportBetween([]byte("a"), []byte("d"), true, true, func(key, byteValue []byte, byteExists bool, timestamp, expiry int64, subTree *Tree) bool {
+		found = append(found, fmt.Sprintf("%s:%s:%v:%v:%v", key, byteValue, byteExists, expiry, subTree.Size()))
+		return true
+	})
+	if expected := []string{fmt.Sprintf("b:2:true:%v:1", int64(1<<62)), "d::false:0:1"}; !reflect.DeepEqual(found, expected) {
+		t.Errorf("wanted %v but got %v", expected, found)
+	}
+}
+
+func TestTreeIndexes(t *testing.T) {
+	tree := NewTree()
+	tree.SubPut([]byte("a"), []byte("x"), append(common.EncodeInt64(3), "c"...), 1)
+	tree.SubPut([]byte("a"), []byte("y"), append(common.EncodeInt64(-5), "a"...), 1)
+	tree.SubAddConfiguration([]byte("a"), 1, "index.num", "int64")
+	tree.SubAddConfiguration([]byte("a"), 1, "index.tail", "bytes:8:")
+	tree.SubAddConfiguration([]byte("a"), 1, "index.broken", "bytes:x")
+	tree.SubPut([]byte("a"), []byte("z"), append(common.EncodeInt64(1), "b"...), 1)
+	tree.SubPut([]byte("a"), []byte("short"), []byte("s"), 1)
+	var keys []string
+	tree.SubIndexEachBetween([]byte("a"), "num", nil, nil, true, true, func(key, value []byte, timestamp int64) bool {
+		keys = append(keys, fmt.Sprintf("%v=%s", common.MustDecodeInt64(key), value))
+		return true
+	})
+	if exp := []string{"-5=y", "1=z", "3=x"}; !reflect.DeepEqual(keys, exp) {
+		t.Errorf("%v should be %v", keys, exp)
+	}
+	keys = nil
+	tree.SubIndexReverseEachBetween([]byte("a"), "tail", []byte("a"), []byte("b"), false, true, func(key, value []byte, timestamp int64) bool {
+		keys = append(keys, fmt.Sprintf("%s=%s", key, value))
+		return true
+	})
+	if exp := []string{"b=z"}; !reflect.DeepEqual(keys, exp) {
+		t.Errorf("%v should be %v", keys, exp)
+	}
+	if ind, existed := tree.SubIndexIndexOf([]byte("a"), "num", common.EncodeInt64(1)); ind != 1 || !existed {
+		t.Errorf("%v should have 1 at index 1, not %v, %v", tree.Describe(), ind, existed)
+	}
+	if ind, existed := tree.SubIndexIndexOf([]byte("a"), "num", common.EncodeInt64(2)); ind != 2 || existed {
+		t.Errorf("%v should have 2 at index 2 if it existed, not %v, %v", tree.Describe(), ind, existed)
+	}
+	tree.SubFakeDel([]byte("a"), []byte("z"), 2)
+	tree.SubPut([]byte("a"), []byte("x"), append(common.EncodeInt64(-10), "d"...), 2)
+	keys = nil
+	tree.SubIndexEachBetween([]byte("a"), "num", nil, nil, true, true, func(key, value []byte, timestamp int64) bool {
+		keys = append(keys, fmt.Sprintf("%v=%s", common.MustDecodeInt64(key), value))
+		return true
+	})
+	if exp := []string{"-10=x", "-5=y"}; !reflect.DeepEqual(keys, exp) {
+		t.Errorf("%v should be %v", keys, exp)
+	}
+	if size := tree.SubIndexSizeBetween([]byte("a"), "broken", nil, nil, true, true); size != 0 {
+		t.Errorf("%v should not have a broken index, but it has %v entries", tree.Describe(), size)
+	}
+	tree.SubAddConfiguration([]byte("a"), 3, "index.num", "")
+	if size := tree.SubIndexSizeBetween([]byte("a"), "num", nil, nil, true, true); size != 0 {
+		t.Errorf("%v should not have a num index after removing it, but it has %v entries", tree.Describe(), size)
+	}
+}
+
 func TestSyncVersions(t *testing.T) {
 	tree1 := NewTree()
 	tree3 := NewTree()
@@ -190,6 +465,65 @@ func TestTreeSizeBetween(t *testing.T) {
 	}
 }
 
+func TestTreeMirrorLeadingZeros(t *testing.T) {
+	tree := NewTree()
+	tree.AddConfiguration(1, mirrored, yes)
+	for i := byte(1); i < 6; i++ {
+		tree.Put([]byte{i}, []byte{0, i * 10}, 1)
+	}
+	var found []byte
+	tree.MirrorEachBetween([]byte{0, 20}, []byte{0, 40}, true, true, func(key, value []byte, version int64) bool {
+		found = append(found, value...)
+		return true
+	})
+	if expected := []byte{2, 3, 4}; bytes.Compare(found, expected) != 0 {
+		t.Errorf("%v.MirrorEachBetween([0 20], [0 40], true, true) should give %v but gave %v", tree.Describe(), expected, found)
+	}
+	if size := tree.MirrorSizeBetween([]byte{0, 20}, []byte{0, 40}, true, true); size != 3 {
+		t.Errorf("%v.MirrorSizeBetween([0 20], [0 40], true, true) should be 3 but was %v", tree.Describe(), size)
+	}
+}
+
+func TestTreePrefix(t *testing.T) {
+	tree := NewTree()
+	for _, key := range [][]byte{[]byte("a"), []byte("ab"), []byte("abc"), []byte("abd"), []byte("ac"), []byte("b"), []byte{'a', 0xff}, []byte{'a', 0xff, 0xff}, []byte{'b', 0}} {
+		tree.Put(key, key, 1)
+		tree.SubPut([]byte("sub"), key, key, 1)
+	}
+	tree.SubPut([]byte("ab"), []byte("x"), []byte("x"), 1)
+	var found [][]byte
+	tree.PrefixEach([]byte("ab"), func(key, value []byte, version int64) bool {
+		found = append(found, key)
+		return true
+	})
+	if expected := [][]byte{[]byte("ab"), []byte("abc"), []byte("abd")}; !reflect.DeepEqual(found, expected) {
+		t.Errorf("%v.PrefixEach(ab) should give %v but gave %v", tree.Describe(), expected, found)
+	}
+	for prefix, expected := range map[string]int{"": 9, "a": 7, "ab": 3, "abc": 1, "abe": 0, "a\xff": 2, "b": 2, "c": 0} {
+		if count := tree.PrefixCount([]byte(prefix)); count != expected {
+			t.Errorf("%v.PrefixCount(%v) should be %v but was %v", tree.Describe(), common.HexEncode([]byte(prefix)), expected, count)
+		}
+		if count := tree.SubPrefixCount([]byte("sub"), []byte(prefix)); count != expected {
+			t.Errorf("%v.SubPrefixCount(sub, %v) should be %v but was %v", tree.Describe(), common.HexEncode([]byte(prefix)), expected, count)
+		}
+	}
+	if deleted := tree.PrefixDelete([]byte("ab"), 2); len(deleted) != 3 {
+		t.Errorf("%v.PrefixDelete(ab) should delete 3 but deleted %v", tree.Describe(), deleted)
+	}
+	if count := tree.PrefixCount([]byte("a")); count != 4 {
+		t.Errorf("%v.PrefixCount(a) should be 4 after PrefixDelete but was %v", tree.Describe(), count)
+	}
+	if _, _, ex := tree.SubGet([]byte("ab"), []byte("x")); !ex {
+		t.Errorf("%v.PrefixDelete(ab) should not remove the sub tree at ab", tree.Describe())
+	}
+	if deleted := tree.SubPrefixDelete([]byte("sub"), []byte("a\xff"), 2); len(deleted) != 2 {
+		t.Errorf("%v.SubPrefixDelete(sub, a\\xff) should delete 2 but deleted %v", tree.Describe(), deleted)
+	}
+	if count := tree.SubPrefixCount([]byte("sub"), nil); count != 7 {
+		t.Errorf("%v.SubPrefixCount(sub, nil) should be 7 after SubPrefixDelete but was %v", tree.Describe(), count)
+	}
+}
+
 func TestSubTree(t *testing.T) {
 	tree := NewTree()
 	assertSize(t, tree, 0)
@@ -287,6 +621,40 @@ func TestSyncSubTree(t *testing.T) {
 	}
 }
 
+func TestSyncFilter(t *testing.T) {
+	tree1 := NewTree()
+	tree1.Put([]byte("a"), []byte("a"), 1)
+	tree1.Put([]byte("b"), []byte("b"), 1)
+	tree1.SubPut([]byte("c"), []byte("c"), []byte("c"), 1)
+	tree1.SubPut([]byte("d"), []byte("d"), []byte("d"), 1)
+	tree2 := NewTree()
+	NewSync(tree1, tree2).Filter(func(key []byte, subTree bool) bool {
+		return string(key) == "a" || (subTree && string(key) == "c")
+	}).Run()
+	if value, _, existed := tree2.Get([]byte("a")); !existed || string(value) != "a" {
+		t.Errorf("%v should contain a", tree2.Describe())
+	}
+	if _, _, existed := tree2.Get([]byte("b")); existed {
+		t.Errorf("%v should not contain b", tree2.Describe())
+	}
+	if value, _, existed := tree2.SubGet([]byte("c"), []byte("c")); !existed || string(value) != "c" {
+		t.Errorf("%v should contain c/c", tree2.Describe())
+	}
+	if size := tree2.SubSize([]byte("d")); size != 0 {
+		t.Errorf("%v should not contain d", tree2.Describe())
+	}
+	tree3 := NewTree()
+	NewSync(tree1, tree3).Filter(func(key []byte, subTree bool) bool {
+		return !subTree
+	}).Destroy().Run()
+	if _, _, existed := tree1.Get([]byte("a")); existed || tree1.SubSize([]byte("c")) != 1 || tree1.SubSize([]byte("d")) != 1 {
+		t.Errorf("%v should only have lost its byte values", tree1.Describe())
+	}
+	if _, _, existed := tree3.Get([]byte("b")); !existed || tree3.SubSize([]byte("c")) != 0 {
+		t.Errorf("%v should contain only the byte values", tree3.Describe())
+	}
+}
+
 func TestSyncDestructive(t *testing.T) {
 	tree1 := NewTree()
 	tree3 := NewTree()
@@ -826,6 +1194,21 @@ func TestTreeEachBetweenIndex(t *testing.T) {
 			}
 		}
 	}
+	nested := []string{"a", "b", "bb", "bbb", "c"}
+	tree = NewTree()
+	for _, key := range nested {
+		tree.Put([]byte(key), []byte(key), 1)
+	}
+	for i := 0; i < len(nested); i++ {
+		foundKeys = nil
+		tree.EachBetweenIndex(&i, &i, func(key []byte, byteValue []byte, timestamp int64, index int) bool {
+			foundKeys = append(foundKeys, key)
+			return true
+		})
+		if len(foundKeys) != 1 || string(foundKeys[0]) != nested[i] {
+			t.Errorf("%v.EachBetweenIndex(%v, %v) => %v should be %v", tree.Describe(), i, i, foundKeys, nested[i])
+		}
+	}
 }
 
 func TestTreeNilKey(t *testing.T) {
@@ -20,11 +20,11 @@ func (self *subTreeWrapper) Hash() (hash []byte) {
 func (self *subTreeWrapper) Finger(subKey []Nibble) *Print {
 	return self.parentTree.SubFinger(self.key, subKey)
 }
-func (self *subTreeWrapper) GetTimestamp(subKey []Nibble) (byteValue []byte, version int64, present bool) {
+func (self *subTreeWrapper) GetTimestamp(subKey []Nibble) (byteValue []byte, version, expiry int64, present bool) {
 	return self.parentTree.SubGetTimestamp(self.key, subKey)
 }
-func (self *subTreeWrapper) PutTimestamp(subKey []Nibble, byteValue []byte, present bool, expected, version int64) bool {
-	return self.parentTree.SubPutTimestamp(self.key, subKey, byteValue, present, expected, version)
+func (self *subTreeWrapper) PutTimestamp(subKey []Nibble, byteValue []byte, present bool, expected, version, expiry int64) bool {
+	return self.parentTree.SubPutTimestamp(self.key, subKey, byteValue, present, expected, version, expiry)
 }
 func (self *subTreeWrapper) DelTimestamp(subKey []Nibble, expected int64) bool {
 	return self.parentTree.SubDelTimestamp(self.key, subKey, expected)
@@ -38,10 +38,10 @@ func (self *subTreeWrapper) SubConfigure(key []byte, conf map[string]string, ts
 func (self *subTreeWrapper) SubFinger(key, subKey []Nibble) (result *Print) {
 	panic(subTreeError)
 }
-func (self *subTreeWrapper) SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, version int64, present bool) {
+func (self *subTreeWrapper) SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, version, expiry int64, present bool) {
 	panic(subTreeError)
 }
-func (self *subTreeWrapper) SubPutTimestamp(key, subKey []Nibble, byteValue []byte, present bool, subExpected, subTimestamp int64) bool {
+func (self *subTreeWrapper) SubPutTimestamp(key, subKey []Nibble, byteValue []byte, present bool, subExpected, subTimestamp, subExpiry int64) bool {
 	panic(subTreeError)
 }
 func (self *subTreeWrapper) SubDelTimestamp(key, subKey []Nibble, subExpected int64) bool {
@@ -17,16 +17,16 @@ type HashTree interface {
 	Configure(conf map[string]string, timestamp int64)
 
 	Finger(key []Nibble) *Print
-	GetTimestamp(key []Nibble) (byteValue []byte, timestamp int64, present bool)
-	PutTimestamp(key []Nibble, byteValue []byte, present bool, expected, timestamp int64) bool
+	GetTimestamp(key []Nibble) (byteValue []byte, timestamp, expiry int64, present bool)
+	PutTimestamp(key []Nibble, byteValue []byte, present bool, expected, timestamp, expiry int64) bool
 	DelTimestamp(key []Nibble, expected int64) bool
 
 	SubConfiguration(key []byte) (conf map[string]string, timestamp int64)
 	SubConfigure(key []byte, conf map[string]string, timestamp int64)
 
 	SubFinger(key, subKey []Nibble) (result *Print)
-	SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, timestamp int64, present bool)
-	SubPutTimestamp(key, subKey []Nibble, byteValue []byte, present bool, subExpected, subTimestamp int64) bool
+	SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, timestamp, expiry int64, present bool)
+	SubPutTimestamp(key, subKey []Nibble, byteValue []byte, present bool, subExpected, subTimestamp, subExpiry int64) bool
 	SubDelTimestamp(key, subKey []Nibble, subExpected int64) bool
 	SubClearTimestamp(key []Nibble, expected, timestamp int64) (deleted int)
 	SubKillTimestamp(key []Nibble, expected int64) (deleted int)
@@ -39,6 +39,7 @@ type Sync struct {
 	from        []Nibble
 	to          []Nibble
 	destructive bool
+	filter      func(key []byte, subTree bool) bool
 	putCount    int
 	delCount    int
 }
@@ -68,6 +69,12 @@ func (self *Sync) Destroy() *Sync {
 	return self
 }
 
+// Filter defines a function deciding whether this Sync will synchronize the byte value, or the sub tree if subTree, under key.
+func (self *Sync) Filter(f func(key []byte, subTree bool) bool) *Sync {
+	self.filter = f
+	return self
+}
+
 // PutCount returns the number of entries this Sync has inserted into the destination Tree.
 func (self *Sync) PutCount() int {
 	return self.putCount
@@ -122,14 +129,19 @@ func (self *Sync) withinLimits(key []Nibble) bool {
 	return common.BetweenIE(toBytes(key), toBytes(self.from), toBytes(self.to))
 }
 
+// accepts will check if the filter of this Sync, if any, lets it synchronize the byte value or sub tree under key.
+func (self *Sync) accepts(key []Nibble, subTree bool) bool {
+	return self.filter == nil || self.filter(Stitch(key), subTree)
+}
+
 // synchronize will recursively run the actual synchronization.
 func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
 	// If there is a source key
 	if sourcePrint.Exists {
 		// If it represents a node containing synchronizable data, and it is within our limits
 		if !sourcePrint.Empty && self.withinLimits(sourcePrint.Key) {
-			// If it contains a sub tree
-			if sourcePrint.SubTree {
+			// If it contains a sub tree we are allowed to synchronize
+			if sourcePrint.SubTree && self.accepts(sourcePrint.Key, true) {
 				// If the sub tree in the destination is not equal to the sub tree in the source
 				if bytes.Compare(sourcePrint.TreeHash, destinationPrint.TreeHash) != 0 {
 					// If the source is empty, but not the destination, and the source is newer than the destination
@@ -156,14 +168,14 @@ func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
 					self.delCount += self.source.SubKillTimestamp(sourcePrint.Key, sourcePrint.TreeDataTimestamp)
 				}
 			}
-			// If the source has a byte value.
-			if sourcePrint.Timestamp > 0 {
+			// If the source has a byte value we are allowed to synchronize.
+			if sourcePrint.Timestamp > 0 && self.accepts(sourcePrint.Key, false) {
 				// If the destination print is not covered by the source print (it is not equal and it is older)
 				if !sourcePrint.coveredBy(destinationPrint) {
 					// If the source still contains the same timestamp
-					if value, timestamp, present := self.source.GetTimestamp(sourcePrint.Key); timestamp == sourcePrint.timestamp() {
-						// Put the found data in the destination
-						if self.destination.PutTimestamp(sourcePrint.Key, value, present, destinationPrint.timestamp(), sourcePrint.timestamp()) {
+					if value, timestamp, expiry, present := self.source.GetTimestamp(sourcePrint.Key); timestamp == sourcePrint.timestamp() {
+						// Put the found data, including its expiry, in the destination
+						if self.destination.PutTimestamp(sourcePrint.Key, value, present, destinationPrint.timestamp(), sourcePrint.timestamp(), expiry) {
 							self.putCount++
 						}
 					}
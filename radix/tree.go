@@ -28,6 +28,10 @@ type TreeIterator func(key, value []byte, timestamp int64) (cont bool)
 // If they return false, the iteration will end.
 type TreeIndexIterator func(key, value []byte, timestamp int64, index int) (cont bool)
 
+// ExportIterators iterate over trees, and see the key, the byte value (if byteExists) with its timestamp and expiry, and the sub tree (if any) of what they iterate over.
+// If they return false, the iteration will end.
+type ExportIterator func(key, byteValue []byte, byteExists bool, timestamp, expiry int64, subTree *Tree) (cont bool)
+
 func cmps(mininc, maxinc bool) (mincmp, maxcmp int) {
 	if mininc {
 		mincmp = -1
@@ -51,7 +55,11 @@ func escapeBytes(b []byte) (result []byte) {
 }
 
 func incrementBytes(b []byte) []byte {
-	return new(big.Int).Add(new(big.Int).SetBytes(b), big.NewInt(1)).Bytes()
+	result := new(big.Int).Add(new(big.Int).SetBytes(b), big.NewInt(1)).Bytes()
+	if len(result) < len(b) {
+		result = append(make([]byte, len(b)-len(result)), result...)
+	}
+	return result
 }
 
 func newMirrorIterator(min, max []byte, mininc, maxinc bool, f TreeIterator) TreeIterator {
@@ -97,12 +105,18 @@ func newNodeIndexIterator(f TreeIndexIterator) nodeIndexIterator {
 // A Tree can be mirrored, which means that it contains another Tree where the keys are the values of the master Tree, and the values are the keys of the master Tree.
 //
 // A Tree is configured to be mirrored or not by using AddConfiguration or SubAddConfiguration (for a sub tree) setting 'mirrored' to 'yes'.
+//
+// A Tree can also have any number of named secondary indexes that work like the mirror Tree, but contain keys derived from the values of the master Tree.
+// An index is configured by setting 'index.NAME' to 'bytes' to index the entire value, 'bytes:FROM:TO' to index the bytes between FROM (inclusive)
+// and TO (exclusive) of the value, where an empty TO means the end of the value, or 'int64' or 'float64' to index the first 8 bytes of the value
+// decoded as a common.EncodeInt64 or common.EncodeFloat64 number in numerical order. Values too short for an index are not indexed by it.
 type Tree struct {
 	lock                   *common.TimeLock
 	timer                  Timer
 	logger                 *persistence.Logger
 	root                   *node
 	mirror                 *Tree
+	indexes                map[string]*index
 	configuration          map[string]string
 	configurationTimestamp int64
 	dataTimestamp          int64
@@ -142,36 +156,45 @@ func (self *Tree) Configuration() (map[string]string, int64) {
 	defer self.lock.RUnlock()
 	return self.conf()
 }
+
+// mirrorKey will return the key under which key, having value, is stored in a mirror Tree or index.
+func mirrorKey(key, value []byte) (result []byte) {
+	escapedKey := escapeBytes(key)
+	result = make([]byte, len(escapedKey)+len(value)+1)
+	copy(result, value)
+	copy(result[len(value)+1:], escapedKey)
+	return
+}
 func (self *Tree) mirrorClear(timestamp int64) {
 	if self.mirror != nil {
 		self.mirror.Clear(timestamp)
 	}
+	for _, index := range self.indexes {
+		index.tree.Clear(timestamp)
+	}
 }
 func (self *Tree) mirrorPut(key, value []byte, timestamp int64) {
 	if self.mirror != nil {
-		escapedKey := escapeBytes(key)
-		newKey := make([]byte, len(escapedKey)+len(value)+1)
-		copy(newKey, value)
-		copy(newKey[len(value)+1:], escapedKey)
-		self.mirror.Put(newKey, key, timestamp)
+		self.mirror.Put(mirrorKey(key, value), key, timestamp)
+	}
+	for _, index := range self.indexes {
+		index.put(key, value, timestamp)
 	}
 }
 func (self *Tree) mirrorFakeDel(key, value []byte, timestamp int64) {
 	if self.mirror != nil {
-		escapedKey := escapeBytes(key)
-		newKey := make([]byte, len(escapedKey)+len(value)+1)
-		copy(newKey, value)
-		copy(newKey[len(value)+1:], escapedKey)
-		self.mirror.FakeDel(newKey, timestamp)
+		self.mirror.FakeDel(mirrorKey(key, value), timestamp)
+	}
+	for _, index := range self.indexes {
+		index.fakeDel(key, value, timestamp)
 	}
 }
 func (self *Tree) mirrorDel(key, value []byte) {
 	if self.mirror != nil {
-		escapedKey := escapeBytes(key)
-		newKey := make([]byte, len(escapedKey)+len(value)+1)
-		copy(newKey, value)
-		copy(newKey[len(value)+1:], escapedKey)
-		self.mirror.Del(newKey)
+		self.mirror.Del(mirrorKey(key, value))
+	}
+	for _, index := range self.indexes {
+		index.del(key, value)
 	}
 }
 func (self *Tree) startMirroring() {
@@ -187,6 +210,7 @@ func (self *Tree) configure(conf map[string]string, ts int64) {
 	} else if conf[mirrored] != yes && self.configuration[mirrored] == yes {
 		self.mirror = nil
 	}
+	self.configureIndexes(conf)
 	self.configuration = conf
 	self.configurationTimestamp = ts
 	self.log(persistence.Op{
@@ -196,7 +220,8 @@ func (self *Tree) configure(conf map[string]string, ts int64) {
 }
 
 // Configure will set a new configuration and timestamp to this tree.
-// If the configuration has mirrored=yes this tree will start mirroring all its keys and values in a mirror Tree.
+// If the configuration has mirrored=yes this tree will start mirroring all its keys and values in a mirror Tree,
+// and if it has index.NAME entries it will start maintaining the defined indexes.
 func (self *Tree) Configure(conf map[string]string, ts int64) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -219,7 +244,12 @@ func (self *Tree) AddConfiguration(ts int64, key, value string) bool {
 
 // Log will make this Tree start logging using a new persistence.Logger.
 func (self *Tree) Log(dir string) *Tree {
-	self.logger = persistence.NewLogger(dir)
+	return self.LogTo(persistence.NewLogger(dir))
+}
+
+// LogTo will make this Tree start logging using logger.
+func (self *Tree) LogTo(logger *persistence.Logger) *Tree {
+	self.logger = logger
 	<-self.logger.Record()
 	return self
 }
@@ -237,9 +267,9 @@ func (self *Tree) Restore() *Tree {
 			}
 		} else if op.Put {
 			if op.SubKey == nil {
-				self.Put(op.Key, op.Value, op.Timestamp)
+				self.PutExpiry(op.Key, op.Value, op.Timestamp, op.Expiry)
 			} else {
-				self.SubPut(op.Key, op.SubKey, op.Value, op.Timestamp)
+				self.SubPutExpiry(op.Key, op.SubKey, op.Value, op.Timestamp, op.Expiry)
 			}
 		} else {
 			if op.SubKey == nil {
@@ -265,9 +295,17 @@ func (self *Tree) log(op persistence.Op) {
 		self.logger.Dump(op)
 	}
 }
-func (self *Tree) newTreeWith(key []Nibble, byteValue []byte, timestamp int64) (result *Tree) {
+
+// Sync will not return until everything this Tree has logged is written to its logfile, and unless the logger is in
+// persistence.FsyncNever mode, on stable storage.
+func (self *Tree) Sync() {
+	if self.logger != nil {
+		self.logger.Sync()
+	}
+}
+func (self *Tree) newTreeWith(key []Nibble, byteValue []byte, timestamp, expiry int64) (result *Tree) {
 	result = NewTreeTimer(self.timer)
-	result.PutTimestamp(key, byteValue, true, 0, timestamp)
+	result.PutTimestamp(key, byteValue, true, 0, timestamp, expiry)
 	return
 }
 
@@ -314,6 +352,33 @@ func (self *Tree) EachBetween(min, max []byte, mininc, maxinc bool, f TreeIterat
 	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue, newNodeIterator(f))
 }
 
+// ExportBetween will iterate between min and max over all keys with byte values or sub trees using f.
+// Tombstones and expired byte values are skipped.
+func (self *Tree) ExportBetween(min, max []byte, mininc, maxinc bool, f ExportIterator) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue|treeValue, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		var expiry int64
+		byteExists := use&byteValue != 0
+		if byteExists {
+			n := self.root.find(Rip(key))
+			expiry = n.expiry
+			byteExists = !self.expired(n)
+		}
+		if use&treeValue == 0 {
+			tValue = nil
+		}
+		if !byteExists && tValue == nil {
+			return true
+		}
+		return f(key, bValue, byteExists, timestamp, expiry, tValue)
+	})
+}
+
 // MirrorReverseEachBetween will iterate between min and max in the mirror Tree, in reverse order, using f.
 func (self *Tree) MirrorReverseEachBetween(min, max []byte, mininc, maxinc bool, f TreeIterator) {
 	if self == nil || self.mirror == nil {
@@ -439,6 +504,13 @@ func (self *Tree) ReverseEachBetweenIndex(min, max *int, f TreeIndexIterator) {
 	self.root.reverseEachBetweenIndex(nil, 0, min, max, byteValue, newNodeIndexIterator(f))
 }
 
+// nextExpiry returns the earliest expiry of any value in this Tree, or 0 if nothing in it will expire.
+func (self *Tree) nextExpiry() int64 {
+	if self == nil || self.root == nil {
+		return 0
+	}
+	return self.root.nextExpiry
+}
 func (self *Tree) DataTimestamp() int64 {
 	if self == nil {
 		return 0
@@ -516,6 +588,34 @@ func (self *Tree) SizeBetween(min, max []byte, mininc, maxinc bool) int {
 	return self.sizeBetween(min, max, mininc, maxinc, byteValue|treeValue)
 }
 
+// PrefixEach will iterate over all keys starting with prefix using f.
+func (self *Tree) PrefixEach(prefix []byte, f TreeIterator) {
+	self.EachBetween(prefix, common.PrefixEnd(prefix), true, false, f)
+}
+
+// PrefixCount returns the number of byte values, not including tombstones, with keys starting with prefix.
+func (self *Tree) PrefixCount(prefix []byte) int {
+	return self.sizeBetween(prefix, common.PrefixEnd(prefix), true, false, byteValue)
+}
+
+// PrefixDelete will FakeDel all byte values with keys starting with prefix under one lock, and return the keys that were deleted.
+func (self *Tree) PrefixDelete(prefix []byte, timestamp int64) (deleted [][]byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var keys [][]byte
+	mincmp, maxcmp := cmps(true, false)
+	self.root.eachBetween(nil, Rip(prefix), Rip(common.PrefixEnd(prefix)), mincmp, maxcmp, byteValue, newNodeIterator(func(key, value []byte, version int64) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	for _, key := range keys {
+		if _, _, existed := self.fakeDel(key, timestamp); existed {
+			deleted = append(deleted, key)
+		}
+	}
+	return
+}
+
 // RealSize returns the real, as in 'including tombstones and sub trees', size of this Tree.
 func (self *Tree) RealSize() int {
 	if self == nil {
@@ -569,6 +669,9 @@ func (self *Tree) Describe() string {
 func (self *Tree) FakeDel(key []byte, timestamp int64) (oldBytes []byte, oldTree *Tree, existed bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
+	return self.fakeDel(key, timestamp)
+}
+func (self *Tree) fakeDel(key []byte, timestamp int64) (oldBytes []byte, oldTree *Tree, existed bool) {
 	var ex int
 	self.root, oldBytes, oldTree, _, ex = self.root.fakeDel(nil, Rip(key), byteValue, timestamp, self.timer.ContinuousTime())
 	existed = ex&byteValue != 0
@@ -581,17 +684,32 @@ func (self *Tree) FakeDel(key []byte, timestamp int64) (oldBytes []byte, oldTree
 	return
 }
 func (self *Tree) put(key []Nibble, byteValue []byte, treeValue *Tree, use int, timestamp int64) (oldBytes []byte, oldTree *Tree, existed int) {
-	self.dataTimestamp = timestamp
-	self.root, oldBytes, oldTree, _, existed = self.root.insert(nil, newNode(key, byteValue, treeValue, timestamp, false, use), self.timer.ContinuousTime())
+	return self.putNode(newNode(key, byteValue, treeValue, timestamp, false, use))
+}
+func (self *Tree) putNode(n *node) (oldBytes []byte, oldTree *Tree, existed int) {
+	self.dataTimestamp = n.timestamp
+	self.root, oldBytes, oldTree, _, existed = self.root.insert(nil, n, self.timer.ContinuousTime())
 	return
 }
 
 // Put will put key and value with timestamp in this Tree.
 func (self *Tree) Put(key []byte, bValue []byte, timestamp int64) (oldBytes []byte, existed bool) {
+	return self.PutExpiry(key, bValue, timestamp, 0)
+}
+
+// PutExpiry will put key and value with timestamp in this Tree, and make it expire when the Timer of this Tree reaches expiry.
+// An expiry of 0 means that the value will never expire.
+//
+// Get, SubGet, Update and ExportBetween treat the value as gone as soon as it expires, but Size, Slice, Each and the other iterators, which rely on the sizes
+// kept in each node, keep including it until Expire replaces it with a tombstone.
+func (self *Tree) PutExpiry(key []byte, bValue []byte, timestamp, expiry int64) (oldBytes []byte, existed bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	oldBytes, _, ex := self.put(Rip(key), bValue, nil, byteValue, timestamp)
-	existed = ex*byteValue != 0
+	return self.putExpiry(key, bValue, timestamp, expiry)
+}
+func (self *Tree) putExpiry(key []byte, bValue []byte, timestamp, expiry int64) (oldBytes []byte, existed bool) {
+	oldBytes, _, ex := self.putNode(newExpiringNode(Rip(key), bValue, timestamp, expiry, byteValue))
+	existed = ex&byteValue != 0
 	if existed {
 		self.mirrorDel(key, oldBytes)
 	}
@@ -600,20 +718,78 @@ func (self *Tree) Put(key []byte, bValue []byte, timestamp int64) (oldBytes []by
 		Key:       key,
 		Value:     bValue,
 		Timestamp: timestamp,
+		Expiry:    expiry,
 		Put:       true,
 	})
 	return
 }
 
+// PutIf will put key and value with timestamp in this Tree if matches returns true for the current value, timestamp and existence of key.
+// Values that have expired will not be considered existing.
+//
+// It returns the current value, timestamp and existence if nothing was put, and the new ones if something was.
+func (self *Tree) PutIf(key []byte, bValue []byte, timestamp int64, matches func(value []byte, timestamp int64, existed bool) bool) (currentBytes []byte, currentTimestamp int64, existed, swapped bool) {
+	return self.Update(key, timestamp, func(value []byte, timestamp int64, existed bool) ([]byte, bool) {
+		return bValue, matches(value, timestamp, existed)
+	})
+}
+
+// Update will put the value returned by f, given the current value, timestamp and existence of key, under key with timestamp in this Tree.
+// If f returns false, nothing will be put. Values that have expired will not be considered existing, and the expiry of existing values is kept.
+//
+// It returns the current value, timestamp and existence if nothing was put, and the new ones if something was.
+func (self *Tree) Update(key []byte, timestamp int64, f func(value []byte, timestamp int64, existed bool) ([]byte, bool)) (currentBytes []byte, currentTimestamp int64, existed, updated bool) {
+	currentBytes, currentTimestamp, _, existed, updated = self.UpdateExpiry(key, timestamp, keepExpiry(f))
+	return
+}
+
+// UpdateExpiry will put the value returned by f, given the current value, timestamp, expiry and existence of key, under key with timestamp in this Tree,
+// and make it expire at the expiry returned by f.
+// If f returns false, nothing will be put. Values that have expired will not be considered existing, and will be given to f with an expiry of 0.
+//
+// It returns the current value, timestamp, expiry and existence if nothing was put, and the new ones if something was.
+func (self *Tree) UpdateExpiry(key []byte, timestamp int64, f func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool)) (currentBytes []byte, currentTimestamp, currentExpiry int64, existed, updated bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if n := self.root.find(Rip(key)); n != nil {
+		currentBytes, currentTimestamp = n.byteValue, n.timestamp
+		if existed = n.use&byteValue != 0 && !self.expired(n); existed {
+			currentExpiry = n.expiry
+		}
+	}
+	if newBytes, newExpiry, ok := f(currentBytes, currentTimestamp, currentExpiry, existed); ok {
+		self.putExpiry(key, newBytes, timestamp, newExpiry)
+		currentBytes, currentTimestamp, currentExpiry, existed, updated = newBytes, timestamp, newExpiry, true, true
+	}
+	return
+}
+
+// keepExpiry returns an UpdateExpiry function that updates using f and keeps the current expiry.
+func keepExpiry(f func(value []byte, timestamp int64, existed bool) ([]byte, bool)) func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool) {
+	return func(value []byte, timestamp, expiry int64, existed bool) (result []byte, newExpiry int64, ok bool) {
+		result, ok = f(value, timestamp, existed)
+		newExpiry = expiry
+		return
+	}
+}
+
 // Get will return the value and timestamp at key.
+// Values that have expired, but not yet been removed by Expire, will not be considered existing.
 func (self *Tree) Get(key []byte) (bValue []byte, timestamp int64, existed bool) {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
-	bValue, _, timestamp, ex := self.root.get(Rip(key))
-	existed = ex&byteValue != 0
+	if n := self.root.find(Rip(key)); n != nil {
+		bValue, timestamp = n.byteValue, n.timestamp
+		existed = n.use&byteValue != 0 && !self.expired(n)
+	}
 	return
 }
 
+// expired returns whether the byte value of n has expired according to the Timer of this Tree.
+func (self *Tree) expired(n *node) bool {
+	return n.expiry != 0 && n.expired(self.timer.ContinuousTime())
+}
+
 // PrevMarker returns the previous key of tombstone or real value before key.
 func (self *Tree) PrevMarker(key []byte) (prevKey []byte, existed bool) {
 	if self == nil {
@@ -840,6 +1016,58 @@ func (self *Tree) Clear(timestamp int64) {
 		self.logger.Clear()
 	}
 }
+
+// Expire will replace all values in this Tree and its sub trees that have expired according to the Timer of this Tree with tombstones.
+//
+// The tombstones get the timestamp of the expired value plus one, so that every replica expiring the same value creates the same tombstone,
+// while any value written after the expiring one still wins when the replicas are synchronized.
+func (self *Tree) Expire() (expired int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := self.timer.ContinuousTime()
+	self.expire(now, func(key, subKey []byte) {
+		expired++
+		self.log(persistence.Op{
+			Key:    key,
+			SubKey: subKey,
+		})
+	})
+	return
+}
+func (self *Tree) lockedExpire(now int64, f func(key []byte)) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.expire(now, func(key, subKey []byte) {
+		f(key)
+	})
+}
+func (self *Tree) expire(now int64, f func(key, subKey []byte)) {
+	var keys [][]Nibble
+	var nodes []*node
+	self.root.eachExpired(nil, now, func(key []Nibble, n *node) {
+		keys = append(keys, key)
+		nodes = append(nodes, n)
+	})
+	for index, n := range nodes {
+		key := keys[index]
+		stitched := Stitch(key)
+		if n.use&treeValue != 0 && n.treeValue != nil && n.treeValue.nextExpiry() != 0 && n.treeValue.nextExpiry() <= now {
+			if _, subTree, subTreeTimestamp, ex := self.root.get(key); ex&treeValue != 0 && subTree != nil {
+				subTree.lockedExpire(now, func(subKey []byte) {
+					f(stitched, subKey)
+				})
+				self.put(key, nil, subTree, treeValue, subTreeTimestamp)
+			}
+		}
+		if n.use&byteValue != 0 && n.expired(now) {
+			tombstone := n.timestamp + 1
+			var oldBytes []byte
+			self.root, oldBytes, _, _, _ = self.root.fakeDel(nil, key, byteValue, tombstone, now)
+			self.mirrorFakeDel(stitched, oldBytes, tombstone)
+			f(stitched, nil)
+		}
+	}
+}
 func (self *Tree) del(key []Nibble, use int) (oldBytes []byte, existed bool) {
 	var ex int
 	self.root, oldBytes, _, _, ex = self.root.del(nil, key, use, self.timer.ContinuousTime())
@@ -1013,6 +1241,9 @@ func (self *Tree) SubSizeBetween(key, min, max []byte, mininc, maxinc bool) (res
 	}
 	return
 }
+
+// SubGet will return the value and timestamp at subKey in the sub tree at key.
+// Values that have expired, but not yet been removed by Expire, will not be considered existing.
 func (self *Tree) SubGet(key, subKey []byte) (byteValue []byte, timestamp int64, existed bool) {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
@@ -1078,14 +1309,60 @@ func (self *Tree) SubEachBetweenIndex(key []byte, min, max *int, f TreeIndexIter
 	}
 }
 func (self *Tree) SubPut(key, subKey []byte, byteValue []byte, timestamp int64) (oldBytes []byte, existed bool) {
+	return self.SubPutExpiry(key, subKey, byteValue, timestamp, 0)
+}
+
+// SubPutIf does PutIf on the sub tree.
+func (self *Tree) SubPutIf(key, subKey []byte, byteValue []byte, timestamp int64, matches func(value []byte, timestamp int64, existed bool) bool) (currentBytes []byte, currentTimestamp int64, existed, swapped bool) {
+	return self.SubUpdate(key, subKey, timestamp, func(value []byte, timestamp int64, existed bool) ([]byte, bool) {
+		return byteValue, matches(value, timestamp, existed)
+	})
+}
+
+// SubUpdate does Update on the sub tree.
+func (self *Tree) SubUpdate(key, subKey []byte, timestamp int64, f func(value []byte, timestamp int64, existed bool) ([]byte, bool)) (currentBytes []byte, currentTimestamp int64, existed, updated bool) {
+	currentBytes, currentTimestamp, _, existed, updated = self.SubUpdateExpiry(key, subKey, timestamp, keepExpiry(f))
+	return
+}
+
+// SubUpdateExpiry does UpdateExpiry on the sub tree.
+func (self *Tree) SubUpdateExpiry(key, subKey []byte, timestamp int64, f func(value []byte, timestamp, expiry int64, existed bool) ([]byte, int64, bool)) (currentBytes []byte, currentTimestamp, currentExpiry int64, existed, updated bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	ripped := Rip(key)
 	_, subTree, subTreeTimestamp, ex := self.root.get(ripped)
 	if ex&treeValue == 0 || subTree == nil {
-		subTree = self.newTreeWith(Rip(subKey), byteValue, timestamp)
+		newBytes, newExpiry, ok := f(nil, 0, 0, false)
+		if !ok {
+			return
+		}
+		subTree = self.newTreeWith(Rip(subKey), newBytes, timestamp, newExpiry)
+		currentBytes, currentTimestamp, currentExpiry, existed, updated = newBytes, timestamp, newExpiry, true, true
+	} else if currentBytes, currentTimestamp, currentExpiry, existed, updated = subTree.UpdateExpiry(subKey, timestamp, f); !updated {
+		return
+	}
+	self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
+	self.log(persistence.Op{
+		Key:       key,
+		SubKey:    subKey,
+		Value:     currentBytes,
+		Timestamp: timestamp,
+		Expiry:    currentExpiry,
+		Put:       true,
+	})
+	return
+}
+
+// SubPutExpiry does PutExpiry on the sub tree.
+func (self *Tree) SubPutExpiry(key, subKey []byte, byteValue []byte, timestamp, expiry int64) (oldBytes []byte, existed bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	_, subTree, subTreeTimestamp, ex := self.root.get(ripped)
+	if ex&treeValue == 0 || subTree == nil {
+		subTree = self.newTreeWith(Rip(subKey), byteValue, timestamp, expiry)
 	} else {
-		oldBytes, existed = subTree.Put(subKey, byteValue, timestamp)
+		oldBytes, existed = subTree.PutExpiry(subKey, byteValue, timestamp, expiry)
 	}
 	self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
 	self.log(persistence.Op{
@@ -1093,6 +1370,7 @@ func (self *Tree) SubPut(key, subKey []byte, byteValue []byte, timestamp int64)
 		SubKey:    subKey,
 		Value:     byteValue,
 		Timestamp: timestamp,
+		Expiry:    expiry,
 		Put:       true,
 	})
 	return
@@ -1154,6 +1432,43 @@ func (self *Tree) SubClear(key []byte, timestamp int64) (deleted int) {
 	return
 }
 
+// SubPrefixEach does PrefixEach on the sub tree.
+func (self *Tree) SubPrefixEach(key, prefix []byte, f TreeIterator) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		subTree.PrefixEach(prefix, f)
+	}
+}
+
+// SubPrefixCount does PrefixCount on the sub tree.
+func (self *Tree) SubPrefixCount(key, prefix []byte) (result int) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		result = subTree.PrefixCount(prefix)
+	}
+	return
+}
+
+// SubPrefixDelete does PrefixDelete on the sub tree, under the lock of this Tree.
+func (self *Tree) SubPrefixDelete(key, prefix []byte, timestamp int64) (deleted [][]byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	if _, subTree, subTreeTimestamp, ex := self.root.get(ripped); ex&treeValue != 0 && subTree != nil {
+		deleted = subTree.PrefixDelete(prefix, timestamp)
+		self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
+	}
+	for _, subKey := range deleted {
+		self.log(persistence.Op{
+			Key:    key,
+			SubKey: subKey,
+		})
+	}
+	return
+}
+
 // SubKill will completely remove the sub tree.
 func (self *Tree) SubKill(key []byte) (deleted int) {
 	self.lock.Lock()
@@ -1176,21 +1491,22 @@ func (self *Tree) Finger(key []Nibble) *Print {
 	defer self.lock.RUnlock()
 	return self.root.finger(&Print{}, key)
 }
-func (self *Tree) GetTimestamp(key []Nibble) (bValue []byte, timestamp int64, present bool) {
+func (self *Tree) GetTimestamp(key []Nibble) (bValue []byte, timestamp, expiry int64, present bool) {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
-	bValue, _, timestamp, ex := self.root.get(key)
-	present = ex&byteValue != 0
+	if n := self.root.find(key); n != nil {
+		bValue, timestamp, expiry, present = n.byteValue, n.timestamp, n.expiry, n.use&byteValue != 0
+	}
 	return
 }
-func (self *Tree) putTimestamp(key []Nibble, bValue []byte, treeValue *Tree, nodeUse, insertUse int, expected, timestamp int64) (result bool, oldBytes []byte) {
-	if _, _, current, _ := self.root.get(key); current == expected {
-		self.dataTimestamp, result = timestamp, true
-		self.root, oldBytes, _, _, _ = self.root.insertHelp(nil, newNode(key, bValue, treeValue, timestamp, false, nodeUse), insertUse, self.timer.ContinuousTime())
+func (self *Tree) putTimestamp(n *node, insertUse int, expected int64) (result bool, oldBytes []byte) {
+	if _, _, current, _ := self.root.get(n.segment); current == expected {
+		self.dataTimestamp, result = n.timestamp, true
+		self.root, oldBytes, _, _, _ = self.root.insertHelp(nil, n, insertUse, self.timer.ContinuousTime())
 	}
 	return
 }
-func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expected, timestamp int64) (result bool) {
+func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expected, timestamp, expiry int64) (result bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	nodeUse := 0
@@ -1198,7 +1514,7 @@ func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expect
 		nodeUse = byteValue
 	}
 	var oldBytes []byte
-	result, oldBytes = self.putTimestamp(key, bValue, nil, nodeUse, byteValue, expected, timestamp)
+	result, oldBytes = self.putTimestamp(newExpiringNode(key, bValue, timestamp, expiry, nodeUse), byteValue, expected)
 	if result {
 		stitched := Stitch(key)
 		self.mirrorDel(stitched, oldBytes)
@@ -1207,6 +1523,7 @@ func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expect
 			Key:       Stitch(key),
 			Value:     bValue,
 			Timestamp: timestamp,
+			Expiry:    expiry,
 			Put:       true,
 		})
 	}
@@ -1286,31 +1603,42 @@ func (self *Tree) SubFinger(key, subKey []Nibble) (result *Print) {
 	}
 	return
 }
-func (self *Tree) SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, timestamp int64, present bool) {
+
+// SubHash will return the hash of the sub tree under key, or nil if there is no such sub tree.
+func (self *Tree) SubHash(key []byte) []byte {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 {
+		return subTree.Hash()
+	}
+	return nil
+}
+func (self *Tree) SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, timestamp, expiry int64, present bool) {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
 	if _, subTree, _, ex := self.root.get(key); ex&treeValue != 0 && subTree != nil {
-		byteValue, timestamp, present = subTree.GetTimestamp(subKey)
+		byteValue, timestamp, expiry, present = subTree.GetTimestamp(subKey)
 	}
 	return
 }
-func (self *Tree) SubPutTimestamp(key, subKey []Nibble, bValue []byte, present bool, subExpected, subTimestamp int64) (result bool) {
+func (self *Tree) SubPutTimestamp(key, subKey []Nibble, bValue []byte, present bool, subExpected, subTimestamp, subExpiry int64) (result bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	_, subTree, subTreeTimestamp, _ := self.root.get(key)
 	if subTree == nil {
 		result = true
-		subTree = self.newTreeWith(subKey, bValue, subTimestamp)
+		subTree = self.newTreeWith(subKey, bValue, subTimestamp, subExpiry)
 	} else {
-		result = subTree.PutTimestamp(subKey, bValue, present, subExpected, subTimestamp)
+		result = subTree.PutTimestamp(subKey, bValue, present, subExpected, subTimestamp, subExpiry)
 	}
-	self.putTimestamp(key, nil, subTree, treeValue, treeValue, subTreeTimestamp, subTreeTimestamp)
+	self.putTimestamp(newNode(key, nil, subTree, subTreeTimestamp, false, treeValue), treeValue, subTreeTimestamp)
 	if result {
 		self.log(persistence.Op{
 			Key:       Stitch(key),
 			SubKey:    Stitch(subKey),
 			Value:     bValue,
 			Timestamp: subTimestamp,
+			Expiry:    subExpiry,
 			Put:       true,
 		})
 	}
@@ -1324,7 +1652,7 @@ func (self *Tree) SubDelTimestamp(key, subKey []Nibble, subExpected int64) (resu
 		if subTree.Size() == 0 {
 			self.delTimestamp(key, treeValue, subTreeTimestamp)
 		} else {
-			self.putTimestamp(key, nil, subTree, treeValue, treeValue, subTreeTimestamp, subTreeTimestamp)
+			self.putTimestamp(newNode(key, nil, subTree, subTreeTimestamp, false, treeValue), treeValue, subTreeTimestamp)
 		}
 	}
 	if result {
@@ -1341,7 +1669,7 @@ func (self *Tree) SubClearTimestamp(key []Nibble, expected, timestamp int64) (de
 	if _, subTree, subTreeTimestamp, ex := self.root.get(key); ex&treeValue != 0 && subTree != nil && subTree.DataTimestamp() == expected {
 		deleted = subTree.Size()
 		subTree.Clear(timestamp)
-		self.putTimestamp(key, nil, subTree, treeValue, treeValue, subTreeTimestamp, subTreeTimestamp)
+		self.putTimestamp(newNode(key, nil, subTree, subTreeTimestamp, false, treeValue), treeValue, subTreeTimestamp)
 	}
 	if deleted > 0 {
 		self.log(persistence.Op{
@@ -0,0 +1,361 @@
+package resp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/zond/god/common"
+	"github.com/zond/setop"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mirrored = "mirrored"
+	yes      = "yes"
+)
+
+// command is a command understood by the Server.
+type command struct {
+	// arity is the number of arguments including the command name, or if negative the minimum number.
+	arity int
+	// open commands can be run before AUTH.
+	open bool
+	f    func(s *session, args [][]byte) bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {-1, true, (*session).ping},
+		"QUIT":    {1, true, (*session).quit},
+		"AUTH":    {-2, true, (*session).auth},
+		"GET":     {2, false, (*session).get},
+		"SET":     {-3, false, (*session).set},
+		"DEL":     {-2, false, (*session).del},
+		"HSET":    {-4, false, (*session).hset},
+		"HGET":    {3, false, (*session).hget},
+		"HGETALL": {2, false, (*session).hgetall},
+		"ZADD":    {-4, false, (*session).zadd},
+		"ZRANGE":  {-4, false, (*session).zrange},
+		"ZRANK":   {3, false, (*session).zrank},
+		"ZCARD":   {2, false, (*session).zcard},
+		"SINTER":  {-2, false, (*session).sinter},
+		"SUNION":  {-2, false, (*session).sunion},
+	}
+}
+
+// encodeScore will encode f so that encoded scores sort in the same order as the scores themselves.
+func encodeScore(f float64) (result []byte) {
+	result = make([]byte, 8)
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	binary.BigEndian.PutUint64(result, bits)
+	return
+}
+func decodeScore(b []byte) float64 {
+	if len(b) != 8 {
+		return 0
+	}
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+func formatScore(f float64) []byte {
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+func (self *session) ping(args [][]byte) bool {
+	if len(args) > 1 {
+		self.writeBulk(args[1])
+	} else {
+		self.writeStatus("PONG")
+	}
+	return true
+}
+func (self *session) quit(args [][]byte) bool {
+	self.writeStatus("OK")
+	return false
+}
+
+// auth will authenticate the session using the last argument as token, ignoring any user name before it.
+func (self *session) auth(args [][]byte) bool {
+	authenticator, _ := self.server.getAuth()
+	if authenticator == nil {
+		self.writeError("ERR AUTH called without any password configured")
+		return true
+	}
+	principal, ok := authenticator(string(args[len(args)-1]))
+	if !ok {
+		self.writeError("WRONGPASS invalid token")
+		return true
+	}
+	self.principal, self.authenticated = principal, true
+	self.writeStatus("OK")
+	return true
+}
+
+func (self *session) get(args [][]byte) bool {
+	if !self.authorize("DHash.Get", common.Item{Key: args[1]}) {
+		return true
+	}
+	if value, existed := self.server.conn.Get(args[1]); existed {
+		self.writeBulk(value)
+	} else {
+		self.writeBulk(nil)
+	}
+	return true
+}
+
+// set supports the EX and PX options, using PutTTL.
+func (self *session) set(args [][]byte) bool {
+	var ttl time.Duration
+	for i := 3; i < len(args); i += 2 {
+		option := strings.ToUpper(string(args[i]))
+		if (option != "EX" && option != "PX") || i+1 >= len(args) {
+			self.writeError("ERR syntax error")
+			return true
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || n <= 0 {
+			self.writeError("ERR invalid expire time in 'set' command")
+			return true
+		}
+		if option == "EX" {
+			ttl = time.Duration(n) * time.Second
+		} else {
+			ttl = time.Duration(n) * time.Millisecond
+		}
+	}
+	if ttl > 0 {
+		if !self.authorize("DHash.PutTTL", common.Item{Key: args[1]}) {
+			return true
+		}
+		self.server.conn.PutTTL(args[1], args[2], ttl)
+	} else {
+		if !self.authorize("DHash.Put", common.Item{Key: args[1]}) {
+			return true
+		}
+		self.server.conn.Put(args[1], args[2])
+	}
+	self.writeStatus("OK")
+	return true
+}
+func (self *session) del(args [][]byte) bool {
+	for _, key := range args[1:] {
+		if !self.authorize("DHash.Del", common.Item{Key: key}) {
+			return true
+		}
+	}
+	ops := make([]common.BatchOp, 0, len(args)-1)
+	for _, key := range args[1:] {
+		ops = append(ops, common.BatchOp{
+			Type: common.BatchDel,
+			Key:  key,
+		})
+	}
+	removed := 0
+	for _, result := range self.server.conn.Batch(ops) {
+		if result.Existed {
+			removed++
+		}
+	}
+	self.writeInt(removed)
+	return true
+}
+
+func (self *session) hset(args [][]byte) bool {
+	if len(args)%2 != 0 {
+		self.writeError("ERR wrong number of arguments for 'hset' command")
+		return true
+	}
+	if !self.authorize("DHash.SubPut", common.Item{Key: args[1]}) {
+		return true
+	}
+	ops := make([]common.BatchOp, 0, len(args)/2-1)
+	for i := 2; i < len(args); i += 2 {
+		ops = append(ops, common.BatchOp{
+			Type:   common.BatchSubPut,
+			Key:    args[1],
+			SubKey: args[i],
+			Value:  args[i+1],
+		})
+	}
+	added := 0
+	for _, result := range self.server.conn.Batch(ops) {
+		if !result.Existed {
+			added++
+		}
+	}
+	self.writeInt(added)
+	return true
+}
+func (self *session) hget(args [][]byte) bool {
+	if !self.authorize("DHash.SubGet", common.Item{Key: args[1]}) {
+		return true
+	}
+	if value, existed := self.server.conn.SubGet(args[1], args[2]); existed {
+		self.writeBulk(value)
+	} else {
+		self.writeBulk(nil)
+	}
+	return true
+}
+func (self *session) hgetall(args [][]byte) bool {
+	if !self.authorize("DHash.Slice", common.Range{Key: args[1]}) {
+		return true
+	}
+	var result [][]byte
+	for _, item := range self.server.conn.Slice(args[1], nil, nil, true, true) {
+		result = append(result, item.Key, item.Value)
+	}
+	self.writeArray(result)
+	return true
+}
+
+// zadd will make sure the sub tree is mirrored before adding the members, since the mirror is what the other sorted set commands use.
+func (self *session) zadd(args [][]byte) bool {
+	if len(args)%2 != 0 {
+		self.writeError("ERR syntax error")
+		return true
+	}
+	scores := make([][]byte, 0, len(args)/2)
+	for i := 2; i < len(args); i += 2 {
+		f, err := strconv.ParseFloat(string(args[i]), 64)
+		if err != nil || math.IsNaN(f) {
+			self.writeError("ERR value is not a valid float")
+			return true
+		}
+		scores = append(scores, encodeScore(f))
+	}
+	if !self.authorize("DHash.SubPut", common.Item{Key: args[1]}) {
+		return true
+	}
+	if self.server.conn.SubConfiguration(args[1])[mirrored] != yes {
+		if !self.authorize("DHash.SubAddConfiguration", common.ConfItem{TreeKey: args[1], Key: mirrored, Value: yes}) {
+			return true
+		}
+		self.server.conn.SubAddConfiguration(args[1], mirrored, yes)
+	}
+	added := 0
+	for index, score := range scores {
+		member := args[3+index*2]
+		if _, existed := self.server.conn.SubGet(args[1], member); !existed {
+			added++
+		}
+		self.server.conn.SubPut(args[1], member, score)
+	}
+	self.writeInt(added)
+	return true
+}
+
+// zrange supports negative indices and WITHSCORES.
+func (self *session) zrange(args [][]byte) bool {
+	withScores := false
+	if len(args) == 5 && strings.ToUpper(string(args[4])) == "WITHSCORES" {
+		withScores = true
+	} else if len(args) != 4 {
+		self.writeError("ERR syntax error")
+		return true
+	}
+	start, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		self.writeError("ERR value is not an integer or out of range")
+		return true
+	}
+	stop, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		self.writeError("ERR value is not an integer or out of range")
+		return true
+	}
+	if !self.authorize("DHash.MirrorSliceIndex", common.Range{Key: args[1]}) {
+		return true
+	}
+	if start < 0 || stop < 0 {
+		size := self.server.conn.SubSize(args[1])
+		if start < 0 {
+			start += size
+		}
+		if stop < 0 {
+			stop += size
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	var result [][]byte
+	if start <= stop {
+		for _, item := range self.server.conn.MirrorSliceIndex(args[1], &start, &stop) {
+			result = append(result, item.Value)
+			if withScores {
+				result = append(result, formatScore(decodeScore(item.Key)))
+			}
+		}
+	}
+	self.writeArray(result)
+	return true
+}
+
+// zrank will count the members with lower scores, and the members with the same score sorted before the member in the mirror tree.
+func (self *session) zrank(args [][]byte) bool {
+	if !self.authorize("DHash.SubGet", common.Item{Key: args[1]}) {
+		return true
+	}
+	score, existed := self.server.conn.SubGet(args[1], args[2])
+	if !existed {
+		self.writeBulk(nil)
+		return true
+	}
+	rank := self.server.conn.MirrorCount(args[1], nil, score, true, false)
+	for _, item := range self.server.conn.MirrorSlice(args[1], score, score, true, true) {
+		if bytes.Compare(item.Value, args[2]) == 0 {
+			break
+		}
+		rank++
+	}
+	self.writeInt(rank)
+	return true
+}
+func (self *session) zcard(args [][]byte) bool {
+	if !self.authorize("DHash.SubSize", args[1]) {
+		return true
+	}
+	self.writeInt(self.server.conn.SubSize(args[1]))
+	return true
+}
+
+func (self *session) sinter(args [][]byte) bool {
+	return self.setExpression(setop.Intersection, args[1:])
+}
+func (self *session) sunion(args [][]byte) bool {
+	return self.setExpression(setop.Union, args[1:])
+}
+func (self *session) setExpression(typ setop.SetOpType, keys [][]byte) bool {
+	op := &setop.SetOp{
+		Type:  typ,
+		Merge: setop.Append,
+	}
+	for _, key := range keys {
+		op.Sources = append(op.Sources, setop.SetOpSource{Key: key})
+	}
+	expr := setop.SetExpression{Op: op}
+	if !self.authorize("DHash.SetExpression", expr) {
+		return true
+	}
+	var result [][]byte
+	for _, res := range self.server.conn.SetExpression(expr) {
+		result = append(result, res.Key)
+	}
+	self.writeArray(result)
+	return true
+}
@@ -0,0 +1,155 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/dhash"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTest(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+func (self *testClient) readReply() interface{} {
+	line, _, err := self.reader.ReadLine()
+	if err != nil {
+		self.t.Fatalf("%v", err)
+	}
+	switch line[0] {
+	case '+', '-':
+		return string(line)
+	case ':':
+		i, _ := strconv.Atoi(string(line[1:]))
+		return i
+	case '$':
+		n, _ := strconv.Atoi(string(line[1:]))
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(self.reader, b); err != nil {
+			self.t.Fatalf("%v", err)
+		}
+		return string(b[:n])
+	case '*':
+		n, _ := strconv.Atoi(string(line[1:]))
+		result := []interface{}{}
+		for i := 0; i < n; i++ {
+			result = append(result, self.readReply())
+		}
+		return result
+	}
+	self.t.Fatalf("unknown reply %#v", string(line))
+	return nil
+}
+func (self *testClient) do(args ...string) interface{} {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "*%v\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(buf, "$%v\r\n%v\r\n", len(arg), arg)
+	}
+	if _, err := self.conn.Write(buf.Bytes()); err != nil {
+		self.t.Fatalf("%v", err)
+	}
+	return self.readReply()
+}
+func (self *testClient) assert(wanted interface{}, args ...string) {
+	if found := self.do(args...); !reflect.DeepEqual(found, wanted) {
+		self.t.Errorf("%v: wanted %#v, got %#v", args, wanted, found)
+	}
+}
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(-1), -1000.5, -1, -0.25, 0, 0.25, 1, 1000.5, math.Inf(1)}
+	for index, score := range scores {
+		if decoded := decodeScore(encodeScore(score)); decoded != score {
+			t.Errorf("wanted %v, got %v", score, decoded)
+		}
+		if index > 0 && bytes.Compare(encodeScore(scores[index-1]), encodeScore(score)) >= 0 {
+			t.Errorf("wanted %v to sort before %v", scores[index-1], score)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	node := dhash.NewNodeDir("127.0.0.1:13191", "127.0.0.1:13191", "").MustStart()
+	defer node.Stop()
+	conn := client.MustConn("127.0.0.1:13191")
+	server := NewServer(conn)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer listener.Close()
+	go server.Serve(listener)
+	c := dialTest(t, listener.Addr().String())
+	defer c.conn.Close()
+
+	c.assert("+PONG", "PING")
+	c.assert("-ERR unknown command 'NOSUCH'", "NOSUCH")
+	c.assert("-ERR wrong number of arguments for 'get' command", "GET")
+	c.assert(nil, "GET", "k")
+	c.assert("+OK", "SET", "k", "v")
+	c.assert("v", "GET", "k")
+	c.assert("+OK", "SET", "k2", "v2", "EX", "100")
+	c.assert(2, "DEL", "k", "k2", "k3")
+	c.assert(nil, "GET", "k")
+
+	c.assert(2, "HSET", "h", "f1", "v1", "f2", "v2")
+	c.assert(0, "HSET", "h", "f1", "v3")
+	c.assert("v3", "HGET", "h", "f1")
+	c.assert(nil, "HGET", "h", "f3")
+	c.assert([]interface{}{"f1", "v3", "f2", "v2"}, "HGETALL", "h")
+
+	c.assert(3, "ZADD", "z", "2", "b", "-1.5", "a", "10", "c")
+	c.assert(1, "ZADD", "z", "2", "bb", "3", "c")
+	c.assert(4, "ZCARD", "z")
+	c.assert([]interface{}{"a", "b", "bb", "c"}, "ZRANGE", "z", "0", "-1")
+	c.assert([]interface{}{"bb", "2", "c", "3"}, "ZRANGE", "z", "-2", "-1", "WITHSCORES")
+	c.assert([]interface{}{}, "ZRANGE", "z", "3", "1")
+	c.assert(0, "ZRANK", "z", "a")
+	c.assert(2, "ZRANK", "z", "bb")
+	c.assert(nil, "ZRANK", "z", "d")
+	c.assert("-ERR value is not a valid float", "ZADD", "z", "x", "d")
+
+	if _, err := c.conn.Write([]byte("PING\r\n")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if reply := c.readReply(); reply != "+PONG" {
+		t.Errorf("wanted an inline PING to give +PONG, got %#v", reply)
+	}
+
+	server.SetAuth(func(token string) (string, bool) {
+		return "alice", token == "alicetoken"
+	}, func(principal, service string, args interface{}) error {
+		if service == "DHash.Get" {
+			return nil
+		}
+		return common.AccessDenied("%v may not call %v", principal, service)
+	})
+	a := dialTest(t, listener.Addr().String())
+	defer a.conn.Close()
+	a.assert("-NOAUTH Authentication required.", "GET", "k")
+	a.assert("-WRONGPASS invalid token", "AUTH", "badtoken")
+	a.assert("+OK", "AUTH", "alicetoken")
+	a.assert(nil, "GET", "k")
+	a.assert("-NOPERM Access denied: alice may not call DHash.Put", "SET", "k", "v")
+}
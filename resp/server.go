@@ -0,0 +1,250 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/zond/god/client"
+	"github.com/zond/god/discord"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	maxBulkLength  = 512 * 1024 * 1024
+	maxArgs        = 1024 * 1024
+	maxInlineBytes = 64 * 1024
+)
+
+// Server is a front end speaking the Redis protocol (RESP) that translates the commands it understands to calls to a god cluster using a client.Conn.
+//
+// GET, SET and DEL work on byte values.
+//
+// HSET, HGET and HGETALL work on sub trees, using the fields as sub keys.
+//
+// ZADD, ZRANGE, ZRANK and ZCARD work on mirrored sub trees, using the members as sub keys and the scores, encoded to sort correctly, as values.
+// ZADD will configure the sub tree to be mirrored if it isn't already.
+//
+// SINTER and SUNION work on sub trees using SetExpression, returning the sub keys of the result.
+type Server struct {
+	conn          *client.Conn
+	lock          sync.RWMutex
+	authenticator discord.Authenticator
+	authorizer    discord.Authorizer
+}
+
+// NewServer will return a Server running commands using conn.
+func NewServer(conn *client.Conn) *Server {
+	return &Server{conn: conn}
+}
+
+// SetAuth will make the Server require clients to AUTH with a token accepted by authenticator before running any other commands,
+// and ask authorizer about every call the commands make to the cluster on behalf of the principal of the token.
+//
+// Since the Server makes the calls using its own client.Conn, authorizer is asked using the names and arguments of the DHash net/rpc methods, see dhash.Node#Auth.
+func (self *Server) SetAuth(authenticator discord.Authenticator, authorizer discord.Authorizer) *Server {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.authenticator, self.authorizer = authenticator, authorizer
+	return self
+}
+func (self *Server) getAuth() (discord.Authenticator, discord.Authorizer) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.authenticator, self.authorizer
+}
+
+// ListenAndServe will listen to addr and serve all clients connecting to it.
+func (self *Server) ListenAndServe(addr string) (err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	return self.Serve(listener)
+}
+
+// Serve will serve all clients connecting to listener, until listener is closed.
+func (self *Server) Serve(listener net.Listener) (err error) {
+	var conn net.Conn
+	for {
+		if conn, err = listener.Accept(); err != nil {
+			return
+		}
+		go self.serve(conn)
+	}
+}
+func (self *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	authenticator, _ := self.getAuth()
+	s := &session{
+		server:        self,
+		reader:        bufio.NewReader(conn),
+		writer:        bufio.NewWriter(conn),
+		authenticated: authenticator == nil,
+	}
+	for {
+		args, err := s.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				s.writeError(fmt.Sprintf("ERR Protocol error: %v", err))
+				s.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if !s.run(args) {
+			s.writer.Flush()
+			return
+		}
+		if s.reader.Buffered() == 0 {
+			if err = s.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// session is the state of a single client connection.
+type session struct {
+	server        *Server
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	authenticated bool
+	principal     string
+}
+
+// readCommand will read either a RESP array of bulk strings, or an inline command, from the client.
+func (self *session) readCommand() (result [][]byte, err error) {
+	first, err := self.reader.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] != '*' {
+		var line string
+		if line, err = self.readLine(); err != nil {
+			return
+		}
+		for _, field := range strings.Fields(line) {
+			result = append(result, []byte(field))
+		}
+		return
+	}
+	self.reader.ReadByte()
+	n, err := self.readInt()
+	if err != nil {
+		return
+	}
+	if n > maxArgs {
+		err = fmt.Errorf("invalid multibulk length")
+		return
+	}
+	for i := 0; i < n; i++ {
+		var b byte
+		if b, err = self.reader.ReadByte(); err != nil {
+			return
+		}
+		if b != '$' {
+			err = fmt.Errorf("expected '$', got '%c'", b)
+			return
+		}
+		var length int
+		if length, err = self.readInt(); err != nil {
+			return
+		}
+		if length < 0 || length > maxBulkLength {
+			err = fmt.Errorf("invalid bulk length")
+			return
+		}
+		arg := make([]byte, length+2)
+		if _, err = io.ReadFull(self.reader, arg); err != nil {
+			return
+		}
+		result = append(result, arg[:length])
+	}
+	return
+}
+func (self *session) readLine() (result string, err error) {
+	line, isPrefix, err := self.reader.ReadLine()
+	if err != nil {
+		return
+	}
+	if isPrefix || len(line) > maxInlineBytes {
+		err = fmt.Errorf("too big inline request")
+		return
+	}
+	return string(line), nil
+}
+func (self *session) readInt() (result int, err error) {
+	line, err := self.readLine()
+	if err != nil {
+		return
+	}
+	return strconv.Atoi(line)
+}
+
+func (self *session) writeStatus(s string) {
+	fmt.Fprintf(self.writer, "+%v\r\n", s)
+}
+func (self *session) writeError(s string) {
+	fmt.Fprintf(self.writer, "-%v\r\n", strings.Replace(strings.Replace(s, "\r", " ", -1), "\n", " ", -1))
+}
+func (self *session) writeInt(i int) {
+	fmt.Fprintf(self.writer, ":%v\r\n", i)
+}
+func (self *session) writeBulk(b []byte) {
+	if b == nil {
+		self.writer.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(self.writer, "$%v\r\n", len(b))
+	self.writer.Write(b)
+	self.writer.WriteString("\r\n")
+}
+func (self *session) writeArray(items [][]byte) {
+	fmt.Fprintf(self.writer, "*%v\r\n", len(items))
+	for _, item := range items {
+		self.writeBulk(item)
+	}
+}
+
+// authorize will return whether the principal of this session may call service with args, and reply with an error if not.
+func (self *session) authorize(service string, args interface{}) bool {
+	_, authorizer := self.server.getAuth()
+	if authorizer == nil {
+		return true
+	}
+	if err := authorizer(self.principal, service, args); err != nil {
+		self.writeError(fmt.Sprintf("NOPERM %v", err))
+		return false
+	}
+	return true
+}
+
+// run will run the command in args and write its reply, and return whether the session should continue.
+func (self *session) run(args [][]byte) (cont bool) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, found := commands[name]
+	if !found {
+		self.writeError(fmt.Sprintf("ERR unknown command '%v'", string(args[0])))
+		return true
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		self.writeError(fmt.Sprintf("ERR wrong number of arguments for '%v' command", strings.ToLower(name)))
+		return true
+	}
+	if !self.authenticated && !cmd.open {
+		self.writeError("NOAUTH Authentication required.")
+		return true
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			self.writeError(fmt.Sprintf("ERR %v", e))
+			cont = true
+		}
+	}()
+	return cmd.f(self, args)
+}
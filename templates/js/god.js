@@ -3,6 +3,7 @@ Node = function(g, data) {
   var that = new Object();
 	that.data = data;
 	that.gob_addr = data.Addr;
+	that.zone = data.Zone || "";
 	var m = /^(.*):(.*)$/.exec(that.gob_addr);
 	that.json_addr = m[1] + ":" + (1 + parseInt(m[2]));
 	that.hexpos = "";
@@ -60,7 +61,7 @@ God = function() {
 			var spot = new createjs.Shape();
 			spot.graphics.beginStroke(createjs.Graphics.getRGB(0,0,0)).beginFill(createjs.Graphics.getRGB(0,0,0)).drawCircle(route.x, route.y, 20);
 			stage.addChild(spot);
-			var label = new createjs.Text(route.hexpos + "@" + route.gob_addr, "bold 25px Courier");
+			var label = new createjs.Text(route.hexpos + "@" + route.gob_addr + (route.zone == "" ? "" : " in " + route.zone), "bold 25px Courier");
 			label.x = route.x + 30;
 			label.y = route.y - 10;
 			stage.addChild(label);
@@ -122,6 +123,7 @@ God = function() {
 				$("#node_json_addr").text(that.node.json_addr);
 				$("#node_gob_addr").text(that.node.gob_addr);
 				$("#node_pos").text(that.node.hexpos);
+				$("#node_zone").text(that.node.zone);
 				$("#node_owned_keys").text(that.node.data.OwnedEntries);
 				$("#node_held_keys").text(that.node.data.HeldEntries);
 				$("#node_load").text(that.node.data.Load);
@@ -155,7 +157,7 @@ God = function() {
 	  addr = addr.replace('localhost', '127.0.0.1');
 	  if (that.opened_sockets[addr] == null) {
 			that.opened_sockets[addr] = true;
-			$.websocket("ws://" + addr + "/ws", {
+			$.websocket((document.location.protocol == "https:" ? "wss://" : "ws://") + addr + "/ws" + document.location.search, {
 				open: function() { 
 					console.log("socket to " + addr + " opened");
 				},
@@ -256,7 +258,7 @@ God = function() {
 	};
 	that.display_endpoint_form = function(endp) {
 		$("#code_container").html(that.api_endpoint_templ({ api_endpoint: endp }));
-    var generated_code = "$.ajax('/rpc/DHash." + endp.name + "', {\n  type: 'POST',\n  contentType: 'application/json; charset=UTF-8',\n  data: JSON.stringify(" + that.generate_example_params(endp) + "),\n  success: function(data) {\n    displayResult(data);\n  },\n  dataType: 'json',\n});";
+    var generated_code = "$.ajax('/rpc/DHash." + endp.name + document.location.search + "', {\n  type: 'POST',\n  contentType: 'application/json; charset=UTF-8',\n  data: JSON.stringify(" + that.generate_example_params(endp) + "),\n  success: function(data) {\n    displayResult(data);\n  },\n  dataType: 'json',\n});";
 		$("#code").val(generated_code);
 		$("#execute").click(function(ev) {
 		  eval($("#code").val());